@@ -0,0 +1,110 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"Dana/agent/model"
+)
+
+// APITokenPrefix starts every API token so it can be told apart from a JWT.
+const APITokenPrefix = "dana_"
+
+// lastUsedResolution limits how often the last-used time of a token is
+// written back.
+const lastUsedResolution = time.Minute
+
+var (
+	ErrTokenExpired  = errors.New("token has expired")
+	ErrUserNotActive = errors.New("token owner is disabled or deleted")
+)
+
+// APITokenStore looks up API tokens by hash.
+type APITokenStore interface {
+	GetTokenByHash(ctx context.Context, hash string) (*model.APIToken, error)
+	TouchToken(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+// UserStore looks up the owner of an API token.
+type UserStore interface {
+	GetUserByUsername(ctx context.Context, username string) (*model.User, error)
+}
+
+// GenerateAPIToken returns a new random API token and its hash.
+func GenerateAPIToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("generating api token: %w", err)
+	}
+	token = APITokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashAPIToken(token), nil
+}
+
+// HashAPIToken returns the hash stored for an API token. API tokens are
+// random, so a plain SHA-256 is sufficient and allows lookups by hash.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateAPIToken resolves an API token to its record and owner.
+func (a *Authenticator) authenticateAPIToken(ctx context.Context, tokenString string) (*model.APIToken, *model.User, error) {
+	token, err := a.tokens.GetTokenByHash(ctx, HashAPIToken(tokenString))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if !token.ExpiresAt.IsZero() && now.After(token.ExpiresAt) {
+		return nil, nil, ErrTokenExpired
+	}
+
+	user, err := a.users.GetUserByUsername(ctx, token.Username)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil, ErrUserNotActive
+		}
+		return nil, nil, err
+	}
+	if user.Disabled {
+		return nil, nil, ErrUserNotActive
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedResolution {
+		if err := a.tokens.TouchToken(ctx, token.ID, now); err != nil {
+			return nil, nil, err
+		}
+	}
+	return token, user, nil
+}
+
+// scopeAllows reports whether a token with the given scope may call the
+// route. Reads are always allowed, changes only within the scope.
+func scopeAllows(scope model.TokenScope, method, route string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	switch scope {
+	case model.ScopeInputs:
+		return strings.HasPrefix(route, "/api/v1/input/")
+	case model.ScopeDashboards:
+		return strings.HasPrefix(route, "/api/v1/dashboards") || strings.HasPrefix(route, "/api/v1/folders")
+	}
+	return false
+}
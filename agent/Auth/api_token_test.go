@@ -0,0 +1,131 @@
+package authentication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"Dana/agent/model"
+)
+
+func TestGenerateAPIToken(t *testing.T) {
+	token, hash, err := GenerateAPIToken()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, APITokenPrefix))
+	require.Len(t, token, len(APITokenPrefix)+43)
+	require.Equal(t, HashAPIToken(token), hash)
+	require.NotContains(t, hash, token)
+
+	other, otherHash, err := GenerateAPIToken()
+	require.NoError(t, err)
+	require.NotEqual(t, token, other)
+	require.NotEqual(t, hash, otherHash)
+}
+
+func TestHashAPIToken(t *testing.T) {
+	// SHA-256 of "dana_test", so stored hashes keep matching
+	require.Equal(t, "b0777bb52f26d61289d9f253e32ab73cf5b2cbcf5046db741d12c5d86f201162", HashAPIToken("dana_test"))
+	require.NotEqual(t, HashAPIToken("dana_test"), HashAPIToken("dana_tesT"))
+}
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		scope    model.TokenScope
+		method   string
+		route    string
+		expected bool
+	}{
+		{scope: model.ScopeReadOnly, method: http.MethodGet, route: "/api/v1/users", expected: true},
+		{scope: model.ScopeReadOnly, method: http.MethodHead, route: "/api/v1/inputs", expected: true},
+		{scope: model.ScopeReadOnly, method: http.MethodPost, route: "/api/v1/input/:type", expected: false},
+		{scope: model.ScopeReadOnly, method: http.MethodDelete, route: "/api/v1/dashboards/:id", expected: false},
+		{scope: model.ScopeInputs, method: http.MethodPost, route: "/api/v1/input/:type", expected: true},
+		{scope: model.ScopeInputs, method: http.MethodDelete, route: "/api/v1/input/:type/:id", expected: true},
+		{scope: model.ScopeInputs, method: http.MethodPost, route: "/api/v1/plugins/:category/:type", expected: false},
+		{scope: model.ScopeInputs, method: http.MethodPut, route: "/api/v1/dashboards/:id", expected: false},
+		{scope: model.ScopeDashboards, method: http.MethodPut, route: "/api/v1/dashboards/:id", expected: true},
+		{scope: model.ScopeDashboards, method: http.MethodPost, route: "/api/v1/folders", expected: true},
+		{scope: model.ScopeDashboards, method: http.MethodPost, route: "/api/v1/input/:type", expected: false},
+		{scope: model.ScopeDashboards, method: http.MethodPost, route: "/api/v1/users", expected: false},
+		{scope: "unknown", method: http.MethodGet, route: "/api/v1/users", expected: true},
+		{scope: "unknown", method: http.MethodPost, route: "/api/v1/input/:type", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.scope)+" "+tt.method+" "+tt.route, func(t *testing.T) {
+			require.Equal(t, tt.expected, scopeAllows(tt.scope, tt.method, tt.route))
+		})
+	}
+}
+
+func TestValidateJWTAPIToken(t *testing.T) {
+	recent := time.Now().Add(-time.Second)
+	tokens := &memoryTokens{byHash: make(map[string]*model.APIToken)}
+	add := func(username string, scope model.TokenScope, expiresAt time.Time, lastUsedAt *time.Time) (string, primitive.ObjectID) {
+		token, hash, err := GenerateAPIToken()
+		require.NoError(t, err)
+		id := primitive.NewObjectID()
+		tokens.byHash[hash] = &model.APIToken{ID: id, Username: username, Scope: scope, Hash: hash, ExpiresAt: expiresAt, LastUsedAt: lastUsedAt}
+		return token, id
+	}
+	users := memoryUsers{
+		"alice": {Username: "alice", Role: model.RoleAdmin},
+		"bob":   {Username: "bob", Role: model.RoleAdmin, Disabled: true},
+	}
+	a, err := New(Options{Keys: map[string][]byte{"k1": []byte("secret-1")}}, &memoryDenylist{}, tokens, users)
+	require.NoError(t, err)
+
+	e := echo.New()
+	handler := func(c echo.Context) error {
+		require.NotNil(t, CurrentAPIToken(c))
+		require.Nil(t, CurrentClaims(c))
+		return c.String(http.StatusOK, Username(c)+" "+string(Role(c)))
+	}
+	e.GET("/api/v1/inputs", handler, a.ValidateJWT)
+	e.POST("/api/v1/input/:type", handler, a.ValidateJWT)
+
+	inputs, inputsID := add("alice", model.ScopeInputs, time.Now().Add(time.Hour), nil)
+	readOnly, readOnlyID := add("alice", model.ScopeReadOnly, time.Time{}, &recent)
+	expired, _ := add("alice", model.ScopeInputs, time.Now().Add(-time.Minute), nil)
+	disabled, _ := add("bob", model.ScopeInputs, time.Time{}, nil)
+	deleted, _ := add("carol", model.ScopeInputs, time.Time{}, nil)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		expected int
+	}{
+		{name: "read", method: http.MethodGet, path: "/api/v1/inputs", token: inputs, expected: http.StatusOK},
+		{name: "change in scope", method: http.MethodPost, path: "/api/v1/input/cpu", token: inputs, expected: http.StatusOK},
+		{name: "never expires", method: http.MethodGet, path: "/api/v1/inputs", token: readOnly, expected: http.StatusOK},
+		{name: "change out of scope", method: http.MethodPost, path: "/api/v1/input/cpu", token: readOnly, expected: http.StatusForbidden},
+		{name: "expired", method: http.MethodGet, path: "/api/v1/inputs", token: expired, expected: http.StatusUnauthorized},
+		{name: "disabled owner", method: http.MethodGet, path: "/api/v1/inputs", token: disabled, expected: http.StatusUnauthorized},
+		{name: "deleted owner", method: http.MethodGet, path: "/api/v1/inputs", token: deleted, expected: http.StatusUnauthorized},
+		{name: "unknown", method: http.MethodGet, path: "/api/v1/inputs", token: APITokenPrefix + "unknown", expected: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			require.Equal(t, tt.expected, rec.Code)
+			if tt.expected == http.StatusOK {
+				// The role comes from the owner, not the token
+				require.Equal(t, "alice admin", rec.Body.String())
+			}
+		})
+	}
+
+	// Use is only recorded once per lastUsedResolution
+	require.Contains(t, tokens.touched, inputsID)
+	require.NotContains(t, tokens.touched, readOnlyID)
+}
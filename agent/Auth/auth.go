@@ -1,62 +1,287 @@
 package authentication
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+
+	"Dana/agent/model"
+)
+
+const (
+	// TokenTypeAccess marks tokens accepted by the API routes
+	TokenTypeAccess = "access"
+	// TokenTypeRefresh marks tokens only accepted for issuing new tokens
+	TokenTypeRefresh = "refresh"
+
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenRevoked = errors.New("token has been revoked")
 )
 
-const secret = "calgor"
+// Denylist keeps track of revoked token ids.
+type Denylist interface {
+	Revoke(ctx context.Context, id string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, id string) (bool, error)
+}
+
+// Options configures the signing keys and token lifetimes.
+type Options struct {
+	// Keys maps key ids to HMAC secrets. All keys are accepted when
+	// verifying tokens.
+	Keys map[string][]byte
+	// ActiveKey is the id of the key used to sign new tokens.
+	ActiveKey       string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// Claims are the claims carried in every token issued by the agent.
+type Claims struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Type     string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair is returned on login and refresh.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Authenticator issues, verifies and revokes API tokens.
+type Authenticator struct {
+	keys            map[string][]byte
+	activeKey       string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	denylist        Denylist
+	tokens          APITokenStore
+	users           UserStore
+}
+
+// New returns an Authenticator for the given options. Without any keys a
+// random one is generated, so tokens do not survive a restart.
+func New(opts Options, denylist Denylist, tokens APITokenStore, users UserStore) (*Authenticator, error) {
+	a := &Authenticator{
+		keys:            opts.Keys,
+		activeKey:       opts.ActiveKey,
+		accessTokenTTL:  opts.AccessTokenTTL,
+		refreshTokenTTL: opts.RefreshTokenTTL,
+		denylist:        denylist,
+		tokens:          tokens,
+		users:           users,
+	}
+	if a.accessTokenTTL <= 0 {
+		a.accessTokenTTL = defaultAccessTokenTTL
+	}
+	if a.refreshTokenTTL <= 0 {
+		a.refreshTokenTTL = defaultRefreshTokenTTL
+	}
+
+	if len(a.keys) == 0 {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generating signing key: %w", err)
+		}
+		a.keys = map[string][]byte{"ephemeral": key}
+		a.activeKey = "ephemeral"
+	}
+	if a.activeKey == "" && len(a.keys) == 1 {
+		for id := range a.keys {
+			a.activeKey = id
+		}
+	}
+	if _, found := a.keys[a.activeKey]; !found {
+		return nil, fmt.Errorf("active signing key %q is not configured", a.activeKey)
+	}
 
-func GenerateJWT(username string) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["exp"] = time.Now().Add(24 * time.Hour).Unix()
-	claims["username"] = username
-	tokenString, err := token.SignedString([]byte(secret))
+	return a, nil
+}
+
+// GenerateTokens issues a new access and refresh token for the user.
+func (a *Authenticator) GenerateTokens(username string, role model.Role) (*TokenPair, error) {
+	access, err := a.sign(username, role, TokenTypeAccess, a.accessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := a.sign(username, role, TokenTypeRefresh, a.refreshTokenTTL)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(a.accessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (a *Authenticator) sign(username string, role model.Role, typ string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := &Claims{
+		Username: username,
+		Role:     string(role),
+		Type:     typ,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
 	}
 
-	return tokenString, nil
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = a.activeKey
+	return token.SignedString(a.keys[a.activeKey])
 }
 
-func ValidateJWT(next echo.HandlerFunc) echo.HandlerFunc {
+// ParseToken verifies the token's signature, expiry, type and revocation
+// state and returns its claims.
+func (a *Authenticator) ParseToken(ctx context.Context, tokenString, typ string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, found := a.keys[kid]
+		if !found {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if !token.Valid || claims.Type != typ || claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	revoked, err := a.denylist.IsRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// Revoke puts the token on the denylist until it expires.
+func (a *Authenticator) Revoke(ctx context.Context, claims *Claims) error {
+	expiresAt := time.Now().Add(a.refreshTokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return a.denylist.Revoke(ctx, claims.ID, expiresAt)
+}
+
+// ValidateJWT only lets requests through that carry a valid access token or
+// API token in an "Authorization: Bearer <token>" header.
+func (a *Authenticator) ValidateJWT(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		auth := c.Request().Header.Get("Authorization")
-		token, err := jwt.Parse(auth, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("there was an error")
-			}
-			return []byte(secret), nil
-		})
+		tokenString, err := BearerToken(c.Request().Header.Get("Authorization"))
 		if err != nil {
-			fmt.Println(err, token)
 			return c.String(http.StatusUnauthorized, "unauthorized")
 		}
-		if token.Valid {
+
+		if strings.HasPrefix(tokenString, APITokenPrefix) {
+			token, user, err := a.authenticateAPIToken(c.Request().Context(), tokenString)
+			if err != nil {
+				c.Logger().Warn("API token rejected: ", err)
+				return c.String(http.StatusUnauthorized, "unauthorized")
+			}
+			if !scopeAllows(token.Scope, c.Request().Method, c.Path()) {
+				return c.String(http.StatusForbidden, "forbidden")
+			}
+
+			c.Set("api_token", token)
+			c.Set("username", user.Username)
+			c.Set("role", string(user.Role))
 			return next(c)
 		}
-		return c.String(http.StatusUnauthorized, "unauthorized")
+
+		claims, err := a.ParseToken(c.Request().Context(), tokenString, TokenTypeAccess)
+		if err != nil {
+			c.Logger().Warn("Token rejected: ", err)
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+
+		c.Set("claims", claims)
+		c.Set("username", claims.Username)
+		c.Set("role", claims.Role)
+		return next(c)
 	}
 }
 
-func ExtractClaimsFromToken(tokenString string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte("calgor"), nil
-	})
-	if err != nil {
-		return nil, err
+// BearerToken extracts the token from an Authorization header value.
+func BearerToken(header string) (string, error) {
+	scheme, token, found := strings.Cut(strings.TrimSpace(header), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", ErrMissingToken
 	}
-	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", ErrMissingToken
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("could not extract claims")
+	return token, nil
+}
+
+// RequireRole only lets callers through whose role is at least min. It must
+// run after ValidateJWT.
+func RequireRole(min model.Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if Role(c).Level() < min.Level() {
+				return c.String(http.StatusForbidden, "forbidden")
+			}
+			return next(c)
+		}
 	}
-	return claims, nil
+}
+
+// CurrentAPIToken returns the API token used for the request, or nil if the
+// caller authenticated with a JWT.
+func CurrentAPIToken(c echo.Context) *model.APIToken {
+	token, _ := c.Get("api_token").(*model.APIToken)
+	return token
+}
+
+// CurrentClaims returns the claims of the access token used for the request
+// as stored in the request context by ValidateJWT.
+func CurrentClaims(c echo.Context) *Claims {
+	claims, _ := c.Get("claims").(*Claims)
+	return claims
+}
+
+// Username returns the username of the authenticated caller as stored in the
+// request context by ValidateJWT.
+func Username(c echo.Context) string {
+	username, _ := c.Get("username").(string)
+	return username
+}
+
+// Role returns the role of the authenticated caller as stored in the request
+// context by ValidateJWT.
+func Role(c echo.Context) model.Role {
+	role, _ := c.Get("role").(string)
+	return model.Role(role)
 }
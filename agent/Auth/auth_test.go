@@ -0,0 +1,329 @@
+package authentication
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"Dana/agent/model"
+)
+
+// memoryDenylist keeps revoked token ids in memory.
+type memoryDenylist struct {
+	sync.Mutex
+	revoked map[string]time.Time
+}
+
+func (d *memoryDenylist) Revoke(_ context.Context, id string, expiresAt time.Time) error {
+	d.Lock()
+	defer d.Unlock()
+	if d.revoked == nil {
+		d.revoked = make(map[string]time.Time)
+	}
+	d.revoked[id] = expiresAt
+	return nil
+}
+
+func (d *memoryDenylist) IsRevoked(_ context.Context, id string) (bool, error) {
+	d.Lock()
+	defer d.Unlock()
+	_, found := d.revoked[id]
+	return found, nil
+}
+
+// newTestAuthenticator returns an Authenticator signing with a single key and
+// without any API tokens or users.
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	a, err := New(Options{Keys: map[string][]byte{"k1": []byte("secret-1")}}, &memoryDenylist{}, &memoryTokens{}, memoryUsers{})
+	require.NoError(t, err)
+	return a
+}
+
+// memoryTokens keeps API tokens by hash and records when they are used.
+type memoryTokens struct {
+	byHash  map[string]*model.APIToken
+	touched []primitive.ObjectID
+}
+
+func (m *memoryTokens) GetTokenByHash(_ context.Context, hash string) (*model.APIToken, error) {
+	token, found := m.byHash[hash]
+	if !found {
+		return nil, mongo.ErrNoDocuments
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (m *memoryTokens) TouchToken(_ context.Context, id primitive.ObjectID, _ time.Time) error {
+	m.touched = append(m.touched, id)
+	return nil
+}
+
+// memoryUsers maps usernames to users.
+type memoryUsers map[string]*model.User
+
+func (m memoryUsers) GetUserByUsername(_ context.Context, username string) (*model.User, error) {
+	user, found := m[username]
+	if !found {
+		return nil, mongo.ErrNoDocuments
+	}
+	return user, nil
+}
+
+func TestRequireRole(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	// Wired like the agent's API: roles are checked after the token
+	e := echo.New()
+	v1 := e.Group("/api/v1")
+	v1.Use(a.ValidateJWT)
+	ok := func(c echo.Context) error { return c.String(http.StatusOK, Username(c)) }
+	v1.Group("", RequireRole(model.RoleViewer)).GET("/viewer", ok)
+	v1.Group("", RequireRole(model.RoleEditor)).GET("/editor", ok)
+	v1.Group("", RequireRole(model.RoleAdmin)).GET("/admin", ok)
+	// Without ValidateJWT no role is known and every caller is denied
+	e.GET("/unauthenticated", ok, RequireRole(model.RoleViewer))
+
+	token := func(role model.Role) string {
+		pair, err := a.GenerateTokens("alice", role)
+		require.NoError(t, err)
+		return pair.AccessToken
+	}
+
+	tests := []struct {
+		path     string
+		role     model.Role
+		expected int
+	}{
+		{path: "/api/v1/viewer", role: model.RoleViewer, expected: http.StatusOK},
+		{path: "/api/v1/editor", role: model.RoleViewer, expected: http.StatusForbidden},
+		{path: "/api/v1/admin", role: model.RoleViewer, expected: http.StatusForbidden},
+		{path: "/api/v1/viewer", role: model.RoleEditor, expected: http.StatusOK},
+		{path: "/api/v1/editor", role: model.RoleEditor, expected: http.StatusOK},
+		{path: "/api/v1/admin", role: model.RoleEditor, expected: http.StatusForbidden},
+		{path: "/api/v1/admin", role: model.RoleAdmin, expected: http.StatusOK},
+		// Unknown roles have no privileges at all
+		{path: "/api/v1/viewer", role: "owner", expected: http.StatusForbidden},
+		// Missing tokens are rejected before roles are checked
+		{path: "/api/v1/viewer", expected: http.StatusUnauthorized},
+		{path: "/api/v1/admin", expected: http.StatusUnauthorized},
+		{path: "/unauthenticated", role: model.RoleAdmin, expected: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" as "+string(tt.role), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.role != "" {
+				req.Header.Set("Authorization", "Bearer "+token(tt.role))
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			require.Equal(t, tt.expected, rec.Code)
+			if tt.expected == http.StatusOK {
+				require.Equal(t, "alice", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	// Without keys tokens are signed with a random key that only this
+	// Authenticator knows
+	a, err := New(Options{}, &memoryDenylist{}, &memoryTokens{}, memoryUsers{})
+	require.NoError(t, err)
+	require.Equal(t, "ephemeral", a.activeKey)
+	require.Len(t, a.keys["ephemeral"], 32)
+	require.Equal(t, defaultAccessTokenTTL, a.accessTokenTTL)
+	require.Equal(t, defaultRefreshTokenTTL, a.refreshTokenTTL)
+
+	other, err := New(Options{}, &memoryDenylist{}, &memoryTokens{}, memoryUsers{})
+	require.NoError(t, err)
+	require.NotEqual(t, a.keys["ephemeral"], other.keys["ephemeral"])
+
+	pair, err := a.GenerateTokens("alice", model.RoleViewer)
+	require.NoError(t, err)
+	_, err = a.ParseToken(context.Background(), pair.AccessToken, TokenTypeAccess)
+	require.NoError(t, err)
+	_, err = other.ParseToken(context.Background(), pair.AccessToken, TokenTypeAccess)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// A single key is active without naming it
+	a, err = New(Options{Keys: map[string][]byte{"k1": []byte("secret-1")}}, &memoryDenylist{}, &memoryTokens{}, memoryUsers{})
+	require.NoError(t, err)
+	require.Equal(t, "k1", a.activeKey)
+
+	// With several keys the active one must be named and configured
+	keys := map[string][]byte{"k1": []byte("secret-1"), "k2": []byte("secret-2")}
+	_, err = New(Options{Keys: keys}, &memoryDenylist{}, &memoryTokens{}, memoryUsers{})
+	require.Error(t, err)
+	_, err = New(Options{Keys: keys, ActiveKey: "k3"}, &memoryDenylist{}, &memoryTokens{}, memoryUsers{})
+	require.Error(t, err)
+}
+
+func TestParseToken(t *testing.T) {
+	a := newTestAuthenticator(t)
+	pair, err := a.GenerateTokens("alice", model.RoleEditor)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer", pair.TokenType)
+	require.Equal(t, int64(defaultAccessTokenTTL.Seconds()), pair.ExpiresIn)
+
+	claims, err := a.ParseToken(context.Background(), pair.AccessToken, TokenTypeAccess)
+	require.NoError(t, err)
+	require.Equal(t, "alice", claims.Username)
+	require.Equal(t, string(model.RoleEditor), claims.Role)
+	require.NotEmpty(t, claims.ID)
+
+	// Each token is only accepted for its own purpose
+	_, err = a.ParseToken(context.Background(), pair.AccessToken, TokenTypeRefresh)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	refresh, err := a.ParseToken(context.Background(), pair.RefreshToken, TokenTypeRefresh)
+	require.NoError(t, err)
+	require.NotEqual(t, claims.ID, refresh.ID)
+	_, err = a.ParseToken(context.Background(), pair.RefreshToken, TokenTypeAccess)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	expired, err := a.sign("alice", model.RoleEditor, TokenTypeAccess, -time.Minute)
+	require.NoError(t, err)
+	_, err = a.ParseToken(context.Background(), expired, TokenTypeAccess)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// Unsigned tokens are never accepted
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	unsigned.Header["kid"] = "k1"
+	none, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = a.ParseToken(context.Background(), none, TokenTypeAccess)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = a.ParseToken(context.Background(), pair.AccessToken+"x", TokenTypeAccess)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestParseTokenKeyRotation(t *testing.T) {
+	denylist := &memoryDenylist{}
+	old, err := New(Options{Keys: map[string][]byte{"k1": []byte("secret-1")}}, denylist, &memoryTokens{}, memoryUsers{})
+	require.NoError(t, err)
+	oldPair, err := old.GenerateTokens("alice", model.RoleViewer)
+	require.NoError(t, err)
+
+	// k2 signs new tokens while tokens signed with k1 stay valid
+	rotated, err := New(Options{
+		Keys:      map[string][]byte{"k1": []byte("secret-1"), "k2": []byte("secret-2")},
+		ActiveKey: "k2",
+	}, denylist, &memoryTokens{}, memoryUsers{})
+	require.NoError(t, err)
+	_, err = rotated.ParseToken(context.Background(), oldPair.AccessToken, TokenTypeAccess)
+	require.NoError(t, err)
+
+	newPair, err := rotated.GenerateTokens("alice", model.RoleViewer)
+	require.NoError(t, err)
+	token, err := jwt.ParseWithClaims(newPair.AccessToken, &Claims{}, func(*jwt.Token) (interface{}, error) {
+		return []byte("secret-2"), nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "k2", token.Header["kid"])
+
+	// Once k1 is removed its tokens are rejected
+	retired, err := New(Options{Keys: map[string][]byte{"k2": []byte("secret-2")}}, denylist, &memoryTokens{}, memoryUsers{})
+	require.NoError(t, err)
+	_, err = retired.ParseToken(context.Background(), oldPair.AccessToken, TokenTypeAccess)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	_, err = retired.ParseToken(context.Background(), newPair.AccessToken, TokenTypeAccess)
+	require.NoError(t, err)
+
+	// A key id does not help a token signed with another secret
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		Username:         "mallory",
+		Role:             string(model.RoleAdmin),
+		Type:             TokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{ID: "forged", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	})
+	forged.Header["kid"] = "k2"
+	signed, err := forged.SignedString([]byte("guessed"))
+	require.NoError(t, err)
+	_, err = retired.ParseToken(context.Background(), signed, TokenTypeAccess)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRevoke(t *testing.T) {
+	denylist := &memoryDenylist{}
+	a, err := New(Options{Keys: map[string][]byte{"k1": []byte("secret-1")}}, denylist, &memoryTokens{}, memoryUsers{})
+	require.NoError(t, err)
+	pair, err := a.GenerateTokens("alice", model.RoleViewer)
+	require.NoError(t, err)
+
+	// Refreshing revokes the refresh token that was used
+	refresh, err := a.ParseToken(context.Background(), pair.RefreshToken, TokenTypeRefresh)
+	require.NoError(t, err)
+	require.NoError(t, a.Revoke(context.Background(), refresh))
+	_, err = a.ParseToken(context.Background(), pair.RefreshToken, TokenTypeRefresh)
+	require.ErrorIs(t, err, ErrTokenRevoked)
+	require.True(t, refresh.ExpiresAt.Time.Equal(denylist.revoked[refresh.ID]))
+
+	// The access token issued with it stays valid until revoked as well
+	access, err := a.ParseToken(context.Background(), pair.AccessToken, TokenTypeAccess)
+	require.NoError(t, err)
+	require.NoError(t, a.Revoke(context.Background(), access))
+	_, err = a.ParseToken(context.Background(), pair.AccessToken, TokenTypeAccess)
+	require.ErrorIs(t, err, ErrTokenRevoked)
+
+	next, err := a.GenerateTokens("alice", model.RoleViewer)
+	require.NoError(t, err)
+	_, err = a.ParseToken(context.Background(), next.AccessToken, TokenTypeAccess)
+	require.NoError(t, err)
+}
+
+func TestValidateJWT(t *testing.T) {
+	a := newTestAuthenticator(t)
+	e := echo.New()
+	e.GET("/api/v1/whoami", func(c echo.Context) error {
+		require.NotNil(t, CurrentClaims(c))
+		require.Nil(t, CurrentAPIToken(c))
+		return c.String(http.StatusOK, Username(c)+" "+string(Role(c)))
+	}, a.ValidateJWT)
+
+	pair, err := a.GenerateTokens("alice", model.RoleEditor)
+	require.NoError(t, err)
+	revoked, err := a.GenerateTokens("alice", model.RoleEditor)
+	require.NoError(t, err)
+	claims, err := a.ParseToken(context.Background(), revoked.AccessToken, TokenTypeAccess)
+	require.NoError(t, err)
+	require.NoError(t, a.Revoke(context.Background(), claims))
+
+	tests := []struct {
+		name     string
+		header   string
+		expected int
+	}{
+		{name: "access token", header: "Bearer " + pair.AccessToken, expected: http.StatusOK},
+		{name: "scheme is case insensitive", header: "bearer  " + pair.AccessToken, expected: http.StatusOK},
+		{name: "no header", expected: http.StatusUnauthorized},
+		{name: "other scheme", header: "Basic " + pair.AccessToken, expected: http.StatusUnauthorized},
+		{name: "empty token", header: "Bearer ", expected: http.StatusUnauthorized},
+		{name: "refresh token", header: "Bearer " + pair.RefreshToken, expected: http.StatusUnauthorized},
+		{name: "revoked token", header: "Bearer " + revoked.AccessToken, expected: http.StatusUnauthorized},
+		{name: "garbage", header: "Bearer not-a-token", expected: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/whoami", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			require.Equal(t, tt.expected, rec.Code)
+			if tt.expected == http.StatusOK {
+				require.Equal(t, "alice editor", rec.Body.String())
+			}
+		})
+	}
+}
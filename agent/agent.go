@@ -17,6 +17,7 @@ import (
 
 	"Dana"
 	authentication "Dana/agent/Auth"
+	"Dana/agent/model"
 	"Dana/agent/repository"
 	"Dana/config"
 	"Dana/internal"
@@ -28,16 +29,32 @@ import (
 
 // Server runs a set of plugins.
 type Server struct {
-	Config           *config.Config
-	echo             *echo.Echo
-	InputRepo        repository.HandlerInputRepo
-	UserRepo         repository.UserRepo
-	DashboardRepo    repository.DashboardRepo
-	FolderRepo       repository.FolderRepo
-	NotificationRepo repository.NotificationRepo
-	NetworkRepo      repository.NetworkRepo
-	InputDstChan     chan<- Dana.Metric
-	StartTime        time.Time
+	Config                *config.Config
+	echo                  *echo.Echo
+	InputRepo             repository.HandlerInputRepo
+	PluginRepo            repository.HandlerPluginRepo
+	UserRepo              repository.UserRepo
+	DashboardRepo         repository.DashboardRepo
+	DashboardRevisionRepo repository.DashboardRevisionRepo
+	FolderRepo            repository.FolderRepo
+	NotificationRepo      repository.NotificationRepo
+	NetworkRepo           repository.NetworkRepo
+	TokenRepo             repository.TokenRepo
+	APITokenRepo          repository.APITokenRepo
+	AuditRepo             repository.AuditRepo
+	AnnotationRepo        repository.AnnotationRepo
+	SnapshotRepo          repository.SnapshotRepo
+	NotificationQueueRepo repository.NotificationQueueRepo
+	AlertRuleRepo         repository.AlertRuleRepo
+	SilenceRepo           repository.SilenceRepo
+	Auth                  *authentication.Authenticator
+	InputDstChan          chan<- Dana.Metric
+	StartTime             time.Time
+
+	managed    managedInputs
+	plugins    managedPlugins
+	deliveries *notificationQueue
+	alerts     *alertEngine
 }
 
 // NewServer returns a Server for the given Config.
@@ -58,27 +75,152 @@ func NewServer(cfg *config.Config) *Server {
 
 	// Create repositories
 	inputRepo := repository.NewHandlerInputRepo(client, "db", "inputs")
+	pluginRepo := repository.NewHandlerPluginRepo(client, "db", "plugins")
 	userRepo := repository.NewUserRepo(client, "db", "users")
+	if err := userRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
 	dashboardRepo := repository.NewDashboardRepo(client, "db", "dashboards")
+	dashboardRevisionRepo := repository.NewDashboardRevisionRepo(client, "db", "dashboard_revisions")
+	if err := dashboardRevisionRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
 	folderRepo := repository.NewFolderRepo(client, "db", "folders")
 	notificationRepo := repository.NewNotificationRepo(client, "db", "notifications")
 	networkRepo := repository.NewNetworkRepo(client, "db", "networks")
+	if err := inputRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+	if err := pluginRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+	if err := dashboardRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+	if err := folderRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+	if err := networkRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+	tokenRepo := repository.NewTokenRepo(client, "db", "revoked_tokens")
+	if err := tokenRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	apiTokenRepo := repository.NewAPITokenRepo(client, "db", "api_tokens")
+	if err := apiTokenRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	auditRepo := repository.NewAuditRepo(client, "db", "audit_log")
+	if err := auditRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+	annotationRepo := repository.NewAnnotationRepo(client, "db", "annotations")
+	if err := annotationRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+	snapshotRepo := repository.NewSnapshotRepo(client, "db", "snapshots")
+	if err := snapshotRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+	notificationQueueRepo := repository.NewNotificationQueueRepo(client, "db", "notification_queue")
+	if err := notificationQueueRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+	alertRuleRepo := repository.NewAlertRuleRepo(client, "db", "alert_rules")
+	if err := alertRuleRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+	silenceRepo := repository.NewSilenceRepo(client, "db", "silences")
+	if err := silenceRepo.CreateIndexes(ctx); err != nil {
+		panic(err)
+	}
+
+	authOpts, err := authOptions(cfg.ServerConfig)
+	if err != nil {
+		panic(err)
+	}
+	if len(authOpts.Keys) == 0 {
+		log.Println("W! [agent] No jwt_keys configured, using a random signing key; tokens will not survive a restart")
+	}
+	auth, err := authentication.New(authOpts, tokenRepo, apiTokenRepo, userRepo)
+	if err != nil {
+		panic(err)
+	}
 
 	log.Println("Connected to MongoDB")
 	a := &Server{
-		Config: cfg,
-		echo:   echo.New(),
+		Config:     cfg,
+		echo:       echo.New(),
+		deliveries: newNotificationQueue(),
+		alerts:     newAlertEngine(),
 	}
 	a.UserRepo = userRepo
 	a.InputRepo = inputRepo
+	a.PluginRepo = pluginRepo
 	a.DashboardRepo = dashboardRepo
+	a.DashboardRevisionRepo = dashboardRevisionRepo
 	a.FolderRepo = folderRepo
 	a.NotificationRepo = notificationRepo
 	a.NetworkRepo = networkRepo
+	a.TokenRepo = tokenRepo
+	a.APITokenRepo = apiTokenRepo
+	a.AuditRepo = auditRepo
+	a.AnnotationRepo = annotationRepo
+	a.SnapshotRepo = snapshotRepo
+	a.NotificationQueueRepo = notificationQueueRepo
+	a.AlertRuleRepo = alertRuleRepo
+	a.SilenceRepo = silenceRepo
+	a.Auth = auth
+
+	migrateCtx, migrateCancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer migrateCancel()
+	if err := a.migrateFolders(migrateCtx); err != nil {
+		panic(err)
+	}
 
 	return a
 }
 
+// authOptions builds the token signing options from the server config,
+// resolving the key secrets.
+func authOptions(cfg *config.ServerConfig) (authentication.Options, error) {
+	opts := authentication.Options{
+		Keys:            make(map[string][]byte, len(cfg.JWTKeys)),
+		ActiveKey:       cfg.JWTActiveKey,
+		AccessTokenTTL:  time.Duration(cfg.AccessTokenTTL),
+		RefreshTokenTTL: time.Duration(cfg.RefreshTokenTTL),
+	}
+	for _, key := range cfg.JWTKeys {
+		if key.ID == "" {
+			return opts, errors.New("jwt key without id")
+		}
+		if _, found := opts.Keys[key.ID]; found {
+			return opts, fmt.Errorf("duplicate jwt key id %q", key.ID)
+		}
+		secret, err := key.Secret.Get()
+		if err != nil {
+			return opts, fmt.Errorf("getting jwt key %q failed: %w", key.ID, err)
+		}
+		if secret.Size() == 0 {
+			secret.Destroy()
+			return opts, fmt.Errorf("jwt key %q is empty", key.ID)
+		}
+		opts.Keys[key.ID] = append([]byte(nil), secret.Bytes()...)
+		secret.Destroy()
+	}
+	return opts, nil
+}
+
+const (
+	// defaultAPITokenDays is the lifetime of API tokens created without one
+	defaultAPITokenDays = 90
+	// maxAPITokenDays is the longest lifetime an API token can be given
+	maxAPITokenDays = 365
+)
+
 // inputUnit is a group of input plugins and the shared channel they write to.
 //
 // ┌───────┐
@@ -144,57 +286,158 @@ type aggregatorUnit struct {
 type outputUnit struct {
 	src     <-chan Dana.Metric
 	outputs []*models.RunningOutput
+
+	// The outputs can be changed through the API while metrics are written,
+	// so once the unit runs the fields below are guarded by the lock.
+	sync.RWMutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	flushers map[*models.RunningOutput]*outputFlusher
+	closed   bool
+}
+
+// outputFlusher controls the flush loop of a single output.
+type outputFlusher struct {
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 // Run starts and runs the Server until the context is done.
 func (a *Server) Run(ctx context.Context) error {
 	v1 := a.echo.Group("/api/v1")
-	v1.Use(authentication.ValidateJWT)
-	v1.GET("/query", a.Query)
-	v1.GET("/inputs", a.GetInput)
-	v1.GET("/orgs", a.Orgs)
-	v1.GET("/inputs/:type", a.GetInputByType)
-	v1.POST("/input/:type", a.PostInput)
-
-	// Add dashboard routes
-	v1.POST("/dashboards", a.CreateDashboard)
-	v1.GET("/dashboards/:id", a.GetDashboard)
-	v1.PUT("/dashboards/:id", a.UpdateDashboard)
-	v1.DELETE("/dashboards/:id", a.DeleteDashboard)
-	v1.GET("/dashboards", a.GetDashboards)
+	v1.Use(a.auditLog, a.Auth.ValidateJWT)
+
+	// Every authenticated user can read; editors may change dashboards,
+	// folders and notifications; admins manage the agent itself and users.
+	viewer := v1.Group("", authentication.RequireRole(model.RoleViewer))
+	editor := v1.Group("", authentication.RequireRole(model.RoleEditor))
+	admin := v1.Group("", authentication.RequireRole(model.RoleAdmin))
+
+	viewer.GET("/status", a.GetStatus)
+	viewer.GET("/query", a.Query)
+	viewer.GET("/inputs", a.GetInput)
+	viewer.GET("/orgs", a.Orgs)
+	viewer.GET("/inputs/:type", a.GetInputByType)
+	admin.POST("/input/:type", a.PostInput)
+	admin.POST("/input/:type/test", a.TestInput)
+	viewer.GET("/input/:type/:id", a.GetInputByID)
+	admin.PUT("/input/:type/:id", a.UpdateInput)
+	admin.DELETE("/input/:type/:id", a.DeleteInput)
+
+	// Outputs, processors and aggregators
+	viewer.GET("/plugins/:category", a.GetPlugins)
+	viewer.GET("/plugins/:category/:type", a.GetPluginsByType)
+	viewer.GET("/plugins/:category/:type/schema", a.GetPluginSchema)
+	admin.POST("/plugins/:category/:type", a.PostPlugin)
+	viewer.GET("/plugins/:category/:type/:id", a.GetPluginByID)
+	admin.PUT("/plugins/:category/:type/:id", a.UpdatePlugin)
+	admin.DELETE("/plugins/:category/:type/:id", a.DeletePlugin)
+
+	// Add dashboard routes. Access to a single dashboard also depends on the
+	// permissions of the folder holding it.
+	canView := a.requireDashboardRole(model.RoleViewer)
+	canEdit := a.requireDashboardRole(model.RoleEditor)
+	editor.POST("/dashboards", a.CreateDashboard)
+	editor.POST("/dashboards/import", a.ImportGrafanaDashboard)
+	viewer.GET("/dashboards/:id", a.GetDashboard, canView)
+	editor.PUT("/dashboards/:id", a.UpdateDashboard, canEdit)
+	editor.DELETE("/dashboards/:id", a.DeleteDashboard, canEdit)
+	viewer.GET("/dashboards", a.GetDashboards)
+	editor.POST("/dashboards/:id/move", a.MoveDashboard, canEdit)
+	viewer.GET("/dashboards/:id/export", a.ExportGrafanaDashboard, canView)
+	viewer.GET("/dashboards/:id/data", a.GetDashboardData, canView)
+	viewer.GET("/dashboards/:id/variables", a.GetDashboardVariables, canView)
+	viewer.GET("/dashboards/:id/revisions", a.GetDashboardRevisions, canView)
+	viewer.GET("/dashboards/:id/revisions/diff", a.DiffDashboardRevisions, canView)
+	viewer.GET("/dashboards/:id/revisions/:version", a.GetDashboardRevision, canView)
+	editor.POST("/dashboards/:id/revisions/:version/restore", a.RestoreDashboardRevision, canEdit)
+	editor.POST("/dashboards/:id/snapshot", a.CreateSnapshot, canEdit)
+	viewer.GET("/dashboards/:id/snapshots", a.GetSnapshots, canView)
+	editor.DELETE("/dashboards/:id/snapshots/:snapshotID", a.DeleteSnapshot, canEdit)
 
 	// Add folder routes
-	v1.POST("/folders", a.CreateFolder)
-	v1.GET("/folders/:id", a.GetFolder)
-	v1.PUT("/folders/:folderID/dashboards/:dashboardID", a.UpdateDashboardInFolder)
-	v1.DELETE("/folders/:id", a.DeleteFolder)
-	v1.GET("/folders", a.GetFolders)
-
-	v1.POST("/addnotification", a.AddNotification)
-	v1.GET("/notification/:channelName", a.GetNotification)
-	v1.DELETE("/notification/:channelName", a.DeleteNotification)
-	v1.POST("/notification", a.SendNotification)
-	v1.GET("/notificationEndpoints", a.NotificationEndpointsGet)
-	v1.GET("/notificationRules", a.NotificationRulesGet)
-	v1.GET("/checks", a.ChecksGet)
-	v1.POST("/notificationEndpoints", a.NotificationEndpointsPost)
-	v1.POST("/notificationRules", a.NotificationRulesPost)
-	v1.POST("/checks", a.ChecksPost)
-	v1.DELETE("/notificationEndpoints", a.NotificationEndpointsDelete)
-	v1.DELETE("/notificationRules", a.NotificationRulesDelete)
-	v1.DELETE("/checks", a.ChecksDelete)
+	canViewFolder := a.requireFolderRole(model.RoleViewer)
+	canEditFolder := a.requireFolderRole(model.RoleEditor)
+	editor.POST("/folders", a.CreateFolder)
+	viewer.GET("/folders/:id", a.GetFolder, canViewFolder)
+	editor.POST("/folders/:id/rename", a.RenameFolder, canEditFolder)
+	editor.POST("/folders/:id/move", a.MoveFolder, canEditFolder)
+	admin.PUT("/folders/:id/permissions", a.SetFolderPermissions)
+	editor.PUT("/folders/:folderID/dashboards/:dashboardID", a.UpdateDashboardInFolder)
+	editor.DELETE("/folders/:id", a.DeleteFolder, canEditFolder)
+	viewer.GET("/folders", a.GetFolders)
+
+	editor.POST("/addnotification", a.AddNotification)
+	viewer.GET("/notification/:channelName", a.GetNotification)
+	editor.DELETE("/notification/:channelName", a.DeleteNotification)
+	editor.POST("/notification", a.SendNotification)
+	viewer.GET("/notificationDrivers", a.GetNotificationDrivers)
+	viewer.GET("/notifications/history", a.GetNotificationHistory)
+	viewer.GET("/notifications/history/:id", a.GetNotificationDelivery)
+	editor.POST("/notification/preview", a.PreviewNotification)
+	viewer.GET("/notificationEndpoints", a.NotificationEndpointsGet)
+	viewer.GET("/notificationRules", a.NotificationRulesGet)
+	viewer.GET("/checks", a.ChecksGet)
+	editor.POST("/notificationEndpoints", a.NotificationEndpointsPost)
+	editor.POST("/notificationRules", a.NotificationRulesPost)
+	editor.POST("/checks", a.ChecksPost)
+	editor.DELETE("/notificationEndpoints", a.NotificationEndpointsDelete)
+	editor.DELETE("/notificationRules", a.NotificationRulesDelete)
+	editor.DELETE("/checks", a.ChecksDelete)
+
+	// Alert rules are evaluated by the agent itself on the metric stream
+	editor.POST("/alerts/rules", a.CreateAlertRule)
+	viewer.GET("/alerts/rules", a.GetAlertRules)
+	viewer.GET("/alerts/rules/:id", a.GetAlertRule)
+	editor.PUT("/alerts/rules/:id", a.UpdateAlertRule)
+	editor.DELETE("/alerts/rules/:id", a.DeleteAlertRule)
+	viewer.GET("/alerts/states", a.GetAlertStates)
+
+	// Silences suppress notifications sent by the agent's rules and
+	// forwarded from InfluxDB alike
+	editor.POST("/alerts/silences", a.CreateSilence)
+	viewer.GET("/alerts/silences", a.GetSilences)
+	viewer.GET("/alerts/silences/:id", a.GetSilence)
+	editor.PUT("/alerts/silences/:id", a.UpdateSilence)
+	editor.DELETE("/alerts/silences/:id", a.DeleteSilence)
 
 	//nmap
-	v1.POST("/addnetwork", a.AddNetwork)
-	v1.GET("/networks", a.GetNetworks)
-	v1.GET("/network/:name", a.GetNetwork)
-	v1.DELETE("/network/:name", a.DeleteNetwork)
-
-	v1.POST("/script", a.AddScript)
+	admin.POST("/addnetwork", a.AddNetwork)
+	viewer.GET("/networks", a.GetNetworks)
+	viewer.GET("/network/:name", a.GetNetwork)
+	admin.DELETE("/network/:name", a.DeleteNetwork)
+
+	admin.POST("/script", a.AddScript)
+
+	// Add user routes
+	admin.GET("/users", a.GetUsers)
+	admin.GET("/users/:id", a.GetUser)
+	admin.PUT("/users/:id", a.UpdateUser)
+	admin.DELETE("/users/:id", a.DeleteUser)
+	admin.POST("/users/:id/disable", a.DisableUser)
+	admin.POST("/users/:id/enable", a.EnableUser)
+	admin.PUT("/users/:id/password", a.ResetPassword)
+	viewer.PUT("/user/password", a.ChangePassword)
+	viewer.POST("/logout", a.Logout)
+	viewer.POST("/tokens", a.CreateAPIToken)
+	viewer.GET("/tokens", a.GetAPITokens)
+	viewer.DELETE("/tokens/:id", a.DeleteAPIToken)
+	admin.GET("/audit", a.GetAuditLog)
+
+	// Annotations scoped to a dashboard follow the dashboard's permissions
+	editor.POST("/annotations", a.CreateAnnotation)
+	viewer.GET("/annotations", a.GetAnnotations)
+	viewer.GET("/annotations/:id", a.GetAnnotation)
+	editor.PUT("/annotations/:id", a.UpdateAnnotation)
+	editor.DELETE("/annotations/:id", a.DeleteAnnotation)
 
 	a.echo.POST("/login", a.Login)
+	a.echo.POST("/refresh", a.Refresh)
 	a.echo.POST("/register", a.Register)
 	a.echo.GET("/health", a.HealthCheck)
+	// Snapshot links are public and only serve the stored results
+	a.echo.GET("/snapshots/:token", a.GetPublicSnapshot)
 
 	go func() { a.echo.Logger.Fatal(a.echo.Start("127.0.0.1:" + a.Config.ServerConfig.Port)) }()
 
@@ -217,6 +460,22 @@ func (a *Server) Run(ctx context.Context) error {
 		return err
 	}
 
+	log.Printf("D! [agent] Loading stored inputs")
+	storedInputs, err := a.loadStoredInputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("D! [agent] Loading stored plugins")
+	if err := a.loadStoredPlugins(ctx); err != nil {
+		return err
+	}
+
+	log.Printf("D! [agent] Loading alert rules")
+	if err := a.loadAlertRules(ctx); err != nil {
+		return err
+	}
+
 	if a.Config.Persister != nil {
 		log.Printf("D! [agent] Initializing plugin states")
 		if err := a.initPersister(); err != nil {
@@ -234,38 +493,28 @@ func (a *Server) Run(ctx context.Context) error {
 	a.StartTime = startTime
 
 	log.Printf("D! [agent] Connecting outputs")
-	next, ou, err := a.startOutputs(ctx, a.Config.Outputs)
+	next, ou, err := a.startOutputs(ctx, a.pipelineOutputs())
 	if err != nil {
 		return err
 	}
 
-	var apu []*processorUnit
-	var au *aggregatorUnit
-	if len(a.Config.Aggregators) != 0 {
-		aggC := next
-		if len(a.Config.AggProcessors) != 0 && !*a.Config.Agent.SkipProcessorsAfterAggregators {
-			aggC, apu, err = a.startProcessors(next, a.Config.AggProcessors)
-			if err != nil {
-				return err
-			}
-		}
-
-		next, au = a.startAggregators(aggC, next, a.Config.Aggregators)
-	}
-
-	var pu []*processorUnit
-	if len(a.Config.Processors) != 0 {
-		next, pu, err = a.startProcessors(next, a.Config.Processors)
-		if err != nil {
-			return err
-		}
+	// Processors and aggregators are restarted as a whole when they are
+	// changed through the API, so the inputs write to a relay instead.
+	if err := a.attachProcessing(ou, next, startTime); err != nil {
+		return err
 	}
+	inputC := make(chan Dana.Metric, 100)
 
-	a.InputDstChan = next
-	iu, err := a.startInputs(next, a.Config.Inputs)
+	iu, err := a.startInputs(inputC, a.Config.Inputs)
 	if err != nil {
 		return err
 	}
+	a.attachInputDst(ctx, inputC)
+	for id, inputs := range storedInputs {
+		if err := a.startManagedInputs(id, inputs); err != nil {
+			log.Printf("E! [agent] Starting stored input %s failed: %v", id, err)
+		}
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -274,32 +523,32 @@ func (a *Server) Run(ctx context.Context) error {
 		a.runOutputs(ou)
 	}()
 
-	if au != nil {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			a.runProcessors(apu)
-		}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.relayMetrics(inputC)
+	}()
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			a.runAggregators(startTime, au)
-		}()
-	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.runNotificationWorkers(ctx)
+	}()
 
-	if pu != nil {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			a.runProcessors(pu)
-		}()
-	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.runAlertRules(ctx)
+	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		a.runInputs(ctx, startTime, iu)
+
+		// Once all inputs are stopped the rest of the pipeline can drain
+		a.closeManagedInputs()
+		close(iu.dst)
 	}()
 
 	wg.Wait()
@@ -802,7 +1051,7 @@ func (a *Server) runAggregators(
 
 	// Before calling Add, initialize the aggregation window.  This ensures
 	// that any metric created after start time will be aggregated.
-	for _, agg := range a.Config.Aggregators {
+	for _, agg := range unit.aggregators {
 		since, until := updateWindow(startTime, a.Config.Agent.RoundInterval, agg.Period())
 		agg.UpdateWindow(since, until)
 	}
@@ -813,7 +1062,7 @@ func (a *Server) runAggregators(
 		defer wg.Done()
 		for metric := range unit.src {
 			var dropOriginal bool
-			for _, agg := range a.Config.Aggregators {
+			for _, agg := range unit.aggregators {
 				if ok := agg.Add(metric); ok {
 					dropOriginal = true
 				}
@@ -828,7 +1077,7 @@ func (a *Server) runAggregators(
 		cancel()
 	}()
 
-	for _, agg := range a.Config.Aggregators {
+	for _, agg := range unit.aggregators {
 		wg.Add(1)
 		go func(agg *models.RunningAggregator) {
 			defer wg.Done()
@@ -897,7 +1146,11 @@ func (a *Server) startOutputs(
 	outputs []*models.RunningOutput,
 ) (chan<- Dana.Metric, *outputUnit, error) {
 	src := make(chan Dana.Metric, 100)
-	unit := &outputUnit{src: src}
+	unit := &outputUnit{
+		src:      src,
+		flushers: make(map[*models.RunningOutput]*outputFlusher),
+	}
+	unit.ctx, unit.cancel = context.WithCancel(context.Background())
 	for _, output := range outputs {
 		if err := a.connectOutput(ctx, output); err != nil {
 			var fatalErr *internal.FatalError
@@ -944,39 +1197,19 @@ func (a *Server) connectOutput(ctx context.Context, output *models.RunningOutput
 func (a *Server) runOutputs(
 	unit *outputUnit,
 ) {
-	var wg sync.WaitGroup
-
-	// Start flush loop
-	interval := time.Duration(a.Config.Agent.FlushInterval)
-	jitter := time.Duration(a.Config.Agent.FlushJitter)
-
-	ctx, cancel := context.WithCancel(context.Background())
-
+	unit.Lock()
 	for _, output := range unit.outputs {
-		interval := interval
-		// Overwrite agent flush_interval if this plugin has its own.
-		if output.Config.FlushInterval != 0 {
-			interval = output.Config.FlushInterval
-		}
-
-		jitter := jitter
-		// Overwrite agent flush_jitter if this plugin has its own.
-		if output.Config.FlushJitter != 0 {
-			jitter = output.Config.FlushJitter
-		}
-
-		wg.Add(1)
-		go func(output *models.RunningOutput) {
-			defer wg.Done()
-
-			ticker := NewRollingTicker(interval, jitter)
-			defer ticker.Stop()
-
-			a.flushLoop(ctx, output, ticker)
-		}(output)
+		a.startFlushLoop(unit, output)
 	}
+	unit.Unlock()
 
 	for metric := range unit.src {
+		a.alerts.observe(metric)
+
+		unit.RLock()
+		if len(unit.outputs) == 0 {
+			metric.Drop()
+		}
 		for i, output := range unit.outputs {
 			if i == len(unit.outputs)-1 {
 				output.AddMetricNoCopy(metric)
@@ -984,16 +1217,54 @@ func (a *Server) runOutputs(
 				output.AddMetric(metric)
 			}
 		}
+		unit.RUnlock()
 	}
 
 	log.Println("I! [agent] Hang on, flushing any cached metrics before shutdown")
-	cancel()
-	wg.Wait()
+	unit.Lock()
+	unit.closed = true
+	unit.Unlock()
+	unit.cancel()
+	unit.wg.Wait()
 
 	log.Println("I! [agent] Stopping running outputs")
 	stopRunningOutputs(unit.outputs)
 }
 
+// startFlushLoop starts flushing the output periodically until the unit or
+// the returned flusher is stopped. The unit must be locked.
+func (a *Server) startFlushLoop(unit *outputUnit, output *models.RunningOutput) {
+	// Overwrite agent flush_interval if this plugin has its own.
+	interval := time.Duration(a.Config.Agent.FlushInterval)
+	if output.Config.FlushInterval != 0 {
+		interval = output.Config.FlushInterval
+	}
+
+	// Overwrite agent flush_jitter if this plugin has its own.
+	jitter := time.Duration(a.Config.Agent.FlushJitter)
+	if output.Config.FlushJitter != 0 {
+		jitter = output.Config.FlushJitter
+	}
+
+	ctx, cancel := context.WithCancel(unit.ctx)
+	flusher := &outputFlusher{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	unit.flushers[output] = flusher
+
+	unit.wg.Add(1)
+	go func() {
+		defer unit.wg.Done()
+		defer close(flusher.done)
+
+		ticker := NewRollingTicker(interval, jitter)
+		defer ticker.Stop()
+
+		a.flushLoop(ctx, output, ticker)
+	}()
+}
+
 // flushLoop runs an output's flush function periodically until the context is
 // done.
 func (a *Server) flushLoop(
@@ -0,0 +1,485 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"Dana"
+	"Dana/agent/model"
+	"Dana/agent/notification"
+	"Dana/metric"
+)
+
+// Settings of the alert rule engine. Metrics are evaluated by a single
+// goroutine; if it falls behind by more than alertSampleBuffer metrics the
+// excess is not evaluated.
+const (
+	alertMeasurement  = "dana_alerts"
+	alertSampleBuffer = 1000
+	deadmanInterval   = 5 * time.Second
+)
+
+// alertLevelCodes are written along with the level, so levels can be
+// graphed and compared.
+var alertLevelCodes = map[string]int64{
+	model.AlertOK:   0,
+	model.AlertWarn: 1,
+	model.AlertCrit: 2,
+}
+
+// alertEngine evaluates the alert rules on the metrics passed to the
+// outputs, after the processors and aggregators ran. The outputs hand over
+// metrics without waiting, so a slow channel never holds up the pipeline.
+type alertEngine struct {
+	samples      chan *alertSample
+	measurements atomic.Pointer[map[string]bool]
+	dropped      atomic.Uint64
+
+	sync.Mutex
+	rules map[primitive.ObjectID]*ruleEval
+}
+
+// alertSample is the part of a metric the rules are evaluated on.
+type alertSample struct {
+	name   string
+	tags   map[string]string
+	fields map[string]interface{}
+	time   time.Time
+}
+
+// ruleEval is an enabled rule and the state of its groups.
+type ruleEval struct {
+	rule      *model.AlertRule
+	warnAfter time.Duration
+	critAfter time.Duration
+	groups    map[string]*groupEval
+}
+
+// groupEval is the state of a group of a rule. prev and prevTime hold the
+// previous value of rate rules.
+type groupEval struct {
+	tags     map[string]string
+	level    string
+	value    float64
+	since    time.Time
+	lastSeen time.Time
+	prev     float64
+	prevTime time.Time
+}
+
+// alertTransition is a change of the level of a group.
+type alertTransition struct {
+	rule     *model.AlertRule
+	state    model.AlertState
+	previous string
+	message  string
+}
+
+func newAlertEngine() *alertEngine {
+	e := &alertEngine{
+		samples: make(chan *alertSample, alertSampleBuffer),
+		rules:   make(map[primitive.ObjectID]*ruleEval),
+	}
+	e.measurements.Store(&map[string]bool{})
+	return e
+}
+
+// observe hands a metric passed to the outputs to the engine if a rule uses
+// its measurement. It never blocks.
+func (e *alertEngine) observe(m Dana.Metric) {
+	if !(*e.measurements.Load())[m.Name()] || m.Name() == alertMeasurement {
+		return
+	}
+	sample := &alertSample{
+		name:   m.Name(),
+		tags:   m.Tags(),
+		fields: m.Fields(),
+		time:   m.Time(),
+	}
+	select {
+	case e.samples <- sample:
+	default:
+		if n := e.dropped.Add(1); n%alertSampleBuffer == 1 {
+			log.Printf("W! [agent] Alert rules fall behind, %d metrics were not evaluated", n)
+		}
+	}
+}
+
+// setRule starts evaluating a rule or replaces it. Groups are kept as long
+// as the rule selects the same series, so changing the conditions does not
+// reset the levels.
+func (e *alertEngine) setRule(rule *model.AlertRule, now time.Time) {
+	e.Lock()
+	defer e.Unlock()
+	defer e.updateMeasurements()
+
+	previous, found := e.rules[rule.ID]
+	if rule.Disabled {
+		delete(e.rules, rule.ID)
+		return
+	}
+
+	r := &ruleEval{rule: rule, groups: make(map[string]*groupEval)}
+	if rule.Type == model.AlertDeadman {
+		if rule.Warn != nil {
+			r.warnAfter, _ = time.ParseDuration(rule.Warn.After)
+		}
+		if rule.Crit != nil {
+			r.critAfter, _ = time.ParseDuration(rule.Crit.After)
+		}
+	}
+	if found && sameSeries(previous.rule, rule) {
+		r.groups = previous.groups
+	} else if rule.Type == model.AlertDeadman && len(rule.GroupBy) == 0 {
+		// Without groups the rule knows what to expect before any data
+		// arrived, so it alerts even if the series never shows up
+		r.groups[""] = &groupEval{level: model.AlertOK, since: now, lastSeen: now}
+	}
+	e.rules[rule.ID] = r
+}
+
+// removeRule stops evaluating a rule.
+func (e *alertEngine) removeRule(id primitive.ObjectID) {
+	e.Lock()
+	defer e.Unlock()
+
+	delete(e.rules, id)
+	e.updateMeasurements()
+}
+
+// updateMeasurements publishes the measurements used by the rules to
+// observe. The lock must be held.
+func (e *alertEngine) updateMeasurements() {
+	measurements := make(map[string]bool, len(e.rules))
+	for _, r := range e.rules {
+		measurements[r.rule.Measurement] = true
+	}
+	e.measurements.Store(&measurements)
+}
+
+// sameSeries reports whether two rules evaluate the same series the same
+// way.
+func sameSeries(a, b *model.AlertRule) bool {
+	return a.Type == b.Type && a.Measurement == b.Measurement && a.Field == b.Field &&
+		maps.Equal(a.Tags, b.Tags) && slices.Equal(a.GroupBy, b.GroupBy)
+}
+
+// evaluate evaluates the rules of the sample's measurement and returns the
+// resulting changes of levels.
+func (e *alertEngine) evaluate(s *alertSample, now time.Time) []alertTransition {
+	e.Lock()
+	defer e.Unlock()
+
+	var transitions []alertTransition
+	for _, r := range e.rules {
+		rule := r.rule
+		if rule.Measurement != s.name || !matchTags(rule.Tags, s.tags) {
+			continue
+		}
+		value, ok := fieldValue(s.fields[rule.Field])
+		if !ok {
+			continue
+		}
+
+		key, tags := groupKey(rule.GroupBy, s.tags)
+		g, found := r.groups[key]
+		if !found {
+			g = &groupEval{tags: tags, level: model.AlertOK, since: now}
+			r.groups[key] = g
+		}
+		g.lastSeen = now
+
+		var level, message string
+		field := rule.Measurement + "." + rule.Field
+		switch rule.Type {
+		case model.AlertThreshold:
+			g.value = value
+			level, message = conditionLevel(rule, value, fmt.Sprintf("%s is %.6g", field, value))
+		case model.AlertRate:
+			prev, prevTime := g.prev, g.prevTime
+			g.prev, g.prevTime = value, s.time
+			elapsed := s.time.Sub(prevTime).Seconds()
+			if prevTime.IsZero() || elapsed <= 0 {
+				continue
+			}
+			if rule.Counter && value < prev {
+				// The counter was reset, the rate is taken again from the
+				// next sample on
+				continue
+			}
+			rate := (value - prev) / elapsed
+			g.value = rate
+			level, message = conditionLevel(rule, rate, fmt.Sprintf("%s changes by %.6g/s", field, rate))
+		case model.AlertDeadman:
+			g.value = 0
+			level, message = model.AlertOK, fmt.Sprintf("%s is received again", field)
+		}
+		if t, changed := r.transition(g, level, message, now); changed {
+			transitions = append(transitions, t)
+		}
+	}
+	return transitions
+}
+
+// checkDeadman raises the level of deadman groups that stopped sending.
+func (e *alertEngine) checkDeadman(now time.Time) []alertTransition {
+	e.Lock()
+	defer e.Unlock()
+
+	var transitions []alertTransition
+	for _, r := range e.rules {
+		if r.rule.Type != model.AlertDeadman {
+			continue
+		}
+		for _, g := range r.groups {
+			silent := now.Sub(g.lastSeen)
+			level := model.AlertOK
+			after := time.Duration(0)
+			switch {
+			case r.critAfter > 0 && silent >= r.critAfter:
+				level, after = model.AlertCrit, r.critAfter
+			case r.warnAfter > 0 && silent >= r.warnAfter:
+				level, after = model.AlertWarn, r.warnAfter
+			}
+			if level == model.AlertOK {
+				continue
+			}
+			g.value = silent.Seconds()
+			message := fmt.Sprintf("no %s.%s received for %s", r.rule.Measurement, r.rule.Field, after)
+			if t, changed := r.transition(g, level, message, now); changed {
+				transitions = append(transitions, t)
+			}
+		}
+	}
+	return transitions
+}
+
+// transition sets the level of a group and reports whether it changed.
+func (r *ruleEval) transition(g *groupEval, level, message string, now time.Time) (alertTransition, bool) {
+	if level == g.level {
+		return alertTransition{}, false
+	}
+	previous := g.level
+	g.level, g.since = level, now
+	return alertTransition{
+		rule:     r.rule,
+		state:    r.state(g),
+		previous: previous,
+		message:  message,
+	}, true
+}
+
+func (r *ruleEval) state(g *groupEval) model.AlertState {
+	return model.AlertState{
+		RuleID:   r.rule.ID,
+		RuleName: r.rule.Name,
+		Tags:     g.tags,
+		Level:    g.level,
+		Value:    g.value,
+		Since:    g.since,
+		LastSeen: g.lastSeen,
+	}
+}
+
+// states returns the levels of all groups, by rule name and tags.
+func (e *alertEngine) states() []model.AlertState {
+	e.Lock()
+	defer e.Unlock()
+
+	states := make([]model.AlertState, 0, len(e.rules))
+	for _, r := range e.rules {
+		for _, g := range r.groups {
+			states = append(states, r.state(g))
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].RuleName != states[j].RuleName {
+			return states[i].RuleName < states[j].RuleName
+		}
+		return groupString(states[i].Tags) < groupString(states[j].Tags)
+	})
+	return states
+}
+
+// conditionLevel returns the level of a threshold or rate rule for a value
+// and describes the value.
+func conditionLevel(rule *model.AlertRule, value float64, description string) (string, string) {
+	switch {
+	case rule.Crit != nil && rule.Crit.Match(value):
+		return model.AlertCrit, fmt.Sprintf("%s, crit when %s %.6g", description, rule.Crit.Op, rule.Crit.Value)
+	case rule.Warn != nil && rule.Warn.Match(value):
+		return model.AlertWarn, fmt.Sprintf("%s, warn when %s %.6g", description, rule.Warn.Op, rule.Warn.Value)
+	}
+	return model.AlertOK, description
+}
+
+// matchTags reports whether a series has all the given tag values.
+func matchTags(want, tags map[string]string) bool {
+	for key, value := range want {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// groupKey returns the key and tags of the group a series belongs to.
+func groupKey(groupBy []string, tags map[string]string) (string, map[string]string) {
+	group := make(map[string]string, len(groupBy))
+	for _, key := range groupBy {
+		if value, found := tags[key]; found {
+			group[key] = value
+		}
+	}
+	return groupString(group), group
+}
+
+func groupString(tags map[string]string) string {
+	keys := slices.Sorted(maps.Keys(tags))
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(tags[key])
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+// fieldValue converts a numeric or boolean field value to a float.
+func fieldValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// checkAlertRule validates a rule and checks that its channels exist.
+// Invalid rules are reported as *echo.HTTPError.
+func (a *Server) checkAlertRule(ctx context.Context, rule *model.AlertRule) error {
+	if err := rule.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	for _, channel := range rule.Channels {
+		if _, err := a.NotificationRepo.GetNotification(ctx, channel); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("channel %q not found", channel))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAlertRules starts evaluating the stored alert rules.
+func (a *Server) loadAlertRules(ctx context.Context) error {
+	rules, err := a.AlertRuleRepo.GetRules(ctx)
+	if err != nil {
+		return fmt.Errorf("loading alert rules: %w", err)
+	}
+	now := time.Now()
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			log.Printf("E! [agent] Skipping alert rule %q: %v", rule.Name, err)
+			continue
+		}
+		a.alerts.setRule(rule, now)
+	}
+	return nil
+}
+
+// runAlertRules evaluates the alert rules until the context is done.
+func (a *Server) runAlertRules(ctx context.Context) {
+	ticker := time.NewTicker(deadmanInterval)
+	defer ticker.Stop()
+
+	for {
+		var transitions []alertTransition
+		select {
+		case <-ctx.Done():
+			return
+		case sample := <-a.alerts.samples:
+			transitions = a.alerts.evaluate(sample, time.Now())
+		case now := <-ticker.C:
+			transitions = a.alerts.checkDeadman(now)
+		}
+		for _, t := range transitions {
+			a.alertChanged(ctx, t)
+		}
+	}
+}
+
+// alertChanged writes a change of level as a metric and sends it to the
+// channels of the rule.
+func (a *Server) alertChanged(ctx context.Context, t alertTransition) {
+	log.Printf("I! [agent] Alert rule %q%s changed from %s to %s: %s",
+		t.rule.Name, groupSuffix(t.state.Tags), t.previous, t.state.Level, t.message)
+
+	tags := make(map[string]string, len(t.state.Tags)+3)
+	for key, value := range t.state.Tags {
+		tags[key] = value
+	}
+	tags["rule"] = t.rule.Name
+	tags["rule_id"] = t.rule.ID.Hex()
+	tags["type"] = t.rule.Type
+	a.emitMetric(metric.New(alertMeasurement, tags, map[string]interface{}{
+		"level":          t.state.Level,
+		"level_code":     alertLevelCodes[t.state.Level],
+		"previous_level": t.previous,
+		"value":          t.state.Value,
+		"message":        t.message,
+	}, t.state.Since))
+
+	for _, channel := range t.rule.Channels {
+		alert := &notification.Alert{
+			CheckName: t.rule.Name,
+			Level:     t.state.Level,
+			Message:   t.message,
+			Time:      t.state.Since,
+			Fields: map[string]interface{}{
+				"_value":          t.state.Value,
+				"_previous_level": t.previous,
+				"_rule_id":        t.rule.ID.Hex(),
+				"_type":           t.rule.Type,
+				"_measurement":    t.rule.Measurement,
+				"_field":          t.rule.Field,
+			},
+			Tags:    t.state.Tags,
+			Channel: channel,
+		}
+		if _, err := a.queueAlert(ctx, channel, alert, ""); err != nil {
+			log.Printf("E! [agent] Queueing alert of rule %q to %q failed: %v", t.rule.Name, channel, err)
+		}
+	}
+}
+
+func groupSuffix(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return " [" + strings.TrimSuffix(groupString(tags), ",") + "]"
+}
@@ -0,0 +1,339 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"Dana/agent/model"
+)
+
+// alertStep is either a sample evaluated at the given offset or, without
+// fields, a check of the deadman rules. expected lists the transitions as
+// "<group><previous>-><level> <value>".
+type alertStep struct {
+	at       time.Duration
+	tags     map[string]string
+	fields   map[string]interface{}
+	expected []string
+}
+
+func runAlertSteps(t *testing.T, rule *model.AlertRule, steps []alertStep) {
+	t.Helper()
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rule.ID = primitive.NewObjectID()
+	require.NoError(t, rule.Validate())
+
+	e := newAlertEngine()
+	e.setRule(rule, start)
+	for i, step := range steps {
+		now := start.Add(step.at)
+		var transitions []alertTransition
+		if step.fields == nil {
+			transitions = e.checkDeadman(now)
+		} else {
+			transitions = e.evaluate(&alertSample{name: "cpu", tags: step.tags, fields: step.fields, time: now}, now)
+		}
+
+		actual := make([]string, 0, len(transitions))
+		for _, tr := range transitions {
+			require.Equal(t, now, tr.state.Since)
+			actual = append(actual, fmt.Sprintf("%s%s->%s %.6g", groupString(tr.state.Tags), tr.previous, tr.state.Level, tr.state.Value))
+		}
+		if step.expected == nil {
+			step.expected = []string{}
+		}
+		require.Equal(t, step.expected, actual, "step %d at %s", i, step.at)
+	}
+}
+
+func TestAlertThreshold(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  model.AlertRule
+		steps []alertStep
+	}{
+		{
+			name: "levels",
+			rule: model.AlertRule{
+				Warn: &model.AlertCondition{Op: ">", Value: 80},
+				Crit: &model.AlertCondition{Op: ">", Value: 90},
+			},
+			steps: []alertStep{
+				{at: 0, fields: map[string]interface{}{"usage": 50.0}},
+				{at: 10 * time.Second, fields: map[string]interface{}{"usage": 85.0}, expected: []string{"ok->warn 85"}},
+				{at: 20 * time.Second, fields: map[string]interface{}{"usage": 87.0}},
+				{at: 30 * time.Second, fields: map[string]interface{}{"usage": 95.0}, expected: []string{"warn->crit 95"}},
+				{at: 40 * time.Second, fields: map[string]interface{}{"usage": 80.0}, expected: []string{"crit->ok 80"}},
+			},
+		},
+		{
+			name: "crit only",
+			rule: model.AlertRule{
+				Crit: &model.AlertCondition{Op: "<=", Value: 10},
+			},
+			steps: []alertStep{
+				{at: 0, fields: map[string]interface{}{"usage": int64(10)}, expected: []string{"ok->crit 10"}},
+				{at: time.Second, fields: map[string]interface{}{"usage": uint64(11)}, expected: []string{"crit->ok 11"}},
+			},
+		},
+		{
+			name: "boolean field",
+			rule: model.AlertRule{
+				Crit: &model.AlertCondition{Op: "==", Value: 1},
+			},
+			steps: []alertStep{
+				{at: 0, fields: map[string]interface{}{"usage": false}},
+				{at: time.Second, fields: map[string]interface{}{"usage": true}, expected: []string{"ok->crit 1"}},
+			},
+		},
+		{
+			name: "missing data keeps the level",
+			rule: model.AlertRule{
+				Crit: &model.AlertCondition{Op: ">", Value: 90},
+			},
+			steps: []alertStep{
+				{at: 0, fields: map[string]interface{}{"usage": 95.0}, expected: []string{"ok->crit 95"}},
+				{at: time.Second, fields: map[string]interface{}{"other": 10.0}},
+				{at: 2 * time.Second, fields: map[string]interface{}{"usage": "10"}},
+				{at: 3 * time.Second},
+				{at: 4 * time.Second, fields: map[string]interface{}{"usage": 10.0}, expected: []string{"crit->ok 10"}},
+			},
+		},
+		{
+			name: "tags and groups",
+			rule: model.AlertRule{
+				Tags:    map[string]string{"cpu": "cpu-total"},
+				GroupBy: []string{"host"},
+				Warn:    &model.AlertCondition{Op: ">", Value: 80},
+			},
+			steps: []alertStep{
+				{at: 0, tags: map[string]string{"cpu": "cpu0", "host": "a"}, fields: map[string]interface{}{"usage": 95.0}},
+				{at: time.Second, tags: map[string]string{"cpu": "cpu-total", "host": "a"}, fields: map[string]interface{}{"usage": 95.0},
+					expected: []string{"host=a,ok->warn 95"}},
+				{at: 2 * time.Second, tags: map[string]string{"cpu": "cpu-total", "host": "b"}, fields: map[string]interface{}{"usage": 50.0}},
+				{at: 3 * time.Second, tags: map[string]string{"cpu": "cpu-total", "host": "b"}, fields: map[string]interface{}{"usage": 85.0},
+					expected: []string{"host=b,ok->warn 85"}},
+				{at: 4 * time.Second, tags: map[string]string{"cpu": "cpu-total", "host": "a"}, fields: map[string]interface{}{"usage": 50.0},
+					expected: []string{"host=a,warn->ok 50"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name, tt.rule.Type, tt.rule.Measurement, tt.rule.Field = tt.name, model.AlertThreshold, "cpu", "usage"
+			runAlertSteps(t, &tt.rule, tt.steps)
+		})
+	}
+}
+
+func TestAlertRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  model.AlertRule
+		steps []alertStep
+	}{
+		{
+			name: "rate per second",
+			rule: model.AlertRule{
+				Warn: &model.AlertCondition{Op: ">", Value: 2},
+				Crit: &model.AlertCondition{Op: ">", Value: 5},
+			},
+			steps: []alertStep{
+				// The first sample only sets the starting point
+				{at: 0, fields: map[string]interface{}{"usage": 1000.0}},
+				{at: 10 * time.Second, fields: map[string]interface{}{"usage": 1100.0}, expected: []string{"ok->crit 10"}},
+				// Samples without time passing are skipped
+				{at: 10 * time.Second, fields: map[string]interface{}{"usage": 5000.0}},
+				{at: 20 * time.Second, fields: map[string]interface{}{"usage": 5030.0}, expected: []string{"crit->warn 3"}},
+				{at: 40 * time.Second, fields: map[string]interface{}{"usage": 5050.0}, expected: []string{"warn->ok 1"}},
+			},
+		},
+		{
+			name: "missing data is skipped",
+			rule: model.AlertRule{
+				Crit: &model.AlertCondition{Op: ">=", Value: 5},
+			},
+			steps: []alertStep{
+				{at: 0, fields: map[string]interface{}{"usage": int64(0)}},
+				{at: 10 * time.Second, fields: map[string]interface{}{"other": int64(500)}},
+				{at: 20 * time.Second, fields: map[string]interface{}{"usage": int64(100)}, expected: []string{"ok->crit 5"}},
+			},
+		},
+		{
+			name: "counter reset",
+			rule: model.AlertRule{
+				Counter: true,
+				Warn:    &model.AlertCondition{Op: "<", Value: 0},
+				Crit:    &model.AlertCondition{Op: ">", Value: 5},
+			},
+			steps: []alertStep{
+				{at: 0, fields: map[string]interface{}{"usage": uint64(1000)}},
+				{at: 10 * time.Second, fields: map[string]interface{}{"usage": uint64(1010)}},
+				// The counter restarted, which is neither a negative rate
+				// nor a change of level
+				{at: 20 * time.Second, fields: map[string]interface{}{"usage": uint64(5)}},
+				{at: 30 * time.Second, fields: map[string]interface{}{"usage": uint64(105)}, expected: []string{"ok->crit 10"}},
+				{at: 40 * time.Second, fields: map[string]interface{}{"usage": uint64(0)}},
+				{at: 50 * time.Second, fields: map[string]interface{}{"usage": uint64(10)}, expected: []string{"crit->ok 1"}},
+			},
+		},
+		{
+			name: "gauge decreasing",
+			rule: model.AlertRule{
+				Warn: &model.AlertCondition{Op: "<", Value: -5},
+			},
+			steps: []alertStep{
+				{at: 0, fields: map[string]interface{}{"usage": 1000.0}},
+				{at: 10 * time.Second, fields: map[string]interface{}{"usage": 900.0}, expected: []string{"ok->warn -10"}},
+				{at: 20 * time.Second, fields: map[string]interface{}{"usage": 900.0}, expected: []string{"warn->ok 0"}},
+			},
+		},
+		{
+			name: "groups have their own rate",
+			rule: model.AlertRule{
+				GroupBy: []string{"host"},
+				Crit:    &model.AlertCondition{Op: ">", Value: 5},
+			},
+			steps: []alertStep{
+				{at: 0, tags: map[string]string{"host": "a"}, fields: map[string]interface{}{"usage": 0.0}},
+				{at: 5 * time.Second, tags: map[string]string{"host": "b"}, fields: map[string]interface{}{"usage": 1000.0}},
+				{at: 10 * time.Second, tags: map[string]string{"host": "a"}, fields: map[string]interface{}{"usage": 100.0},
+					expected: []string{"host=a,ok->crit 10"}},
+				{at: 15 * time.Second, tags: map[string]string{"host": "b"}, fields: map[string]interface{}{"usage": 1010.0}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name, tt.rule.Type, tt.rule.Measurement, tt.rule.Field = tt.name, model.AlertRate, "cpu", "usage"
+			runAlertSteps(t, &tt.rule, tt.steps)
+		})
+	}
+}
+
+func TestAlertDeadman(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  model.AlertRule
+		steps []alertStep
+	}{
+		{
+			name: "alerts without any data",
+			rule: model.AlertRule{
+				Warn: &model.AlertCondition{After: "1m"},
+				Crit: &model.AlertCondition{After: "5m"},
+			},
+			steps: []alertStep{
+				{at: 30 * time.Second},
+				{at: time.Minute, expected: []string{"ok->warn 60"}},
+				{at: 2 * time.Minute},
+				{at: 5 * time.Minute, expected: []string{"warn->crit 300"}},
+				{at: 6 * time.Minute, fields: map[string]interface{}{"usage": 1.0}, expected: []string{"crit->ok 0"}},
+				{at: 6*time.Minute + 30*time.Second},
+				{at: 7 * time.Minute, expected: []string{"ok->warn 60"}},
+			},
+		},
+		{
+			name: "data resets the timer",
+			rule: model.AlertRule{
+				Crit: &model.AlertCondition{After: "1m"},
+			},
+			steps: []alertStep{
+				{at: 50 * time.Second, fields: map[string]interface{}{"usage": 1.0}},
+				{at: 100 * time.Second},
+				// Metrics without the field do not count
+				{at: 105 * time.Second, fields: map[string]interface{}{"other": 1.0}},
+				{at: 110 * time.Second, expected: []string{"ok->crit 60"}},
+			},
+		},
+		{
+			name: "groups are only known once seen",
+			rule: model.AlertRule{
+				GroupBy: []string{"host"},
+				Warn:    &model.AlertCondition{After: "1m"},
+			},
+			steps: []alertStep{
+				{at: 10 * time.Minute},
+				{at: 10 * time.Minute, tags: map[string]string{"host": "a"}, fields: map[string]interface{}{"usage": 1.0}},
+				{at: 10*time.Minute + 30*time.Second, tags: map[string]string{"host": "b"}, fields: map[string]interface{}{"usage": 1.0}},
+				{at: 11 * time.Minute, expected: []string{"host=a,ok->warn 60"}},
+				{at: 11*time.Minute + 20*time.Second, tags: map[string]string{"host": "a"}, fields: map[string]interface{}{"usage": 1.0},
+					expected: []string{"host=a,warn->ok 0"}},
+				{at: 11*time.Minute + 30*time.Second, expected: []string{"host=b,ok->warn 60"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name, tt.rule.Type, tt.rule.Measurement, tt.rule.Field = tt.name, model.AlertDeadman, "cpu", "usage"
+			runAlertSteps(t, &tt.rule, tt.steps)
+		})
+	}
+}
+
+func TestAlertSetRuleKeepsLevels(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rule := &model.AlertRule{
+		ID:          primitive.NewObjectID(),
+		Name:        "cpu",
+		Type:        model.AlertThreshold,
+		Measurement: "cpu",
+		Field:       "usage",
+		Crit:        &model.AlertCondition{Op: ">", Value: 90},
+	}
+
+	e := newAlertEngine()
+	e.setRule(rule, now)
+	require.Len(t, e.evaluate(&alertSample{name: "cpu", fields: map[string]interface{}{"usage": 95.0}, time: now}, now), 1)
+
+	// Changing the condition keeps the level of the groups
+	changed := *rule
+	changed.Crit = &model.AlertCondition{Op: ">", Value: 99}
+	e.setRule(&changed, now)
+	states := e.states()
+	require.Len(t, states, 1)
+	require.Equal(t, model.AlertCrit, states[0].Level)
+
+	// Selecting other series starts over
+	other := changed
+	other.Field = "usage_user"
+	e.setRule(&other, now)
+	require.Empty(t, e.states())
+
+	disabled := other
+	disabled.Disabled = true
+	e.setRule(&disabled, now)
+	require.Empty(t, e.evaluate(&alertSample{name: "cpu", fields: map[string]interface{}{"usage_user": 100.0}, time: now}, now))
+}
+
+func TestAlertRuleValidate(t *testing.T) {
+	valid := func() *model.AlertRule {
+		return &model.AlertRule{
+			Name:        "cpu",
+			Type:        model.AlertThreshold,
+			Measurement: "cpu",
+			Field:       "usage",
+			Crit:        &model.AlertCondition{Op: ">", Value: 90},
+		}
+	}
+	require.NoError(t, valid().Validate())
+
+	tests := map[string]func(r *model.AlertRule){
+		"unknown type":         func(r *model.AlertRule) { r.Type = "anomaly" },
+		"unknown op":           func(r *model.AlertRule) { r.Crit.Op = "=>" },
+		"no condition":         func(r *model.AlertRule) { r.Crit = nil },
+		"after on threshold":   func(r *model.AlertRule) { r.Crit.After = "1m" },
+		"deadman without time": func(r *model.AlertRule) { r.Type = model.AlertDeadman },
+		"counter on threshold": func(r *model.AlertRule) { r.Counter = true },
+	}
+	for name, change := range tests {
+		r := valid()
+		change(r)
+		require.Error(t, r.Validate(), name)
+	}
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"Dana/agent/model"
+)
+
+// errAnnotationDenied is returned when the caller may not change annotations
+// of the dashboard an annotation is scoped to.
+var errAnnotationDenied = errors.New("not allowed to annotate this dashboard")
+
+// checkAnnotationScope checks that the dashboard and panel an annotation is
+// scoped to exist and that the user may edit the dashboard. Invalid scopes
+// are reported as *echo.HTTPError.
+func (a *Server) checkAnnotationScope(ctx context.Context, annotation *model.Annotation, username string, role model.Role) error {
+	if annotation.DashboardID == nil {
+		return nil
+	}
+
+	dashboard, err := a.DashboardRepo.GetDashboard(ctx, annotation.DashboardID.Hex())
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return &echo.HTTPError{Code: 400, Message: fmt.Sprintf("dashboard_id: dashboard %s not found", annotation.DashboardID.Hex())}
+		}
+		return err
+	}
+	if annotation.PanelIndex != nil && !hasPanel(dashboard, *annotation.PanelIndex) {
+		return &echo.HTTPError{Code: 400, Message: fmt.Sprintf("panel_index: dashboard has no panel %d", *annotation.PanelIndex)}
+	}
+
+	return a.checkAnnotationRole(ctx, annotation, username, role, model.RoleEditor)
+}
+
+// checkAnnotationRole returns errAnnotationDenied unless the user's role on
+// the dashboard the annotation is scoped to is at least min. Annotations
+// applying to every dashboard only depend on the user's own role.
+func (a *Server) checkAnnotationRole(ctx context.Context, annotation *model.Annotation, username string, role, min model.Role) error {
+	if annotation.DashboardID != nil {
+		tree, err := a.loadFolderTree(ctx)
+		if err != nil {
+			return err
+		}
+		role = tree.dashboardRole(*annotation.DashboardID, username, role)
+	}
+	if role.Level() < min.Level() {
+		return errAnnotationDenied
+	}
+	return nil
+}
+
+// notificationAnnotation returns the annotation recording a notification
+// sent now.
+func notificationAnnotation(notif *model.Notification, author string) *model.Annotation {
+	tags := []string{"notification"}
+	if notif.Level != "" {
+		tags = append(tags, notif.Level)
+	}
+	tags = append(tags, notif.AnnotationTags...)
+
+	text := notif.Message
+	if notif.CheckName != "" {
+		text = notif.CheckName + ": " + text
+	}
+
+	now := time.Now()
+	return &model.Annotation{
+		Time:        now,
+		Text:        text,
+		Tags:        tags,
+		DashboardID: notif.DashboardID,
+		PanelIndex:  notif.PanelIndex,
+		Author:      author,
+		CreatedAt:   now,
+	}
+}
+
+func hasPanel(dashboard *model.Dashboard, index int) bool {
+	for _, p := range dashboard.Panels {
+		if p.Index == index {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,333 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"Dana/agent/model"
+	"Dana/agent/repository"
+	"Dana/config"
+)
+
+// storedAnnotations records the annotations created and the last query.
+type storedAnnotations struct {
+	repository.AnnotationRepo
+	created []*model.Annotation
+	filter  model.AnnotationFilter
+	opts    model.ListOptions
+}
+
+func (r *storedAnnotations) CreateAnnotation(_ context.Context, annotation *model.Annotation) (primitive.ObjectID, error) {
+	r.created = append(r.created, annotation)
+	return primitive.NewObjectID(), nil
+}
+
+func (r *storedAnnotations) ListAnnotations(_ context.Context, filter model.AnnotationFilter, opts model.ListOptions) (*model.Page[*model.Annotation], error) {
+	r.filter, r.opts = filter, opts
+	return &model.Page[*model.Annotation]{Items: []*model.Annotation{}}, nil
+}
+
+// knownDashboards serves the dashboards it holds by id.
+type knownDashboards struct {
+	repository.DashboardRepo
+	dashboards map[primitive.ObjectID]*model.Dashboard
+}
+
+func (r knownDashboards) GetDashboard(_ context.Context, id string) (*model.Dashboard, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	if d, found := r.dashboards[objectID]; found {
+		return d, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+// annotationScope holds a dashboard at the top level and one in a folder
+// only bob may view.
+type annotationScope struct {
+	open, restricted primitive.ObjectID
+	server           *Server
+	annotations      *storedAnnotations
+}
+
+func newAnnotationScope() *annotationScope {
+	s := &annotationScope{
+		open:        primitive.NewObjectID(),
+		restricted:  primitive.NewObjectID(),
+		annotations: &storedAnnotations{},
+	}
+	folders := &storedFolders{folders: []*model.Folder{{
+		ID:           primitive.NewObjectID(),
+		Name:         "restricted",
+		DashboardIDs: []primitive.ObjectID{s.restricted},
+		Permissions:  []model.FolderPermission{{Username: "bob", Role: model.RoleViewer}},
+	}}}
+	dashboards := knownDashboards{dashboards: map[primitive.ObjectID]*model.Dashboard{
+		s.open:       {ID: s.open, Panels: []model.Panel{{Name: "cpu", Index: 3}}},
+		s.restricted: {ID: s.restricted, Panels: []model.Panel{{Name: "cpu", Index: 0}}},
+	}}
+	s.server = &Server{AnnotationRepo: s.annotations, DashboardRepo: dashboards, FolderRepo: folders}
+	return s
+}
+
+func newAnnotationContext(method, target, body, username string, role model.Role) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	ctx := echo.New().NewContext(req, rec)
+	ctx.Set("username", username)
+	ctx.Set("role", string(role))
+	return ctx, rec
+}
+
+func TestGetAnnotations(t *testing.T) {
+	s := newAnnotationScope()
+
+	t.Run("window, tags and panel", func(t *testing.T) {
+		query := "from=2024-05-01T10:00:00Z&to=2024-05-01T12:00:00Z&tag=deploy&tag=prod&dashboard_id=" + s.open.Hex() + "&panel_index=3"
+		ctx, rec := newAnnotationContext(http.MethodGet, "/api/v1/annotations?"+query, "", "alice", model.RoleViewer)
+		require.NoError(t, s.server.GetAnnotations(ctx))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		filter := s.annotations.filter
+		require.True(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Equal(filter.From))
+		require.True(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Equal(filter.To))
+		require.Equal(t, &s.open, filter.DashboardID)
+		require.Equal(t, 3, *filter.PanelIndex)
+		require.Equal(t, []string{"deploy", "prod"}, s.annotations.opts.Tags)
+	})
+
+	t.Run("dashboards the user cannot view are hidden", func(t *testing.T) {
+		ctx, rec := newAnnotationContext(http.MethodGet, "/api/v1/annotations", "", "alice", model.RoleViewer)
+		require.NoError(t, s.server.GetAnnotations(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, []primitive.ObjectID{s.restricted}, s.annotations.filter.Hidden)
+
+		ctx, rec = newAnnotationContext(http.MethodGet, "/api/v1/annotations", "", "bob", model.RoleViewer)
+		require.NoError(t, s.server.GetAnnotations(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Empty(t, s.annotations.filter.Hidden)
+	})
+
+	for _, query := range []string{"from=yesterday", "dashboard_id=42", "panel_index=1", "dashboard_id=" + s.open.Hex() + "&panel_index=first"} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			ctx, rec := newAnnotationContext(http.MethodGet, "/api/v1/annotations?"+query, "", "alice", model.RoleViewer)
+			require.NoError(t, s.server.GetAnnotations(ctx))
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestCreateAnnotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     func(s *annotationScope) string
+		username string
+		expected int
+	}{
+		{
+			name:     "every dashboard",
+			body:     func(*annotationScope) string { return `{"text":"deploy","tags":["deploy"]}` },
+			username: "alice",
+			expected: http.StatusCreated,
+		},
+		{
+			name: "panel of a dashboard",
+			body: func(s *annotationScope) string {
+				return `{"text":"deploy","dashboard_id":"` + s.open.Hex() + `","panel_index":3}`
+			},
+			username: "alice",
+			expected: http.StatusCreated,
+		},
+		{
+			name: "unknown dashboard",
+			body: func(*annotationScope) string {
+				return `{"text":"deploy","dashboard_id":"` + primitive.NewObjectID().Hex() + `"}`
+			},
+			username: "alice",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name: "unknown panel",
+			body: func(s *annotationScope) string {
+				return `{"text":"deploy","dashboard_id":"` + s.open.Hex() + `","panel_index":0}`
+			},
+			username: "alice",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "panel without dashboard",
+			body:     func(*annotationScope) string { return `{"text":"deploy","panel_index":0}` },
+			username: "alice",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name: "end before start",
+			body: func(*annotationScope) string {
+				return `{"text":"deploy","time":"2024-05-01T10:00:00Z","time_end":"2024-05-01T09:00:00Z"}`
+			},
+			username: "alice",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name: "dashboard the user may only view",
+			body: func(s *annotationScope) string {
+				return `{"text":"deploy","dashboard_id":"` + s.restricted.Hex() + `"}`
+			},
+			username: "bob",
+			expected: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAnnotationScope()
+			before := time.Now()
+			ctx, rec := newAnnotationContext(http.MethodPost, "/api/v1/annotations", tt.body(s), tt.username, model.RoleEditor)
+			require.NoError(t, s.server.CreateAnnotation(ctx))
+			require.Equal(t, tt.expected, rec.Code, rec.Body.String())
+			if tt.expected != http.StatusCreated {
+				require.Empty(t, s.annotations.created)
+				return
+			}
+			require.Len(t, s.annotations.created, 1)
+			created := s.annotations.created[0]
+			require.Equal(t, tt.username, created.Author)
+			// Annotations without a time mark the time they were created
+			require.False(t, created.Time.Before(before))
+			require.Equal(t, created.CreatedAt, created.Time)
+		})
+	}
+}
+
+func TestNotificationAnnotation(t *testing.T) {
+	dashboardID := primitive.NewObjectID()
+	panel := 2
+	annotation := notificationAnnotation(&model.Notification{
+		CheckName:      "disk usage",
+		Level:          "crit",
+		Message:        "disk is full",
+		AnnotationTags: []string{"db"},
+		DashboardID:    &dashboardID,
+		PanelIndex:     &panel,
+	}, "alice")
+
+	require.Equal(t, "disk usage: disk is full", annotation.Text)
+	require.Equal(t, []string{"notification", "crit", "db"}, annotation.Tags)
+	require.Equal(t, &dashboardID, annotation.DashboardID)
+	require.Equal(t, &panel, annotation.PanelIndex)
+	require.Equal(t, "alice", annotation.Author)
+	require.False(t, annotation.Time.IsZero())
+
+	annotation = notificationAnnotation(&model.Notification{Message: "hello"}, "")
+	require.Equal(t, "hello", annotation.Text)
+	require.Equal(t, []string{"notification"}, annotation.Tags)
+	require.Nil(t, annotation.DashboardID)
+}
+
+// webhookChannel serves a single webhook channel.
+type webhookChannel struct {
+	repository.NotificationRepo
+}
+
+func (webhookChannel) GetNotification(_ context.Context, channelName string) (*model.Notification, error) {
+	return &model.Notification{
+		ChannelName: channelName,
+		Driver:      "webhook",
+		Config:      map[string]interface{}{"url": "http://127.0.0.1:1/hook"},
+	}, nil
+}
+
+// queuedDeliveries records the deliveries queued.
+type queuedDeliveries struct {
+	repository.NotificationQueueRepo
+	deliveries []*model.NotificationDelivery
+}
+
+func (r *queuedDeliveries) Enqueue(_ context.Context, delivery *model.NotificationDelivery) error {
+	delivery.ID = primitive.NewObjectID()
+	r.deliveries = append(r.deliveries, delivery)
+	return nil
+}
+
+// noSilences never silences anything.
+type noSilences struct {
+	repository.SilenceRepo
+}
+
+func (noSilences) GetCurrentSilences(context.Context, time.Time) ([]*model.Silence, error) {
+	return nil, nil
+}
+
+func TestSendNotificationAnnotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     func(s *annotationScope) string
+		expected int
+		queued   int
+		tags     []string
+		scope    func(s *annotationScope) *primitive.ObjectID
+	}{
+		{
+			name: "annotated",
+			body: func(s *annotationScope) string {
+				return `{"_check_name":"cpu","_level":"crit","_message":"cpu high","annotate":true,` +
+					`"annotation_tags":["web"],"dashboard_id":"` + s.open.Hex() + `","panel_index":3}`
+			},
+			expected: http.StatusAccepted,
+			queued:   1,
+			tags:     []string{"notification", "crit", "web"},
+			scope:    func(s *annotationScope) *primitive.ObjectID { return &s.open },
+		},
+		{
+			name:     "not annotated",
+			body:     func(*annotationScope) string { return `{"_check_name":"cpu","_level":"crit","_message":"cpu high"}` },
+			expected: http.StatusAccepted,
+			queued:   1,
+		},
+		{
+			name: "invalid scope is not sent",
+			body: func(s *annotationScope) string {
+				return `{"_message":"cpu high","annotate":true,"dashboard_id":"` + s.open.Hex() + `","panel_index":7}`
+			},
+			expected: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAnnotationScope()
+			queue := &queuedDeliveries{}
+			s.server.NotificationRepo = webhookChannel{}
+			s.server.NotificationQueueRepo = queue
+			s.server.SilenceRepo = noSilences{}
+			s.server.deliveries = newNotificationQueue()
+			s.server.Config = &config.Config{ServerConfig: &config.ServerConfig{}}
+
+			ctx, rec := newAnnotationContext(http.MethodPost, "/api/v1/notifications/send?channelName=ops", tt.body(s), "alice", model.RoleEditor)
+			require.NoError(t, s.server.SendNotification(ctx))
+			require.Equal(t, tt.expected, rec.Code, rec.Body.String())
+			require.Len(t, queue.deliveries, tt.queued)
+			if tt.tags == nil {
+				require.Empty(t, s.annotations.created)
+				return
+			}
+			require.Len(t, s.annotations.created, 1)
+			annotation := s.annotations.created[0]
+			require.Equal(t, "cpu: cpu high", annotation.Text)
+			require.Equal(t, tt.tags, annotation.Tags)
+			require.Equal(t, tt.scope(s), annotation.DashboardID)
+			require.Equal(t, "alice", annotation.Author)
+			require.Equal(t, "/dashboards/"+s.open.Hex(), queue.deliveries[0].Alert["dashboard_url"])
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	authentication "Dana/agent/Auth"
+	"Dana/agent/model"
+)
+
+const (
+	// auditBodyLimit is the largest request body read for the audit summary
+	auditBodyLimit = 64 * 1024
+	// auditStringLimit truncates long values such as scripts in the summary
+	auditStringLimit = 256
+	auditTimeout     = 5 * time.Second
+)
+
+// auditSecretRe matches the keys of values never written to the audit log.
+var auditSecretRe = regexp.MustCompile(`(?i)pass|secret|token|key|auth|credential|community`)
+
+// auditLog records every call to a mutating route, including rejected ones,
+// in the audit log. It must run before ValidateJWT so calls rejected for
+// missing or invalid credentials are recorded as well.
+func (a *Server) auditLog(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		switch req.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			return next(c)
+		}
+
+		var body []byte
+		if req.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(req.Body, auditBodyLimit))
+			req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+		}
+
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		entry := &model.AuditEntry{
+			Time:       start,
+			Username:   authentication.Username(c),
+			Role:       authentication.Role(c),
+			Method:     req.Method,
+			Route:      c.Path(),
+			Path:       req.URL.Path,
+			Status:     c.Response().Status,
+			ClientIP:   c.RealIP(),
+			DurationMS: time.Since(start).Milliseconds(),
+			Body:       auditBody(body),
+		}
+		if token := authentication.CurrentAPIToken(c); token != nil {
+			entry.APITokenID = token.ID.Hex()
+		}
+		if names := c.ParamNames(); len(names) > 0 {
+			entry.Params = make(map[string]string, len(names))
+			for _, name := range names {
+				entry.Params[name] = c.Param(name)
+			}
+			entry.TargetID = auditTarget(entry.Params)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+		defer cancel()
+		if err := a.AuditRepo.AddEntry(ctx, entry); err != nil {
+			c.Logger().Error("Error writing audit log entry: ", err)
+		}
+		return nil
+	}
+}
+
+// auditTarget picks the id of the object a call acts on from the route
+// parameters.
+func auditTarget(params map[string]string) string {
+	for _, name := range []string{"id", "dashboardID", "token", "name", "channelName"} {
+		if v := params[name]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// auditBody summarizes a JSON request body for the audit log. Secrets are
+// replaced and long strings truncated; other bodies are only described by
+// their size.
+func auditBody(body []byte) interface{} {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return map[string]interface{}{"bytes": len(body)}
+	}
+	return redact(v)
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if auditSecretRe.MatchString(key) {
+				v[key] = "[REDACTED]"
+				continue
+			}
+			v[key] = redact(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redact(value)
+		}
+		return v
+	case string:
+		if len(v) > auditStringLimit {
+			return v[:auditStringLimit] + "...[truncated]"
+		}
+	}
+	return v
+}
@@ -0,0 +1,194 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"Dana/agent/model"
+	"Dana/agent/repository"
+)
+
+// recordedAudit keeps the audit entries added to it and the last query.
+type recordedAudit struct {
+	repository.AuditRepo
+	entries []*model.AuditEntry
+	filter  model.AuditFilter
+	opts    model.ListOptions
+}
+
+func (r *recordedAudit) AddEntry(_ context.Context, entry *model.AuditEntry) error {
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func (r *recordedAudit) ListEntries(_ context.Context, filter model.AuditFilter, opts model.ListOptions) (*model.Page[*model.AuditEntry], error) {
+	r.filter, r.opts = filter, opts
+	return &model.Page[*model.AuditEntry]{Items: r.entries, Total: int64(len(r.entries))}, nil
+}
+
+// newAuditTestServer serves the audit log middleware in front of a stand-in
+// for ValidateJWT, which accepts requests naming their user in X-User.
+func newAuditTestServer(repo *recordedAudit) *echo.Echo {
+	a := &Server{AuditRepo: repo}
+	e := echo.New()
+	v1 := e.Group("/api/v1")
+	v1.Use(a.auditLog, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			username := c.Request().Header.Get("X-User")
+			if username == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+			}
+			c.Set("username", username)
+			c.Set("role", string(model.RoleAdmin))
+			return next(c)
+		}
+	})
+	v1.GET("/users", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, "OK")
+	})
+	v1.PUT("/users/:id", func(c echo.Context) error {
+		// The handler still gets the whole body
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, string(body))
+	})
+	v1.DELETE("/users/:id", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusConflict, "last admin")
+	})
+	return e
+}
+
+func serveAudit(e *echo.Echo, method, target, username, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if username != "" {
+		req.Header.Set("X-User", username)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuditLog(t *testing.T) {
+	t.Run("mutating call", func(t *testing.T) {
+		repo := &recordedAudit{}
+		e := newAuditTestServer(repo)
+
+		long := strings.Repeat("x", auditStringLimit+10)
+		body := `{"email":"bob@example.com","password":"hunter2","settings":{"api_key":"k","script":"` + long + `"}}`
+		before := time.Now()
+		rec := serveAudit(e, http.MethodPut, "/api/v1/users/42", "alice", body)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, body, rec.Body.String())
+
+		require.Len(t, repo.entries, 1)
+		entry := repo.entries[0]
+		require.False(t, entry.Time.Before(before))
+		require.Equal(t, "alice", entry.Username)
+		require.Equal(t, model.RoleAdmin, entry.Role)
+		require.Equal(t, http.MethodPut, entry.Method)
+		require.Equal(t, "/api/v1/users/:id", entry.Route)
+		require.Equal(t, "/api/v1/users/42", entry.Path)
+		require.Equal(t, http.StatusOK, entry.Status)
+		require.Equal(t, map[string]string{"id": "42"}, entry.Params)
+		require.Equal(t, "42", entry.TargetID)
+		require.Equal(t, map[string]interface{}{
+			"email":    "bob@example.com",
+			"password": "[REDACTED]",
+			"settings": map[string]interface{}{
+				"api_key": "[REDACTED]",
+				"script":  long[:auditStringLimit] + "...[truncated]",
+			},
+		}, entry.Body)
+	})
+
+	t.Run("rejected credentials", func(t *testing.T) {
+		repo := &recordedAudit{}
+		e := newAuditTestServer(repo)
+
+		rec := serveAudit(e, http.MethodDelete, "/api/v1/users/42", "", "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Len(t, repo.entries, 1)
+		require.Empty(t, repo.entries[0].Username)
+		require.Equal(t, http.StatusUnauthorized, repo.entries[0].Status)
+		require.Nil(t, repo.entries[0].Body)
+	})
+
+	t.Run("failed call", func(t *testing.T) {
+		repo := &recordedAudit{}
+		e := newAuditTestServer(repo)
+
+		rec := serveAudit(e, http.MethodDelete, "/api/v1/users/42", "alice", "")
+		require.Equal(t, http.StatusConflict, rec.Code)
+		require.Len(t, repo.entries, 1)
+		require.Equal(t, "alice", repo.entries[0].Username)
+		require.Equal(t, http.StatusConflict, repo.entries[0].Status)
+	})
+
+	t.Run("body that is not JSON", func(t *testing.T) {
+		repo := &recordedAudit{}
+		e := newAuditTestServer(repo)
+
+		serveAudit(e, http.MethodPut, "/api/v1/users/42", "alice", "not json")
+		require.Len(t, repo.entries, 1)
+		require.Equal(t, map[string]interface{}{"bytes": 8}, repo.entries[0].Body)
+	})
+
+	t.Run("reading calls are not recorded", func(t *testing.T) {
+		repo := &recordedAudit{}
+		e := newAuditTestServer(repo)
+
+		rec := serveAudit(e, http.MethodGet, "/api/v1/users", "alice", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Empty(t, repo.entries)
+	})
+}
+
+func TestGetAuditLog(t *testing.T) {
+	get := func(query string) (*recordedAudit, *httptest.ResponseRecorder) {
+		repo := &recordedAudit{entries: []*model.AuditEntry{{Username: "alice", Method: http.MethodPost}}}
+		a := &Server{AuditRepo: repo}
+		rec := httptest.NewRecorder()
+		ctx := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/audit?"+query, nil), rec)
+		require.NoError(t, a.GetAuditLog(ctx))
+		return repo, rec
+	}
+
+	t.Run("filters", func(t *testing.T) {
+		before := time.Now()
+		repo, rec := get("user=alice&method=post&route=/api/v1/users/:id&from=now-1h&to=2024-05-01T10:00:00Z&limit=10&sort=username")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), `"username":"alice"`)
+
+		require.Equal(t, "alice", repo.filter.Username)
+		require.Equal(t, http.MethodPost, repo.filter.Method)
+		require.Equal(t, "/api/v1/users/:id", repo.filter.Route)
+		require.WithinDuration(t, before.Add(-time.Hour), repo.filter.From, time.Minute)
+		require.True(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Equal(repo.filter.To))
+		require.Equal(t, 10, repo.opts.Limit)
+		require.Equal(t, "username", repo.opts.Sort)
+	})
+
+	t.Run("no filters", func(t *testing.T) {
+		repo, rec := get("")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, model.AuditFilter{}, repo.filter)
+	})
+
+	for _, query := range []string{"from=yesterday", "to=now-", "limit=0"} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			_, rec := get(query)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Dana/agent/model"
+)
+
+const (
+	// defaultQueryTimeout limits each panel query unless the caller asks for
+	// a different timeout.
+	defaultQueryTimeout = 10 * time.Second
+	maxQueryTimeout     = time.Minute
+	// maxConcurrentQueries limits the queries sent to InfluxDB at once for a
+	// single dashboard.
+	maxConcurrentQueries = 8
+	defaultTimeRange     = 6 * time.Hour
+)
+
+// variableRe matches the variable references supported in panel queries:
+// $name, ${name} and [[name]].
+var variableRe = regexp.MustCompile(`\$\{([A-Za-z0-9_.]+)\}|\[\[([A-Za-z0-9_.]+)\]\]|\$([A-Za-z0-9_]+)`)
+
+// relativeTimeRe matches relative times such as "now", "now-6h" or "now-7d".
+var relativeTimeRe = regexp.MustCompile(`^now(?:([+-])(\d+)(ms|s|m|h|d|w))?$`)
+
+// queryRange is the time range a dashboard is rendered for.
+type queryRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// parseQueryTime parses the "from" and "to" parameters of the data endpoint.
+// Times may be given as RFC3339 timestamps, Unix milliseconds or relative to
+// now as in Grafana, e.g. "now-6h".
+func parseQueryTime(value string, now time.Time) (time.Time, error) {
+	if m := relativeTimeRe.FindStringSubmatch(value); m != nil {
+		if m[1] == "" {
+			return now, nil
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			return time.Time{}, err
+		}
+		var unit time.Duration
+		switch m[3] {
+		case "ms":
+			unit = time.Millisecond
+		case "s":
+			unit = time.Second
+		case "m":
+			unit = time.Minute
+		case "h":
+			unit = time.Hour
+		case "d":
+			unit = 24 * time.Hour
+		case "w":
+			unit = 7 * 24 * time.Hour
+		}
+		offset := time.Duration(n) * unit
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return now.Add(offset), nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseQueryRange returns the time range given by the "from" and "to"
+// parameters, defaulting to the last six hours.
+func parseQueryRange(from, to string) (queryRange, error) {
+	now := time.Now()
+	r := queryRange{From: now.Add(-defaultTimeRange), To: now}
+
+	var err error
+	if from != "" {
+		if r.From, err = parseQueryTime(from, now); err != nil {
+			return r, fmt.Errorf("invalid from time %q", from)
+		}
+	}
+	if to != "" {
+		if r.To, err = parseQueryTime(to, now); err != nil {
+			return r, fmt.Errorf("invalid to time %q", to)
+		}
+	}
+	if !r.From.Before(r.To) {
+		return r, errors.New("from must be before to")
+	}
+	return r, nil
+}
+
+// variableValues collects the "var.<name>" parameters. A variable given more
+// than once has multiple values selected, "$__all" selects all values.
+func variableValues(params url.Values) map[string][]string {
+	values := make(map[string][]string)
+	for key, v := range params {
+		if name, found := strings.CutPrefix(key, "var."); found && name != "" {
+			values[name] = v
+		}
+	}
+	return values
+}
+
+// interpolateQuery substitutes the variable values and the time range into a
+// query. Besides the dashboard variables, $timeFilter expands to an InfluxQL
+// time condition and $__from and $__to to the range in Unix milliseconds.
+// Values are escaped as regular expressions and multiple values are
+// formatted as a regex group, e.g. (a|b), to be used as in
+// "host =~ /^$host$/". Unknown variables are left as they are.
+func interpolateQuery(query string, values map[string][]string, r queryRange) string {
+	return variableRe.ReplaceAllStringFunc(query, func(ref string) string {
+		m := variableRe.FindStringSubmatch(ref)
+		name := m[1] + m[2] + m[3]
+
+		switch name {
+		case "timeFilter":
+			return fmt.Sprintf("time >= %dms AND time <= %dms", r.From.UnixMilli(), r.To.UnixMilli())
+		case "__from":
+			return strconv.FormatInt(r.From.UnixMilli(), 10)
+		case "__to":
+			return strconv.FormatInt(r.To.UnixMilli(), 10)
+		}
+
+		v, found := values[name]
+		if !found || len(v) == 0 {
+			return ref
+		}
+		if len(v) == 1 {
+			return quoteRegex(v[0])
+		}
+		quoted := make([]string, 0, len(v))
+		for _, e := range v {
+			quoted = append(quoted, quoteRegex(e))
+		}
+		return "(" + strings.Join(quoted, "|") + ")"
+	})
+}
+
+// quoteRegex escapes a value for use in an InfluxQL regex, which is delimited
+// by slashes.
+func quoteRegex(value string) string {
+	return strings.ReplaceAll(regexp.QuoteMeta(value), "/", `\/`)
+}
+
+// dashboardData runs all panel queries of the dashboard concurrently. Failed
+// queries are reported in the result of their panel while the other results
+// are still returned. Results are keyed by panel index, see panelKeys.
+func (a *Server) dashboardData(ctx context.Context, dashboard *model.Dashboard, r queryRange,
+	values map[string][]string, params url.Values, timeout time.Duration) *model.DashboardData {
+	data := &model.DashboardData{
+		From:   r.From,
+		To:     r.To,
+		Panels: make(map[int][]model.PanelQueryData, len(dashboard.Panels)),
+	}
+	keys := panelKeys(dashboard.Panels)
+	for position, panel := range dashboard.Panels {
+		data.Panels[keys[position]] = make([]model.PanelQueryData, len(panel.Query))
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentQueries)
+	for position, panel := range dashboard.Panels {
+		results := data.Panels[keys[position]]
+		for i, query := range panel.Query {
+			wg.Add(1)
+			go func(i int, query string) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				result := a.runPanelQuery(ctx, interpolateQuery(query, values, r), params, timeout)
+
+				mu.Lock()
+				defer mu.Unlock()
+				results[i] = result
+				if result.Error != "" {
+					data.Errors++
+				}
+			}(i, query)
+		}
+	}
+	wg.Wait()
+
+	return data
+}
+
+// panelKeys returns the key of the results of each panel, which is the panel
+// index. Dashboards stored before indexes had to be unique may repeat them,
+// usually leaving every panel at 0, so their panels are keyed by position.
+func panelKeys(panels []model.Panel) []int {
+	keys := make([]int, len(panels))
+	unique := uniquePanelIndexes(panels)
+	for position, panel := range panels {
+		keys[position] = panel.Index
+		if !unique {
+			keys[position] = position
+		}
+	}
+	return keys
+}
+
+// uniquePanelIndexes reports whether no two panels share an index.
+func uniquePanelIndexes(panels []model.Panel) bool {
+	seen := make(map[int]bool, len(panels))
+	for _, panel := range panels {
+		if seen[panel.Index] {
+			return false
+		}
+		seen[panel.Index] = true
+	}
+	return true
+}
+
+// runPanelQuery sends a single query to InfluxDB, giving up after the
+// timeout. Errors InfluxDB reports for the statements are returned as the
+// query's error next to the response.
+func (a *Server) runPanelQuery(ctx context.Context, query string, params url.Values, timeout time.Duration) model.PanelQueryData {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	result := model.PanelQueryData{Query: query}
+	body, err := a.influxQuery(ctx, query, params)
+	result.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("query timed out after %s", timeout)
+		}
+		result.Error = err.Error()
+		return result
+	}
+	result.Result = body
+
+	var response struct {
+		Results []struct {
+			Error string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil {
+		messages := make([]string, 0)
+		for _, r := range response.Results {
+			if r.Error != "" {
+				messages = append(messages, r.Error)
+			}
+		}
+		result.Error = strings.Join(messages, "; ")
+	}
+	return result
+}
+
+// influxQuery runs a query against the InfluxDB /query endpoint, passing on
+// the other parameters such as "db" or "epoch".
+func (a *Server) influxQuery(ctx context.Context, query string, params url.Values) ([]byte, error) {
+	target := url.URL{
+		Scheme: "http",
+		Host:   a.Config.ServerConfig.InfluxHost + ":" + a.Config.ServerConfig.InfluxPort,
+		Path:   "/query",
+	}
+	values := make(url.Values, len(params)+1)
+	for key, v := range params {
+		values[key] = v
+	}
+	values.Set("q", query)
+	target.RawQuery = values.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if a.Config.ServerConfig.InfluxToken != "" {
+		req.Header.Set("Authorization", "Token "+a.Config.ServerConfig.InfluxToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var response struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(body, &response); err == nil && response.Error+response.Message != "" {
+			return nil, fmt.Errorf("InfluxDB returned %s: %s", resp.Status, response.Error+response.Message)
+		}
+		return nil, fmt.Errorf("InfluxDB returned %s", resp.Status)
+	}
+	return body, nil
+}
+
+// influxParams returns the parameters of the data endpoint that are passed on
+// to InfluxDB, i.e. all except the time range, timeout and variables.
+func influxParams(params url.Values) url.Values {
+	passed := make(url.Values)
+	for key, v := range params {
+		switch {
+		case key == "from", key == "to", key == "timeout", key == "q", strings.HasPrefix(key, "var."):
+			continue
+		}
+		passed[key] = v
+	}
+	return passed
+}
@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"Dana/agent/model"
+	"Dana/config"
+)
+
+// newInfluxTestServer returns a server whose InfluxDB answers each query
+// with the statement result returned by respond.
+func newInfluxTestServer(t *testing.T, respond func(query string) map[string]interface{}) *Server {
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := respond(r.URL.Query().Get("q"))
+		result["statement_id"] = 0
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"results": []interface{}{result}})
+	}))
+	t.Cleanup(influx.Close)
+
+	u, err := url.Parse(influx.URL)
+	require.NoError(t, err)
+	host, port, err := net.SplitHostPort(u.Host)
+	require.NoError(t, err)
+	return &Server{Config: &config.Config{ServerConfig: &config.ServerConfig{InfluxHost: host, InfluxPort: port}}}
+}
+
+func TestDashboardDataDuplicateIndex(t *testing.T) {
+	// Every query is answered with itself, or fails if it starts with FAIL
+	a := newInfluxTestServer(t, func(query string) map[string]interface{} {
+		if strings.HasPrefix(query, "FAIL") {
+			return map[string]interface{}{"query": query, "error": "query failed"}
+		}
+		return map[string]interface{}{"query": query}
+	})
+
+	// Dashboards stored before indexes were validated repeat them, so the
+	// results are keyed by position
+	dashboard := &model.Dashboard{
+		Panels: []model.Panel{
+			{Name: "one", Query: []string{"SELECT 1"}},
+			{Name: "two", Query: []string{"SELECT 2", "FAIL 2", "SELECT $host"}},
+			{Name: "three", Index: 1, Query: []string{"SELECT 3"}},
+			{Name: "four", Index: 1},
+		},
+	}
+	r := queryRange{From: time.Unix(0, 0), To: time.Unix(60, 0)}
+	values := map[string][]string{"host": {"a"}}
+
+	data := a.dashboardData(context.Background(), dashboard, r, values, url.Values{"db": {"test"}}, time.Second)
+
+	require.Equal(t, r.From, data.From)
+	require.Equal(t, r.To, data.To)
+	require.Equal(t, 1, data.Errors)
+	require.Len(t, data.Panels, 4)
+
+	queries := make(map[int][]string)
+	for position, results := range data.Panels {
+		queries[position] = make([]string, 0, len(results))
+		for _, result := range results {
+			queries[position] = append(queries[position], result.Query)
+			require.NotEmpty(t, result.Result)
+		}
+	}
+	require.Equal(t, map[int][]string{
+		0: {"SELECT 1"},
+		1: {"SELECT 2", "FAIL 2", "SELECT a"},
+		2: {"SELECT 3"},
+		3: {},
+	}, queries)
+	require.Equal(t, "query failed", data.Panels[1][1].Error)
+	require.Empty(t, data.Panels[1][0].Error)
+}
+
+func TestDashboardDataKeyedByIndex(t *testing.T) {
+	a := newInfluxTestServer(t, func(query string) map[string]interface{} {
+		return map[string]interface{}{"query": query}
+	})
+
+	dashboard := &model.Dashboard{
+		Panels: []model.Panel{
+			{Name: "one", Index: 2, Query: []string{"SELECT 1"}},
+			{Name: "two", Index: 0, Query: []string{"SELECT 2"}},
+			{Name: "three", Index: 5},
+		},
+	}
+	r := queryRange{From: time.Unix(0, 0), To: time.Unix(60, 0)}
+
+	data := a.dashboardData(context.Background(), dashboard, r, nil, url.Values{"db": {"test"}}, time.Second)
+	require.Zero(t, data.Errors)
+	require.Len(t, data.Panels, 3)
+	require.Equal(t, "SELECT 1", data.Panels[2][0].Query)
+	require.Equal(t, "SELECT 2", data.Panels[0][0].Query)
+	require.Empty(t, data.Panels[5])
+	require.NotNil(t, data.Panels[5])
+}
+
+func TestPanelKeys(t *testing.T) {
+	panels := func(indexes ...int) []model.Panel {
+		p := make([]model.Panel, 0, len(indexes))
+		for _, i := range indexes {
+			p = append(p, model.Panel{Index: i})
+		}
+		return p
+	}
+
+	require.Equal(t, []int{3, 1, 7}, panelKeys(panels(3, 1, 7)))
+	require.Equal(t, []int{0}, panelKeys(panels(0)))
+	require.Equal(t, []int{0, 1, 2}, panelKeys(panels(0, 0, 0)))
+	require.Equal(t, []int{0, 1, 2}, panelKeys(panels(4, 2, 4)))
+	require.Empty(t, panelKeys(nil))
+}
+
+func TestInterpolateQuery(t *testing.T) {
+	r := queryRange{From: time.UnixMilli(1000), To: time.UnixMilli(61000)}
+	values := map[string][]string{
+		"host":     {"web-1"},
+		"path":     {"/var/log(1)"},
+		"hosts":    {"web.1", "db+2", "a|b"},
+		"dc.name":  {"eu"},
+		"interval": {"5m"},
+		"empty":    {},
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "dollar",
+			query:    `SELECT * FROM cpu WHERE host = '$host'`,
+			expected: `SELECT * FROM cpu WHERE host = 'web-1'`,
+		},
+		{
+			name:     "braces",
+			query:    `SELECT * FROM cpu WHERE host = '${host}x'`,
+			expected: `SELECT * FROM cpu WHERE host = 'web-1x'`,
+		},
+		{
+			name:     "brackets",
+			query:    `SELECT * FROM cpu GROUP BY time([[interval]])`,
+			expected: `SELECT * FROM cpu GROUP BY time(5m)`,
+		},
+		{
+			name:     "dotted name",
+			query:    `SELECT * FROM cpu WHERE dc = '${dc.name}' OR dc = '[[dc.name]]'`,
+			expected: `SELECT * FROM cpu WHERE dc = 'eu' OR dc = 'eu'`,
+		},
+		{
+			name:     "single values are escaped",
+			query:    `SELECT * FROM disk WHERE path =~ /^$path$/`,
+			expected: `SELECT * FROM disk WHERE path =~ /^\/var\/log\(1\)$/`,
+		},
+		{
+			name:     "multiple values are escaped into a regex group",
+			query:    `SELECT * FROM cpu WHERE host =~ /^$hosts$/`,
+			expected: `SELECT * FROM cpu WHERE host =~ /^(web\.1|db\+2|a\|b)$/`,
+		},
+		{
+			name:     "longer name is a different variable",
+			query:    `SELECT * FROM cpu WHERE host = '$hostname'`,
+			expected: `SELECT * FROM cpu WHERE host = '$hostname'`,
+		},
+		{
+			name:     "unknown and empty variables are kept",
+			query:    `SELECT * FROM cpu WHERE a = '$unknown' AND b = '${empty}'`,
+			expected: `SELECT * FROM cpu WHERE a = '$unknown' AND b = '${empty}'`,
+		},
+		{
+			name:     "time range",
+			query:    `SELECT * FROM cpu WHERE $timeFilter AND time > ${__from}ms AND time < [[__to]]ms`,
+			expected: `SELECT * FROM cpu WHERE time >= 1000ms AND time <= 61000ms AND time > 1000ms AND time < 61000ms`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, interpolateQuery(tt.query, values, r))
+		})
+	}
+}
+
+func TestParseQueryTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Time
+	}{
+		{value: "now", expected: now},
+		{value: "now-6h", expected: now.Add(-6 * time.Hour)},
+		{value: "now+30m", expected: now.Add(30 * time.Minute)},
+		{value: "now-2d", expected: now.Add(-48 * time.Hour)},
+		{value: "now-1w", expected: now.Add(-7 * 24 * time.Hour)},
+		{value: "1714564800000", expected: time.UnixMilli(1714564800000)},
+		{value: "2024-05-01T10:00:00Z", expected: now.Add(-2 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			actual, err := parseQueryTime(tt.value, now)
+			require.NoError(t, err)
+			require.True(t, tt.expected.Equal(actual), "expected %s, got %s", tt.expected, actual)
+		})
+	}
+
+	for _, value := range []string{"now-", "now-6y", "yesterday"} {
+		_, err := parseQueryTime(value, now)
+		require.Error(t, err, value)
+	}
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"Dana/agent/model"
+)
+
+// recordRevision stores the given state of a dashboard as the revision of its
+// current version.
+func (a *Server) recordRevision(ctx context.Context, dashboard *model.Dashboard, author, message string) error {
+	snapshot := *dashboard
+	snapshot.ID = primitive.NilObjectID
+	snapshot.Version = 0
+
+	return a.DashboardRevisionRepo.AddRevision(ctx, &model.DashboardRevision{
+		DashboardID: dashboard.ID,
+		Version:     dashboard.Version,
+		Author:      author,
+		Message:     message,
+		CreatedAt:   primitive.NewDateTimeFromTime(time.Now()),
+		Dashboard:   snapshot,
+	})
+}
+
+// diffDashboards compares two dashboard snapshots by their JSON form, so the
+// paths reported match the fields of the API.
+func diffDashboards(from, to *model.Dashboard) ([]model.DashboardChange, error) {
+	old, err := dashboardValue(from)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := dashboardValue(to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]model.DashboardChange, 0)
+	diffValues("", old, updated, &changes)
+	return changes, nil
+}
+
+func dashboardValue(dashboard *model.Dashboard) (interface{}, error) {
+	buf, err := json.Marshal(dashboard)
+	if err != nil {
+		return nil, err
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal(buf, &value); err != nil {
+		return nil, err
+	}
+	delete(value, "id")
+	delete(value, "version")
+	return value, nil
+}
+
+// diffValues appends the differences between two decoded JSON values.
+// Objects and arrays are compared element by element, everything else as a
+// whole.
+func diffValues(path string, old, updated interface{}, changes *[]model.DashboardChange) {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		if u, ok := updated.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(o)+len(u))
+			for k := range o {
+				keys = append(keys, k)
+			}
+			for k := range u {
+				if _, found := o[k]; !found {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				p := k
+				if path != "" {
+					p = path + "." + k
+				}
+				diffValues(p, o[k], u[k], changes)
+			}
+			return
+		}
+	case []interface{}:
+		if u, ok := updated.([]interface{}); ok {
+			for i := 0; i < len(o) || i < len(u); i++ {
+				p := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(u):
+					*changes = append(*changes, model.DashboardChange{Path: p, Type: "removed", Old: o[i]})
+				case i >= len(o):
+					*changes = append(*changes, model.DashboardChange{Path: p, Type: "added", New: u[i]})
+				default:
+					diffValues(p, o[i], u[i], changes)
+				}
+			}
+			return
+		}
+	}
+
+	switch {
+	case reflect.DeepEqual(old, updated):
+	case old == nil:
+		*changes = append(*changes, model.DashboardChange{Path: path, Type: "added", New: updated})
+	case updated == nil:
+		*changes = append(*changes, model.DashboardChange{Path: path, Type: "removed", Old: old})
+	default:
+		*changes = append(*changes, model.DashboardChange{Path: path, Type: "changed", Old: old, New: updated})
+	}
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"Dana/agent/model"
+	"Dana/agent/repository"
+)
+
+// recordedRevisions keeps the revisions added to it.
+type recordedRevisions struct {
+	repository.DashboardRevisionRepo
+	revisions []*model.DashboardRevision
+}
+
+func (r *recordedRevisions) AddRevision(_ context.Context, revision *model.DashboardRevision) error {
+	r.revisions = append(r.revisions, revision)
+	return nil
+}
+
+func TestRecordRevision(t *testing.T) {
+	repo := &recordedRevisions{}
+	a := &Server{DashboardRevisionRepo: repo}
+	dashboard := &model.Dashboard{
+		ID:      primitive.NewObjectID(),
+		Name:    "servers",
+		Panels:  []model.Panel{{Name: "cpu", Query: []string{"SELECT 1"}}},
+		Version: 3,
+	}
+
+	require.NoError(t, a.recordRevision(context.Background(), dashboard, "alice", "add cpu"))
+	require.Len(t, repo.revisions, 1)
+	revision := repo.revisions[0]
+	require.Equal(t, dashboard.ID, revision.DashboardID)
+	require.Equal(t, 3, revision.Version)
+	require.Equal(t, "alice", revision.Author)
+	require.Equal(t, "add cpu", revision.Message)
+	require.NotZero(t, revision.CreatedAt)
+
+	// The copy does not carry the identity of the dashboard
+	require.True(t, revision.Dashboard.ID.IsZero())
+	require.Zero(t, revision.Dashboard.Version)
+	require.Equal(t, "servers", revision.Dashboard.Name)
+
+	// Later changes to the dashboard do not change the revision
+	dashboard.Name = "renamed"
+	require.Equal(t, "servers", revision.Dashboard.Name)
+}
+
+func TestDiffDashboards(t *testing.T) {
+	from := &model.Dashboard{
+		ID:      primitive.NewObjectID(),
+		Name:    "servers",
+		Version: 1,
+		Panels: []model.Panel{
+			{Name: "cpu", Query: []string{"SELECT 1"}},
+			{Name: "mem", Index: 1},
+		},
+		Tags: []string{"infra"},
+	}
+	to := &model.Dashboard{
+		ID:      primitive.NewObjectID(),
+		Name:    "servers",
+		Version: 2,
+		Panels: []model.Panel{
+			{Name: "cpu", Query: []string{"SELECT 2", "SELECT 3"}, Unit: "percent"},
+		},
+	}
+
+	changes, err := diffDashboards(from, to)
+	require.NoError(t, err)
+	require.Equal(t, []model.DashboardChange{
+		{Path: "panels[0].query[0]", Type: "changed", Old: "SELECT 1", New: "SELECT 2"},
+		{Path: "panels[0].query[1]", Type: "added", New: "SELECT 3"},
+		{Path: "panels[0].unit", Type: "added", New: "percent"},
+		{Path: "panels[1]", Type: "removed", Old: map[string]interface{}{
+			"name": "mem", "query": nil, "index": float64(1), "color": nil,
+		}},
+		{Path: "tags", Type: "removed", Old: []interface{}{"infra"}},
+	}, changes)
+
+	// Ids and versions always differ between revisions and are ignored
+	changes, err = diffDashboards(from, from)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+	require.NotNil(t, changes)
+}
@@ -0,0 +1,247 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"Dana/agent/model"
+)
+
+// allValue selects the "all" option of a variable, e.g. "var.host=$__all".
+const allValue = "$__all"
+
+var errCircularVariable = errors.New("circular variable reference")
+
+// resolveVariables evaluates the dashboard's variables in the order of their
+// references, so the query of a variable can use the values selected for the
+// variables it refers to. It returns the values of each variable and the
+// values to substitute into queries, keyed by variable name.
+func (a *Server) resolveVariables(ctx context.Context, dashboard *model.Dashboard, r queryRange,
+	selected map[string][]string, params url.Values, timeout time.Duration) ([]model.VariableValues, map[string][]string) {
+	order, failed := variableOrder(dashboard.Variables)
+
+	resolved := make(map[string]model.VariableValues, len(dashboard.Variables))
+	values := make(map[string][]string, len(dashboard.Variables))
+	for _, v := range order {
+		vv := model.VariableValues{
+			Name:    v.Name,
+			Type:    v.Type,
+			Options: make([]string, 0),
+			Current: make([]string, 0),
+		}
+		if vv.Type == "" {
+			vv.Type = model.VariableTypeQuery
+		}
+
+		if err := failed[v.Name]; err != nil {
+			vv.Error = err.Error()
+			resolved[v.Name] = vv
+			continue
+		}
+
+		var err error
+		switch vv.Type {
+		case model.VariableTypeQuery:
+			if v.Query == "" {
+				break
+			}
+			vv.Query = interpolateQuery(v.Query, values, r)
+			vv.Options, err = a.variableOptions(ctx, vv.Query, params, timeout)
+		case model.VariableTypeCustom, model.VariableTypeInterval:
+			vv.Options = splitOptions(v.Query)
+		case model.VariableTypeConstant:
+			vv.Options = []string{v.Query}
+		default:
+			err = fmt.Errorf("unknown variable type %q", v.Type)
+		}
+		if err != nil {
+			vv.Error = err.Error()
+		}
+
+		vv.Current, vv.All = selectValues(v, vv.Options, selected[v.Name])
+		values[v.Name] = vv.Current
+		if vv.All && v.AllValue != "" {
+			values[v.Name] = []string{v.AllValue}
+		}
+		resolved[v.Name] = vv
+	}
+
+	result := make([]model.VariableValues, 0, len(dashboard.Variables))
+	for _, v := range dashboard.Variables {
+		result = append(result, resolved[v.Name])
+	}
+	return result, values
+}
+
+// variableOrder sorts the variables so each comes after the variables its
+// query refers to. Variables that are part of a reference cycle, or refer to
+// one, are returned as failed.
+func variableOrder(variables []model.Variable) ([]model.Variable, map[string]error) {
+	byName := make(map[string]model.Variable, len(variables))
+	for _, v := range variables {
+		byName[v.Name] = v
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(variables))
+	failed := make(map[string]error)
+	order := make([]model.Variable, 0, len(variables))
+
+	var visit func(v model.Variable) error
+	visit = func(v model.Variable) error {
+		switch state[v.Name] {
+		case visiting:
+			return errCircularVariable
+		case done:
+			return failed[v.Name]
+		}
+		state[v.Name] = visiting
+
+		var err error
+		for _, ref := range variableRefs(v.Query) {
+			dep, found := byName[ref]
+			if !found || ref == v.Name {
+				continue
+			}
+			if depErr := visit(dep); depErr != nil && err == nil {
+				err = fmt.Errorf("%w via %s", errCircularVariable, dep.Name)
+			}
+		}
+
+		state[v.Name] = done
+		if err != nil {
+			failed[v.Name] = err
+		}
+		order = append(order, v)
+		return err
+	}
+	for _, v := range variables {
+		_ = visit(v)
+	}
+
+	return order, failed
+}
+
+// variableRefs returns the names of the variables referenced in a query.
+func variableRefs(query string) []string {
+	matches := variableRe.FindAllStringSubmatch(query, -1)
+	refs := make([]string, 0, len(matches))
+	for _, m := range matches {
+		refs = append(refs, m[1]+m[2]+m[3])
+	}
+	return refs
+}
+
+// variableOptions runs a variable query and returns the distinct values of
+// the result. For SHOW TAG VALUES the "value" column is used, otherwise the
+// first column other than "time".
+func (a *Server) variableOptions(ctx context.Context, query string, params url.Values, timeout time.Duration) ([]string, error) {
+	result := a.runPanelQuery(ctx, query, params, timeout)
+	if result.Error != "" {
+		return make([]string, 0), errors.New(result.Error)
+	}
+
+	var response struct {
+		Results []struct {
+			Series []struct {
+				Columns []string        `json:"columns"`
+				Values  [][]interface{} `json:"values"`
+			} `json:"series"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(result.Result, &response); err != nil {
+		return make([]string, 0), fmt.Errorf("decoding query result: %w", err)
+	}
+
+	options := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, res := range response.Results {
+		for _, series := range res.Series {
+			column := -1
+			for i, c := range series.Columns {
+				if c == "value" {
+					column = i
+					break
+				}
+				if c != "time" && column < 0 {
+					column = i
+				}
+			}
+			if column < 0 {
+				continue
+			}
+			for _, row := range series.Values {
+				if column >= len(row) || row[column] == nil {
+					continue
+				}
+				option := fmt.Sprint(row[column])
+				if !seen[option] {
+					seen[option] = true
+					options = append(options, option)
+				}
+			}
+		}
+	}
+	return options, nil
+}
+
+// splitOptions splits the comma separated values of custom and interval
+// variables.
+func splitOptions(query string) []string {
+	options := make([]string, 0)
+	for _, o := range strings.Split(query, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			options = append(options, o)
+		}
+	}
+	return options
+}
+
+// selectValues returns the values selected for a variable and whether the
+// "all" option is selected. Without a selection the first option is used,
+// and only the first value is kept for single value variables.
+func selectValues(v model.Variable, options, selection []string) ([]string, bool) {
+	if v.IncludeAll {
+		for _, s := range selection {
+			if s == allValue {
+				return options, true
+			}
+		}
+	}
+
+	current := make([]string, 0, len(selection))
+	for _, s := range selection {
+		if s != "" && s != allValue {
+			current = append(current, s)
+		}
+	}
+	if len(current) == 0 && len(options) > 0 {
+		current = append(current, options[0])
+	}
+	if !v.Multi && len(current) > 1 {
+		current = current[:1]
+	}
+	return current, false
+}
+
+// queryTimeout returns the per-query timeout given by the "timeout"
+// parameter.
+func queryTimeout(params url.Values) (time.Duration, error) {
+	t := params.Get("timeout")
+	if t == "" {
+		return defaultQueryTimeout, nil
+	}
+	timeout, err := time.ParseDuration(t)
+	if err != nil || timeout <= 0 || timeout > maxQueryTimeout {
+		return 0, fmt.Errorf("timeout must be a duration up to %s", maxQueryTimeout)
+	}
+	return timeout, nil
+}
@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"Dana/agent/model"
+)
+
+func TestVariableOrder(t *testing.T) {
+	variables := []model.Variable{
+		{Name: "host", Query: `SHOW TAG VALUES WITH KEY = "host" WHERE dc = '$dc'`},
+		{Name: "dc", Query: `SHOW TAG VALUES WITH KEY = "dc" WHERE region = '${region}'`},
+		{Name: "region", Type: model.VariableTypeCustom, Query: "eu,us"},
+		{Name: "a", Query: "SELECT $b"},
+		{Name: "b", Query: "SELECT [[a]]"},
+		{Name: "c", Query: "SELECT $a"},
+		{Name: "self", Query: "SELECT $self"},
+	}
+
+	order, failed := variableOrder(variables)
+
+	names := make([]string, 0, len(order))
+	for _, v := range order {
+		names = append(names, v.Name)
+	}
+	require.Equal(t, []string{"region", "dc", "host", "b", "a", "c", "self"}, names)
+
+	require.Len(t, failed, 3)
+	for _, name := range []string{"a", "b", "c"} {
+		require.True(t, errors.Is(failed[name], errCircularVariable), name)
+	}
+	require.NotContains(t, failed, "self")
+}
+
+func TestSelectValues(t *testing.T) {
+	options := []string{"a", "b", "c"}
+
+	tests := []struct {
+		name      string
+		variable  model.Variable
+		selection []string
+		expected  []string
+		all       bool
+	}{
+		{
+			name:     "defaults to the first option",
+			expected: []string{"a"},
+		},
+		{
+			name:      "single value keeps the first selection",
+			selection: []string{"c", "b"},
+			expected:  []string{"c"},
+		},
+		{
+			name:      "multi value keeps every selection",
+			variable:  model.Variable{Multi: true},
+			selection: []string{"c", "", "b"},
+			expected:  []string{"c", "b"},
+		},
+		{
+			name:      "all selects every option",
+			variable:  model.Variable{IncludeAll: true},
+			selection: []string{allValue},
+			expected:  options,
+			all:       true,
+		},
+		{
+			name:      "all is ignored unless included",
+			selection: []string{allValue},
+			expected:  []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, all := selectValues(tt.variable, options, tt.selection)
+			require.Equal(t, tt.expected, current)
+			require.Equal(t, tt.all, all)
+		})
+	}
+}
+
+func TestSplitOptions(t *testing.T) {
+	require.Equal(t, []string{"1m", "5m", "1h"}, splitOptions(" 1m, 5m,,1h ,"))
+	require.Equal(t, []string{}, splitOptions(""))
+}
+
+func TestQueryTimeout(t *testing.T) {
+	timeout, err := queryTimeout(url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, defaultQueryTimeout, timeout)
+
+	timeout, err = queryTimeout(url.Values{"timeout": {"30s"}})
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, timeout)
+
+	for _, value := range []string{"soon", "-1s", "0s", "2m"} {
+		_, err := queryTimeout(url.Values{"timeout": {value}})
+		require.Error(t, err, value)
+	}
+}
+
+func TestResolveVariables(t *testing.T) {
+	var queries []string
+	a := newInfluxTestServer(t, func(query string) map[string]interface{} {
+		queries = append(queries, query)
+		values := [][]interface{}{{"host", "web-1"}, {"host", "web-2"}, {"host", "web-1"}}
+		if strings.Contains(query, "FAIL") {
+			return map[string]interface{}{"error": "query failed"}
+		}
+		return map[string]interface{}{"series": []interface{}{
+			map[string]interface{}{"name": "cpu", "columns": []string{"key", "value"}, "values": values},
+		}}
+	})
+
+	dashboard := &model.Dashboard{
+		Variables: []model.Variable{
+			{Name: "host", Query: `SHOW TAG VALUES WITH KEY = "host" WHERE dc =~ /^$dc$/`, IncludeAll: true, AllValue: ".*"},
+			{Name: "dc", Type: model.VariableTypeCustom, Query: "eu.1,us", Multi: true},
+			{Name: "env", Type: model.VariableTypeConstant, Query: "prod"},
+			{Name: "broken", Query: "FAIL"},
+			{Name: "odd", Type: "textbox"},
+		},
+	}
+	r := queryRange{From: time.UnixMilli(0), To: time.UnixMilli(1000)}
+	selected := map[string][]string{
+		"host": {allValue},
+		"dc":   {"eu.1", "us"},
+	}
+
+	result, values := a.resolveVariables(context.Background(), dashboard, r, selected, url.Values{}, time.Second)
+
+	require.Equal(t, []model.VariableValues{
+		{
+			Name:    "host",
+			Type:    model.VariableTypeQuery,
+			Query:   `SHOW TAG VALUES WITH KEY = "host" WHERE dc =~ /^(eu\.1|us)$/`,
+			Options: []string{"web-1", "web-2"},
+			Current: []string{"web-1", "web-2"},
+			All:     true,
+		},
+		{
+			Name:    "dc",
+			Type:    model.VariableTypeCustom,
+			Options: []string{"eu.1", "us"},
+			Current: []string{"eu.1", "us"},
+		},
+		{
+			Name:    "env",
+			Type:    model.VariableTypeConstant,
+			Options: []string{"prod"},
+			Current: []string{"prod"},
+		},
+		{
+			Name:    "broken",
+			Type:    model.VariableTypeQuery,
+			Query:   "FAIL",
+			Options: []string{},
+			Current: []string{},
+			Error:   "query failed",
+		},
+		{
+			Name:    "odd",
+			Type:    "textbox",
+			Options: []string{},
+			Current: []string{},
+			Error:   `unknown variable type "textbox"`,
+		},
+	}, result)
+	require.Equal(t, map[string][]string{
+		"host":   {".*"},
+		"dc":     {"eu.1", "us"},
+		"env":    {"prod"},
+		"broken": {},
+		"odd":    {},
+	}, values)
+	require.Len(t, queries, 2)
+}
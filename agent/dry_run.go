@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"Dana"
+	"Dana/agent/model"
+	"Dana/config"
+	"Dana/internal/snmp"
+	"Dana/models"
+)
+
+// dryRunTimeout bounds the single gather of a dry run.
+const dryRunTimeout = 10 * time.Second
+
+// dryRunAccumulator records the errors a plugin reports during a dry run in
+// addition to logging them.
+type dryRunAccumulator struct {
+	Dana.Accumulator
+	plugin string
+	result *dryRunCollector
+}
+
+func (acc *dryRunAccumulator) AddError(err error) {
+	if err == nil {
+		return
+	}
+	acc.Accumulator.AddError(err)
+	acc.result.addError("gather", acc.plugin, err)
+}
+
+// dryRunCollector gathers the results of concurrently running plugins.
+type dryRunCollector struct {
+	sync.Mutex
+	result model.DryRunResult
+}
+
+func (c *dryRunCollector) addError(stage, plugin string, err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.result.Errors = append(c.result.Errors, model.DryRunError{
+		Stage:   stage,
+		Plugin:  plugin,
+		Message: err.Error(),
+	})
+}
+
+func (c *dryRunCollector) addMetric(plugin string, m Dana.Metric) {
+	c.Lock()
+	defer c.Unlock()
+	c.result.Metrics = append(c.result.Metrics, model.DryRunMetric{
+		Plugin: plugin,
+		Name:   m.Name(),
+		Tags:   m.Tags(),
+		Fields: m.Fields(),
+		Time:   m.Time(),
+	})
+}
+
+// snapshot returns a copy of the results collected so far.
+func (c *dryRunCollector) snapshot() *model.DryRunResult {
+	c.Lock()
+	defer c.Unlock()
+	result := c.result
+	result.Metrics = append(make([]model.DryRunMetric, 0, len(c.result.Metrics)), c.result.Metrics...)
+	result.Errors = append([]model.DryRunError(nil), c.result.Errors...)
+	return &result
+}
+
+// dryRunInput loads and initializes the given input configuration and runs a
+// single gather, without touching the running pipeline. Plugins not finishing
+// within dryRunTimeout are reported as gather errors.
+func (a *Server) dryRunInput(ctx context.Context, input *model.HandlerInput) *model.DryRunResult {
+	collector := &dryRunCollector{}
+	collector.result.Metrics = make([]model.DryRunMetric, 0)
+
+	tomll, err := ConvertMapToTOML(input.Data, input.Type)
+	if err != nil {
+		collector.addError("convert", "", err)
+		return collector.snapshot()
+	}
+	collector.result.TOML = string(tomll)
+
+	newConfig := config.NewConfig()
+	err = newConfig.LoadConfigData(tomll)
+	for field := range newConfig.UnusedFields {
+		collector.result.UnusedFields = append(collector.result.UnusedFields, field)
+	}
+	sort.Strings(collector.result.UnusedFields)
+	if err != nil {
+		collector.addError("config", "", err)
+		return collector.snapshot()
+	}
+	if len(newConfig.Inputs) == 0 {
+		collector.addError("config", "", fmt.Errorf("no %s input defined", input.Type))
+		return collector.snapshot()
+	}
+
+	ready := make([]*models.RunningInput, 0, len(newConfig.Inputs))
+	for _, ri := range newConfig.Inputs {
+		if ri.Config.Name != input.Type {
+			collector.addError("config", ri.LogName(), fmt.Errorf("input does not match type %s", input.Type))
+			continue
+		}
+		ri.SetDefaultTags(a.Config.Tags)
+		if tp, ok := ri.Input.(snmp.TranslatorPlugin); ok {
+			tp.SetTranslator(a.Config.Agent.SnmpTranslator)
+		}
+		if err := ri.Init(); err != nil {
+			collector.addError("init", ri.LogName(), err)
+			continue
+		}
+		ready = append(ready, ri)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dryRunTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, ri := range ready {
+		wg.Add(1)
+		go func(ri *models.RunningInput) {
+			defer wg.Done()
+			a.dryRunGather(ctx, ri, collector)
+		}(ri)
+	}
+	wg.Wait()
+
+	return collector.snapshot()
+}
+
+// dryRunGather starts the input if it is a service input and gathers once.
+func (a *Server) dryRunGather(ctx context.Context, ri *models.RunningInput, collector *dryRunCollector) {
+	plugin := ri.LogName()
+	metrics := make(chan Dana.Metric, 100)
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for m := range metrics {
+			collector.addMetric(plugin, m)
+		}
+	}()
+
+	acc := &dryRunAccumulator{
+		Accumulator: NewAccumulator(ri, metrics),
+		plugin:      plugin,
+		result:      collector,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// The channel is only closed once the plugin is done with it, which
+		// may be after the dry run gave up waiting.
+		defer close(metrics)
+		defer close(done)
+
+		if err := ri.Start(acc); err != nil {
+			collector.addError("start", plugin, err)
+			return
+		}
+		defer ri.Stop()
+
+		if err := ri.Gather(acc); err != nil {
+			acc.AddError(err)
+		}
+	}()
+
+	select {
+	case <-done:
+		<-drained
+	case <-ctx.Done():
+		collector.addError("gather", plugin, fmt.Errorf("no result within %s: %w", dryRunTimeout, ctx.Err()))
+	}
+}
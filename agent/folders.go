@@ -0,0 +1,293 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	authentication "Dana/agent/Auth"
+	"Dana/agent/model"
+)
+
+// migrationTimeout limits the folder migration run on startup.
+const migrationTimeout = time.Minute
+
+// errFolderCycle is returned when a folder would become its own ancestor.
+var errFolderCycle = errors.New("folder cannot be moved into itself or its subfolders")
+
+// folderTree is a snapshot of all folders used to resolve permissions and
+// check moves.
+type folderTree struct {
+	folders    map[primitive.ObjectID]*model.Folder
+	dashboards map[primitive.ObjectID]primitive.ObjectID
+}
+
+func (a *Server) loadFolderTree(ctx context.Context) (*folderTree, error) {
+	folders, err := a.FolderRepo.GetFolders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &folderTree{
+		folders:    make(map[primitive.ObjectID]*model.Folder, len(folders)),
+		dashboards: make(map[primitive.ObjectID]primitive.ObjectID),
+	}
+	for _, f := range folders {
+		t.folders[f.ID] = f
+		for _, id := range f.DashboardIDs {
+			t.dashboards[id] = f.ID
+		}
+	}
+	return t, nil
+}
+
+// folderRole returns the role the user has on a folder. The permissions of
+// the closest folder up the hierarchy that has any apply; users not listed
+// there have no access. Admins always keep their role.
+func (t *folderTree) folderRole(id primitive.ObjectID, username string, role model.Role) model.Role {
+	if role == model.RoleAdmin {
+		return role
+	}
+
+	seen := make(map[primitive.ObjectID]bool)
+	for f := t.folders[id]; f != nil && !seen[f.ID]; {
+		seen[f.ID] = true
+		if len(f.Permissions) > 0 {
+			for _, p := range f.Permissions {
+				if p.Username == username {
+					return minRole(role, p.Role)
+				}
+			}
+			return ""
+		}
+		if f.ParentID == nil {
+			break
+		}
+		f = t.folders[*f.ParentID]
+	}
+	return role
+}
+
+// dashboardRole returns the role the user has on a dashboard, inherited from
+// the folder holding it.
+func (t *folderTree) dashboardRole(id primitive.ObjectID, username string, role model.Role) model.Role {
+	folderID, found := t.dashboards[id]
+	if !found {
+		return role
+	}
+	return t.folderRole(folderID, username, role)
+}
+
+// hiddenFolders returns the folders the user has no access to.
+func (t *folderTree) hiddenFolders(username string, role model.Role) []primitive.ObjectID {
+	hidden := make([]primitive.ObjectID, 0)
+	for id := range t.folders {
+		if t.folderRole(id, username, role).Level() < model.RoleViewer.Level() {
+			hidden = append(hidden, id)
+		}
+	}
+	return hidden
+}
+
+// hiddenDashboards returns the dashboards in folders the user has no access
+// to.
+func (t *folderTree) hiddenDashboards(username string, role model.Role) []primitive.ObjectID {
+	hidden := make([]primitive.ObjectID, 0)
+	for id, folderID := range t.dashboards {
+		if t.folderRole(folderID, username, role).Level() < model.RoleViewer.Level() {
+			hidden = append(hidden, id)
+		}
+	}
+	return hidden
+}
+
+// isAncestor reports whether the folder ancestor is the folder id or one of
+// its parents.
+func (t *folderTree) isAncestor(ancestor, id primitive.ObjectID) bool {
+	seen := make(map[primitive.ObjectID]bool)
+	for f := t.folders[id]; f != nil && !seen[f.ID]; {
+		if f.ID == ancestor {
+			return true
+		}
+		seen[f.ID] = true
+		if f.ParentID == nil {
+			break
+		}
+		f = t.folders[*f.ParentID]
+	}
+	return false
+}
+
+// permissionSource returns the folder whose permissions apply to the folder
+// id, i.e. the closest folder up the hierarchy that has any, or nil if none
+// has and the users' own roles apply.
+func (t *folderTree) permissionSource(id *primitive.ObjectID) *primitive.ObjectID {
+	if id == nil {
+		return nil
+	}
+	seen := make(map[primitive.ObjectID]bool)
+	for f := t.folders[*id]; f != nil && !seen[f.ID]; {
+		seen[f.ID] = true
+		if len(f.Permissions) > 0 {
+			return &f.ID
+		}
+		if f.ParentID == nil {
+			break
+		}
+		f = t.folders[*f.ParentID]
+	}
+	return nil
+}
+
+// dashboardFolder returns the folder holding the dashboard id, or nil if it
+// is at the top level.
+func (t *folderTree) dashboardFolder(id primitive.ObjectID) *primitive.ObjectID {
+	folderID, found := t.dashboards[id]
+	if !found {
+		return nil
+	}
+	return &folderID
+}
+
+// moveKeepsAccess reports whether content moved from the folder from to the
+// folder to, nil being the top level, stays under the same permissions.
+func (t *folderTree) moveKeepsAccess(from, to *primitive.ObjectID) bool {
+	a, b := t.permissionSource(from), t.permissionSource(to)
+	return (a == nil) == (b == nil) && (a == nil || *a == *b)
+}
+
+func minRole(a, b model.Role) model.Role {
+	if b.Level() < a.Level() {
+		return b
+	}
+	return a
+}
+
+// requireDashboardRole only lets callers through whose role on the dashboard
+// in the "id" parameter is at least min. Unknown dashboards are left to the
+// handler to report.
+func (a *Server) requireDashboardRole(min model.Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, err := primitive.ObjectIDFromHex(c.Param("id"))
+			if err != nil {
+				return next(c)
+			}
+			tree, err := a.loadFolderTree(c.Request().Context())
+			if err != nil {
+				c.Logger().Error("Error loading folders: ", err)
+				return c.JSON(500, "internal server error")
+			}
+			role := tree.dashboardRole(id, authentication.Username(c), authentication.Role(c))
+			if role.Level() < min.Level() {
+				return c.String(http.StatusForbidden, "forbidden")
+			}
+			return next(c)
+		}
+	}
+}
+
+// requireFolderRole only lets callers through whose role on the folder in
+// the "id" parameter is at least min.
+func (a *Server) requireFolderRole(min model.Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, err := primitive.ObjectIDFromHex(c.Param("id"))
+			if err != nil {
+				return next(c)
+			}
+			tree, err := a.loadFolderTree(c.Request().Context())
+			if err != nil {
+				c.Logger().Error("Error loading folders: ", err)
+				return c.JSON(500, "internal server error")
+			}
+			role := tree.folderRole(id, authentication.Username(c), authentication.Role(c))
+			if role.Level() < min.Level() {
+				return c.String(http.StatusForbidden, "forbidden")
+			}
+			return next(c)
+		}
+	}
+}
+
+// migrateFolders converts folders embedding dashboard copies to folders
+// referencing dashboards. Embedded copies identical to a dashboard that still
+// exists are dropped in favor of the dashboard itself. Copies differing from
+// it are kept as new dashboards in the same folder, as there is no telling
+// which one is newer, and copies of deleted dashboards are stored as new
+// dashboards. A dashboard embedded in several folders is kept in the first.
+func (a *Server) migrateFolders(ctx context.Context) error {
+	folders, err := a.FolderRepo.GetLegacyFolders(ctx)
+	if err != nil {
+		return fmt.Errorf("loading folders: %w", err)
+	}
+
+	assigned := make(map[primitive.ObjectID]bool)
+	for _, folder := range folders {
+		name := folder.Name
+		if name == "" {
+			name = "Folder " + folder.ID.Hex()
+		}
+
+		ids := make([]primitive.ObjectID, 0, len(folder.LegacyDashboards))
+		for i := range folder.LegacyDashboards {
+			embedded := &folder.LegacyDashboards[i]
+			if assigned[embedded.ID] {
+				log.Printf("W! [agent] Folder %s: dashboard %s is already in another folder", folder.ID.Hex(), embedded.ID.Hex())
+				continue
+			}
+			if !embedded.ID.IsZero() {
+				stored, err := a.DashboardRepo.GetDashboard(ctx, embedded.ID.Hex())
+				switch {
+				case err == nil:
+					assigned[embedded.ID] = true
+					ids = append(ids, embedded.ID)
+					if sameDashboard(stored, embedded) {
+						log.Printf("I! [agent] Folder %s: using dashboard %s instead of its embedded copy", folder.ID.Hex(), embedded.ID.Hex())
+						continue
+					}
+					log.Printf("W! [agent] Folder %s: embedded copy of dashboard %s differs from it, keeping the copy as a new dashboard",
+						folder.ID.Hex(), embedded.ID.Hex())
+					embedded.Name += " (copy from " + name + ")"
+				case !errors.Is(err, mongo.ErrNoDocuments):
+					return fmt.Errorf("loading dashboard %s: %w", embedded.ID.Hex(), err)
+				}
+			}
+
+			id, err := a.DashboardRepo.CreateDashboard(ctx, embedded)
+			if err != nil {
+				return fmt.Errorf("storing dashboard of folder %s: %w", folder.ID.Hex(), err)
+			}
+			embedded.ID = id
+			embedded.Version = 1
+			if err := a.recordRevision(ctx, embedded, "", "Migrated from folder "+folder.ID.Hex()); err != nil {
+				return fmt.Errorf("storing revision of dashboard %s: %w", id.Hex(), err)
+			}
+			assigned[id] = true
+			ids = append(ids, id)
+		}
+
+		if err := a.FolderRepo.ConvertLegacyFolder(ctx, folder.ID, name, ids); err != nil {
+			return fmt.Errorf("converting folder %s: %w", folder.ID.Hex(), err)
+		}
+		log.Printf("I! [agent] Converted folder %s with %d dashboard(s)", folder.ID.Hex(), len(ids))
+	}
+	return nil
+}
+
+// sameDashboard reports whether two dashboards have the same content,
+// whatever their id and version.
+func sameDashboard(a, b *model.Dashboard) bool {
+	x, y := *a, *b
+	x.ID, y.ID = primitive.NilObjectID, primitive.NilObjectID
+	x.Version, y.Version = 0, 0
+	changes, err := diffDashboards(&x, &y)
+	return err == nil && len(changes) == 0
+}
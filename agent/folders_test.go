@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"Dana/agent/model"
+	"Dana/agent/repository"
+)
+
+// storedFolders serves a fixed set of folders and records the changes made
+// to them.
+type storedFolders struct {
+	repository.FolderRepo
+	folders []*model.Folder
+	moved   map[primitive.ObjectID]*primitive.ObjectID
+	created []*model.Folder
+	deleted []string
+}
+
+func (r *storedFolders) GetFolders(context.Context) ([]*model.Folder, error) {
+	return r.folders, nil
+}
+
+func (r *storedFolders) CreateFolder(_ context.Context, folder *model.Folder) (primitive.ObjectID, error) {
+	r.created = append(r.created, folder)
+	return primitive.NewObjectID(), nil
+}
+
+func (r *storedFolders) MoveDashboard(_ context.Context, dashboardID primitive.ObjectID, folderID *primitive.ObjectID) error {
+	if r.moved == nil {
+		r.moved = make(map[primitive.ObjectID]*primitive.ObjectID)
+	}
+	r.moved[dashboardID] = folderID
+	return nil
+}
+
+func (r *storedFolders) DeleteFolder(_ context.Context, id string) error {
+	r.deleted = append(r.deleted, id)
+	return nil
+}
+
+// existingDashboards knows every dashboard, whatever its id.
+type existingDashboards struct {
+	repository.DashboardRepo
+}
+
+func (existingDashboards) GetDashboard(_ context.Context, id string) (*model.Dashboard, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &model.Dashboard{ID: objectID}, nil
+}
+
+// restrictedTree holds two folders restricting access, a subfolder
+// inheriting the permissions of one of them and an open folder, with a
+// dashboard in the subfolder, one in the open folder and one at the top
+// level.
+type restrictedTree struct {
+	restricted, other, child, open primitive.ObjectID
+	inChild, inOpen, atTop         primitive.ObjectID
+}
+
+func newRestrictedTree() (*restrictedTree, []*model.Folder) {
+	t := &restrictedTree{
+		restricted: primitive.NewObjectID(),
+		other:      primitive.NewObjectID(),
+		child:      primitive.NewObjectID(),
+		open:       primitive.NewObjectID(),
+		inChild:    primitive.NewObjectID(),
+		inOpen:     primitive.NewObjectID(),
+		atTop:      primitive.NewObjectID(),
+	}
+	bob := []model.FolderPermission{{Username: "bob", Role: model.RoleEditor}}
+	return t, []*model.Folder{
+		{ID: t.restricted, Name: "restricted", Permissions: bob},
+		{ID: t.other, Name: "other", Permissions: bob},
+		{ID: t.child, Name: "child", ParentID: &t.restricted, DashboardIDs: []primitive.ObjectID{t.inChild}},
+		{ID: t.open, Name: "open", DashboardIDs: []primitive.ObjectID{t.inOpen}},
+	}
+}
+
+func newFolderContext(method, target, body string, role model.Role) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	ctx := echo.New().NewContext(req, rec)
+	ctx.Set("username", "bob")
+	ctx.Set("role", string(role))
+	return ctx, rec
+}
+
+func TestFolderTreeMoveKeepsAccess(t *testing.T) {
+	ids, folders := newRestrictedTree()
+	tree := &folderTree{
+		folders:    make(map[primitive.ObjectID]*model.Folder),
+		dashboards: make(map[primitive.ObjectID]primitive.ObjectID),
+	}
+	for _, f := range folders {
+		tree.folders[f.ID] = f
+		for _, id := range f.DashboardIDs {
+			tree.dashboards[id] = f.ID
+		}
+	}
+
+	require.Equal(t, &ids.restricted, tree.permissionSource(&ids.child))
+	require.Equal(t, &ids.restricted, tree.permissionSource(&ids.restricted))
+	require.Nil(t, tree.permissionSource(&ids.open))
+	require.Nil(t, tree.permissionSource(nil))
+
+	require.Equal(t, &ids.child, tree.dashboardFolder(ids.inChild))
+	require.Nil(t, tree.dashboardFolder(ids.atTop))
+
+	require.True(t, tree.moveKeepsAccess(&ids.child, &ids.restricted))
+	require.True(t, tree.moveKeepsAccess(&ids.open, nil))
+	require.True(t, tree.moveKeepsAccess(nil, nil))
+	require.False(t, tree.moveKeepsAccess(&ids.child, nil))
+	require.False(t, tree.moveKeepsAccess(&ids.child, &ids.open))
+	require.False(t, tree.moveKeepsAccess(&ids.child, &ids.other))
+	require.False(t, tree.moveKeepsAccess(nil, &ids.restricted))
+}
+
+func TestMoveDashboardPermissions(t *testing.T) {
+	ids, folders := newRestrictedTree()
+
+	tests := []struct {
+		name      string
+		dashboard primitive.ObjectID
+		to        *primitive.ObjectID
+		role      model.Role
+		expected  int
+	}{
+		{name: "out of a restricted folder to the top level", dashboard: ids.inChild, role: model.RoleEditor, expected: http.StatusForbidden},
+		{name: "out of a restricted folder to an open one", dashboard: ids.inChild, to: &ids.open, role: model.RoleEditor, expected: http.StatusForbidden},
+		{name: "to a folder with other permissions", dashboard: ids.inChild, to: &ids.other, role: model.RoleEditor, expected: http.StatusForbidden},
+		{name: "into a restricted folder", dashboard: ids.atTop, to: &ids.restricted, role: model.RoleEditor, expected: http.StatusForbidden},
+		{name: "within a restricted folder", dashboard: ids.inChild, to: &ids.restricted, role: model.RoleEditor, expected: http.StatusOK},
+		{name: "open folder to the top level", dashboard: ids.inOpen, role: model.RoleEditor, expected: http.StatusOK},
+		{name: "top level to an open folder", dashboard: ids.atTop, to: &ids.open, role: model.RoleEditor, expected: http.StatusOK},
+		{name: "admin out of a restricted folder", dashboard: ids.inChild, role: model.RoleAdmin, expected: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &storedFolders{folders: folders}
+			a := &Server{FolderRepo: repo, DashboardRepo: existingDashboards{}}
+
+			body := `{"folder_id":null}`
+			if tt.to != nil {
+				body = `{"folder_id":"` + tt.to.Hex() + `"}`
+			}
+			ctx, rec := newFolderContext(http.MethodPost, "/api/v1/dashboards/"+tt.dashboard.Hex()+"/move", body, tt.role)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(tt.dashboard.Hex())
+
+			require.NoError(t, a.MoveDashboard(ctx))
+			require.Equal(t, tt.expected, rec.Code, rec.Body.String())
+			if tt.expected != http.StatusOK {
+				require.Empty(t, repo.moved)
+				return
+			}
+			require.Contains(t, repo.moved, tt.dashboard)
+			require.Equal(t, tt.to, repo.moved[tt.dashboard])
+		})
+	}
+}
+
+func TestCreateFolderWithDashboardsPermissions(t *testing.T) {
+	ids, folders := newRestrictedTree()
+
+	tests := []struct {
+		name     string
+		body     string
+		role     model.Role
+		expected int
+	}{
+		{
+			name:     "restricted dashboard to the top level",
+			body:     `{"name":"new","dashboard_ids":["` + ids.inChild.Hex() + `"]}`,
+			role:     model.RoleEditor,
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "restricted dashboard within the restricted folder",
+			body:     `{"name":"new","parent_id":"` + ids.restricted.Hex() + `","dashboard_ids":["` + ids.inChild.Hex() + `"]}`,
+			role:     model.RoleEditor,
+			expected: http.StatusCreated,
+		},
+		{
+			name:     "open dashboards to the top level",
+			body:     `{"name":"new","dashboard_ids":["` + ids.inOpen.Hex() + `","` + ids.atTop.Hex() + `"]}`,
+			role:     model.RoleEditor,
+			expected: http.StatusCreated,
+		},
+		{
+			name:     "admin moves a restricted dashboard to the top level",
+			body:     `{"name":"new","dashboard_ids":["` + ids.inChild.Hex() + `"]}`,
+			role:     model.RoleAdmin,
+			expected: http.StatusCreated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &storedFolders{folders: folders}
+			a := &Server{FolderRepo: repo, DashboardRepo: existingDashboards{}}
+
+			ctx, rec := newFolderContext(http.MethodPost, "/api/v1/folders", tt.body, tt.role)
+			require.NoError(t, a.CreateFolder(ctx))
+			require.Equal(t, tt.expected, rec.Code, rec.Body.String())
+			if tt.expected != http.StatusCreated {
+				require.Empty(t, repo.created)
+				require.Empty(t, repo.moved)
+				return
+			}
+			require.Len(t, repo.created, 1)
+			require.NotEmpty(t, repo.moved)
+		})
+	}
+}
+
+func TestDeleteFolderPermissions(t *testing.T) {
+	ids, folders := newRestrictedTree()
+
+	tests := []struct {
+		name     string
+		folder   primitive.ObjectID
+		role     model.Role
+		expected int
+	}{
+		{name: "folder with permissions", folder: ids.restricted, role: model.RoleEditor, expected: http.StatusForbidden},
+		{name: "folder inheriting permissions", folder: ids.child, role: model.RoleEditor, expected: http.StatusOK},
+		{name: "open folder", folder: ids.open, role: model.RoleEditor, expected: http.StatusOK},
+		{name: "admin deletes a folder with permissions", folder: ids.restricted, role: model.RoleAdmin, expected: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &storedFolders{folders: folders}
+			a := &Server{FolderRepo: repo}
+
+			ctx, rec := newFolderContext(http.MethodDelete, "/api/v1/folders/"+tt.folder.Hex(), "", tt.role)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(tt.folder.Hex())
+
+			require.NoError(t, a.DeleteFolder(ctx))
+			require.Equal(t, tt.expected, rec.Code, rec.Body.String())
+			if tt.expected != http.StatusOK {
+				require.Empty(t, repo.deleted)
+				return
+			}
+			require.Equal(t, []string{tt.folder.Hex()}, repo.deleted)
+		})
+	}
+}
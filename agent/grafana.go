@@ -0,0 +1,562 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"Dana/agent/model"
+)
+
+// grafanaSchemaVersion is the dashboard schema version written on export.
+// Grafana migrates older versions on import, so a fixed version is enough.
+const grafanaSchemaVersion = 39
+
+// grafanaPanelTypes maps the Grafana visualizations to panel types. Other
+// Grafana panels, e.g. text or news panels, cannot be represented by a Panel.
+var grafanaPanelTypes = map[string]string{
+	"timeseries": model.PanelTypeTimeseries,
+	"graph":      model.PanelTypeTimeseries,
+	"stat":       model.PanelTypeStat,
+	"singlestat": model.PanelTypeStat,
+	"gauge":      model.PanelTypeGauge,
+	"bargauge":   model.PanelTypeGauge,
+	"table":      model.PanelTypeTable,
+	"table-old":  model.PanelTypeTable,
+	"heatmap":    model.PanelTypeHeatmap,
+}
+
+// grafanaMatchers maps the Grafana field matchers to FieldMatcher types.
+var grafanaMatchers = map[string]string{
+	"byName":       "by_name",
+	"byRegexp":     "by_regexp",
+	"byFrameRefID": "by_query",
+}
+
+var errNotGrafanaDashboard = errors.New("not a Grafana dashboard")
+
+// grafanaDashboard is the subset of the Grafana dashboard JSON model used by
+// the import and export.
+type grafanaDashboard struct {
+	UID           string             `json:"uid,omitempty"`
+	Title         string             `json:"title"`
+	Tags          []string           `json:"tags,omitempty"`
+	SchemaVersion int                `json:"schemaVersion"`
+	Version       int                `json:"version,omitempty"`
+	Time          *grafanaTimeRange  `json:"time,omitempty"`
+	Panels        []grafanaPanel     `json:"panels"`
+	Templating    grafanaTemplating  `json:"templating"`
+	Rows          []grafanaLegacyRow `json:"rows,omitempty"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaPanel struct {
+	ID          int                 `json:"id"`
+	Type        string              `json:"type"`
+	Title       string              `json:"title"`
+	GridPos     *grafanaGridPos     `json:"gridPos,omitempty"`
+	Targets     []grafanaTarget     `json:"targets,omitempty"`
+	FieldConfig *grafanaFieldConfig `json:"fieldConfig,omitempty"`
+	Options     *grafanaOptions     `json:"options,omitempty"`
+	// Panels holds the panels of collapsed rows
+	Panels []grafanaPanel `json:"panels,omitempty"`
+}
+
+// grafanaLegacyRow is a row of dashboards written before schema version 16,
+// where panels were nested in rows.
+type grafanaLegacyRow struct {
+	Panels []grafanaPanel `json:"panels"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaTarget struct {
+	RefID    string `json:"refId"`
+	Query    string `json:"query,omitempty"`
+	RawQuery bool   `json:"rawQuery,omitempty"`
+	Expr     string `json:"expr,omitempty"`
+	RawSQL   string `json:"rawSql,omitempty"`
+}
+
+type grafanaFieldConfig struct {
+	Defaults  grafanaFieldDefaults   `json:"defaults"`
+	Overrides []grafanaFieldOverride `json:"overrides"`
+}
+
+type grafanaFieldDefaults struct {
+	Unit       string             `json:"unit,omitempty"`
+	Decimals   *int               `json:"decimals,omitempty"`
+	Color      *grafanaColor      `json:"color,omitempty"`
+	Thresholds *grafanaThresholds `json:"thresholds,omitempty"`
+}
+
+type grafanaThresholds struct {
+	Mode  string                 `json:"mode"`
+	Steps []grafanaThresholdStep `json:"steps"`
+}
+
+type grafanaThresholdStep struct {
+	Color string   `json:"color"`
+	Value *float64 `json:"value"`
+}
+
+type grafanaOptions struct {
+	Legend *grafanaLegend `json:"legend,omitempty"`
+}
+
+type grafanaLegend struct {
+	ShowLegend  bool     `json:"showLegend"`
+	DisplayMode string   `json:"displayMode,omitempty"`
+	Placement   string   `json:"placement,omitempty"`
+	Calcs       []string `json:"calcs"`
+}
+
+type grafanaColor struct {
+	Mode       string `json:"mode"`
+	FixedColor string `json:"fixedColor,omitempty"`
+}
+
+type grafanaFieldOverride struct {
+	Matcher    grafanaMatcher    `json:"matcher"`
+	Properties []grafanaProperty `json:"properties"`
+}
+
+type grafanaMatcher struct {
+	ID      string      `json:"id"`
+	Options interface{} `json:"options"`
+}
+
+type grafanaProperty struct {
+	ID    string      `json:"id"`
+	Value interface{} `json:"value"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Query      json.RawMessage `json:"query,omitempty"`
+	Definition string          `json:"definition,omitempty"`
+	Multi      bool            `json:"multi,omitempty"`
+	IncludeAll bool            `json:"includeAll,omitempty"`
+	AllValue   string          `json:"allValue,omitempty"`
+}
+
+// parseGrafanaDashboard decodes a Grafana dashboard either as exported from
+// the UI or wrapped in a "dashboard" field as returned by Grafana's HTTP API.
+func parseGrafanaDashboard(data []byte) (*grafanaDashboard, error) {
+	var wrapper struct {
+		Dashboard json.RawMessage `json:"dashboard"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return nil, err
+	}
+	if len(wrapper.Dashboard) > 0 {
+		data = wrapper.Dashboard
+	}
+
+	var dashboard grafanaDashboard
+	if err := json.Unmarshal(data, &dashboard); err != nil {
+		return nil, err
+	}
+	if dashboard.Title == "" && len(dashboard.Panels) == 0 && len(dashboard.Rows) == 0 {
+		return nil, errNotGrafanaDashboard
+	}
+	return &dashboard, nil
+}
+
+// fromGrafana converts a Grafana dashboard to a Dashboard. Everything that
+// cannot be converted is returned as unsupported.
+func fromGrafana(g *grafanaDashboard) (*model.Dashboard, []model.GrafanaUnsupported) {
+	dashboard := &model.Dashboard{
+		Name:      g.Title,
+		Tags:      g.Tags,
+		Panels:    make([]model.Panel, 0, len(g.Panels)),
+		Variables: make([]model.Variable, 0, len(g.Templating.List)),
+	}
+	unsupported := make([]model.GrafanaUnsupported, 0)
+
+	// Flatten rows, both collapsed ones and those of old dashboards
+	panels := make([]grafanaPanel, 0, len(g.Panels))
+	for _, row := range g.Rows {
+		panels = append(panels, row.Panels...)
+	}
+	for _, p := range g.Panels {
+		if p.Type == "row" {
+			panels = append(panels, p.Panels...)
+			continue
+		}
+		panels = append(panels, p)
+	}
+
+	for _, p := range panels {
+		panelType, found := grafanaPanelTypes[p.Type]
+		if !found {
+			unsupported = append(unsupported, model.GrafanaUnsupported{
+				Kind:   "panel",
+				Name:   p.Title,
+				Type:   p.Type,
+				Reason: "panel type is not supported",
+			})
+			continue
+		}
+
+		panel := model.Panel{
+			Name:  p.Title,
+			Index: len(dashboard.Panels),
+			Type:  panelType,
+		}
+		if p.GridPos != nil {
+			panel.GridPos = &model.GridPos{X: p.GridPos.X, Y: p.GridPos.Y, W: p.GridPos.W, H: p.GridPos.H}
+		}
+		if p.Options != nil && p.Options.Legend != nil {
+			l := p.Options.Legend
+			panel.Legend = &model.Legend{
+				Show:        l.ShowLegend && l.DisplayMode != "hidden",
+				Placement:   l.Placement,
+				DisplayMode: l.DisplayMode,
+				Calcs:       l.Calcs,
+			}
+		}
+		var refIDs []string
+		for _, t := range p.Targets {
+			name := p.Title + "/" + t.RefID
+			switch {
+			case t.Query != "":
+				panel.Query = append(panel.Query, t.Query)
+				refIDs = append(refIDs, t.RefID)
+			case t.Expr != "":
+				unsupported = append(unsupported, model.GrafanaUnsupported{
+					Kind: "target", Name: name, Type: "prometheus", Reason: "only InfluxDB queries are supported",
+				})
+			case t.RawSQL != "":
+				unsupported = append(unsupported, model.GrafanaUnsupported{
+					Kind: "target", Name: name, Type: "sql", Reason: "only InfluxDB queries are supported",
+				})
+			default:
+				unsupported = append(unsupported, model.GrafanaUnsupported{
+					Kind: "target", Name: name, Reason: "target has no query text",
+				})
+			}
+		}
+		panel.Colors = grafanaColors(p.FieldConfig, refIDs)
+		if fc := p.FieldConfig; fc != nil {
+			panel.Unit = fc.Defaults.Unit
+			panel.Decimals = fc.Defaults.Decimals
+			if t := fc.Defaults.Thresholds; t != nil && len(t.Steps) > 0 {
+				panel.Thresholds = &model.Thresholds{Mode: t.Mode, Steps: make([]model.ThresholdStep, 0, len(t.Steps))}
+				for i, step := range t.Steps {
+					value := step.Value
+					if i == 0 {
+						// Grafana stores the base step as -Infinity or null
+						value = nil
+					}
+					panel.Thresholds.Steps = append(panel.Thresholds.Steps, model.ThresholdStep{Value: value, Color: step.Color})
+				}
+			}
+			panel.Overrides = grafanaOverrides(fc.Overrides, refIDs)
+		}
+
+		dashboard.Panels = append(dashboard.Panels, panel)
+	}
+
+	for _, v := range g.Templating.List {
+		switch v.Type {
+		case model.VariableTypeQuery, model.VariableTypeCustom, model.VariableTypeConstant, model.VariableTypeInterval:
+		default:
+			unsupported = append(unsupported, model.GrafanaUnsupported{
+				Kind:   "variable",
+				Name:   v.Name,
+				Type:   v.Type,
+				Reason: "variable type is not supported",
+			})
+			continue
+		}
+		query := grafanaVariableQuery(v)
+		if query == "" && v.Type == model.VariableTypeQuery {
+			unsupported = append(unsupported, model.GrafanaUnsupported{
+				Kind:   "variable",
+				Name:   v.Name,
+				Type:   v.Type,
+				Reason: "variable has no query text",
+			})
+			continue
+		}
+		dashboard.Variables = append(dashboard.Variables, model.Variable{
+			Name:       v.Name,
+			Query:      query,
+			Type:       v.Type,
+			Multi:      v.Multi,
+			IncludeAll: v.IncludeAll,
+			AllValue:   v.AllValue,
+		})
+	}
+
+	return dashboard, unsupported
+}
+
+// grafanaColors returns the fixed color of each query, taken from the
+// overrides matching the query's refId or the panel's default color.
+func grafanaColors(fc *grafanaFieldConfig, refIDs []string) []string {
+	if fc == nil {
+		return nil
+	}
+
+	var fallback string
+	if fc.Defaults.Color != nil && fc.Defaults.Color.Mode == "fixed" {
+		fallback = fc.Defaults.Color.FixedColor
+	}
+	byRefID := make(map[string]string)
+	for _, o := range fc.Overrides {
+		refID, ok := o.Matcher.Options.(string)
+		if o.Matcher.ID != "byFrameRefID" || !ok {
+			continue
+		}
+		for _, prop := range o.Properties {
+			if prop.ID != "color" {
+				continue
+			}
+			if c, ok := prop.Value.(map[string]interface{}); ok {
+				if fixed, ok := c["fixedColor"].(string); ok {
+					byRefID[refID] = fixed
+				}
+			}
+		}
+	}
+	if fallback == "" && len(byRefID) == 0 {
+		return nil
+	}
+
+	colors := make([]string, 0, len(refIDs))
+	for _, refID := range refIDs {
+		if c, found := byRefID[refID]; found {
+			colors = append(colors, c)
+			continue
+		}
+		colors = append(colors, fallback)
+	}
+	if len(colors) == 0 {
+		colors = append(colors, fallback)
+	}
+	return colors
+}
+
+// grafanaOverrides converts the Grafana field overrides with the properties
+// a FieldOverride supports. Colors of single queries are kept in the panel's
+// colors instead, and queries are renamed after the ones imported.
+func grafanaOverrides(overrides []grafanaFieldOverride, refIDs []string) []model.FieldOverride {
+	queries := make(map[string]string, len(refIDs))
+	for i, refID := range refIDs {
+		queries[refID] = grafanaRefID(i)
+	}
+
+	result := make([]model.FieldOverride, 0)
+	for _, o := range overrides {
+		matcher, found := grafanaMatchers[o.Matcher.ID]
+		value, ok := o.Matcher.Options.(string)
+		if !found || !ok || value == "" {
+			continue
+		}
+		if matcher == "by_query" {
+			if value, found = queries[value]; !found {
+				continue
+			}
+		}
+
+		override := model.FieldOverride{Matcher: model.FieldMatcher{Type: matcher, Value: value}}
+		for _, prop := range o.Properties {
+			switch prop.ID {
+			case "displayName":
+				override.DisplayName, _ = prop.Value.(string)
+			case "unit":
+				override.Unit, _ = prop.Value.(string)
+			case "decimals":
+				if d, ok := prop.Value.(float64); ok {
+					decimals := int(d)
+					override.Decimals = &decimals
+				}
+			case "color":
+				if c, ok := prop.Value.(map[string]interface{}); ok && matcher != "by_query" {
+					override.Color, _ = c["fixedColor"].(string)
+				}
+			}
+		}
+		if override.DisplayName != "" || override.Unit != "" || override.Decimals != nil || override.Color != "" {
+			result = append(result, override)
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+// grafanaVariableQuery returns the query of a templating variable, which is
+// either a plain string or an object holding the query depending on the data
+// source and Grafana version.
+func grafanaVariableQuery(v grafanaVariable) string {
+	var query string
+	if err := json.Unmarshal(v.Query, &query); err == nil && query != "" {
+		return query
+	}
+	var object struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(v.Query, &object); err == nil && object.Query != "" {
+		return object.Query
+	}
+	return v.Definition
+}
+
+// toGrafana converts a Dashboard to a Grafana dashboard with one target per
+// query. Panels without a position are laid out in two columns.
+func toGrafana(d *model.Dashboard) *grafanaDashboard {
+	g := &grafanaDashboard{
+		UID:           d.ID.Hex(),
+		Title:         d.Name,
+		Tags:          d.Tags,
+		SchemaVersion: grafanaSchemaVersion,
+		Version:       d.Version,
+		Time:          &grafanaTimeRange{From: "now-6h", To: "now"},
+		Panels:        make([]grafanaPanel, 0, len(d.Panels)),
+		Templating:    grafanaTemplating{List: make([]grafanaVariable, 0, len(d.Variables))},
+	}
+
+	for i, p := range d.Panels {
+		panel := grafanaPanel{
+			ID:    i + 1,
+			Type:  p.Type,
+			Title: p.Name,
+			GridPos: &grafanaGridPos{
+				H: 8,
+				W: 12,
+				X: (i % 2) * 12,
+				Y: (i / 2) * 8,
+			},
+			Targets: make([]grafanaTarget, 0, len(p.Query)),
+			FieldConfig: &grafanaFieldConfig{
+				Defaults: grafanaFieldDefaults{
+					Unit:     p.Unit,
+					Decimals: p.Decimals,
+				},
+				Overrides: make([]grafanaFieldOverride, 0, len(p.Colors)+len(p.Overrides)),
+			},
+		}
+		if panel.Type == "" {
+			panel.Type = model.PanelTypeTimeseries
+		}
+		if g := p.GridPos; g != nil {
+			panel.GridPos = &grafanaGridPos{H: g.H, W: g.W, X: g.X, Y: g.Y}
+		}
+		if t := p.Thresholds; t != nil {
+			thresholds := &grafanaThresholds{Mode: t.Mode, Steps: make([]grafanaThresholdStep, 0, len(t.Steps))}
+			if thresholds.Mode == "" {
+				thresholds.Mode = "absolute"
+			}
+			for _, step := range t.Steps {
+				thresholds.Steps = append(thresholds.Steps, grafanaThresholdStep{Color: step.Color, Value: step.Value})
+			}
+			panel.FieldConfig.Defaults.Thresholds = thresholds
+		}
+		if l := p.Legend; l != nil {
+			legend := &grafanaLegend{
+				ShowLegend:  l.Show,
+				DisplayMode: l.DisplayMode,
+				Placement:   l.Placement,
+				Calcs:       l.Calcs,
+			}
+			if legend.Calcs == nil {
+				legend.Calcs = make([]string, 0)
+			}
+			panel.Options = &grafanaOptions{Legend: legend}
+		}
+		for j, q := range p.Query {
+			refID := grafanaRefID(j)
+			panel.Targets = append(panel.Targets, grafanaTarget{RefID: refID, Query: q, RawQuery: true})
+			if j >= len(p.Colors) || p.Colors[j] == "" {
+				continue
+			}
+			panel.FieldConfig.Overrides = append(panel.FieldConfig.Overrides, grafanaFieldOverride{
+				Matcher: grafanaMatcher{ID: "byFrameRefID", Options: refID},
+				Properties: []grafanaProperty{{
+					ID:    "color",
+					Value: map[string]interface{}{"mode": "fixed", "fixedColor": p.Colors[j]},
+				}},
+			})
+		}
+		for _, o := range p.Overrides {
+			panel.FieldConfig.Overrides = append(panel.FieldConfig.Overrides, toGrafanaOverride(o))
+		}
+		g.Panels = append(g.Panels, panel)
+	}
+
+	for _, v := range d.Variables {
+		query, _ := json.Marshal(v.Query)
+		variable := grafanaVariable{
+			Name:       v.Name,
+			Type:       v.Type,
+			Query:      query,
+			Multi:      v.Multi,
+			IncludeAll: v.IncludeAll,
+			AllValue:   v.AllValue,
+		}
+		if variable.Type == "" {
+			variable.Type = model.VariableTypeQuery
+		}
+		if variable.Type == model.VariableTypeQuery {
+			variable.Definition = v.Query
+		}
+		g.Templating.List = append(g.Templating.List, variable)
+	}
+
+	return g
+}
+
+// toGrafanaOverride converts a FieldOverride to a Grafana field override.
+func toGrafanaOverride(o model.FieldOverride) grafanaFieldOverride {
+	override := grafanaFieldOverride{Properties: make([]grafanaProperty, 0, 4)}
+	for id, matcher := range grafanaMatchers {
+		if matcher == o.Matcher.Type {
+			override.Matcher = grafanaMatcher{ID: id, Options: o.Matcher.Value}
+		}
+	}
+	if o.DisplayName != "" {
+		override.Properties = append(override.Properties, grafanaProperty{ID: "displayName", Value: o.DisplayName})
+	}
+	if o.Unit != "" {
+		override.Properties = append(override.Properties, grafanaProperty{ID: "unit", Value: o.Unit})
+	}
+	if o.Decimals != nil {
+		override.Properties = append(override.Properties, grafanaProperty{ID: "decimals", Value: *o.Decimals})
+	}
+	if o.Color != "" {
+		override.Properties = append(override.Properties, grafanaProperty{
+			ID:    "color",
+			Value: map[string]interface{}{"mode": "fixed", "fixedColor": o.Color},
+		})
+	}
+	return override
+}
+
+// grafanaRefID returns the refId Grafana assigns to the n-th target, i.e.
+// "A" to "Z" followed by "AA", "AB" and so on.
+func grafanaRefID(n int) string {
+	id := ""
+	for n >= 0 {
+		id = fmt.Sprintf("%c", 'A'+n%26) + id
+		n = n/26 - 1
+	}
+	return id
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"Dana/agent/model"
+)
+
+func TestGrafanaRoundTrip(t *testing.T) {
+	data, err := os.ReadFile("testdata/grafana_dashboard.json")
+	require.NoError(t, err)
+
+	g, err := parseGrafanaDashboard(data)
+	require.NoError(t, err)
+	dashboard, unsupported := fromGrafana(g)
+
+	decimals1, decimals2 := 1, 2
+	red := 90.0
+	expected := &model.Dashboard{
+		Name: "Servers",
+		Tags: []string{"infra", "linux"},
+		Panels: []model.Panel{
+			{
+				Name: "CPU",
+				Query: []string{
+					`SELECT mean("usage_user") FROM "cpu" WHERE "host" =~ /^$host$/ AND $timeFilter GROUP BY time($__interval)`,
+					`SELECT mean("usage_system") FROM "cpu" WHERE $timeFilter GROUP BY time($__interval)`,
+				},
+				Index:    0,
+				Colors:   []string{"blue", "orange"},
+				Type:     model.PanelTypeTimeseries,
+				GridPos:  &model.GridPos{X: 0, Y: 0, W: 16, H: 9},
+				Unit:     "percent",
+				Decimals: &decimals1,
+				Thresholds: &model.Thresholds{
+					Mode: "absolute",
+					Steps: []model.ThresholdStep{
+						{Color: "green"},
+						{Color: "red", Value: &red},
+					},
+				},
+				Legend: &model.Legend{Show: true, Placement: "right", DisplayMode: "table", Calcs: []string{"mean", "max"}},
+				Overrides: []model.FieldOverride{
+					// The refId is renamed after the imported queries
+					{Matcher: model.FieldMatcher{Type: "by_query", Value: "B"}, DisplayName: "system"},
+					{Matcher: model.FieldMatcher{Type: "by_name", Value: "cpu.mean"}, Unit: "short", Decimals: &decimals2, Color: "purple"},
+				},
+			},
+			{
+				Name:    "Used memory",
+				Query:   []string{`SELECT last("used_percent") FROM "mem" WHERE $timeFilter`},
+				Index:   1,
+				Type:    model.PanelTypeStat,
+				GridPos: &model.GridPos{X: 0, Y: 10, W: 6, H: 6},
+			},
+		},
+		Variables: []model.Variable{
+			{Name: "host", Query: `SHOW TAG VALUES WITH KEY = "host"`, Type: model.VariableTypeQuery, Multi: true, IncludeAll: true, AllValue: ".*"},
+			{Name: "interval", Query: "1m,5m,1h", Type: model.VariableTypeInterval},
+		},
+	}
+	require.Equal(t, expected, dashboard)
+	require.Equal(t, []model.GrafanaUnsupported{
+		{Kind: "target", Name: "CPU/B", Type: "prometheus", Reason: "only InfluxDB queries are supported"},
+		{Kind: "panel", Name: "Notes", Type: "text", Reason: "panel type is not supported"},
+		{Kind: "target", Name: "Used memory/B", Type: "sql", Reason: "only InfluxDB queries are supported"},
+		{Kind: "variable", Name: "source", Type: "datasource", Reason: "variable type is not supported"},
+	}, unsupported)
+
+	// Exporting and importing again keeps everything a Dashboard holds
+	exported, err := json.Marshal(toGrafana(dashboard))
+	require.NoError(t, err)
+	g, err = parseGrafanaDashboard(exported)
+	require.NoError(t, err)
+	require.Equal(t, grafanaSchemaVersion, g.SchemaVersion)
+	require.Equal(t, []grafanaTarget{
+		{RefID: "A", Query: expected.Panels[0].Query[0], RawQuery: true},
+		{RefID: "B", Query: expected.Panels[0].Query[1], RawQuery: true},
+	}, g.Panels[0].Targets)
+
+	reimported, unsupported := fromGrafana(g)
+	require.Empty(t, unsupported)
+	require.Equal(t, expected, reimported)
+}
+
+func TestGrafanaExportLayout(t *testing.T) {
+	dashboard := &model.Dashboard{
+		Name: "Layout",
+		Panels: []model.Panel{
+			{Name: "first", Query: []string{"SELECT 1"}},
+			{Name: "second", Query: []string{"SELECT 2"}},
+			{Name: "third", Query: []string{"SELECT 3"}},
+		},
+	}
+
+	g := toGrafana(dashboard)
+	require.Len(t, g.Panels, 3)
+	require.Equal(t, &grafanaGridPos{H: 8, W: 12, X: 0, Y: 0}, g.Panels[0].GridPos)
+	require.Equal(t, &grafanaGridPos{H: 8, W: 12, X: 12, Y: 0}, g.Panels[1].GridPos)
+	require.Equal(t, &grafanaGridPos{H: 8, W: 12, X: 0, Y: 8}, g.Panels[2].GridPos)
+	for _, p := range g.Panels {
+		require.Equal(t, "timeseries", p.Type)
+	}
+}
+
+func TestParseGrafanaDashboardInvalid(t *testing.T) {
+	_, err := parseGrafanaDashboard([]byte(`{"name": "not grafana"}`))
+	require.ErrorIs(t, err, errNotGrafanaDashboard)
+
+	_, err = parseGrafanaDashboard([]byte(`[1, 2]`))
+	require.Error(t, err)
+}
+
+func TestGrafanaRefID(t *testing.T) {
+	for n, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 51: "AZ", 52: "BA"} {
+		require.Equal(t, expected, grafanaRefID(n))
+	}
+}
@@ -1,18 +1,18 @@
 package agent
 
 import (
-	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
-	"os/user"
-	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,8 +24,8 @@ import (
 	authentication "Dana/agent/Auth"
 	"Dana/agent/model"
 	"Dana/agent/notification"
+	"Dana/agent/repository"
 	"Dana/config"
-	"Dana/internal/snmp"
 )
 
 func (a *Server) HealthCheck(ctx echo.Context) error {
@@ -33,6 +33,13 @@ func (a *Server) HealthCheck(ctx echo.Context) error {
 	return ctx.JSON(200, "OK")
 }
 
+// GetStatus reports the running state of the agent, its inputs and outputs
+// and the agent's internal statistics.
+func (a *Server) GetStatus(ctx echo.Context) error {
+	ctx.Logger().Info("GetStatus endpoint called")
+	return ctx.JSON(200, a.status())
+}
+
 func (a *Server) Register(ctx echo.Context) error {
 	ctx.Logger().Info("Register endpoint called")
 	user := &model.User{}
@@ -40,7 +47,51 @@ func (a *Server) Register(ctx echo.Context) error {
 		ctx.Logger().Error("Error binding request: ", err)
 		return ctx.JSON(400, errors.New("invalid request"))
 	}
+	hasUsers, err := a.UserRepo.HasUsers(ctx.Request().Context())
+	if err != nil {
+		ctx.Logger().Error("Error checking for users: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if hasUsers {
+		// Once any account exists only admins may create accounts. Users
+		// stored before roles existed count as well, so an upgraded
+		// installation is not open to anyone registering as admin.
+		tokenString, err := authentication.BearerToken(ctx.Request().Header.Get("Authorization"))
+		if err != nil {
+			ctx.Logger().Warn("Register called without a token")
+			return ctx.JSON(401, "unauthorized")
+		}
+		claims, err := a.Auth.ParseToken(ctx.Request().Context(), tokenString, authentication.TokenTypeAccess)
+		if err != nil {
+			ctx.Logger().Warn("Register called without a valid token: ", err)
+			return ctx.JSON(401, "unauthorized")
+		}
+		if model.Role(claims.Role) != model.RoleAdmin {
+			ctx.Logger().Warn("Register called by a non-admin user")
+			return ctx.JSON(403, "forbidden")
+		}
+	} else {
+		// The first account of an empty installation becomes admin
+		user.Role = model.RoleAdmin
+		user.Bootstrap = true
+	}
 	if err := a.UserRepo.AddUser(ctx.Request().Context(), user); err != nil {
+		if errors.Is(err, repository.ErrUsernameExists) {
+			ctx.Logger().Warn("Username already exists")
+			return ctx.JSON(409, "username already exists")
+		}
+		if errors.Is(err, repository.ErrAlreadyBootstrapped) {
+			ctx.Logger().Warn(err)
+			return ctx.JSON(409, err.Error())
+		}
+		if errors.Is(err, repository.ErrInvalidRole) {
+			ctx.Logger().Warn("Invalid role")
+			return ctx.JSON(400, "invalid role")
+		}
+		if errors.Is(err, repository.ErrMissingCredentials) {
+			ctx.Logger().Warn("Username or password missing")
+			return ctx.JSON(400, err.Error())
+		}
 		ctx.Logger().Error("Error adding user: ", err)
 		return ctx.JSON(500, "internal server error")
 	}
@@ -55,17 +106,342 @@ func (a *Server) Login(ctx echo.Context) error {
 		ctx.Logger().Error("Error binding request: ", err)
 		return ctx.JSON(400, errors.New("invalid request"))
 	}
-	if err := a.UserRepo.UserAuth(ctx.Request().Context(), user.Username, user.Password); err != nil {
+	authUser, err := a.UserRepo.UserAuth(ctx.Request().Context(), user.Username, user.Password)
+	if err != nil {
 		ctx.Logger().Error("Authentication failed: ", err)
+		if errors.Is(err, repository.ErrUserDisabled) {
+			return ctx.JSON(403, "user is disabled")
+		}
 		return ctx.JSON(401, "unauthorized")
 	}
-	token, err := authentication.GenerateJWT(user.Username)
+	tokens, err := a.Auth.GenerateTokens(authUser.Username, authUser.Role)
 	if err != nil {
 		ctx.Logger().Error("Error generating token: ", err)
 		return ctx.JSON(500, "internal server error")
 	}
 	ctx.Logger().Info("User logged in successfully")
-	return ctx.JSON(200, token)
+	return ctx.JSON(200, tokens)
+}
+
+// Refresh exchanges a refresh token for a new token pair. The refresh token
+// is single use and revoked once exchanged.
+func (a *Server) Refresh(ctx echo.Context) error {
+	ctx.Logger().Info("Refresh endpoint called")
+	req := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	if err := ctx.Bind(&req); err != nil || req.RefreshToken == "" {
+		ctx.Logger().Error("Error binding request: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	claims, err := a.Auth.ParseToken(ctx.Request().Context(), req.RefreshToken, authentication.TokenTypeRefresh)
+	if err != nil {
+		ctx.Logger().Warn("Refresh token rejected: ", err)
+		return ctx.JSON(401, "unauthorized")
+	}
+
+	// Pick up role changes and reject users disabled since the last login
+	user, err := a.UserRepo.GetUserByUsername(ctx.Request().Context(), claims.Username)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.Logger().Warn("Refresh token for unknown user")
+			return ctx.JSON(401, "unauthorized")
+		}
+		ctx.Logger().Error("Error retrieving user: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if user.Disabled {
+		ctx.Logger().Warn("Refresh token for disabled user")
+		return ctx.JSON(403, "user is disabled")
+	}
+
+	if err := a.Auth.Revoke(ctx.Request().Context(), claims); err != nil {
+		ctx.Logger().Error("Error revoking refresh token: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	tokens, err := a.Auth.GenerateTokens(user.Username, user.Role)
+	if err != nil {
+		ctx.Logger().Error("Error generating token: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Token refreshed successfully")
+	return ctx.JSON(200, tokens)
+}
+
+// Logout revokes the access token used for the request and, if given, the
+// matching refresh token.
+func (a *Server) Logout(ctx echo.Context) error {
+	ctx.Logger().Info("Logout endpoint called")
+	req := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	if err := ctx.Bind(&req); err != nil {
+		ctx.Logger().Error("Error binding request: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+
+	claims := authentication.CurrentClaims(ctx)
+	if claims == nil {
+		ctx.Logger().Warn("Logout called with an API token")
+		return ctx.JSON(400, "api tokens are revoked through /api/v1/tokens")
+	}
+	if err := a.Auth.Revoke(ctx.Request().Context(), claims); err != nil {
+		ctx.Logger().Error("Error revoking access token: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if req.RefreshToken != "" {
+		claims, err := a.Auth.ParseToken(ctx.Request().Context(), req.RefreshToken, authentication.TokenTypeRefresh)
+		if err == nil && claims.Username == authentication.Username(ctx) {
+			if err := a.Auth.Revoke(ctx.Request().Context(), claims); err != nil {
+				ctx.Logger().Error("Error revoking refresh token: ", err)
+				return ctx.JSON(500, "internal server error")
+			}
+		}
+	}
+	ctx.Logger().Info("User logged out successfully")
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) GetUsers(ctx echo.Context) error {
+	ctx.Logger().Info("GetUsers endpoint called")
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	users, err := a.UserRepo.ListUsers(ctx.Request().Context(), opts)
+	if err != nil {
+		return listError(ctx, "users", err)
+	}
+	for _, user := range users.Items {
+		user.Password = ""
+	}
+	ctx.Logger().Info("Users retrieved successfully")
+	return ctx.JSON(200, users)
+}
+
+func (a *Server) GetUser(ctx echo.Context) error {
+	ctx.Logger().Info("GetUser endpoint called")
+	user, err := a.UserRepo.GetUser(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.Logger().Warn("User not found")
+			return ctx.JSON(404, "user not found")
+		}
+		ctx.Logger().Error("Error retrieving user: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	user.Password = ""
+	ctx.Logger().Info("User retrieved successfully")
+	return ctx.JSON(200, user)
+}
+
+func (a *Server) UpdateUser(ctx echo.Context) error {
+	ctx.Logger().Info("UpdateUser endpoint called")
+	user := &model.User{}
+	if err := ctx.Bind(user); err != nil {
+		ctx.Logger().Error("Error binding user data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	if err := a.UserRepo.UpdateUser(ctx.Request().Context(), ctx.Param("id"), user); err != nil {
+		return userError(ctx, "Error updating user: ", err)
+	}
+	ctx.Logger().Info("User updated successfully")
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) DisableUser(ctx echo.Context) error {
+	ctx.Logger().Info("DisableUser endpoint called")
+	if err := a.UserRepo.SetDisabled(ctx.Request().Context(), ctx.Param("id"), true); err != nil {
+		return userError(ctx, "Error disabling user: ", err)
+	}
+	ctx.Logger().Info("User disabled successfully")
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) EnableUser(ctx echo.Context) error {
+	ctx.Logger().Info("EnableUser endpoint called")
+	if err := a.UserRepo.SetDisabled(ctx.Request().Context(), ctx.Param("id"), false); err != nil {
+		return userError(ctx, "Error enabling user: ", err)
+	}
+	ctx.Logger().Info("User enabled successfully")
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) DeleteUser(ctx echo.Context) error {
+	ctx.Logger().Info("DeleteUser endpoint called")
+	if err := a.UserRepo.DeleteUser(ctx.Request().Context(), ctx.Param("id")); err != nil {
+		return userError(ctx, "Error deleting user: ", err)
+	}
+	ctx.Logger().Info("User deleted successfully")
+	return ctx.JSON(200, "OK")
+}
+
+// ChangePassword changes the password of the authenticated user.
+func (a *Server) ChangePassword(ctx echo.Context) error {
+	ctx.Logger().Info("ChangePassword endpoint called")
+	req := &model.PasswordChange{}
+	if err := ctx.Bind(req); err != nil || req.NewPassword == "" {
+		ctx.Logger().Error("Error binding password data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	username := authentication.Username(ctx)
+	if err := a.UserRepo.ChangePassword(ctx.Request().Context(), username, req.OldPassword, req.NewPassword); err != nil {
+		if errors.Is(err, repository.ErrInvalidCredentials) || errors.Is(err, repository.ErrUserDisabled) {
+			ctx.Logger().Warn("Password change rejected: ", err)
+			return ctx.JSON(401, "unauthorized")
+		}
+		ctx.Logger().Error("Error changing password: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Password changed successfully")
+	return ctx.JSON(200, "OK")
+}
+
+// ResetPassword sets a new password for any user without the old one.
+func (a *Server) ResetPassword(ctx echo.Context) error {
+	ctx.Logger().Info("ResetPassword endpoint called")
+	req := &model.PasswordChange{}
+	if err := ctx.Bind(req); err != nil || req.NewPassword == "" {
+		ctx.Logger().Error("Error binding password data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	if err := a.UserRepo.ResetPassword(ctx.Request().Context(), ctx.Param("id"), req.NewPassword); err != nil {
+		return userError(ctx, "Error resetting password: ", err)
+	}
+	ctx.Logger().Info("Password reset successfully")
+	return ctx.JSON(200, "OK")
+}
+
+// userError maps user repository errors to responses.
+func userError(ctx echo.Context, msg string, err error) error {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		ctx.Logger().Warn("User not found")
+		return ctx.JSON(404, "user not found")
+	case errors.Is(err, primitive.ErrInvalidHex):
+		ctx.Logger().Warn("Invalid user id")
+		return ctx.JSON(400, "invalid user id")
+	case errors.Is(err, repository.ErrUsernameExists):
+		ctx.Logger().Warn("Username already exists")
+		return ctx.JSON(409, "username already exists")
+	case errors.Is(err, repository.ErrInvalidRole):
+		ctx.Logger().Warn("Invalid role")
+		return ctx.JSON(400, "invalid role")
+	case errors.Is(err, repository.ErrMissingCredentials), errors.Is(err, repository.ErrUsernameChange):
+		ctx.Logger().Warn(err)
+		return ctx.JSON(400, err.Error())
+	case errors.Is(err, repository.ErrLastAdmin):
+		ctx.Logger().Warn(err)
+		return ctx.JSON(409, err.Error())
+	}
+	ctx.Logger().Error(msg, err)
+	return ctx.JSON(500, "internal server error")
+}
+
+// CreateAPIToken creates a long-lived token for the authenticated user. The
+// token is only returned once and cannot be recovered later.
+func (a *Server) CreateAPIToken(ctx echo.Context) error {
+	ctx.Logger().Info("CreateAPIToken endpoint called")
+	req := struct {
+		Name          string           `json:"name"`
+		Scope         model.TokenScope `json:"scope"`
+		ExpiresInDays int              `json:"expires_in_days"`
+	}{}
+	if err := ctx.Bind(&req); err != nil {
+		ctx.Logger().Error("Error binding token data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	if req.Name == "" || !req.Scope.Valid() {
+		ctx.Logger().Warn("Invalid token name or scope")
+		return ctx.JSON(400, "name and a scope of read-only, inputs or dashboards are required")
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPITokenDays {
+		return ctx.JSON(400, fmt.Sprintf("expires_in_days must be between 1 and %d", maxAPITokenDays))
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultAPITokenDays
+	}
+
+	value, hash, err := authentication.GenerateAPIToken()
+	if err != nil {
+		ctx.Logger().Error("Error generating token: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	now := time.Now()
+	token := &model.APIToken{
+		Name:      req.Name,
+		Username:  authentication.Username(ctx),
+		Scope:     req.Scope,
+		Hash:      hash,
+		Prefix:    value[:len(authentication.APITokenPrefix)+4],
+		ExpiresAt: now.AddDate(0, 0, req.ExpiresInDays),
+		CreatedAt: now,
+	}
+	if err := a.APITokenRepo.CreateToken(ctx.Request().Context(), token); err != nil {
+		ctx.Logger().Error("Error creating token: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("API token created successfully")
+	return ctx.JSON(201, map[string]interface{}{"token": value, "details": token})
+}
+
+func (a *Server) GetAPITokens(ctx echo.Context) error {
+	ctx.Logger().Info("GetAPITokens endpoint called")
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	tokens, err := a.APITokenRepo.ListTokens(ctx.Request().Context(), authentication.Username(ctx), opts)
+	if err != nil {
+		return listError(ctx, "tokens", err)
+	}
+	ctx.Logger().Info("API tokens retrieved successfully")
+	return ctx.JSON(200, tokens)
+}
+
+func (a *Server) DeleteAPIToken(ctx echo.Context) error {
+	ctx.Logger().Info("DeleteAPIToken endpoint called")
+	if err := a.APITokenRepo.DeleteToken(ctx.Request().Context(), authentication.Username(ctx), ctx.Param("id")); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Token not found")
+			return ctx.JSON(404, "token not found")
+		}
+		ctx.Logger().Error("Error deleting token: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("API token deleted successfully")
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) GetAuditLog(ctx echo.Context) error {
+	ctx.Logger().Info("GetAuditLog endpoint called")
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	filter := model.AuditFilter{
+		Username: ctx.QueryParam("user"),
+		Method:   strings.ToUpper(ctx.QueryParam("method")),
+		Route:    ctx.QueryParam("route"),
+	}
+	now := time.Now()
+	if from := ctx.QueryParam("from"); from != "" {
+		if filter.From, err = parseQueryTime(from, now); err != nil {
+			return ctx.JSON(400, fmt.Sprintf("invalid from time %q", from))
+		}
+	}
+	if to := ctx.QueryParam("to"); to != "" {
+		if filter.To, err = parseQueryTime(to, now); err != nil {
+			return ctx.JSON(400, fmt.Sprintf("invalid to time %q", to))
+		}
+	}
+
+	entries, err := a.AuditRepo.ListEntries(ctx.Request().Context(), filter, opts)
+	if err != nil {
+		return listError(ctx, "audit log", err)
+	}
+	ctx.Logger().Info("Audit log retrieved successfully")
+	return ctx.JSON(200, entries)
 }
 
 func (a *Server) Query(ctx echo.Context) error {
@@ -75,321 +451,1874 @@ func (a *Server) Query(ctx echo.Context) error {
 	return ctx.Blob(status, header, body)
 }
 
-func (a *Server) PostInput(ctx echo.Context) error {
-	ctx.Logger().Info("PostInput endpoint called")
-	inputData := &model.HandlerInput{}
-	if err := ctx.Bind(inputData); err != nil {
-		ctx.Logger().Error("Error binding input data: ", err)
-		return ctx.JSON(400, errors.New("invalid request"))
+func (a *Server) PostInput(ctx echo.Context) error {
+	ctx.Logger().Info("PostInput endpoint called")
+	inputData := &model.HandlerInput{}
+	if err := ctx.Bind(inputData); err != nil {
+		ctx.Logger().Error("Error binding input data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	inputData.Type = ctx.Param("type")
+	inputs, err := a.buildInputs(inputData)
+	if err == nil {
+		err = a.checkInputConflicts(inputs)
+	}
+	if err != nil {
+		ctx.Logger().Error("Error building inputs: ", err)
+		return ctx.JSON(400, err.Error())
+	}
+	if err := a.InputRepo.AddServerInput(ctx.Request().Context(), inputData); err != nil {
+		ctx.Logger().Error("Error adding server input: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if err := a.startManagedInputs(inputData.ID.Hex(), inputs); err != nil {
+		ctx.Logger().Error("Error starting inputs: ", err)
+		// Do not keep inputs around that never ran
+		if err := a.InputRepo.DeleteServerInput(ctx.Request().Context(), inputData.ID.Hex()); err != nil {
+			ctx.Logger().Error("Error removing server input: ", err)
+		}
+		return inputStartError(ctx, err)
+	}
+	ctx.Logger().Info("Inputs processed successfully")
+	return ctx.JSON(200, inputData)
+}
+
+// TestInput runs a single gather of an input configuration without saving
+// or starting it, so mistakes show up before they reach the running agent.
+func (a *Server) TestInput(ctx echo.Context) error {
+	ctx.Logger().Info("TestInput endpoint called")
+	inputData := &model.HandlerInput{}
+	if err := ctx.Bind(inputData); err != nil {
+		ctx.Logger().Error("Error binding input data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	inputData.Type = ctx.Param("type")
+	result := a.dryRunInput(ctx.Request().Context(), inputData)
+	if len(result.Errors) > 0 {
+		ctx.Logger().Warn("Input test reported errors")
+		return ctx.JSON(422, result)
+	}
+	ctx.Logger().Info("Input tested successfully")
+	return ctx.JSON(200, result)
+}
+
+// GetPluginSchema returns the JSON Schema of a plugin's configuration so
+// clients can render and validate plugin forms.
+func (a *Server) GetPluginSchema(ctx echo.Context) error {
+	ctx.Logger().Info("GetPluginSchema endpoint called")
+	schema, err := config.PluginSchema(ctx.Param("category"), ctx.Param("type"))
+	if err != nil {
+		if errors.Is(err, config.ErrPluginNotFound) {
+			ctx.Logger().Warn("Plugin not found: ", err)
+			return ctx.JSON(404, err.Error())
+		}
+		ctx.Logger().Error("Error building plugin schema: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Plugin schema retrieved successfully")
+	return ctx.JSON(200, schema)
+}
+
+func (a *Server) GetInputByID(ctx echo.Context) error {
+	ctx.Logger().Info("GetInputByID endpoint called")
+	input, err := a.InputRepo.GetServer(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil || input.Type != ctx.Param("type") {
+		if err == nil || errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Input not found")
+			return ctx.JSON(404, "input not found")
+		}
+		ctx.Logger().Error("Error retrieving input: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Input retrieved successfully")
+	return ctx.JSON(200, input)
+}
+
+// UpdateInput replaces the configuration of an input and swaps the running
+// plugin without restarting the agent. The new configuration is only stored
+// once it started, otherwise the previous one is started again.
+func (a *Server) UpdateInput(ctx echo.Context) error {
+	ctx.Logger().Info("UpdateInput endpoint called")
+	inputData := &model.HandlerInput{}
+	if err := ctx.Bind(inputData); err != nil {
+		ctx.Logger().Error("Error binding input data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	inputData.Type = ctx.Param("type")
+	id := ctx.Param("id")
+	previous, err := a.InputRepo.GetServer(ctx.Request().Context(), id)
+	if err != nil || previous.Type != inputData.Type {
+		if err == nil || errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Input not found")
+			return ctx.JSON(404, "input not found")
+		}
+		ctx.Logger().Error("Error retrieving input: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	inputs, err := a.buildInputs(inputData)
+	if err == nil {
+		err = a.checkInputConflicts(inputs)
+	}
+	if err != nil {
+		ctx.Logger().Error("Error building inputs: ", err)
+		return ctx.JSON(400, err.Error())
+	}
+	if err := a.startManagedInputs(id, inputs); err != nil {
+		ctx.Logger().Error("Error starting inputs: ", err)
+		a.restoreInputs(ctx, id, previous)
+		return inputStartError(ctx, err)
+	}
+	if err := a.InputRepo.UpdateServerInput(ctx.Request().Context(), id, inputData); err != nil {
+		a.restoreInputs(ctx, id, previous)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.Logger().Warn("Input not found")
+			return ctx.JSON(404, "input not found")
+		}
+		ctx.Logger().Error("Error updating input: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Input updated successfully")
+	return ctx.JSON(200, inputData)
+}
+
+// restoreInputs starts the stored configuration of an input again after an
+// update of it failed, so the running agent matches the database.
+func (a *Server) restoreInputs(ctx echo.Context, id string, stored *model.HandlerInput) {
+	inputs, err := a.buildInputs(stored)
+	if err == nil {
+		err = a.startManagedInputs(id, inputs)
+	}
+	if err != nil {
+		if errors.Is(err, errPipelineNotRunning) {
+			return
+		}
+		ctx.Logger().Error("Error restoring previous inputs: ", err)
+		a.stopManagedInputs(id)
+		return
+	}
+	ctx.Logger().Warn("Restored previous configuration of input ", id)
+}
+
+// DeleteInput deletes an input and stops the running plugin.
+func (a *Server) DeleteInput(ctx echo.Context) error {
+	ctx.Logger().Info("DeleteInput endpoint called")
+	id := ctx.Param("id")
+	input, err := a.InputRepo.GetServer(ctx.Request().Context(), id)
+	if err != nil || input.Type != ctx.Param("type") {
+		if err == nil || errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Input not found")
+			return ctx.JSON(404, "input not found")
+		}
+		ctx.Logger().Error("Error retrieving input: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if err := a.InputRepo.DeleteServerInput(ctx.Request().Context(), id); err != nil {
+		ctx.Logger().Error("Error deleting input: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if !a.stopManagedInputs(id) {
+		ctx.Logger().Warn("Input was not running: ", id)
+	}
+	ctx.Logger().Info("Input deleted successfully")
+	return ctx.JSON(200, "OK")
+}
+
+// inputStartError maps errors starting managed inputs to responses.
+func inputStartError(ctx echo.Context, err error) error {
+	if errors.Is(err, errPipelineNotRunning) {
+		return ctx.JSON(503, err.Error())
+	}
+	return ctx.JSON(500, "internal server error")
+}
+
+func (a *Server) GetInput(ctx echo.Context) error {
+	ctx.Logger().Info("GetInput endpoint called")
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	inputs, err := a.InputRepo.ListServers(ctx.Request().Context(), opts)
+	if err != nil {
+		return listError(ctx, "inputs", err)
+	}
+	ctx.Logger().Info("Inputs retrieved successfully")
+	return ctx.JSON(200, inputs)
+}
+
+func (a *Server) GetInputByType(ctx echo.Context) error {
+	ctx.Logger().Info("GetInputByType endpoint called")
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	inputs, err := a.InputRepo.ListServersByType(ctx.Request().Context(), ctx.Param("type"), opts)
+	if err != nil {
+		return listError(ctx, "inputs by type", err)
+	}
+	ctx.Logger().Info("Inputs by type retrieved successfully")
+	return ctx.JSON(200, inputs)
+}
+
+// PostPlugin stores an output, processor or aggregator and adds it to the
+// running pipeline.
+func (a *Server) PostPlugin(ctx echo.Context) error {
+	ctx.Logger().Info("PostPlugin endpoint called")
+	category := ctx.Param("category")
+	if !pluginCategories[category] {
+		ctx.Logger().Warn("Unknown plugin category: ", category)
+		return ctx.JSON(404, "unknown plugin category")
+	}
+	pluginData := &model.HandlerPlugin{}
+	if err := ctx.Bind(pluginData); err != nil {
+		ctx.Logger().Error("Error binding plugin data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	pluginData.Category = category
+	pluginData.Type = ctx.Param("type")
+	cfg, err := a.buildPlugins(pluginData)
+	if err != nil {
+		ctx.Logger().Error("Error building plugins: ", err)
+		return ctx.JSON(400, err.Error())
+	}
+	if err := a.PluginRepo.AddPlugin(ctx.Request().Context(), pluginData); err != nil {
+		ctx.Logger().Error("Error adding plugin: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if err := a.applyPlugins(pluginData.ID.Hex(), cfg); err != nil {
+		ctx.Logger().Error("Error starting plugins: ", err)
+		// Do not keep plugins around that never ran
+		if err := a.PluginRepo.DeletePlugin(ctx.Request().Context(), pluginData.ID.Hex()); err != nil {
+			ctx.Logger().Error("Error removing plugin: ", err)
+		}
+		return pluginStartError(ctx, err)
+	}
+	ctx.Logger().Info("Plugin added successfully")
+	return ctx.JSON(200, pluginData)
+}
+
+func (a *Server) GetPlugins(ctx echo.Context) error {
+	ctx.Logger().Info("GetPlugins endpoint called")
+	category := ctx.Param("category")
+	if !pluginCategories[category] {
+		ctx.Logger().Warn("Unknown plugin category: ", category)
+		return ctx.JSON(404, "unknown plugin category")
+	}
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	plugins, err := a.PluginRepo.ListPlugins(ctx.Request().Context(), category, opts)
+	if err != nil {
+		return listError(ctx, "plugins", err)
+	}
+	ctx.Logger().Info("Plugins retrieved successfully")
+	return ctx.JSON(200, plugins)
+}
+
+func (a *Server) GetPluginsByType(ctx echo.Context) error {
+	ctx.Logger().Info("GetPluginsByType endpoint called")
+	category := ctx.Param("category")
+	if !pluginCategories[category] {
+		ctx.Logger().Warn("Unknown plugin category: ", category)
+		return ctx.JSON(404, "unknown plugin category")
+	}
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	plugins, err := a.PluginRepo.ListPluginsByType(ctx.Request().Context(), category, ctx.Param("type"), opts)
+	if err != nil {
+		return listError(ctx, "plugins by type", err)
+	}
+	ctx.Logger().Info("Plugins by type retrieved successfully")
+	return ctx.JSON(200, plugins)
+}
+
+func (a *Server) GetPluginByID(ctx echo.Context) error {
+	ctx.Logger().Info("GetPluginByID endpoint called")
+	plugin, err := a.PluginRepo.GetPlugin(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil || plugin.Category != ctx.Param("category") || plugin.Type != ctx.Param("type") {
+		if err == nil || errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Plugin not found")
+			return ctx.JSON(404, "plugin not found")
+		}
+		ctx.Logger().Error("Error retrieving plugin: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Plugin retrieved successfully")
+	return ctx.JSON(200, plugin)
+}
+
+// UpdatePlugin replaces the configuration of an output, processor or
+// aggregator and swaps the running plugin without restarting the agent.
+func (a *Server) UpdatePlugin(ctx echo.Context) error {
+	ctx.Logger().Info("UpdatePlugin endpoint called")
+	category := ctx.Param("category")
+	if !pluginCategories[category] {
+		ctx.Logger().Warn("Unknown plugin category: ", category)
+		return ctx.JSON(404, "unknown plugin category")
+	}
+	pluginData := &model.HandlerPlugin{}
+	if err := ctx.Bind(pluginData); err != nil {
+		ctx.Logger().Error("Error binding plugin data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	pluginData.Category = category
+	pluginData.Type = ctx.Param("type")
+	id := ctx.Param("id")
+	previous, err := a.PluginRepo.GetPlugin(ctx.Request().Context(), id)
+	if err != nil || previous.Category != pluginData.Category || previous.Type != pluginData.Type {
+		if err == nil || errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Plugin not found")
+			return ctx.JSON(404, "plugin not found")
+		}
+		ctx.Logger().Error("Error retrieving plugin: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	cfg, err := a.buildPlugins(pluginData)
+	if err != nil {
+		ctx.Logger().Error("Error building plugins: ", err)
+		return ctx.JSON(400, err.Error())
+	}
+	// Only store configurations that run, the previous plugins keep
+	// running if the new ones cannot be started
+	if err := a.applyPlugins(id, cfg); err != nil {
+		ctx.Logger().Error("Error starting plugins: ", err)
+		return pluginStartError(ctx, err)
+	}
+	if err := a.PluginRepo.UpdatePlugin(ctx.Request().Context(), id, pluginData); err != nil {
+		a.restorePlugins(ctx, id, previous)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.Logger().Warn("Plugin not found")
+			return ctx.JSON(404, "plugin not found")
+		}
+		ctx.Logger().Error("Error updating plugin: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Plugin updated successfully")
+	return ctx.JSON(200, pluginData)
+}
+
+// restorePlugins applies the stored configuration of a plugin again after
+// an update of it failed, so the running agent matches the database.
+func (a *Server) restorePlugins(ctx echo.Context, id string, stored *model.HandlerPlugin) {
+	cfg, err := a.buildPlugins(stored)
+	if err == nil {
+		err = a.applyPlugins(id, cfg)
+	}
+	if err != nil {
+		if errors.Is(err, errPipelineNotRunning) {
+			return
+		}
+		ctx.Logger().Error("Error restoring previous plugins: ", err)
+		a.removePlugins(id)
+		return
+	}
+	ctx.Logger().Warn("Restored previous configuration of plugin ", id)
+}
+
+// DeletePlugin deletes an output, processor or aggregator and removes it
+// from the running pipeline.
+func (a *Server) DeletePlugin(ctx echo.Context) error {
+	ctx.Logger().Info("DeletePlugin endpoint called")
+	id := ctx.Param("id")
+	plugin, err := a.PluginRepo.GetPlugin(ctx.Request().Context(), id)
+	if err != nil || plugin.Category != ctx.Param("category") || plugin.Type != ctx.Param("type") {
+		if err == nil || errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Plugin not found")
+			return ctx.JSON(404, "plugin not found")
+		}
+		ctx.Logger().Error("Error retrieving plugin: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if err := a.PluginRepo.DeletePlugin(ctx.Request().Context(), id); err != nil {
+		ctx.Logger().Error("Error deleting plugin: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if !a.removePlugins(id) {
+		ctx.Logger().Warn("Plugin was not running: ", id)
+	}
+	ctx.Logger().Info("Plugin deleted successfully")
+	return ctx.JSON(200, "OK")
+}
+
+// pluginStartError maps errors applying plugins to the pipeline to
+// responses. Unlike inputs, outputs connect to remote services when they
+// start, so the reason is passed on to the caller.
+func pluginStartError(ctx echo.Context, err error) error {
+	if errors.Is(err, errPipelineNotRunning) {
+		return ctx.JSON(503, err.Error())
+	}
+	return ctx.JSON(500, err.Error())
+}
+
+func (a *Server) CreateDashboard(ctx echo.Context) error {
+	ctx.Logger().Info("CreateDashboard endpoint called")
+	dashboard := &model.Dashboard{}
+	if err := ctx.Bind(dashboard); err != nil {
+		ctx.Logger().Error("Error binding dashboard data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	if err := dashboard.Validate(); err != nil {
+		ctx.Logger().Warn("Invalid dashboard: ", err)
+		return ctx.JSON(400, err.Error())
+	}
+	id, err := a.DashboardRepo.CreateDashboard(ctx.Request().Context(), dashboard)
+	if err != nil {
+		ctx.Logger().Error("Error creating dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	dashboard.ID = id
+	dashboard.Version = 1
+	if err := a.recordRevision(ctx.Request().Context(), dashboard, authentication.Username(ctx), "Created"); err != nil {
+		ctx.Logger().Error("Error storing dashboard revision: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Dashboard created successfully")
+	return ctx.JSON(201, map[string]interface{}{"id": id})
+}
+
+func (a *Server) GetDashboard(ctx echo.Context) error {
+	ctx.Logger().Info("GetDashboard endpoint called")
+	id := ctx.Param("id")
+	dashboard, err := a.DashboardRepo.GetDashboard(ctx.Request().Context(), id)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.Logger().Warn("Dashboard not found")
+			return ctx.JSON(404, "dashboard not found")
+		}
+		ctx.Logger().Error("Error retrieving dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Dashboard retrieved successfully")
+	return ctx.JSON(200, dashboard)
+}
+
+func (a *Server) UpdateDashboard(ctx echo.Context) error {
+	ctx.Logger().Info("UpdateDashboard endpoint called")
+	return a.updateDashboard(ctx, ctx.Param("id"))
+}
+
+// UpdateDashboardInFolder updates a dashboard through the folder holding it.
+// It is kept for clients written when folders embedded dashboard copies.
+func (a *Server) UpdateDashboardInFolder(ctx echo.Context) error {
+	ctx.Logger().Info("UpdateDashboardInFolder endpoint called")
+	folderID, err := primitive.ObjectIDFromHex(ctx.Param("folderID"))
+	if err != nil {
+		return ctx.JSON(404, "folder not found")
+	}
+	dashboardID, err := primitive.ObjectIDFromHex(ctx.Param("dashboardID"))
+	if err != nil {
+		return ctx.JSON(404, "dashboard not found")
+	}
+
+	tree, err := a.loadFolderTree(ctx.Request().Context())
+	if err != nil {
+		ctx.Logger().Error("Error loading folders: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if id, found := tree.dashboards[dashboardID]; !found || id != folderID {
+		return ctx.JSON(404, "dashboard not found in folder")
+	}
+	if tree.dashboardRole(dashboardID, authentication.Username(ctx), authentication.Role(ctx)).Level() < model.RoleEditor.Level() {
+		return ctx.String(http.StatusForbidden, "forbidden")
+	}
+	return a.updateDashboard(ctx, dashboardID.Hex())
+}
+
+func (a *Server) updateDashboard(ctx echo.Context, id string) error {
+	update := &model.DashboardUpdate{}
+	if err := ctx.Bind(update); err != nil {
+		ctx.Logger().Error("Error binding dashboard data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	current, err := a.DashboardRepo.GetDashboard(ctx.Request().Context(), id)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Dashboard not found")
+			return ctx.JSON(404, "dashboard not found")
+		}
+		ctx.Logger().Error("Error retrieving dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	// Dashboards stored before indexes had to be unique may repeat them and
+	// have their data keyed by position, see panelKeys. Updates sent back
+	// with repeated indexes are numbered the same way, so they stay valid.
+	if !uniquePanelIndexes(current.Panels) {
+		for position, key := range panelKeys(update.Panels) {
+			update.Panels[position].Index = key
+		}
+	}
+	if err := update.Dashboard.Validate(); err != nil {
+		ctx.Logger().Warn("Invalid dashboard: ", err)
+		return ctx.JSON(400, err.Error())
+	}
+	// Keep the state of dashboards created before revisions were stored
+	if current.Version == 0 {
+		if err := a.recordRevision(ctx.Request().Context(), current, "", "Initial state"); err != nil {
+			ctx.Logger().Error("Error storing dashboard revision: ", err)
+			return ctx.JSON(500, "internal server error")
+		}
+	}
+
+	dashboard, err := a.DashboardRepo.UpdateDashboard(ctx.Request().Context(), &update.Dashboard, current.ID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.Logger().Warn("Dashboard not found")
+			return ctx.JSON(404, "dashboard not found")
+		}
+		ctx.Logger().Error("Error updating dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if dashboard.Version != current.Version {
+		if err := a.recordRevision(ctx.Request().Context(), dashboard, authentication.Username(ctx), update.Message); err != nil {
+			ctx.Logger().Error("Error storing dashboard revision: ", err)
+			return ctx.JSON(500, "internal server error")
+		}
+	}
+	ctx.Logger().Info("Dashboard updated successfully")
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) DeleteDashboard(ctx echo.Context) error {
+	ctx.Logger().Info("DeleteDashboard endpoint called")
+	id := ctx.Param("id")
+	if err := a.DashboardRepo.DeleteDashboard(ctx.Request().Context(), id); err != nil {
+		ctx.Logger().Error("Error deleting dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if err := a.DashboardRevisionRepo.DeleteRevisions(ctx.Request().Context(), id); err != nil {
+		ctx.Logger().Error("Error deleting dashboard revisions: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+		if err := a.FolderRepo.MoveDashboard(ctx.Request().Context(), objectID, nil); err != nil {
+			ctx.Logger().Error("Error removing dashboard from its folder: ", err)
+			return ctx.JSON(500, "internal server error")
+		}
+		if err := a.AnnotationRepo.DeleteDashboardAnnotations(ctx.Request().Context(), objectID); err != nil {
+			ctx.Logger().Error("Error deleting dashboard annotations: ", err)
+			return ctx.JSON(500, "internal server error")
+		}
+		if err := a.SnapshotRepo.DeleteDashboardSnapshots(ctx.Request().Context(), objectID); err != nil {
+			ctx.Logger().Error("Error deleting dashboard snapshots: ", err)
+			return ctx.JSON(500, "internal server error")
+		}
+	}
+	ctx.Logger().Info("Dashboard deleted successfully")
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) GetDashboardRevisions(ctx echo.Context) error {
+	ctx.Logger().Info("GetDashboardRevisions endpoint called")
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	revisions, err := a.DashboardRevisionRepo.ListRevisions(ctx.Request().Context(), ctx.Param("id"), opts)
+	if err != nil {
+		if errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Dashboard not found")
+			return ctx.JSON(404, "dashboard not found")
+		}
+		return listError(ctx, "dashboard revisions", err)
+	}
+	ctx.Logger().Info("Dashboard revisions retrieved successfully")
+	return ctx.JSON(200, revisions)
+}
+
+func (a *Server) GetDashboardRevision(ctx echo.Context) error {
+	ctx.Logger().Info("GetDashboardRevision endpoint called")
+	version, err := strconv.Atoi(ctx.Param("version"))
+	if err != nil {
+		return ctx.JSON(400, "invalid version")
+	}
+	revision, err := a.DashboardRevisionRepo.GetRevision(ctx.Request().Context(), ctx.Param("id"), version)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Dashboard revision not found")
+			return ctx.JSON(404, "revision not found")
+		}
+		ctx.Logger().Error("Error retrieving dashboard revision: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Dashboard revision retrieved successfully")
+	return ctx.JSON(200, revision)
+}
+
+func (a *Server) DiffDashboardRevisions(ctx echo.Context) error {
+	ctx.Logger().Info("DiffDashboardRevisions endpoint called")
+	from, err := strconv.Atoi(ctx.QueryParam("from"))
+	if err != nil {
+		return ctx.JSON(400, "invalid from version")
+	}
+	to, err := strconv.Atoi(ctx.QueryParam("to"))
+	if err != nil {
+		return ctx.JSON(400, "invalid to version")
+	}
+
+	revisions := make([]*model.DashboardRevision, 0, 2)
+	for _, version := range []int{from, to} {
+		revision, err := a.DashboardRevisionRepo.GetRevision(ctx.Request().Context(), ctx.Param("id"), version)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+				ctx.Logger().Warn("Dashboard revision not found")
+				return ctx.JSON(404, fmt.Sprintf("revision %d not found", version))
+			}
+			ctx.Logger().Error("Error retrieving dashboard revision: ", err)
+			return ctx.JSON(500, "internal server error")
+		}
+		revisions = append(revisions, revision)
+	}
+
+	changes, err := diffDashboards(&revisions[0].Dashboard, &revisions[1].Dashboard)
+	if err != nil {
+		ctx.Logger().Error("Error comparing dashboard revisions: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Dashboard revisions compared successfully")
+	return ctx.JSON(200, &model.DashboardDiff{From: from, To: to, Changes: changes})
+}
+
+func (a *Server) RestoreDashboardRevision(ctx echo.Context) error {
+	ctx.Logger().Info("RestoreDashboardRevision endpoint called")
+	version, err := strconv.Atoi(ctx.Param("version"))
+	if err != nil {
+		return ctx.JSON(400, "invalid version")
+	}
+	var req struct {
+		Message string `json:"message"`
+	}
+	if err := ctx.Bind(&req); err != nil {
+		ctx.Logger().Error("Error binding restore data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+
+	revision, err := a.DashboardRevisionRepo.GetRevision(ctx.Request().Context(), ctx.Param("id"), version)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Dashboard revision not found")
+			return ctx.JSON(404, "revision not found")
+		}
+		ctx.Logger().Error("Error retrieving dashboard revision: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+
+	dashboard, err := a.DashboardRepo.RestoreDashboard(ctx.Request().Context(), &revision.Dashboard, revision.DashboardID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.Logger().Warn("Dashboard not found")
+			return ctx.JSON(404, "dashboard not found")
+		}
+		ctx.Logger().Error("Error restoring dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	message := req.Message
+	if message == "" {
+		message = fmt.Sprintf("Restored version %d", version)
+	}
+	if err := a.recordRevision(ctx.Request().Context(), dashboard, authentication.Username(ctx), message); err != nil {
+		ctx.Logger().Error("Error storing dashboard revision: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Dashboard restored successfully")
+	return ctx.JSON(200, dashboard)
+}
+
+func (a *Server) ImportGrafanaDashboard(ctx echo.Context) error {
+	ctx.Logger().Info("ImportGrafanaDashboard endpoint called")
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		ctx.Logger().Error("Error reading request body: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	grafana, err := parseGrafanaDashboard(body)
+	if err != nil {
+		ctx.Logger().Error("Error parsing Grafana dashboard: ", err)
+		return ctx.JSON(400, "invalid Grafana dashboard")
+	}
+
+	dashboard, unsupported := fromGrafana(grafana)
+	if err := dashboard.Validate(); err != nil {
+		ctx.Logger().Warn("Invalid dashboard: ", err)
+		return ctx.JSON(400, err.Error())
+	}
+	for _, u := range unsupported {
+		ctx.Logger().Warnf("Skipping Grafana %s %q: %s", u.Kind, u.Name, u.Reason)
+	}
+	id, err := a.DashboardRepo.CreateDashboard(ctx.Request().Context(), dashboard)
+	if err != nil {
+		ctx.Logger().Error("Error creating dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	dashboard.ID = id
+	dashboard.Version = 1
+	if err := a.recordRevision(ctx.Request().Context(), dashboard, authentication.Username(ctx), "Imported from Grafana"); err != nil {
+		ctx.Logger().Error("Error storing dashboard revision: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Dashboard imported successfully")
+	return ctx.JSON(201, &model.GrafanaImportResult{
+		ID:          id,
+		Panels:      len(dashboard.Panels),
+		Variables:   len(dashboard.Variables),
+		Unsupported: unsupported,
+	})
+}
+
+func (a *Server) ExportGrafanaDashboard(ctx echo.Context) error {
+	ctx.Logger().Info("ExportGrafanaDashboard endpoint called")
+	dashboard, err := a.DashboardRepo.GetDashboard(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Dashboard not found")
+			return ctx.JSON(404, "dashboard not found")
+		}
+		ctx.Logger().Error("Error retrieving dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Dashboard exported successfully")
+	return ctx.JSON(200, toGrafana(dashboard))
+}
+
+func (a *Server) GetDashboardData(ctx echo.Context) error {
+	ctx.Logger().Info("GetDashboardData endpoint called")
+	params := ctx.QueryParams()
+	r, err := parseQueryRange(params.Get("from"), params.Get("to"))
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	timeout, err := queryTimeout(params)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+
+	dashboard, err := a.DashboardRepo.GetDashboard(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Dashboard not found")
+			return ctx.JSON(404, "dashboard not found")
+		}
+		ctx.Logger().Error("Error retrieving dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+
+	_, values := a.resolveVariables(ctx.Request().Context(), dashboard, r, variableValues(params), influxParams(params), timeout)
+	data := a.dashboardData(ctx.Request().Context(), dashboard, r, values, influxParams(params), timeout)
+	if data.Errors > 0 {
+		ctx.Logger().Warnf("Dashboard data retrieved with %d failed queries", data.Errors)
+	} else {
+		ctx.Logger().Info("Dashboard data retrieved successfully")
+	}
+	return ctx.JSON(200, data)
+}
+
+// CreateSnapshot runs the dashboard's panel queries once and stores the
+// results behind a public link. The time range and variables are taken from
+// the query parameters like for the dashboard data.
+func (a *Server) CreateSnapshot(ctx echo.Context) error {
+	ctx.Logger().Info("CreateSnapshot endpoint called")
+	req := struct {
+		Name          string `json:"name"`
+		ExpiresInDays int    `json:"expires_in_days"`
+	}{}
+	if err := ctx.Bind(&req); err != nil {
+		ctx.Logger().Error("Error binding snapshot data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxSnapshotDays {
+		return ctx.JSON(400, fmt.Sprintf("expires_in_days must be between 1 and %d", maxSnapshotDays))
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultSnapshotDays
+	}
+
+	params := ctx.QueryParams()
+	r, err := parseQueryRange(params.Get("from"), params.Get("to"))
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	timeout, err := queryTimeout(params)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+
+	dashboard, err := a.DashboardRepo.GetDashboard(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Dashboard not found")
+			return ctx.JSON(404, "dashboard not found")
+		}
+		ctx.Logger().Error("Error retrieving dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+
+	variables, values := a.resolveVariables(ctx.Request().Context(), dashboard, r, variableValues(params), influxParams(params), timeout)
+	data := a.dashboardData(ctx.Request().Context(), dashboard, r, values, influxParams(params), timeout)
+
+	value, hash, err := generateSnapshotToken()
+	if err != nil {
+		ctx.Logger().Error("Error generating snapshot token: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if req.Name == "" {
+		req.Name = dashboard.Name
+	}
+	now := time.Now()
+	snapshot := &model.Snapshot{
+		DashboardID: dashboard.ID,
+		Name:        req.Name,
+		Hash:        hash,
+		Prefix:      value[:snapshotPrefixLength],
+		Author:      authentication.Username(ctx),
+		CreatedAt:   now,
+		ExpiresAt:   now.AddDate(0, 0, req.ExpiresInDays),
+		Dashboard:   frozenDashboard(dashboard),
+		Variables:   variables,
+		Data:        data,
+	}
+	if err := a.SnapshotRepo.CreateSnapshot(ctx.Request().Context(), snapshot); err != nil {
+		ctx.Logger().Error("Error creating snapshot: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if data.Errors > 0 {
+		ctx.Logger().Warnf("Snapshot created with %d failed queries", data.Errors)
+	} else {
+		ctx.Logger().Info("Snapshot created successfully")
+	}
+	// The content is only served through the link
+	details := *snapshot
+	details.Dashboard, details.Variables, details.Data = nil, nil, nil
+	return ctx.JSON(201, map[string]interface{}{
+		"token":   value,
+		"url":     "/snapshots/" + value,
+		"details": &details,
+	})
+}
+
+func (a *Server) GetSnapshots(ctx echo.Context) error {
+	ctx.Logger().Info("GetSnapshots endpoint called")
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	snapshots, err := a.SnapshotRepo.ListSnapshots(ctx.Request().Context(), ctx.Param("id"), opts)
+	if err != nil {
+		if errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Dashboard not found")
+			return ctx.JSON(404, "dashboard not found")
+		}
+		return listError(ctx, "snapshots", err)
+	}
+	ctx.Logger().Info("Snapshots retrieved successfully")
+	return ctx.JSON(200, snapshots)
+}
+
+func (a *Server) DeleteSnapshot(ctx echo.Context) error {
+	ctx.Logger().Info("DeleteSnapshot endpoint called")
+	if err := a.SnapshotRepo.DeleteSnapshot(ctx.Request().Context(), ctx.Param("id"), ctx.Param("snapshotID")); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Snapshot not found")
+			return ctx.JSON(404, "snapshot not found")
+		}
+		ctx.Logger().Error("Error deleting snapshot: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Snapshot deleted successfully")
+	return ctx.JSON(200, "OK")
+}
+
+// GetPublicSnapshot serves a snapshot to anyone holding its token. Only the
+// stored results are returned; no query ever reaches InfluxDB from here.
+func (a *Server) GetPublicSnapshot(ctx echo.Context) error {
+	ctx.Logger().Info("GetPublicSnapshot endpoint called")
+	snapshot, err := a.SnapshotRepo.GetSnapshotByHash(ctx.Request().Context(), hashSnapshotToken(ctx.Param("token")))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.Logger().Warn("Snapshot not found or expired")
+			return ctx.JSON(404, "snapshot not found")
+		}
+		ctx.Logger().Error("Error retrieving snapshot: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+	ctx.Logger().Info("Snapshot retrieved successfully")
+	return ctx.JSON(200, snapshot.Public())
+}
+
+func (a *Server) GetDashboardVariables(ctx echo.Context) error {
+	ctx.Logger().Info("GetDashboardVariables endpoint called")
+	params := ctx.QueryParams()
+	r, err := parseQueryRange(params.Get("from"), params.Get("to"))
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	timeout, err := queryTimeout(params)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+
+	dashboard, err := a.DashboardRepo.GetDashboard(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Dashboard not found")
+			return ctx.JSON(404, "dashboard not found")
+		}
+		ctx.Logger().Error("Error retrieving dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+
+	variables, _ := a.resolveVariables(ctx.Request().Context(), dashboard, r, variableValues(params), influxParams(params), timeout)
+	ctx.Logger().Info("Dashboard variables resolved successfully")
+	return ctx.JSON(200, variables)
+}
+
+func (a *Server) GetDashboards(ctx echo.Context) error {
+	ctx.Logger().Info("GetDashboards endpoint called")
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	tree, err := a.loadFolderTree(ctx.Request().Context())
+	if err != nil {
+		ctx.Logger().Error("Error loading folders: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	hidden := tree.hiddenDashboards(authentication.Username(ctx), authentication.Role(ctx))
+	dashboards, err := a.DashboardRepo.ListDashboards(ctx.Request().Context(), opts, hidden)
+	if err != nil {
+		return listError(ctx, "dashboards", err)
+	}
+	ctx.Logger().Info("Dashboards retrieved successfully")
+	return ctx.JSON(200, dashboards)
+}
+
+func (a *Server) CreateFolder(ctx echo.Context) error {
+	folder := &model.Folder{}
+	if err := ctx.Bind(folder); err != nil {
+		ctx.Logger().Error("CreateFolder: Invalid request", "error", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	if folder.Name == "" {
+		return ctx.JSON(400, "folder name is required")
+	}
+	if err := validatePermissions(folder.Permissions); err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	// Like SetFolderPermissions, only admins may restrict access
+	if len(folder.Permissions) > 0 && authentication.Role(ctx) != model.RoleAdmin {
+		return ctx.String(http.StatusForbidden, "forbidden")
+	}
+	tree, err := a.loadFolderTree(ctx.Request().Context())
+	if err != nil {
+		ctx.Logger().Error("CreateFolder: Failed to load folders", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if folder.ParentID != nil {
+		if _, found := tree.folders[*folder.ParentID]; !found {
+			return ctx.JSON(404, "parent folder not found")
+		}
+		if tree.folderRole(*folder.ParentID, authentication.Username(ctx), authentication.Role(ctx)).Level() < model.RoleEditor.Level() {
+			return ctx.String(http.StatusForbidden, "forbidden")
+		}
+	}
+	// Dashboards may only be moved by editors of them
+	for _, dashboardID := range folder.DashboardIDs {
+		if _, err := a.DashboardRepo.GetDashboard(ctx.Request().Context(), dashboardID.Hex()); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return ctx.JSON(404, fmt.Sprintf("dashboard %s not found", dashboardID.Hex()))
+			}
+			ctx.Logger().Error("CreateFolder: Failed to load dashboard", "error", err)
+			return ctx.JSON(500, "internal server error")
+		}
+		if tree.dashboardRole(dashboardID, authentication.Username(ctx), authentication.Role(ctx)).Level() < model.RoleEditor.Level() {
+			return ctx.String(http.StatusForbidden, "forbidden")
+		}
+		// Like MoveDashboard, only admins may change who can access it
+		if authentication.Role(ctx) != model.RoleAdmin && !tree.moveKeepsAccess(tree.dashboardFolder(dashboardID), folder.ParentID) {
+			return ctx.String(http.StatusForbidden, "forbidden")
+		}
+	}
+	dashboardIDs := folder.DashboardIDs
+	folder.DashboardIDs = nil
+	id, err := a.FolderRepo.CreateFolder(ctx.Request().Context(), folder)
+	if err != nil {
+		ctx.Logger().Error("CreateFolder: Failed to create folder", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	// Dashboards are moved one by one, so each stays in a single folder
+	for _, dashboardID := range dashboardIDs {
+		if err := a.FolderRepo.MoveDashboard(ctx.Request().Context(), dashboardID, &id); err != nil {
+			ctx.Logger().Error("CreateFolder: Failed to move dashboard", "error", err)
+			return ctx.JSON(500, "internal server error")
+		}
+	}
+	ctx.Logger().Info("CreateFolder: Folder created", "id", id)
+	return ctx.JSON(201, map[string]interface{}{"id": id})
+}
+
+func (a *Server) GetFolder(ctx echo.Context) error {
+	id := ctx.Param("id")
+	folder, err := a.FolderRepo.GetFolder(ctx.Request().Context(), id)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("GetFolder: Folder not found", "id", id)
+			return ctx.JSON(404, "folder not found")
+		}
+		ctx.Logger().Error("GetFolder: Internal server error", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("GetFolder: Folder retrieved", "id", id)
+	return ctx.JSON(200, folder)
+}
+
+func (a *Server) RenameFolder(ctx echo.Context) error {
+	id := ctx.Param("id")
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := ctx.Bind(&req); err != nil {
+		ctx.Logger().Error("RenameFolder: Invalid request", "error", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	if req.Name == "" {
+		return ctx.JSON(400, "folder name is required")
+	}
+	if err := a.FolderRepo.RenameFolder(ctx.Request().Context(), id, req.Name); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("RenameFolder: Folder not found", "id", id)
+			return ctx.JSON(404, "folder not found")
+		}
+		ctx.Logger().Error("RenameFolder: Failed to rename folder", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("RenameFolder: Folder renamed", "id", id)
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) MoveFolder(ctx echo.Context) error {
+	id := ctx.Param("id")
+	move := &model.FolderMove{}
+	if err := ctx.Bind(move); err != nil {
+		ctx.Logger().Error("MoveFolder: Invalid request", "error", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ctx.JSON(404, "folder not found")
+	}
+
+	tree, err := a.loadFolderTree(ctx.Request().Context())
+	if err != nil {
+		ctx.Logger().Error("MoveFolder: Failed to load folders", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if move.FolderID != nil {
+		if _, found := tree.folders[*move.FolderID]; !found {
+			return ctx.JSON(404, "parent folder not found")
+		}
+		if tree.isAncestor(objectID, *move.FolderID) {
+			return ctx.JSON(409, errFolderCycle.Error())
+		}
+		if tree.folderRole(*move.FolderID, authentication.Username(ctx), authentication.Role(ctx)).Level() < model.RoleEditor.Level() {
+			return ctx.String(http.StatusForbidden, "forbidden")
+		}
+	}
+	// A folder without permissions of its own inherits them from its parent.
+	// Only admins may move it where different permissions apply, as that
+	// changes who can access it.
+	if folder, found := tree.folders[objectID]; found && len(folder.Permissions) == 0 && authentication.Role(ctx) != model.RoleAdmin &&
+		!tree.moveKeepsAccess(folder.ParentID, move.FolderID) {
+		return ctx.String(http.StatusForbidden, "forbidden")
+	}
+
+	if err := a.FolderRepo.MoveFolder(ctx.Request().Context(), id, move.FolderID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.Logger().Warn("MoveFolder: Folder not found", "id", id)
+			return ctx.JSON(404, "folder not found")
+		}
+		ctx.Logger().Error("MoveFolder: Failed to move folder", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("MoveFolder: Folder moved", "id", id)
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) SetFolderPermissions(ctx echo.Context) error {
+	id := ctx.Param("id")
+	var permissions []model.FolderPermission
+	if err := ctx.Bind(&permissions); err != nil {
+		ctx.Logger().Error("SetFolderPermissions: Invalid request", "error", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	if err := validatePermissions(permissions); err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	if err := a.FolderRepo.SetPermissions(ctx.Request().Context(), id, permissions); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("SetFolderPermissions: Folder not found", "id", id)
+			return ctx.JSON(404, "folder not found")
+		}
+		ctx.Logger().Error("SetFolderPermissions: Failed to set permissions", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("SetFolderPermissions: Permissions set", "id", id)
+	return ctx.JSON(200, "OK")
+}
+
+// validatePermissions checks that each user is listed once with a known role.
+func validatePermissions(permissions []model.FolderPermission) error {
+	seen := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		if p.Username == "" {
+			return errors.New("permission without username")
+		}
+		if !p.Role.Valid() {
+			return fmt.Errorf("invalid role %q for %s", p.Role, p.Username)
+		}
+		if seen[p.Username] {
+			return fmt.Errorf("duplicate permission for %s", p.Username)
+		}
+		seen[p.Username] = true
+	}
+	return nil
+}
+
+func (a *Server) MoveDashboard(ctx echo.Context) error {
+	ctx.Logger().Info("MoveDashboard endpoint called")
+	move := &model.FolderMove{}
+	if err := ctx.Bind(move); err != nil {
+		ctx.Logger().Error("Error binding move data: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	dashboard, err := a.DashboardRepo.GetDashboard(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("Dashboard not found")
+			return ctx.JSON(404, "dashboard not found")
+		}
+		ctx.Logger().Error("Error retrieving dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	tree, err := a.loadFolderTree(ctx.Request().Context())
+	if err != nil {
+		ctx.Logger().Error("Error loading folders: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	if move.FolderID != nil {
+		if _, found := tree.folders[*move.FolderID]; !found {
+			return ctx.JSON(404, "folder not found")
+		}
+		if tree.folderRole(*move.FolderID, authentication.Username(ctx), authentication.Role(ctx)).Level() < model.RoleEditor.Level() {
+			return ctx.String(http.StatusForbidden, "forbidden")
+		}
+	}
+	// Dashboards inherit the permissions of their folder. Only admins may
+	// move them where different permissions apply, including the top level.
+	if authentication.Role(ctx) != model.RoleAdmin && !tree.moveKeepsAccess(tree.dashboardFolder(dashboard.ID), move.FolderID) {
+		return ctx.String(http.StatusForbidden, "forbidden")
+	}
+
+	if err := a.FolderRepo.MoveDashboard(ctx.Request().Context(), dashboard.ID, move.FolderID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ctx.JSON(404, "folder not found")
+		}
+		ctx.Logger().Error("Error moving dashboard: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("Dashboard moved successfully")
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) DeleteFolder(ctx echo.Context) error {
+	id := ctx.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ctx.JSON(404, "folder not found")
+	}
+	tree, err := a.loadFolderTree(ctx.Request().Context())
+	if err != nil {
+		ctx.Logger().Error("DeleteFolder: Failed to load folders", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	// The contents move up to the parent folder and lose the permissions of
+	// this one, so only admins may delete a folder restricting access
+	if folder, found := tree.folders[objectID]; found && len(folder.Permissions) > 0 && authentication.Role(ctx) != model.RoleAdmin {
+		return ctx.String(http.StatusForbidden, "forbidden")
+	}
+	if err := a.FolderRepo.DeleteFolder(ctx.Request().Context(), id); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			ctx.Logger().Warn("DeleteFolder: Folder not found", "id", id)
+			return ctx.JSON(404, "folder not found")
+		}
+		ctx.Logger().Error("DeleteFolder: Failed to delete folder", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("DeleteFolder: Folder deleted", "id", id)
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) GetFolders(ctx echo.Context) error {
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	tree, err := a.loadFolderTree(ctx.Request().Context())
+	if err != nil {
+		ctx.Logger().Error("GetFolders: Failed to load folders", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	hidden := tree.hiddenFolders(authentication.Username(ctx), authentication.Role(ctx))
+	folders, err := a.FolderRepo.ListFolders(ctx.Request().Context(), opts, hidden)
+	if err != nil {
+		return listError(ctx, "folders", err)
+	}
+	ctx.Logger().Info("GetFolders: Folders retrieved")
+	return ctx.JSON(200, folders)
+}
+
+func (a *Server) AddNotification(ctx echo.Context) error {
+	n := &model.Notification{}
+	if err := ctx.Bind(n); err != nil {
+		ctx.Logger().Error("AddNotification: Invalid request", "error", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	if n.ChannelName == "" {
+		return ctx.JSON(400, "channel_name is required")
+	}
+	if n.RateLimit < 0 {
+		return ctx.JSON(400, "rate_limit must not be negative")
+	}
+	// Store channels created the old way with a typed config as well
+	if n.Driver == "" {
+		n.Driver, n.Config = channelDriver(n)
+		n.ChatID = 0
+	}
+	if _, err := a.channelNotifier(n); err != nil {
+		ctx.Logger().Warn("AddNotification: Invalid channel", "error", err)
+		return ctx.JSON(400, err.Error())
+	}
+	if _, err := renderNotification(n, notification.SampleAlert()); err != nil {
+		ctx.Logger().Warn("AddNotification: Invalid template", "error", err)
+		return ctx.JSON(400, err.Error())
+	}
+	n.ID = primitive.NilObjectID
+	n.CheckName, n.Level, n.Message = "", "", ""
+	if err := a.NotificationRepo.CreateNotification(ctx.Request().Context(), n); err != nil {
+		ctx.Logger().Error("AddNotification: Failed to add notification", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("AddNotification: Notification added")
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) GetNotification(ctx echo.Context) error {
+	channelName := ctx.Param("channelName")
+	n, err := a.NotificationRepo.GetNotification(ctx.Request().Context(), channelName)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.Logger().Warn("GetNotification: Notification not found", "channelName", channelName)
+			return ctx.JSON(404, "notification not found")
+		}
+		ctx.Logger().Error("GetNotification: Internal server error", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	// Driver settings such as passwords and tokens are never returned
+	if config, ok := normalizeValue(n.Config).(map[string]interface{}); ok {
+		n.Config = redact(config).(map[string]interface{})
+	}
+	ctx.Logger().Info("GetNotification: Notification retrieved", "channelName", channelName)
+	return ctx.JSON(200, n)
+}
+
+func (a *Server) DeleteNotification(ctx echo.Context) error {
+	channelName := ctx.Param("channelName")
+	if err := a.NotificationRepo.DeleteNotification(ctx.Request().Context(), channelName); err != nil {
+		ctx.Logger().Error("DeleteNotification: Failed to delete notification", "error", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	ctx.Logger().Info("DeleteNotification: Notification deleted", "channelName", channelName)
+	return ctx.JSON(200, "OK")
+}
+
+func (a *Server) CreateAnnotation(ctx echo.Context) error {
+	ctx.Logger().Info("CreateAnnotation endpoint called")
+	annotation := &model.Annotation{}
+	if err := ctx.Bind(annotation); err != nil {
+		ctx.Logger().Error("Error binding annotation: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	annotation.ID = primitive.NilObjectID
+	annotation.Author = authentication.Username(ctx)
+	annotation.CreatedAt = time.Now()
+	if annotation.Time.IsZero() {
+		annotation.Time = annotation.CreatedAt
+	}
+	if err := a.checkAnnotation(ctx, annotation); err != nil {
+		return annotationError(ctx, err)
+	}
+	id, err := a.AnnotationRepo.CreateAnnotation(ctx.Request().Context(), annotation)
+	if err != nil {
+		return annotationError(ctx, err)
+	}
+	annotation.ID = id
+	ctx.Logger().Info("Annotation created successfully")
+	return ctx.JSON(201, annotation)
+}
+
+// checkAnnotation validates an annotation and checks the caller may scope it
+// to its dashboard.
+func (a *Server) checkAnnotation(ctx echo.Context, annotation *model.Annotation) error {
+	if err := annotation.Validate(); err != nil {
+		return &echo.HTTPError{Code: 400, Message: err.Error()}
+	}
+	return a.checkAnnotationScope(ctx.Request().Context(), annotation, authentication.Username(ctx), authentication.Role(ctx))
+}
+
+func (a *Server) GetAnnotation(ctx echo.Context) error {
+	ctx.Logger().Info("GetAnnotation endpoint called")
+	annotation, err := a.AnnotationRepo.GetAnnotation(ctx.Request().Context(), ctx.Param("id"))
+	if err != nil {
+		return annotationError(ctx, err)
+	}
+	if err := a.checkAnnotationRole(ctx.Request().Context(), annotation, authentication.Username(ctx), authentication.Role(ctx), model.RoleViewer); err != nil {
+		return annotationError(ctx, err)
+	}
+	ctx.Logger().Info("Annotation retrieved successfully")
+	return ctx.JSON(200, annotation)
+}
+
+// GetAnnotations returns the annotations overlapping the from and to time
+// window that carry all given tags, optionally limited to a dashboard and
+// panel.
+func (a *Server) GetAnnotations(ctx echo.Context) error {
+	ctx.Logger().Info("GetAnnotations endpoint called")
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	filter := model.AnnotationFilter{}
+	now := time.Now()
+	if from := ctx.QueryParam("from"); from != "" {
+		if filter.From, err = parseQueryTime(from, now); err != nil {
+			return ctx.JSON(400, fmt.Sprintf("invalid from time %q", from))
+		}
+	}
+	if to := ctx.QueryParam("to"); to != "" {
+		if filter.To, err = parseQueryTime(to, now); err != nil {
+			return ctx.JSON(400, fmt.Sprintf("invalid to time %q", to))
+		}
+	}
+	if id := ctx.QueryParam("dashboard_id"); id != "" {
+		dashboardID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return ctx.JSON(400, "invalid dashboard_id")
+		}
+		filter.DashboardID = &dashboardID
+	}
+	if index := ctx.QueryParam("panel_index"); index != "" {
+		n, err := strconv.Atoi(index)
+		if err != nil || filter.DashboardID == nil {
+			return ctx.JSON(400, "panel_index requires dashboard_id and must be a number")
+		}
+		filter.PanelIndex = &n
+	}
+
+	tree, err := a.loadFolderTree(ctx.Request().Context())
+	if err != nil {
+		ctx.Logger().Error("Error loading folders: ", err)
+		return ctx.JSON(500, "internal server error")
+	}
+	filter.Hidden = tree.hiddenDashboards(authentication.Username(ctx), authentication.Role(ctx))
+
+	annotations, err := a.AnnotationRepo.ListAnnotations(ctx.Request().Context(), filter, opts)
+	if err != nil {
+		return listError(ctx, "annotations", err)
+	}
+	ctx.Logger().Info("Annotations retrieved successfully")
+	return ctx.JSON(200, annotations)
+}
+
+func (a *Server) UpdateAnnotation(ctx echo.Context) error {
+	ctx.Logger().Info("UpdateAnnotation endpoint called")
+	id := ctx.Param("id")
+	existing, err := a.AnnotationRepo.GetAnnotation(ctx.Request().Context(), id)
+	if err != nil {
+		return annotationError(ctx, err)
+	}
+	if err := a.checkAnnotationRole(ctx.Request().Context(), existing, authentication.Username(ctx), authentication.Role(ctx), model.RoleEditor); err != nil {
+		return annotationError(ctx, err)
+	}
+
+	annotation := &model.Annotation{}
+	if err := ctx.Bind(annotation); err != nil {
+		ctx.Logger().Error("Error binding annotation: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	if err := a.checkAnnotation(ctx, annotation); err != nil {
+		return annotationError(ctx, err)
+	}
+
+	updated, err := a.AnnotationRepo.UpdateAnnotation(ctx.Request().Context(), id, annotation)
+	if err != nil {
+		return annotationError(ctx, err)
+	}
+	ctx.Logger().Info("Annotation updated successfully")
+	return ctx.JSON(200, updated)
+}
+
+func (a *Server) DeleteAnnotation(ctx echo.Context) error {
+	ctx.Logger().Info("DeleteAnnotation endpoint called")
+	id := ctx.Param("id")
+	annotation, err := a.AnnotationRepo.GetAnnotation(ctx.Request().Context(), id)
+	if err != nil {
+		return annotationError(ctx, err)
+	}
+	if err := a.checkAnnotationRole(ctx.Request().Context(), annotation, authentication.Username(ctx), authentication.Role(ctx), model.RoleEditor); err != nil {
+		return annotationError(ctx, err)
+	}
+	if err := a.AnnotationRepo.DeleteAnnotation(ctx.Request().Context(), id); err != nil {
+		return annotationError(ctx, err)
+	}
+	ctx.Logger().Info("Annotation deleted successfully")
+	return ctx.JSON(200, "OK")
+}
+
+// annotationError maps errors of the annotation endpoints to responses.
+func annotationError(ctx echo.Context, err error) error {
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return ctx.JSON(httpErr.Code, httpErr.Message)
+	case errors.Is(err, errAnnotationDenied):
+		return ctx.String(http.StatusForbidden, "forbidden")
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, primitive.ErrInvalidHex):
+		ctx.Logger().Warn("Annotation not found")
+		return ctx.JSON(404, "annotation not found")
+	}
+	ctx.Logger().Error("Error handling annotation: ", err)
+	return ctx.JSON(500, "internal server error")
+}
+
+func (a *Server) SendNotification(ctx echo.Context) error {
+	notif := new(model.Notification)
+
+	// Keep the payload as sent, it may carry more alert fields than the
+	// notification has
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		ctx.Logger().Error("SendNotification: Failed to read request", "error", err)
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid input",
+		})
+	}
+	ctx.Request().Body = io.NopCloser(bytes.NewReader(body))
+	var raw map[string]interface{}
+	_ = json.Unmarshal(body, &raw)
+
+	if err := ctx.Bind(notif); err != nil {
+		ctx.Logger().Error("SendNotification: Invalid input", "error", err)
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid input",
+		})
+	}
+
+	notif.ChannelName = ctx.QueryParam("channelName")
+
+	// Check the annotation before sending, so a bad scope does not leave a
+	// notification without its annotation.
+	var annotation *model.Annotation
+	if notif.Annotate {
+		annotation = notificationAnnotation(notif, authentication.Username(ctx))
+		if err := a.checkAnnotation(ctx, annotation); err != nil {
+			return annotationError(ctx, err)
+		}
+	}
+
+	n, err := a.NotificationRepo.GetNotification(ctx.Request().Context(), notif.ChannelName)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.Logger().Warn("SendNotification: Notification not found", "channelName", notif.ChannelName)
+			return ctx.JSON(http.StatusNotFound, map[string]string{
+				"error": "Notification not found",
+			})
+		}
+		ctx.Logger().Error("SendNotification: Failed to retrieve notification", "error", err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve notification",
+		})
 	}
-	inputData.Type = ctx.Param("type")
-	if err := a.InputRepo.AddServerInput(ctx.Request().Context(), inputData); err != nil {
-		ctx.Logger().Error("Error adding server input: ", err)
-		return ctx.JSON(500, "internal server error")
+
+	if _, err := a.channelNotifier(n); err != nil {
+		ctx.Logger().Warn("SendNotification: Invalid channel", "channelName", notif.ChannelName, "error", err)
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid channel: " + err.Error(),
+		})
 	}
-	tomll, err := ConvertMapToTOML(inputData.Data, inputData.Type)
+
+	msg, err := renderNotification(n, a.newAlert(notif, raw))
 	if err != nil {
-		ctx.Logger().Error("Error converting data to TOML: ", err)
-		return ctx.JSON(500, "internal server error")
+		ctx.Logger().Error("SendNotification: Failed to render notification", "channelName", notif.ChannelName, "error", err)
+		return ctx.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Failed to render notification: " + err.Error(),
+		})
 	}
-	err = appendToFile("~/.Dana2/Dana2.conf", string(tomll))
+
+	// Deliveries are retried by the workers, so the channel being down does
+	// not fail the request
+	delivery, err := a.enqueueDelivery(ctx.Request().Context(), n, msg, authentication.Username(ctx))
 	if err != nil {
-		ctx.Logger().Error("Error appending data to file: ", err)
-		return err
+		ctx.Logger().Error("SendNotification: Failed to queue notification", "channelName", notif.ChannelName, "error", err)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to queue notification",
+		})
 	}
-	newConfig := config.NewConfig()
-	if err := newConfig.LoadConfigData(tomll); err != nil {
-		ctx.Logger().Error("Error loading config data: ", err)
-		return ctx.JSON(500, errors.New("internal server error"))
+	status := http.StatusAccepted
+	if delivery.Status == model.DeliverySuppressed {
+		ctx.Logger().Info("SendNotification: Notification suppressed", "channelName", notif.ChannelName, "id", delivery.ID.Hex(), "reason", delivery.Reason)
+		status = http.StatusOK
+	} else {
+		ctx.Logger().Info("SendNotification: Notification queued", "channelName", notif.ChannelName, "id", delivery.ID.Hex())
 	}
-	ctx.Logger().Info("Config data loaded successfully")
-	for _, input := range newConfig.Inputs {
-		// Share the snmp translator setting with plugins that need it.
-		if tp, ok := input.Input.(snmp.TranslatorPlugin); ok {
-			tp.SetTranslator(newConfig.Agent.SnmpTranslator)
-		}
-		err := input.Init()
+
+	if annotation != nil {
+		id, err := a.AnnotationRepo.CreateAnnotation(ctx.Request().Context(), annotation)
 		if err != nil {
-			return fmt.Errorf("could not initialize input %s: %w", input.LogName(), err)
+			ctx.Logger().Error("SendNotification: Failed to create annotation", "error", err)
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Notification queued but annotation could not be created",
+			})
 		}
+		ctx.Logger().Info("SendNotification: Annotation created", "id", id.Hex())
 	}
-	iu := &inputUnit{
-		dst:    a.InputDstChan,
-		inputs: newConfig.Inputs,
-	}
-	go func() {
-		time.Sleep(5 * time.Second)
-		execSelf()
-	}()
-	a.runInputs(ctx.Request().Context(), a.StartTime, iu)
-	ctx.Logger().Info("Inputs processed successfully")
-	return ctx.JSON(200, "OK")
+
+	return ctx.JSON(status, delivery)
 }
 
-func appendToFile(filePath string, textToAppend string) error {
-	filePath = expandHomeDir(filePath)
-	// Open the file in append mode, create it if it doesn't exist
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// GetNotificationHistory lists queued and delivered notifications with
+// their attempts, filtered by channel, status and the time they were queued.
+func (a *Server) GetNotificationHistory(ctx echo.Context) error {
+	ctx.Logger().Info("GetNotificationHistory endpoint called")
+	opts, err := listOptions(ctx)
 	if err != nil {
-		return err
+		return ctx.JSON(400, err.Error())
 	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			return
+	filter := model.DeliveryFilter{
+		ChannelName: ctx.QueryParam("channel"),
+		Status:      ctx.QueryParam("status"),
+	}
+	switch filter.Status {
+	case "", model.DeliveryPending, model.DeliverySending, model.DeliveryDelivered, model.DeliveryFailed, model.DeliverySuppressed:
+	default:
+		return ctx.JSON(400, fmt.Sprintf("invalid status %q", filter.Status))
+	}
+	now := time.Now()
+	if from := ctx.QueryParam("from"); from != "" {
+		if filter.From, err = parseQueryTime(from, now); err != nil {
+			return ctx.JSON(400, fmt.Sprintf("invalid from time %q", from))
+		}
+	}
+	if to := ctx.QueryParam("to"); to != "" {
+		if filter.To, err = parseQueryTime(to, now); err != nil {
+			return ctx.JSON(400, fmt.Sprintf("invalid to time %q", to))
 		}
-	}(file)
-
-	// Write the text to the file followed by a newline
-	_, err = file.WriteString(textToAppend + "\n")
-	if err != nil {
-		return err
 	}
 
-	return nil
-}
-
-func expandHomeDir(path string) string {
-	if path[:2] == "~/" {
-		usr, _ := user.Current()
-		homeDir := usr.HomeDir
-		return filepath.Join(homeDir, path[2:])
+	deliveries, err := a.NotificationQueueRepo.ListDeliveries(ctx.Request().Context(), filter, opts)
+	if err != nil {
+		return listError(ctx, "notification history", err)
 	}
-	return path
+	ctx.Logger().Info("Notification history retrieved successfully")
+	return ctx.JSON(200, deliveries)
 }
 
-func (a *Server) GetInput(ctx echo.Context) error {
-	ctx.Logger().Info("GetInput endpoint called")
-	inputs, err := a.InputRepo.GetServers(ctx.Request().Context())
+func (a *Server) GetNotificationDelivery(ctx echo.Context) error {
+	ctx.Logger().Info("GetNotificationDelivery endpoint called")
+	delivery, err := a.NotificationQueueRepo.GetDelivery(ctx.Request().Context(), ctx.Param("id"))
 	if err != nil {
-		ctx.Logger().Error("Error retrieving inputs: ", err)
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+			return ctx.JSON(404, "notification not found")
+		}
+		ctx.Logger().Error("Error retrieving notification: ", err)
 		return ctx.JSON(500, "internal server error")
 	}
-	ctx.Logger().Info("Inputs retrieved successfully")
-	return ctx.JSON(200, inputs)
+	ctx.Logger().Info("Notification retrieved successfully")
+	return ctx.JSON(200, delivery)
 }
 
-func (a *Server) GetInputByType(ctx echo.Context) error {
-	ctx.Logger().Info("GetInputByType endpoint called")
-	inputType := ctx.Param("type")
-	inputs, err := a.InputRepo.GetServersByType(ctx.Request().Context(), inputType)
+func (a *Server) CreateAlertRule(ctx echo.Context) error {
+	ctx.Logger().Info("CreateAlertRule endpoint called")
+	rule := &model.AlertRule{}
+	if err := ctx.Bind(rule); err != nil {
+		ctx.Logger().Error("Error binding alert rule: ", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
+	if err := a.checkAlertRule(ctx.Request().Context(), rule); err != nil {
+		return alertRuleError(ctx, err)
+	}
+	rule.ID = primitive.NilObjectID
+	rule.Author = authentication.Username(ctx)
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = rule.CreatedAt
+
+	id, err := a.AlertRuleRepo.CreateRule(ctx.Request().Context(), rule)
 	if err != nil {
-		ctx.Logger().Error("Error retrieving inputs by type: ", err)
-		return ctx.JSON(500, "internal server error")
+		return alertRuleError(ctx, err)
 	}
-	ctx.Logger().Info("Inputs by type retrieved successfully")
-	return ctx.JSON(200, inputs)
+	rule.ID = id
+	a.alerts.setRule(rule, time.Now())
+	ctx.Logger().Info("Alert rule created successfully")
+	return ctx.JSON(201, rule)
 }
 
-func (a *Server) CreateDashboard(ctx echo.Context) error {
-	ctx.Logger().Info("CreateDashboard endpoint called")
-	dashboard := &model.Dashboard{}
-	if err := ctx.Bind(dashboard); err != nil {
-		ctx.Logger().Error("Error binding dashboard data: ", err)
-		return ctx.JSON(400, errors.New("invalid request"))
+func (a *Server) GetAlertRules(ctx echo.Context) error {
+	ctx.Logger().Info("GetAlertRules endpoint called")
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
 	}
-	id, err := a.DashboardRepo.CreateDashboard(ctx.Request().Context(), dashboard)
+	rules, err := a.AlertRuleRepo.ListRules(ctx.Request().Context(), opts)
 	if err != nil {
-		ctx.Logger().Error("Error creating dashboard: ", err)
-		return ctx.JSON(500, "internal server error")
+		return listError(ctx, "alert rules", err)
 	}
-	ctx.Logger().Info("Dashboard created successfully")
-	return ctx.JSON(201, map[string]interface{}{"id": id})
+	ctx.Logger().Info("Alert rules retrieved successfully")
+	return ctx.JSON(200, rules)
 }
 
-func (a *Server) GetDashboard(ctx echo.Context) error {
-	ctx.Logger().Info("GetDashboard endpoint called")
-	id := ctx.Param("id")
-	dashboard, err := a.DashboardRepo.GetDashboard(ctx.Request().Context(), id)
+func (a *Server) GetAlertRule(ctx echo.Context) error {
+	ctx.Logger().Info("GetAlertRule endpoint called")
+	rule, err := a.AlertRuleRepo.GetRule(ctx.Request().Context(), ctx.Param("id"))
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			ctx.Logger().Warn("Dashboard not found")
-			return ctx.JSON(404, "dashboard not found")
-		}
-		ctx.Logger().Error("Error retrieving dashboard: ", err)
-		return ctx.JSON(500, "internal server error")
+		return alertRuleError(ctx, err)
 	}
-	ctx.Logger().Info("Dashboard retrieved successfully")
-	return ctx.JSON(200, dashboard)
+	ctx.Logger().Info("Alert rule retrieved successfully")
+	return ctx.JSON(200, rule)
 }
 
-func (a *Server) UpdateDashboard(ctx echo.Context) error {
-	ctx.Logger().Info("UpdateDashboard endpoint called")
-	dashboard := &model.Dashboard{}
-	if err := ctx.Bind(dashboard); err != nil {
-		ctx.Logger().Error("Error binding dashboard data: ", err)
+func (a *Server) UpdateAlertRule(ctx echo.Context) error {
+	ctx.Logger().Info("UpdateAlertRule endpoint called")
+	rule := &model.AlertRule{}
+	if err := ctx.Bind(rule); err != nil {
+		ctx.Logger().Error("Error binding alert rule: ", err)
 		return ctx.JSON(400, errors.New("invalid request"))
 	}
-	id, _ := primitive.ObjectIDFromHex(ctx.Param("id"))
-	if err := a.DashboardRepo.UpdateDashboard(ctx.Request().Context(), dashboard, id); err != nil {
-		ctx.Logger().Error("Error updating dashboard: ", err)
-		return ctx.JSON(500, "internal server error")
+	if err := a.checkAlertRule(ctx.Request().Context(), rule); err != nil {
+		return alertRuleError(ctx, err)
 	}
-	ctx.Logger().Info("Dashboard updated successfully")
-	return ctx.JSON(200, "OK")
+	rule.UpdatedAt = time.Now()
+
+	updated, err := a.AlertRuleRepo.UpdateRule(ctx.Request().Context(), ctx.Param("id"), rule)
+	if err != nil {
+		return alertRuleError(ctx, err)
+	}
+	a.alerts.setRule(updated, time.Now())
+	ctx.Logger().Info("Alert rule updated successfully")
+	return ctx.JSON(200, updated)
 }
 
-func (a *Server) DeleteDashboard(ctx echo.Context) error {
-	ctx.Logger().Info("DeleteDashboard endpoint called")
+func (a *Server) DeleteAlertRule(ctx echo.Context) error {
+	ctx.Logger().Info("DeleteAlertRule endpoint called")
 	id := ctx.Param("id")
-	if err := a.DashboardRepo.DeleteDashboard(ctx.Request().Context(), id); err != nil {
-		ctx.Logger().Error("Error deleting dashboard: ", err)
-		return ctx.JSON(500, "internal server error")
+	if err := a.AlertRuleRepo.DeleteRule(ctx.Request().Context(), id); err != nil {
+		return alertRuleError(ctx, err)
 	}
-	ctx.Logger().Info("Dashboard deleted successfully")
+	// The id was valid, otherwise the rule could not have been deleted
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	a.alerts.removeRule(objectID)
+	ctx.Logger().Info("Alert rule deleted successfully")
 	return ctx.JSON(200, "OK")
 }
 
-func (a *Server) GetDashboards(ctx echo.Context) error {
-	ctx.Logger().Info("GetDashboards endpoint called")
-	dashboards, err := a.DashboardRepo.GetDashboards(ctx.Request().Context())
-	if err != nil {
-		ctx.Logger().Error("Error retrieving dashboards: ", err)
-		return ctx.JSON(500, "internal server error")
+// GetAlertStates returns the current level of every group of the enabled
+// alert rules. level filters the states, e.g. level=crit.
+func (a *Server) GetAlertStates(ctx echo.Context) error {
+	ctx.Logger().Info("GetAlertStates endpoint called")
+	level := ctx.QueryParam("level")
+	states := make([]model.AlertState, 0)
+	for _, state := range a.alerts.states() {
+		if level == "" || state.Level == level {
+			states = append(states, state)
+		}
 	}
-	ctx.Logger().Info("Dashboards retrieved successfully")
-	return ctx.JSON(200, dashboards)
+	return ctx.JSON(200, states)
 }
 
-func (a *Server) CreateFolder(ctx echo.Context) error {
-	folder := &model.Folder{}
-	if err := ctx.Bind(folder); err != nil {
-		ctx.Logger().Error("CreateFolder: Invalid request", "error", err)
+func (a *Server) CreateSilence(ctx echo.Context) error {
+	ctx.Logger().Info("CreateSilence endpoint called")
+	silence := &model.Silence{}
+	if err := ctx.Bind(silence); err != nil {
+		ctx.Logger().Error("Error binding silence: ", err)
 		return ctx.JSON(400, errors.New("invalid request"))
 	}
-	id, err := a.FolderRepo.CreateFolder(ctx.Request().Context(), folder)
-	if err != nil {
-		ctx.Logger().Error("CreateFolder: Failed to create folder", "error", err)
-		return ctx.JSON(500, "internal server error")
+	silence.ID = primitive.NilObjectID
+	silence.CreatedBy = authentication.Username(ctx)
+	silence.CreatedAt = time.Now()
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = silence.CreatedAt
+	}
+	if err := silence.Validate(); err != nil {
+		return ctx.JSON(400, err.Error())
 	}
-	ctx.Logger().Info("CreateFolder: Folder created", "id", id)
-	return ctx.JSON(201, map[string]interface{}{"id": id})
-}
 
-func (a *Server) GetFolder(ctx echo.Context) error {
-	id := ctx.Param("id")
-	folder, err := a.FolderRepo.GetFolder(ctx.Request().Context(), id)
+	id, err := a.SilenceRepo.CreateSilence(ctx.Request().Context(), silence)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			ctx.Logger().Warn("GetFolder: Folder not found", "id", id)
-			return ctx.JSON(404, "folder not found")
-		}
-		ctx.Logger().Error("GetFolder: Internal server error", "error", err)
-		return ctx.JSON(500, "internal server error")
+		return silenceError(ctx, err)
 	}
-	ctx.Logger().Info("GetFolder: Folder retrieved", "id", id)
-	return ctx.JSON(200, folder)
+	silence.ID = id
+	silence.Active = silence.IsActive(time.Now())
+	ctx.Logger().Info("Silence created successfully")
+	return ctx.JSON(201, silence)
 }
 
-func (a *Server) UpdateDashboardInFolder(ctx echo.Context) error {
-	folderID := ctx.Param("folderID")
-	dashboardID := ctx.Param("dashboardID")
-	dashboard := &model.Dashboard{}
-	if err := ctx.Bind(dashboard); err != nil {
-		ctx.Logger().Error("UpdateDashboardInFolder: Invalid request", "error", err)
-		return ctx.JSON(400, errors.New("invalid request"))
+// GetSilences lists the silences that did not end yet, or all of them with
+// expired=true.
+func (a *Server) GetSilences(ctx echo.Context) error {
+	ctx.Logger().Info("GetSilences endpoint called")
+	opts, err := listOptions(ctx)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
 	}
-	if err := a.FolderRepo.UpdateDashboardInFolder(ctx.Request().Context(), folderID, dashboardID, dashboard); err != nil {
-		ctx.Logger().Error("UpdateDashboardInFolder: Failed to update dashboard", "error", err)
-		return ctx.JSON(500, "internal server error")
+	filter := model.SilenceFilter{Expired: ctx.QueryParam("expired") == "true"}
+	silences, err := a.SilenceRepo.ListSilences(ctx.Request().Context(), filter, opts)
+	if err != nil {
+		return listError(ctx, "silences", err)
 	}
-	ctx.Logger().Info("UpdateDashboardInFolder: Dashboard updated", "folderID", folderID, "dashboardID", dashboardID)
-	return ctx.JSON(200, "OK")
-}
-
-func (a *Server) DeleteFolder(ctx echo.Context) error {
-	id := ctx.Param("id")
-	if err := a.FolderRepo.DeleteFolder(ctx.Request().Context(), id); err != nil {
-		ctx.Logger().Error("DeleteFolder: Failed to delete folder", "error", err)
-		return ctx.JSON(500, "internal server error")
+	now := time.Now()
+	for _, silence := range silences.Items {
+		silence.Active = silence.IsActive(now)
 	}
-	ctx.Logger().Info("DeleteFolder: Folder deleted", "id", id)
-	return ctx.JSON(200, "OK")
+	ctx.Logger().Info("Silences retrieved successfully")
+	return ctx.JSON(200, silences)
 }
 
-func (a *Server) GetFolders(ctx echo.Context) error {
-	folders, err := a.FolderRepo.GetFolders(ctx.Request().Context())
+func (a *Server) GetSilence(ctx echo.Context) error {
+	ctx.Logger().Info("GetSilence endpoint called")
+	silence, err := a.SilenceRepo.GetSilence(ctx.Request().Context(), ctx.Param("id"))
 	if err != nil {
-		ctx.Logger().Error("GetFolders: Failed to retrieve folders", "error", err)
-		return ctx.JSON(500, "internal server error")
+		return silenceError(ctx, err)
 	}
-	ctx.Logger().Info("GetFolders: Folders retrieved")
-	return ctx.JSON(200, folders)
+	silence.Active = silence.IsActive(time.Now())
+	ctx.Logger().Info("Silence retrieved successfully")
+	return ctx.JSON(200, silence)
 }
 
-func (a *Server) AddNotification(ctx echo.Context) error {
-	n := &model.Notification{}
-	if err := ctx.Bind(n); err != nil {
-		ctx.Logger().Error("AddNotification: Invalid request", "error", err)
+func (a *Server) UpdateSilence(ctx echo.Context) error {
+	ctx.Logger().Info("UpdateSilence endpoint called")
+	silence := &model.Silence{}
+	if err := ctx.Bind(silence); err != nil {
+		ctx.Logger().Error("Error binding silence: ", err)
 		return ctx.JSON(400, errors.New("invalid request"))
 	}
-	if err := a.NotificationRepo.CreateNotification(ctx.Request().Context(), n); err != nil {
-		ctx.Logger().Error("AddNotification: Failed to add notification", "error", err)
-		return ctx.JSON(500, "internal server error")
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = time.Now()
+	}
+	if err := silence.Validate(); err != nil {
+		return ctx.JSON(400, err.Error())
 	}
-	ctx.Logger().Info("AddNotification: Notification added")
-	return ctx.JSON(200, "OK")
-}
 
-func (a *Server) GetNotification(ctx echo.Context) error {
-	channelName := ctx.Param("channelName")
-	n, err := a.NotificationRepo.GetNotification(ctx.Request().Context(), channelName)
+	updated, err := a.SilenceRepo.UpdateSilence(ctx.Request().Context(), ctx.Param("id"), silence)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			ctx.Logger().Warn("GetNotification: Notification not found", "channelName", channelName)
-			return ctx.JSON(404, "notification not found")
-		}
-		ctx.Logger().Error("GetNotification: Internal server error", "error", err)
-		return ctx.JSON(500, "internal server error")
+		return silenceError(ctx, err)
 	}
-	ctx.Logger().Info("GetNotification: Notification retrieved", "channelName", channelName)
-	return ctx.JSON(200, n)
+	updated.Active = updated.IsActive(time.Now())
+	ctx.Logger().Info("Silence updated successfully")
+	return ctx.JSON(200, updated)
 }
 
-func (a *Server) DeleteNotification(ctx echo.Context) error {
-	channelName := ctx.Param("channelName")
-	if err := a.NotificationRepo.DeleteNotification(ctx.Request().Context(), channelName); err != nil {
-		ctx.Logger().Error("DeleteNotification: Failed to delete notification", "error", err)
-		return ctx.JSON(500, "internal server error")
+func (a *Server) DeleteSilence(ctx echo.Context) error {
+	ctx.Logger().Info("DeleteSilence endpoint called")
+	if err := a.SilenceRepo.DeleteSilence(ctx.Request().Context(), ctx.Param("id")); err != nil {
+		return silenceError(ctx, err)
 	}
-	ctx.Logger().Info("DeleteNotification: Notification deleted", "channelName", channelName)
+	ctx.Logger().Info("Silence deleted successfully")
 	return ctx.JSON(200, "OK")
 }
 
-func (a *Server) SendNotification(ctx echo.Context) error {
-	notif := new(model.Notification)
+// silenceError maps errors of the silence endpoints to responses.
+func silenceError(ctx echo.Context, err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+		ctx.Logger().Warn("Silence not found")
+		return ctx.JSON(404, "silence not found")
+	}
+	ctx.Logger().Error("Error handling silence: ", err)
+	return ctx.JSON(500, "internal server error")
+}
 
-	if err := ctx.Bind(notif); err != nil {
-		ctx.Logger().Error("SendNotification: Invalid input", "error", err)
-		return ctx.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid input",
-		})
+// alertRuleError maps errors of the alert rule endpoints to responses.
+func alertRuleError(ctx echo.Context, err error) error {
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return ctx.JSON(httpErr.Code, httpErr.Message)
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, primitive.ErrInvalidHex):
+		ctx.Logger().Warn("Alert rule not found")
+		return ctx.JSON(404, "alert rule not found")
 	}
+	ctx.Logger().Error("Error handling alert rule: ", err)
+	return ctx.JSON(500, "internal server error")
+}
 
-	notif.ChannelName = ctx.QueryParam("channelName")
+// PreviewNotification renders a template against a sample payload without
+// sending anything. The driver and template default to those of the channel
+// named in the request, the payload to a sample alert.
+func (a *Server) PreviewNotification(ctx echo.Context) error {
+	ctx.Logger().Info("PreviewNotification endpoint called")
+	req := struct {
+		ChannelName string                      `json:"channel_name"`
+		Driver      string                      `json:"driver"`
+		Template    *model.NotificationTemplate `json:"template"`
+		Payload     map[string]interface{}      `json:"payload"`
+	}{}
+	if err := ctx.Bind(&req); err != nil {
+		ctx.Logger().Error("PreviewNotification: Invalid request", "error", err)
+		return ctx.JSON(400, errors.New("invalid request"))
+	}
 
-	n, err := a.NotificationRepo.GetNotification(ctx.Request().Context(), ctx.QueryParam("channelName"))
-	if err != nil {
-		ctx.Logger().Error("SendNotification: Failed to retrieve notification", "error", err)
-		return ctx.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to retrieve notification",
-		})
+	channel := &model.Notification{ChannelName: req.ChannelName}
+	if req.ChannelName != "" {
+		n, err := a.NotificationRepo.GetNotification(ctx.Request().Context(), req.ChannelName)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return ctx.JSON(404, "notification not found")
+			}
+			ctx.Logger().Error("PreviewNotification: Failed to retrieve notification", "error", err)
+			return ctx.JSON(500, "internal server error")
+		}
+		channel = n
+	}
+	if req.Driver != "" {
+		channel.Driver = req.Driver
+	}
+	if req.Template != nil {
+		channel.Template = req.Template
+	}
+	driver, _ := channelDriver(channel)
+	if _, found := notification.Formats[driver]; !found {
+		return ctx.JSON(400, fmt.Sprintf("unknown driver %q", driver))
 	}
 
-	if strings.Contains(notif.ChannelName, "telegram") {
-		notification.SendNotification("https://api.telegram.org", a.Config.ServerConfig.TelegramToken, "checkname: "+notif.CheckName+"\n"+"level: "+notif.Level+"\n"+"message: "+notif.Message, int64(n.ChatID))
-		ctx.Logger().Info("SendNotification: Notification sent to Telegram", "chatID", n.ChatID)
-	} else if strings.Contains(notif.ChannelName, "bale") {
-		notification.SendNotification("https://tapi.bale.ai", a.Config.ServerConfig.BaleToken, "checkname: "+notif.CheckName+"\n"+"level: "+notif.Level+"\n"+"message: "+notif.Message, int64(n.ChatID))
-		ctx.Logger().Info("SendNotification: Notification sent to Bale", "chatID", n.ChatID)
-	} else {
-		ctx.Logger().Warn("SendNotification: Invalid channel name", "channelName", notif.ChannelName)
-		return ctx.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid channel name",
-		})
+	alert := notification.SampleAlert()
+	if req.Payload != nil {
+		notif := &model.Notification{ChannelName: channel.ChannelName}
+		buf, _ := json.Marshal(req.Payload)
+		if err := json.Unmarshal(buf, notif); err != nil {
+			return ctx.JSON(400, "invalid payload: "+err.Error())
+		}
+		alert = a.newAlert(notif, req.Payload)
 	}
 
-	return ctx.JSON(http.StatusOK, notif)
+	msg, err := renderNotification(channel, alert)
+	if err != nil {
+		return ctx.JSON(400, err.Error())
+	}
+	ctx.Logger().Info("PreviewNotification: Template rendered")
+	return ctx.JSON(200, msg)
+}
+
+// GetNotificationDrivers lists the drivers channels can be created with.
+func (a *Server) GetNotificationDrivers(ctx echo.Context) error {
+	ctx.Logger().Info("GetNotificationDrivers endpoint called")
+	return ctx.JSON(200, notification.Drivers())
 }
 
 func (a *Server) NotificationEndpointsGet(ctx echo.Context) error {
@@ -503,15 +2432,46 @@ func (a *Server) GetNetwork(ctx echo.Context) error {
 
 func (a *Server) GetNetworks(ctx echo.Context) error {
 	ctx.Logger().Info("GetNetworks endpoint called")
-	networks, err := a.NetworkRepo.GetNetworks(ctx.Request().Context())
+	opts, err := listOptions(ctx)
 	if err != nil {
-		ctx.Logger().Error("Error retrieving networks", err)
-		return ctx.JSON(500, "internal server error")
+		return ctx.JSON(400, err.Error())
+	}
+	networks, err := a.NetworkRepo.ListNetworks(ctx.Request().Context(), opts)
+	if err != nil {
+		return listError(ctx, "networks", err)
 	}
 	ctx.Logger().Info("Networks retrieved successfully")
 	return ctx.JSON(200, networks)
 }
 
+// listOptions reads the "limit", "cursor", "sort", "q" and "tag" parameters
+// of the list endpoints.
+func listOptions(ctx echo.Context) (model.ListOptions, error) {
+	opts := model.ListOptions{
+		Cursor: ctx.QueryParam("cursor"),
+		Sort:   ctx.QueryParam("sort"),
+		Query:  ctx.QueryParam("q"),
+		Tags:   ctx.QueryParams()["tag"],
+	}
+	if limit := ctx.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > repository.MaxPageSize {
+			return opts, fmt.Errorf("limit must be between 1 and %d", repository.MaxPageSize)
+		}
+		opts.Limit = n
+	}
+	return opts, nil
+}
+
+// listError maps errors of the list endpoints to responses.
+func listError(ctx echo.Context, what string, err error) error {
+	if errors.Is(err, repository.ErrInvalidCursor) || errors.Is(err, repository.ErrInvalidSort) {
+		return ctx.JSON(400, err.Error())
+	}
+	ctx.Logger().Errorf("Error retrieving %s: %v", what, err)
+	return ctx.JSON(500, "internal server error")
+}
+
 func (a *Server) DeleteNetwork(ctx echo.Context) error {
 	ctx.Logger().Info("DeleteNetwork endpoint called")
 	name := ctx.Param("name")
@@ -581,6 +2541,8 @@ func (a *Server) proxyRequest(ctx echo.Context, path string) (int, string, []byt
 		}
 	}
 
+	// The caller's credentials are for the agent, never pass them on
+	targetReq.Header.Del("Authorization")
 	if a.Config.ServerConfig.InfluxToken != "" {
 		targetReq.Header.Set("Authorization", "Token "+a.Config.ServerConfig.InfluxToken)
 	}
@@ -612,65 +2574,92 @@ func (a *Server) proxyRequest(ctx echo.Context, path string) (int, string, []byt
 	return resp.StatusCode, resp.Header.Get("Content-Type"), responseBody
 }
 
-// ConvertMapToTOML takes a map[string]interface{} and converts it to a TOML-formatted file
+// ConvertMapToTOML takes the settings of an input plugin of type t and
+// converts them to a TOML document with one [[inputs.t]] table per entry.
 func ConvertMapToTOML(data map[string]interface{}, t string) ([]byte, error) {
-	tomlTree, err := toml.TreeFromMap(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create TOML tree: %w", err)
-	}
-
-	tomlBytes, err := tomlTree.Marshal()
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal TOML: %w", err)
-	}
-
-	updatedToml := processConfig2(string(tomlBytes), t)
-
-	return []byte(updatedToml), nil
+	return convertPluginToTOML("inputs", t, data)
 }
 
-func processConfig2(input string, t string) string {
-	var result strings.Builder
-	scanner := bufio.NewScanner(strings.NewReader(input))
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		trimmedLine := strings.TrimSpace(line)
-		indentation := strings.Repeat(" ", len(line)-len(trimmedLine))
-		if strings.Contains(trimmedLine, `[["inputs.`+t) {
-			processedLine := strings.ReplaceAll(trimmedLine, `"`, "")
-			result.WriteString(indentation + processedLine + "\n")
-		} else if strings.Contains(trimmedLine, `["inputs.`+t) {
-			processedLine := "[" + strings.ReplaceAll(trimmedLine, `"`, "") + "]"
-			result.WriteString(indentation + processedLine + "\n")
-		} else {
-			result.WriteString(line + "\n")
+// convertPluginToTOML converts plugin settings to a TOML document for the
+// given plugin category and name. The settings may be given either as the
+// plugin table itself, as a list of tables, nested under the category and
+// name or under a dotted "category.name" key.
+func convertPluginToTOML(category, name string, data map[string]interface{}) ([]byte, error) {
+	var settings interface{} = data
+	if v, ok := data[category+"."+name]; ok {
+		settings = v
+	} else if c, ok := normalizeValue(data[category]).(map[string]interface{}); ok {
+		if v, ok := c[name]; ok {
+			settings = v
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading input:", err)
+	var tables []interface{}
+	switch v := normalizeValue(settings).(type) {
+	case map[string]interface{}:
+		tables = []interface{}{v}
+	case []interface{}:
+		for _, e := range v {
+			if _, ok := e.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("%s.%s entries must be tables, got %T", category, name, e)
+			}
+		}
+		tables = v
+	default:
+		return nil, fmt.Errorf("%s.%s settings must be a table or a list of tables, got %T", category, name, v)
 	}
 
-	return result.String()
-}
+	tomlTree, err := toml.TreeFromMap(map[string]interface{}{
+		category: map[string]interface{}{name: tables},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TOML tree: %w", err)
+	}
 
-// execSelf relaunches the current binary
-func execSelf() {
-	binary, err := os.Executable()
+	tomlBytes, err := tomlTree.Marshal()
 	if err != nil {
-		log.Fatalf("Failed to find executable: %v", err)
+		return nil, fmt.Errorf("failed to marshal TOML: %w", err)
 	}
 
-	args := os.Args
-	log.Printf("Restarting application: %s %v\n", binary, args)
+	return tomlBytes, nil
+}
 
-	cmd := exec.Command(binary, args[1:]...) // Pass command-line arguments
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Start(); err != nil {
-		log.Fatalf("Failed to restart: %v", err)
+// normalizeValue converts the values decoded from JSON or BSON to plain maps
+// and slices. Whole floating point numbers are turned into integers as JSON
+// does not distinguish between them but the plugin settings do.
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case primitive.D:
+		return normalizeValue(v.Map())
+	case primitive.M:
+		return normalizeValue(map[string]interface{}(v))
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = normalizeValue(e)
+		}
+		return m
+	case primitive.A:
+		return normalizeValue([]interface{}(v))
+	case []map[string]interface{}:
+		s := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			s = append(s, normalizeValue(e))
+		}
+		return s
+	case []interface{}:
+		s := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			s = append(s, normalizeValue(e))
+		}
+		return s
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+		return v
+	case int32:
+		return int64(v)
 	}
-
-	os.Exit(0) // Exit current process
+	return v
 }
@@ -0,0 +1,194 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"Dana/agent/model"
+	"Dana/agent/repository"
+	"Dana/config"
+)
+
+// createdTokens records the API tokens stored by CreateAPIToken.
+type createdTokens struct {
+	repository.APITokenRepo
+	tokens []*model.APIToken
+}
+
+func (r *createdTokens) CreateToken(_ context.Context, token *model.APIToken) error {
+	r.tokens = append(r.tokens, token)
+	return nil
+}
+
+func TestCreateAPITokenExpiry(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected int
+		days     int
+	}{
+		{body: `{"name":"ci","scope":"inputs"}`, expected: http.StatusCreated, days: defaultAPITokenDays},
+		{body: `{"name":"ci","scope":"inputs","expires_in_days":7}`, expected: http.StatusCreated, days: 7},
+		{body: `{"name":"ci","scope":"inputs","expires_in_days":365}`, expected: http.StatusCreated, days: maxAPITokenDays},
+		{body: `{"name":"ci","scope":"inputs","expires_in_days":366}`, expected: http.StatusBadRequest},
+		{body: `{"name":"ci","scope":"inputs","expires_in_days":-1}`, expected: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			repo := &createdTokens{}
+			a := &Server{APITokenRepo: repo}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/tokens", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, rec)
+			ctx.Set("username", "alice")
+
+			require.NoError(t, a.CreateAPIToken(ctx))
+			require.Equal(t, tt.expected, rec.Code)
+			if tt.expected != http.StatusCreated {
+				require.Contains(t, rec.Body.String(), "between 1 and 365")
+				require.Empty(t, repo.tokens)
+				return
+			}
+			require.Len(t, repo.tokens, 1)
+			token := repo.tokens[0]
+			require.Equal(t, "alice", token.Username)
+			require.Equal(t, token.CreatedAt.AddDate(0, 0, tt.days), token.ExpiresAt)
+		})
+	}
+}
+
+func TestProxyRequestAuthorization(t *testing.T) {
+	for _, influxToken := range []string{"", "influx-secret"} {
+		t.Run("influx token "+influxToken, func(t *testing.T) {
+			headers := make(chan http.Header, 1)
+			influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headers <- r.Header.Clone()
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{})
+			}))
+			t.Cleanup(influx.Close)
+			host, port, _ := strings.Cut(strings.TrimPrefix(influx.URL, "http://"), ":")
+			a := &Server{Config: &config.Config{ServerConfig: &config.ServerConfig{
+				InfluxHost:  host,
+				InfluxPort:  port,
+				InfluxToken: influxToken,
+			}}}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/orgs", nil)
+			req.Header.Set("Authorization", "Bearer dana_secret")
+			req.Header.Set("Accept", "application/json")
+			ctx := echo.New().NewContext(req, httptest.NewRecorder())
+
+			status, _, _ := a.proxyRequest(ctx, "/api/v2/orgs")
+			require.Equal(t, http.StatusOK, status)
+
+			var received http.Header
+			select {
+			case received = <-headers:
+			case <-time.After(5 * time.Second):
+				t.Fatal("request did not reach InfluxDB")
+			}
+			require.Equal(t, "application/json", received.Get("Accept"))
+			if influxToken == "" {
+				require.Empty(t, received.Values("Authorization"))
+			} else {
+				require.Equal(t, []string{"Token influx-secret"}, received.Values("Authorization"))
+			}
+		})
+	}
+}
+
+// storedDashboard serves a single dashboard and records its updates.
+type storedDashboard struct {
+	repository.DashboardRepo
+	current *model.Dashboard
+	updated *model.Dashboard
+}
+
+func (r *storedDashboard) GetDashboard(context.Context, string) (*model.Dashboard, error) {
+	return r.current, nil
+}
+
+func (r *storedDashboard) UpdateDashboard(_ context.Context, dashboard *model.Dashboard, id primitive.ObjectID) (*model.Dashboard, error) {
+	updated := *dashboard
+	updated.ID = id
+	updated.Version = r.current.Version + 1
+	r.updated = &updated
+	return &updated, nil
+}
+
+func TestUpdateDashboardPanelIndexes(t *testing.T) {
+	legacy := []model.Panel{{Name: "cpu"}, {Name: "memory"}, {Name: "disk"}}
+	numbered := []model.Panel{{Name: "cpu", Index: 0}, {Name: "memory", Index: 1}, {Name: "disk", Index: 2}}
+
+	tests := []struct {
+		name     string
+		current  []model.Panel
+		body     string
+		expected int
+		indexes  []int
+	}{
+		{
+			name:     "stored with repeated indexes",
+			current:  legacy,
+			body:     `{"name":"servers","panels":[{"name":"cpu"},{"name":"memory"},{"name":"disk"},{"name":"net"}]}`,
+			expected: http.StatusOK,
+			indexes:  []int{0, 1, 2, 3},
+		},
+		{
+			name:     "stored with repeated indexes and sent with unique ones",
+			current:  legacy,
+			body:     `{"name":"servers","panels":[{"name":"cpu","index":4},{"name":"memory","index":7}]}`,
+			expected: http.StatusOK,
+			indexes:  []int{4, 7},
+		},
+		{
+			name:     "stored with unique indexes",
+			current:  numbered,
+			body:     `{"name":"servers","panels":[{"name":"cpu","index":2},{"name":"memory","index":0},{"name":"disk","index":1}]}`,
+			expected: http.StatusOK,
+			indexes:  []int{2, 0, 1},
+		},
+		{
+			name:     "repeated indexes in a dashboard stored with unique ones",
+			current:  numbered,
+			body:     `{"name":"servers","panels":[{"name":"cpu","index":1},{"name":"memory","index":1}]}`,
+			expected: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &storedDashboard{current: &model.Dashboard{ID: primitive.NewObjectID(), Name: "servers", Panels: tt.current, Version: 1}}
+			a := &Server{DashboardRepo: repo, DashboardRevisionRepo: &recordedRevisions{}}
+
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/dashboards/"+repo.current.ID.Hex(), strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, rec)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(repo.current.ID.Hex())
+
+			require.NoError(t, a.UpdateDashboard(ctx))
+			require.Equal(t, tt.expected, rec.Code, rec.Body.String())
+			if tt.expected != http.StatusOK {
+				require.Contains(t, rec.Body.String(), "panels[1].index")
+				require.Nil(t, repo.updated)
+				return
+			}
+			indexes := make([]int, 0, len(repo.updated.Panels))
+			for _, p := range repo.updated.Panels {
+				indexes = append(indexes, p.Index)
+			}
+			require.Equal(t, tt.indexes, indexes)
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"Dana"
+	"Dana/agent/model"
+	"Dana/config"
+	"Dana/internal/snmp"
+	"Dana/models"
+)
+
+// errPipelineNotRunning is returned when inputs are changed through the API
+// before the pipeline is started or after it was shut down.
+var errPipelineNotRunning = errors.New("agent pipeline is not running")
+
+// managedInputs keeps track of the inputs created through the API. Each
+// entry can be stopped and replaced while the processors, aggregators and
+// outputs behind it keep running, so metrics already buffered in the outputs
+// are not lost.
+type managedInputs struct {
+	sync.Mutex
+	ctx     context.Context
+	dst     chan<- Dana.Metric
+	closed  bool
+	running map[string]*managedInput
+}
+
+// managedInput is the set of running inputs created from a single
+// model.HandlerInput.
+type managedInput struct {
+	unit   *inputUnit
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// buildInputs converts a stored input into initialized running inputs
+// without starting them.
+func (a *Server) buildInputs(input *model.HandlerInput) ([]*models.RunningInput, error) {
+	tomll, err := ConvertMapToTOML(input.Data, input.Type)
+	if err != nil {
+		return nil, fmt.Errorf("converting data to TOML: %w", err)
+	}
+
+	newConfig := config.NewConfig()
+	if err := newConfig.LoadConfigData(tomll); err != nil {
+		return nil, fmt.Errorf("loading config data: %w", err)
+	}
+	if len(newConfig.Inputs) == 0 {
+		return nil, fmt.Errorf("no %s input defined", input.Type)
+	}
+
+	for _, ri := range newConfig.Inputs {
+		if ri.Config.Name != input.Type {
+			return nil, fmt.Errorf("input %s does not match type %s", ri.LogName(), input.Type)
+		}
+		ri.SetDefaultTags(a.Config.Tags)
+
+		// Share the snmp translator setting with plugins that need it.
+		if tp, ok := ri.Input.(snmp.TranslatorPlugin); ok {
+			tp.SetTranslator(a.Config.Agent.SnmpTranslator)
+		}
+		if err := ri.Init(); err != nil {
+			return nil, fmt.Errorf("could not initialize input %s: %w", ri.LogName(), err)
+		}
+	}
+
+	return newConfig.Inputs, nil
+}
+
+// loadStoredInputs builds all inputs stored in MongoDB keyed by their
+// document id. Stored inputs that cannot be built or duplicate an input of
+// the config files, which would otherwise run twice, are logged and skipped
+// so they do not keep the agent from starting.
+func (a *Server) loadStoredInputs(ctx context.Context) (map[string][]*models.RunningInput, error) {
+	stored, err := a.InputRepo.GetServers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading stored inputs: %w", err)
+	}
+
+	result := make(map[string][]*models.RunningInput, len(stored))
+	for _, s := range stored {
+		id := s.ID.Hex()
+		inputs, err := a.buildInputs(s)
+		if err == nil {
+			err = a.checkInputConflicts(inputs)
+		}
+		if err != nil {
+			log.Printf("E! [agent] Skipping stored input %s (%s): %v", id, s.Type, err)
+			continue
+		}
+		result[id] = inputs
+	}
+
+	return result, nil
+}
+
+// checkInputConflicts returns an error if any of the inputs duplicates an
+// input of the config files or uses one of their aliases.
+func (a *Server) checkInputConflicts(inputs []*models.RunningInput) error {
+	fileIDs := make(map[string]bool, len(a.Config.Inputs))
+	fileAliases := make(map[string]bool, len(a.Config.Inputs))
+	for _, ri := range a.Config.Inputs {
+		fileIDs[ri.ID()] = true
+		if ri.Config.Alias != "" {
+			fileAliases[ri.LogName()] = true
+		}
+	}
+
+	var conflicts []string
+	for _, ri := range inputs {
+		switch {
+		case fileIDs[ri.ID()]:
+			conflicts = append(conflicts, fmt.Sprintf("%s is identical to an input in the config files", ri.LogName()))
+		case ri.Config.Alias != "" && fileAliases[ri.LogName()]:
+			conflicts = append(conflicts, fmt.Sprintf("%s uses an alias already used in the config files", ri.LogName()))
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("conflicts with the config files: %s", strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// attachInputDst makes the managed inputs write to the given channel. It is
+// called once the pipeline behind the inputs is running.
+func (a *Server) attachInputDst(ctx context.Context, dst chan<- Dana.Metric) {
+	a.managed.Lock()
+	defer a.managed.Unlock()
+
+	a.managed.ctx = ctx
+	a.managed.dst = dst
+	a.InputDstChan = dst
+}
+
+// emitMetric writes a metric created by the agent itself to the pipeline as
+// if an input gathered it. The metric is dropped if the pipeline is not
+// running or is full; waiting for room while holding the lock would keep the
+// inputs from being stopped, and the channel may be closed once the lock is
+// released.
+func (a *Server) emitMetric(m Dana.Metric) {
+	a.managed.Lock()
+	defer a.managed.Unlock()
+
+	if a.managed.dst == nil || a.managed.closed {
+		return
+	}
+	select {
+	case a.managed.dst <- m:
+	default:
+		log.Printf("W! [agent] Dropping %s metric, the pipeline is full", m.Name())
+	}
+}
+
+// startManagedInputs starts the inputs under the given id, stopping any
+// inputs previously started under the same id.
+func (a *Server) startManagedInputs(id string, inputs []*models.RunningInput) error {
+	a.managed.Lock()
+	defer a.managed.Unlock()
+
+	if a.managed.dst == nil || a.managed.closed {
+		return errPipelineNotRunning
+	}
+	if a.managed.running == nil {
+		a.managed.running = make(map[string]*managedInput)
+	}
+
+	if previous, found := a.managed.running[id]; found {
+		log.Printf("I! [agent] Replacing inputs of %s", id)
+		previous.stop()
+		delete(a.managed.running, id)
+	}
+
+	unit, err := a.startInputs(a.managed.dst, inputs)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(a.managed.ctx)
+	mi := &managedInput{
+		unit:   unit,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(mi.done)
+		a.runInputs(ctx, a.StartTime, unit)
+	}()
+	a.managed.running[id] = mi
+
+	log.Printf("I! [agent] Started %d input(s) for %s", len(unit.inputs), id)
+	return nil
+}
+
+// stopManagedInputs stops the inputs started under the given id. It returns
+// false if there were none.
+func (a *Server) stopManagedInputs(id string) bool {
+	a.managed.Lock()
+	defer a.managed.Unlock()
+
+	mi, found := a.managed.running[id]
+	if !found {
+		return false
+	}
+	mi.stop()
+	delete(a.managed.running, id)
+
+	log.Printf("I! [agent] Stopped inputs of %s", id)
+	return true
+}
+
+// closeManagedInputs stops all managed inputs and refuses to start new ones,
+// so the input channel can be closed safely afterward.
+func (a *Server) closeManagedInputs() {
+	a.managed.Lock()
+	defer a.managed.Unlock()
+
+	for id, mi := range a.managed.running {
+		mi.stop()
+		delete(a.managed.running, id)
+	}
+	a.managed.closed = true
+}
+
+// stop cancels the gather loops and waits until the inputs are stopped.
+func (mi *managedInput) stop() {
+	mi.cancel()
+	<-mi.done
+}
@@ -0,0 +1,294 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"Dana"
+	"Dana/agent/model"
+	"Dana/agent/repository"
+	"Dana/config"
+	"Dana/metric"
+	"Dana/models"
+	"Dana/plugins/inputs"
+)
+
+// testInputEvents receives "start <name>" and "stop <name>" whenever a
+// serviceInput starts or stops.
+var testInputEvents = make(chan string, 100)
+
+// serviceInput is a service input writing a single metric tagged with its
+// name when it starts.
+type serviceInput struct {
+	Name     string `toml:"name"`
+	FailInit bool   `toml:"fail_init"`
+}
+
+func (*serviceInput) SampleConfig() string { return "" }
+
+func (s *serviceInput) Init() error {
+	if s.FailInit {
+		return errors.New("init failed")
+	}
+	return nil
+}
+
+func (s *serviceInput) Start(acc Dana.Accumulator) error {
+	acc.AddFields("agent_test", map[string]interface{}{"value": 1}, map[string]string{"name": s.Name})
+	testInputEvents <- "start " + s.Name
+	return nil
+}
+
+func (*serviceInput) Gather(Dana.Accumulator) error { return nil }
+
+func (s *serviceInput) Stop() {
+	testInputEvents <- "stop " + s.Name
+}
+
+func init() {
+	inputs.Add("agent_test", func() Dana.Input { return &serviceInput{} })
+}
+
+// requireInputEvents checks that exactly the given events happened, in order.
+func requireInputEvents(t *testing.T, expected ...string) {
+	t.Helper()
+	for _, e := range expected {
+		select {
+		case actual := <-testInputEvents:
+			require.Equal(t, e, actual)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %q", e)
+		}
+	}
+	select {
+	case actual := <-testInputEvents:
+		t.Fatalf("unexpected %q", actual)
+	default:
+	}
+}
+
+// testInput returns an input of the test plugin with the given settings.
+func testInput(data map[string]interface{}) *model.HandlerInput {
+	return &model.HandlerInput{ID: primitive.NewObjectID(), Type: "agent_test", Data: data}
+}
+
+// newManagedInputsServer returns a server whose managed inputs write to the
+// returned channel.
+func newManagedInputsServer(t *testing.T) (*Server, chan Dana.Metric) {
+	a := &Server{Config: config.NewConfig()}
+	dst := make(chan Dana.Metric, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	a.attachInputDst(ctx, dst)
+	t.Cleanup(func() {
+		a.closeManagedInputs()
+		cancel()
+		for len(testInputEvents) > 0 {
+			<-testInputEvents
+		}
+	})
+	return a, dst
+}
+
+// buildTestInputs builds the input or fails the test.
+func buildTestInputs(t *testing.T, a *Server, input *model.HandlerInput) []*models.RunningInput {
+	t.Helper()
+	inputs, err := a.buildInputs(input)
+	require.NoError(t, err)
+	return inputs
+}
+
+func TestManagedInputsLifecycle(t *testing.T) {
+	a, dst := newManagedInputsServer(t)
+
+	first := buildTestInputs(t, a, testInput(map[string]interface{}{"name": "first"}))
+	require.NoError(t, a.startManagedInputs("a", first))
+	requireInputEvents(t, "start first")
+	metric := <-dst
+	require.Equal(t, "agent_test", metric.Name())
+	require.Equal(t, map[string]string{"name": "first"}, metric.Tags())
+
+	// Replacing stops the previous inputs before the new ones start
+	second := buildTestInputs(t, a, testInput(map[string]interface{}{"name": "second"}))
+	require.NoError(t, a.startManagedInputs("a", second))
+	requireInputEvents(t, "stop first", "start second")
+
+	other := buildTestInputs(t, a, testInput(map[string]interface{}{"inputs.agent_test": []interface{}{
+		map[string]interface{}{"name": "third"},
+		map[string]interface{}{"name": "fourth"},
+	}}))
+	require.Len(t, other, 2)
+	require.NoError(t, a.startManagedInputs("b", other))
+	requireInputEvents(t, "start third", "start fourth")
+
+	require.True(t, a.stopManagedInputs("a"))
+	requireInputEvents(t, "stop second")
+	require.False(t, a.stopManagedInputs("a"))
+
+	// Closing stops the remaining inputs and refuses new ones
+	a.closeManagedInputs()
+	require.ElementsMatch(t, []string{"stop third", "stop fourth"}, []string{<-testInputEvents, <-testInputEvents})
+	require.ErrorIs(t, a.startManagedInputs("a", first), errPipelineNotRunning)
+	requireInputEvents(t)
+}
+
+func TestManagedInputsNotRunning(t *testing.T) {
+	a := &Server{Config: config.NewConfig()}
+	inputs := buildTestInputs(t, a, testInput(map[string]interface{}{"name": "early"}))
+	require.ErrorIs(t, a.startManagedInputs("a", inputs), errPipelineNotRunning)
+	require.False(t, a.stopManagedInputs("a"))
+	requireInputEvents(t)
+}
+
+func TestEmitMetric(t *testing.T) {
+	a := &Server{Config: config.NewConfig()}
+	alert := metric.New(alertMeasurement, map[string]string{"rule": "cpu"}, map[string]interface{}{"level": "crit"}, time.Now())
+
+	// Not running yet
+	a.emitMetric(alert)
+
+	dst := make(chan Dana.Metric, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.attachInputDst(ctx, dst)
+
+	a.emitMetric(alert)
+	require.Len(t, dst, 1)
+
+	// A full pipeline drops the metric instead of blocking the caller and
+	// everyone else waiting for the lock
+	emitted := make(chan struct{})
+	go func() {
+		defer close(emitted)
+		a.emitMetric(alert)
+	}()
+	select {
+	case <-emitted:
+	case <-time.After(time.Second):
+		t.Fatal("emitMetric blocked on a full pipeline")
+	}
+	require.False(t, a.stopManagedInputs("a"))
+	require.Equal(t, alert, <-dst)
+
+	a.closeManagedInputs()
+	a.emitMetric(alert)
+	require.Empty(t, dst)
+}
+
+// failingInputRepo serves a single stored input and fails to update it.
+type failingInputRepo struct {
+	repository.HandlerInputRepo
+	stored *model.HandlerInput
+}
+
+func (r *failingInputRepo) GetServer(_ context.Context, id string) (*model.HandlerInput, error) {
+	return r.stored, nil
+}
+
+func (r *failingInputRepo) UpdateServerInput(context.Context, string, *model.HandlerInput) error {
+	return errors.New("database is down")
+}
+
+func TestUpdateInputRestoresPreviousInputs(t *testing.T) {
+	a, _ := newManagedInputsServer(t)
+	stored := testInput(map[string]interface{}{"name": "stored"})
+	id := stored.ID.Hex()
+	a.InputRepo = &failingInputRepo{stored: stored}
+	require.NoError(t, a.startManagedInputs(id, buildTestInputs(t, a, stored)))
+	requireInputEvents(t, "start stored")
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		ctx := echo.New().NewContext(req, rec)
+		ctx.SetParamNames("type", "id")
+		ctx.SetParamValues("agent_test", id)
+		require.NoError(t, a.UpdateInput(ctx))
+		return rec
+	}
+
+	// The new inputs ran until storing them failed, then the stored
+	// configuration is running again
+	rec := update(`{"data":{"name":"updated"}}`)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	requireInputEvents(t, "stop stored", "start updated", "stop updated", "start stored")
+
+	// Inputs that cannot be built never replace the running ones
+	rec = update(`{"data":{"name":"broken","fail_init":true}}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	requireInputEvents(t)
+
+	// Nothing is started if the input to restore cannot be built anymore
+	a.InputRepo = &failingInputRepo{stored: testInput(map[string]interface{}{"name": "stored", "fail_init": true})}
+	a.InputRepo.(*failingInputRepo).stored.ID = stored.ID
+	rec = update(`{"data":{"name":"updated"}}`)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	requireInputEvents(t, "stop stored", "start updated", "stop updated")
+	require.False(t, a.stopManagedInputs(id))
+}
+
+func TestCheckInputConflicts(t *testing.T) {
+	a := &Server{Config: config.NewConfig()}
+	a.Config.Inputs = append(
+		buildTestInputs(t, a, testInput(map[string]interface{}{"name": "file"})),
+		buildTestInputs(t, a, testInput(map[string]interface{}{"name": "aliased", "alias": "shared"}))...,
+	)
+
+	tests := []struct {
+		name     string
+		data     map[string]interface{}
+		conflict string
+	}{
+		{name: "different settings", data: map[string]interface{}{"name": "api"}},
+		{name: "different alias", data: map[string]interface{}{"name": "api", "alias": "other"}},
+		{name: "identical settings", data: map[string]interface{}{"name": "file"}, conflict: "inputs.agent_test is identical"},
+		{name: "same alias", data: map[string]interface{}{"name": "api", "alias": "shared"}, conflict: "inputs.agent_test::shared uses an alias"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := a.checkInputConflicts(buildTestInputs(t, a, testInput(tt.data)))
+			if tt.conflict == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.conflict)
+			}
+		})
+	}
+}
+
+// storedInputRepo serves a fixed set of stored inputs.
+type storedInputRepo struct {
+	repository.HandlerInputRepo
+	stored []*model.HandlerInput
+}
+
+func (r *storedInputRepo) GetServers(context.Context) ([]*model.HandlerInput, error) {
+	return r.stored, nil
+}
+
+func TestLoadStoredInputsSkipsBadEntries(t *testing.T) {
+	a := &Server{Config: config.NewConfig()}
+	a.Config.Inputs = buildTestInputs(t, a, testInput(map[string]interface{}{"name": "file"}))
+
+	good := testInput(map[string]interface{}{"name": "good"})
+	failing := testInput(map[string]interface{}{"name": "failing", "fail_init": true})
+	duplicate := testInput(map[string]interface{}{"name": "file"})
+	unknown := &model.HandlerInput{ID: primitive.NewObjectID(), Type: "does_not_exist", Data: map[string]interface{}{}}
+	invalid := testInput(map[string]interface{}{"name": []interface{}{1, 2}})
+	a.InputRepo = &storedInputRepo{stored: []*model.HandlerInput{failing, good, duplicate, unknown, invalid}}
+
+	loaded, err := a.loadStoredInputs(context.Background())
+	require.NoError(t, err)
+	require.Len(t, loaded, 1)
+	require.Contains(t, loaded, good.ID.Hex())
+	require.Equal(t, "good", loaded[good.ID.Hex()][0].Input.(*serviceInput).Name)
+}
@@ -0,0 +1,499 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"Dana"
+	"Dana/agent/model"
+	"Dana/config"
+	"Dana/models"
+)
+
+// pluginCategories are the plugin categories managed through the generic
+// plugin API. Inputs have their own endpoints, see managed_inputs.go.
+var pluginCategories = map[string]bool{
+	"outputs":     true,
+	"processors":  true,
+	"aggregators": true,
+}
+
+// managedPlugins keeps track of the outputs, processors and aggregators
+// created through the API. Outputs are added to and removed from the running
+// output unit one by one. Processors and aggregators form a chain, so the
+// whole chain is rebuilt when one of them changes.
+type managedPlugins struct {
+	sync.Mutex
+	stored  map[string]*config.Config
+	order   []string
+	outputs *outputUnit
+	relay   processingRelay
+}
+
+// processingRelay forwards the metrics of the inputs to the current
+// processing unit.
+//
+//	           ┌───────┐     ┌─────────────────────────┐     ┌─────┐
+//	()_____)──▶│ Relay │──▶  │ Processors, Aggregators │ ──▶ │ Fwd │──▶ ()_____)
+//	           └───────┘     └─────────────────────────┘     └─────┘
+type processingRelay struct {
+	sync.Mutex
+	dst    chan<- Dana.Metric
+	unit   *processingUnit
+	closed bool
+}
+
+// processingUnit is a chain of processors and aggregators. It is done once
+// its source channel is closed and all metrics were passed on.
+type processingUnit struct {
+	src  chan<- Dana.Metric
+	done chan struct{}
+}
+
+// outputsUnit returns the running output unit, or nil if the pipeline was
+// not started yet.
+func (mp *managedPlugins) outputsUnit() *outputUnit {
+	mp.Lock()
+	defer mp.Unlock()
+	return mp.outputs
+}
+
+// buildPlugins converts a stored plugin into a config holding the
+// initialized running plugins without starting them.
+func (a *Server) buildPlugins(plugin *model.HandlerPlugin) (*config.Config, error) {
+	if !pluginCategories[plugin.Category] {
+		return nil, fmt.Errorf("unsupported plugin category %q", plugin.Category)
+	}
+
+	tomll, err := convertPluginToTOML(plugin.Category, plugin.Type, plugin.Data)
+	if err != nil {
+		return nil, fmt.Errorf("converting data to TOML: %w", err)
+	}
+
+	newConfig := config.NewConfig()
+	newConfig.Agent.MetricBatchSize = a.Config.Agent.MetricBatchSize
+	newConfig.Agent.MetricBufferLimit = a.Config.Agent.MetricBufferLimit
+	if err := newConfig.LoadConfigData(tomll); err != nil {
+		return nil, fmt.Errorf("loading config data: %w", err)
+	}
+
+	var names []string
+	switch plugin.Category {
+	case "outputs":
+		for _, ro := range newConfig.Outputs {
+			names = append(names, ro.Config.Name)
+			if err := ro.Init(); err != nil {
+				return nil, fmt.Errorf("could not initialize output %s: %w", ro.LogName(), err)
+			}
+		}
+	case "processors":
+		for _, rp := range newConfig.Processors {
+			names = append(names, rp.Config.Name)
+			if err := rp.Init(); err != nil {
+				return nil, fmt.Errorf("could not initialize processor %s: %w", rp.LogName(), err)
+			}
+		}
+		if !*a.Config.Agent.SkipProcessorsAfterAggregators {
+			for _, rp := range newConfig.AggProcessors {
+				if err := rp.Init(); err != nil {
+					return nil, fmt.Errorf("could not initialize processor %s: %w", rp.LogName(), err)
+				}
+			}
+		}
+	case "aggregators":
+		for _, ra := range newConfig.Aggregators {
+			names = append(names, ra.Config.Name)
+			if err := ra.Init(); err != nil {
+				return nil, fmt.Errorf("could not initialize aggregator %s: %w", ra.LogName(), err)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no %s %s defined", plugin.Category, plugin.Type)
+	}
+	for _, name := range names {
+		if name != plugin.Type {
+			return nil, fmt.Errorf("plugin %s does not match type %s", name, plugin.Type)
+		}
+	}
+
+	return newConfig, nil
+}
+
+// loadStoredPlugins builds all outputs, processors and aggregators stored in
+// MongoDB. They are added to the pipeline after the plugins of the config
+// files in the order they were created. Stored plugins that cannot be built
+// are logged and skipped so they do not keep the agent from starting.
+func (a *Server) loadStoredPlugins(ctx context.Context) error {
+	stored, err := a.PluginRepo.GetPlugins(ctx, "")
+	if err != nil {
+		return fmt.Errorf("loading stored plugins: %w", err)
+	}
+
+	a.plugins.Lock()
+	defer a.plugins.Unlock()
+
+	a.plugins.stored = make(map[string]*config.Config, len(stored))
+	a.plugins.order = make([]string, 0, len(stored))
+	for _, s := range stored {
+		id := s.ID.Hex()
+		cfg, err := a.buildPlugins(s)
+		if err != nil {
+			log.Printf("E! [agent] Skipping stored plugin %s (%s.%s): %v", id, s.Category, s.Type, err)
+			continue
+		}
+		a.plugins.stored[id] = cfg
+		a.plugins.order = append(a.plugins.order, id)
+	}
+	return nil
+}
+
+// pipelineOutputs returns the outputs of the config files followed by the
+// stored outputs.
+func (a *Server) pipelineOutputs() []*models.RunningOutput {
+	a.plugins.Lock()
+	defer a.plugins.Unlock()
+
+	outputs := append(make([]*models.RunningOutput, 0, len(a.Config.Outputs)), a.Config.Outputs...)
+	for _, id := range a.plugins.order {
+		outputs = append(outputs, a.plugins.stored[id].Outputs...)
+	}
+	return outputs
+}
+
+// processingPlugins returns the processors and aggregators of the config
+// files followed by the stored ones. Like processors in the config files,
+// the processors are sorted by their "order" setting, keeping the order
+// they were added in otherwise. The caller must hold the plugins lock.
+func (a *Server) processingPlugins() (models.RunningProcessors, models.RunningProcessors, []*models.RunningAggregator) {
+	procs := append(models.RunningProcessors{}, a.Config.Processors...)
+	aggProcs := append(models.RunningProcessors{}, a.Config.AggProcessors...)
+	aggs := append([]*models.RunningAggregator{}, a.Config.Aggregators...)
+	for _, id := range a.plugins.order {
+		cfg := a.plugins.stored[id]
+		procs = append(procs, cfg.Processors...)
+		aggProcs = append(aggProcs, cfg.AggProcessors...)
+		aggs = append(aggs, cfg.Aggregators...)
+	}
+	sort.Stable(procs)
+	sort.Stable(aggProcs)
+	return procs, aggProcs, aggs
+}
+
+// startProcessing starts a processing unit passing the metrics through all
+// processors and aggregators to dst.
+func (a *Server) startProcessing(
+	dst chan<- Dana.Metric,
+	startTime time.Time,
+	procs, aggProcs models.RunningProcessors,
+	aggs []*models.RunningAggregator,
+) (*processingUnit, error) {
+	// The last stage of the chain closes its destination once done, so the
+	// chain writes to its own channel instead of the outputs' channel.
+	out := make(chan Dana.Metric, 100)
+	next := chan<- Dana.Metric(out)
+
+	var err error
+	var apu []*processorUnit
+	var au *aggregatorUnit
+	if len(aggs) != 0 {
+		aggC := next
+		if len(aggProcs) != 0 && !*a.Config.Agent.SkipProcessorsAfterAggregators {
+			aggC, apu, err = a.startProcessors(next, aggProcs)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		next, au = a.startAggregators(aggC, next, aggs)
+	}
+
+	var pu []*processorUnit
+	if len(procs) != 0 {
+		next, pu, err = a.startProcessors(next, procs)
+		if err != nil {
+			for _, u := range apu {
+				u.processor.Stop()
+			}
+			return nil, err
+		}
+	}
+
+	unit := &processingUnit{
+		src:  next,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(unit.done)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for metric := range out {
+				dst <- metric
+			}
+		}()
+
+		if au != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				a.runProcessors(apu)
+			}()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				a.runAggregators(startTime, au)
+			}()
+		}
+
+		if pu != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				a.runProcessors(pu)
+			}()
+		}
+
+		wg.Wait()
+	}()
+
+	return unit, nil
+}
+
+// attachProcessing starts the processing unit of the pipeline writing to
+// the outputs of the given unit.
+func (a *Server) attachProcessing(unit *outputUnit, dst chan<- Dana.Metric, startTime time.Time) error {
+	a.plugins.Lock()
+	defer a.plugins.Unlock()
+
+	procs, aggProcs, aggs := a.processingPlugins()
+	pu, err := a.startProcessing(dst, startTime, procs, aggProcs, aggs)
+	if err != nil {
+		return err
+	}
+
+	a.plugins.outputs = unit
+	a.plugins.relay.Lock()
+	a.plugins.relay.dst = dst
+	a.plugins.relay.unit = pu
+	a.plugins.relay.Unlock()
+	return nil
+}
+
+// relayMetrics passes the metrics of the inputs to the current processing
+// unit until src is closed. Afterward the processing unit is drained and the
+// outputs' channel is closed.
+func (a *Server) relayMetrics(src <-chan Dana.Metric) {
+	relay := &a.plugins.relay
+	for metric := range src {
+		relay.Lock()
+		relay.unit.src <- metric
+		relay.Unlock()
+	}
+
+	relay.Lock()
+	defer relay.Unlock()
+
+	relay.closed = true
+	close(relay.unit.src)
+	<-relay.unit.done
+	close(relay.dst)
+	log.Printf("D! [agent] Processing channel closed")
+}
+
+// swapProcessing drains the current processing unit and replaces it with one
+// running the current set of processors and aggregators. If the new unit
+// cannot be started metrics are passed to the outputs unprocessed. The caller
+// must hold the plugins lock.
+func (a *Server) swapProcessing() error {
+	relay := &a.plugins.relay
+	relay.Lock()
+	defer relay.Unlock()
+
+	if relay.unit == nil || relay.closed {
+		return errPipelineNotRunning
+	}
+
+	// The same processor and aggregator instances are reused by the new
+	// unit, so the old one must be done before the new one starts.
+	close(relay.unit.src)
+	<-relay.unit.done
+
+	procs, aggProcs, aggs := a.processingPlugins()
+	unit, err := a.startProcessing(relay.dst, time.Now(), procs, aggProcs, aggs)
+	if err != nil {
+		log.Printf("E! [agent] Starting processors and aggregators failed, passing metrics on unprocessed: %v", err)
+		unit, _ = a.startProcessing(relay.dst, time.Now(), nil, nil, nil)
+		relay.unit = unit
+		return err
+	}
+	relay.unit = unit
+
+	log.Printf("I! [agent] Restarted %d processor(s) and %d aggregator(s)", len(procs), len(aggs))
+	return nil
+}
+
+// applyPlugins adds the plugins to the running pipeline under the given id,
+// replacing any plugins previously added under the same id.
+func (a *Server) applyPlugins(id string, cfg *config.Config) error {
+	a.plugins.Lock()
+	defer a.plugins.Unlock()
+
+	if a.plugins.outputs == nil {
+		return errPipelineNotRunning
+	}
+
+	previous, found := a.plugins.stored[id]
+	if len(cfg.Outputs) > 0 {
+		if err := a.addOutputs(cfg.Outputs); err != nil {
+			return err
+		}
+		a.setStoredPlugins(id, cfg)
+		if found {
+			a.removeOutputs(previous.Outputs)
+		}
+		return nil
+	}
+
+	a.setStoredPlugins(id, cfg)
+	if err := a.swapProcessing(); err != nil {
+		// Go back to the plugins running before
+		if found {
+			a.setStoredPlugins(id, previous)
+		} else {
+			a.deleteStoredPlugins(id)
+		}
+		if err := a.swapProcessing(); err != nil {
+			log.Printf("E! [agent] Restoring processors and aggregators failed: %v", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// removePlugins removes the plugins added under the given id from the
+// running pipeline. It returns false if there were none.
+func (a *Server) removePlugins(id string) bool {
+	a.plugins.Lock()
+	defer a.plugins.Unlock()
+
+	cfg, found := a.plugins.stored[id]
+	if !found {
+		return false
+	}
+	a.deleteStoredPlugins(id)
+
+	if len(cfg.Outputs) > 0 {
+		a.removeOutputs(cfg.Outputs)
+	}
+	if len(cfg.Processors) > 0 || len(cfg.Aggregators) > 0 {
+		if err := a.swapProcessing(); err != nil && !errors.Is(err, errPipelineNotRunning) {
+			log.Printf("E! [agent] Removing processors and aggregators of %s failed: %v", id, err)
+		}
+	}
+
+	log.Printf("I! [agent] Removed plugins of %s", id)
+	return true
+}
+
+// setStoredPlugins records the plugins under the given id, keeping the
+// position of plugins previously recorded under it.
+func (a *Server) setStoredPlugins(id string, cfg *config.Config) {
+	if a.plugins.stored == nil {
+		a.plugins.stored = make(map[string]*config.Config)
+	}
+	if _, found := a.plugins.stored[id]; !found {
+		a.plugins.order = append(a.plugins.order, id)
+	}
+	a.plugins.stored[id] = cfg
+}
+
+// deleteStoredPlugins forgets the plugins recorded under the given id.
+func (a *Server) deleteStoredPlugins(id string) {
+	delete(a.plugins.stored, id)
+	for i, e := range a.plugins.order {
+		if e == id {
+			a.plugins.order = append(a.plugins.order[:i], a.plugins.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// addOutputs connects the outputs and starts writing metrics to them.
+func (a *Server) addOutputs(outputs []*models.RunningOutput) error {
+	for i, output := range outputs {
+		if err := output.Connect(); err != nil {
+			stopRunningOutputs(outputs[:i])
+			return fmt.Errorf("connecting output %s: %w", output.LogName(), err)
+		}
+	}
+
+	unit := a.plugins.outputs
+	unit.Lock()
+	defer unit.Unlock()
+
+	if unit.closed {
+		stopRunningOutputs(outputs)
+		return errPipelineNotRunning
+	}
+	unit.outputs = append(unit.outputs, outputs...)
+	for _, output := range outputs {
+		a.startFlushLoop(unit, output)
+	}
+
+	log.Printf("I! [agent] Started %d output(s)", len(outputs))
+	return nil
+}
+
+// removeOutputs stops writing metrics to the outputs, flushes them one last
+// time and closes them.
+func (a *Server) removeOutputs(outputs []*models.RunningOutput) {
+	unit := a.plugins.outputs
+	unit.Lock()
+	if unit.closed {
+		// The outputs are closed when the pipeline shuts down
+		unit.Unlock()
+		return
+	}
+
+	remove := make(map[*models.RunningOutput]bool, len(outputs))
+	for _, output := range outputs {
+		remove[output] = true
+	}
+	// Outputs shut down while connecting are not part of the unit anymore
+	kept := make([]*models.RunningOutput, 0, len(unit.outputs))
+	removed := make([]*models.RunningOutput, 0, len(outputs))
+	for _, output := range unit.outputs {
+		if remove[output] {
+			removed = append(removed, output)
+		} else {
+			kept = append(kept, output)
+		}
+	}
+	unit.outputs = kept
+
+	flushers := make([]*outputFlusher, 0, len(removed))
+	for _, output := range removed {
+		if flusher, found := unit.flushers[output]; found {
+			flushers = append(flushers, flusher)
+			delete(unit.flushers, output)
+		}
+	}
+	unit.Unlock()
+
+	for _, flusher := range flushers {
+		flusher.cancel()
+		<-flusher.done
+	}
+	stopRunningOutputs(removed)
+
+	log.Printf("I! [agent] Stopped %d output(s)", len(removed))
+}
@@ -0,0 +1,219 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"Dana"
+	"Dana/agent/model"
+	"Dana/agent/repository"
+	"Dana/config"
+	"Dana/metric"
+	"Dana/plugins/outputs"
+	"Dana/plugins/processors"
+)
+
+// tagProcessor adds a tag named after its value to every metric.
+type tagProcessor struct {
+	Value    string `toml:"value"`
+	FailInit bool   `toml:"fail_init"`
+}
+
+func (*tagProcessor) SampleConfig() string { return "" }
+
+func (p *tagProcessor) Init() error {
+	if p.FailInit {
+		return errors.New("init failed")
+	}
+	return nil
+}
+
+func (p *tagProcessor) Apply(in ...Dana.Metric) []Dana.Metric {
+	for _, m := range in {
+		m.AddTag(p.Value, "true")
+	}
+	return in
+}
+
+// unreachableOutput is an output that never connects.
+type unreachableOutput struct{}
+
+func (*unreachableOutput) SampleConfig() string      { return "" }
+func (*unreachableOutput) Connect() error            { return errors.New("connection refused") }
+func (*unreachableOutput) Close() error              { return nil }
+func (*unreachableOutput) Write([]Dana.Metric) error { return nil }
+
+func init() {
+	processors.Add("agent_test", func() Dana.Processor { return &tagProcessor{} })
+	outputs.Add("agent_test", func() Dana.Output { return &unreachableOutput{} })
+}
+
+// newProcessingServer returns a server running only the processing part of
+// the pipeline. Metrics written to the returned source channel come out of
+// the returned destination channel.
+func newProcessingServer(t *testing.T) (*Server, chan<- Dana.Metric, <-chan Dana.Metric) {
+	skip := false
+	a := &Server{Config: config.NewConfig()}
+	a.Config.Agent.SkipProcessorsAfterAggregators = &skip
+
+	src := make(chan Dana.Metric)
+	dst := make(chan Dana.Metric, 100)
+	require.NoError(t, a.attachProcessing(&outputUnit{}, dst, time.Now()))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.relayMetrics(src)
+	}()
+	t.Cleanup(func() {
+		close(src)
+		<-done
+	})
+	return a, src, dst
+}
+
+// processedTags passes a metric through the pipeline and returns its tags.
+func processedTags(t *testing.T, src chan<- Dana.Metric, dst <-chan Dana.Metric) map[string]string {
+	t.Helper()
+	src <- metric.New("cpu", map[string]string{}, map[string]interface{}{"value": 1}, time.Now())
+	select {
+	case m := <-dst:
+		return m.Tags()
+	case <-time.After(5 * time.Second):
+		t.Fatal("metric did not pass the pipeline")
+	}
+	return nil
+}
+
+// testPlugin returns a plugin of the test plugins with the given settings.
+func testPlugin(category string, data map[string]interface{}) *model.HandlerPlugin {
+	return &model.HandlerPlugin{ID: primitive.NewObjectID(), Category: category, Type: "agent_test", Data: data}
+}
+
+// storedPluginRepo serves stored plugins and records updates, failing them
+// with err.
+type storedPluginRepo struct {
+	repository.HandlerPluginRepo
+	stored  []*model.HandlerPlugin
+	updates int
+	err     error
+}
+
+func (r *storedPluginRepo) GetPlugins(context.Context, string) ([]*model.HandlerPlugin, error) {
+	return r.stored, nil
+}
+
+func (r *storedPluginRepo) GetPlugin(_ context.Context, id string) (*model.HandlerPlugin, error) {
+	for _, p := range r.stored {
+		if p.ID.Hex() == id {
+			return p, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (r *storedPluginRepo) UpdatePlugin(context.Context, string, *model.HandlerPlugin) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.updates++
+	return nil
+}
+
+func TestManagedPluginsLifecycle(t *testing.T) {
+	a, src, dst := newProcessingServer(t)
+	require.Empty(t, processedTags(t, src, dst))
+
+	build := func(value string) *config.Config {
+		cfg, err := a.buildPlugins(testPlugin("processors", map[string]interface{}{"value": value}))
+		require.NoError(t, err)
+		return cfg
+	}
+
+	require.NoError(t, a.applyPlugins("a", build("first")))
+	require.Equal(t, map[string]string{"first": "true"}, processedTags(t, src, dst))
+	require.NoError(t, a.applyPlugins("b", build("second")))
+	require.Equal(t, map[string]string{"first": "true", "second": "true"}, processedTags(t, src, dst))
+
+	// Replacing keeps the position of the plugins in the chain
+	require.NoError(t, a.applyPlugins("a", build("replaced")))
+	require.Equal(t, []string{"a", "b"}, a.plugins.order)
+	require.Equal(t, map[string]string{"replaced": "true", "second": "true"}, processedTags(t, src, dst))
+
+	require.True(t, a.removePlugins("a"))
+	require.False(t, a.removePlugins("a"))
+	require.Equal(t, map[string]string{"second": "true"}, processedTags(t, src, dst))
+}
+
+func TestLoadStoredPluginsSkipsBadEntries(t *testing.T) {
+	a := &Server{Config: config.NewConfig()}
+	skip := false
+	a.Config.Agent.SkipProcessorsAfterAggregators = &skip
+
+	good := testPlugin("processors", map[string]interface{}{"value": "good"})
+	failing := testPlugin("processors", map[string]interface{}{"value": "failing", "fail_init": true})
+	unknown := &model.HandlerPlugin{ID: primitive.NewObjectID(), Category: "processors", Type: "does_not_exist"}
+	category := testPlugin("inputs", map[string]interface{}{"value": "input"})
+	a.PluginRepo = &storedPluginRepo{stored: []*model.HandlerPlugin{failing, good, unknown, category}}
+
+	require.NoError(t, a.loadStoredPlugins(context.Background()))
+	require.Equal(t, []string{good.ID.Hex()}, a.plugins.order)
+	require.Len(t, a.plugins.stored[good.ID.Hex()].Processors, 1)
+}
+
+func TestUpdatePlugin(t *testing.T) {
+	a, src, dst := newProcessingServer(t)
+	stored := testPlugin("processors", map[string]interface{}{"value": "stored"})
+	output := testPlugin("outputs", map[string]interface{}{})
+	repo := &storedPluginRepo{stored: []*model.HandlerPlugin{stored, output}}
+	a.PluginRepo = repo
+
+	cfg, err := a.buildPlugins(stored)
+	require.NoError(t, err)
+	require.NoError(t, a.applyPlugins(stored.ID.Hex(), cfg))
+
+	update := func(plugin *model.HandlerPlugin, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		ctx := echo.New().NewContext(req, rec)
+		ctx.SetParamNames("category", "type", "id")
+		ctx.SetParamValues(plugin.Category, plugin.Type, plugin.ID.Hex())
+		require.NoError(t, a.UpdatePlugin(ctx))
+		return rec
+	}
+
+	// Configurations that cannot be built or started are not stored
+	rec := update(stored, `{"data":{"value":"broken","fail_init":true}}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = update(output, `{"data":{}}`)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Contains(t, rec.Body.String(), "connection refused")
+	require.Zero(t, repo.updates)
+	require.Equal(t, map[string]string{"stored": "true"}, processedTags(t, src, dst))
+
+	// The stored configuration runs again if storing the new one fails
+	repo.err = errors.New("database is down")
+	rec = update(stored, `{"data":{"value":"updated"}}`)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Equal(t, map[string]string{"stored": "true"}, processedTags(t, src, dst))
+
+	repo.err = nil
+	rec = update(stored, `{"data":{"value":"updated"}}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 1, repo.updates)
+	require.Equal(t, map[string]string{"updated": "true"}, processedTags(t, src, dst))
+
+	// The category and type of a plugin cannot change
+	rec = update(&model.HandlerPlugin{ID: stored.ID, Category: "aggregators", Type: "agent_test"}, `{"data":{}}`)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,137 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Types of alert rules. Threshold rules compare the latest value of a field,
+// rate rules its change per second and deadman rules the time since a group
+// last sent the field.
+const (
+	AlertThreshold = "threshold"
+	AlertRate      = "rate"
+	AlertDeadman   = "deadman"
+)
+
+// Alert levels, named like the levels of InfluxDB checks.
+const (
+	AlertOK   = "ok"
+	AlertWarn = "warn"
+	AlertCrit = "crit"
+)
+
+// AlertRule is evaluated by the agent on the metrics passing through it.
+// Metrics of the measurement matching Tags are grouped by the tags in
+// GroupBy, and each group has its own level. Changes of a group's level are
+// sent to the Channels and written as metrics. Counter marks the field of a
+// rate rule as a counter, so a decreasing value is taken as a reset of the
+// counter rather than a negative rate.
+type AlertRule struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Type        string             `json:"type" bson:"type"`
+	Measurement string             `json:"measurement" bson:"measurement"`
+	Field       string             `json:"field" bson:"field"`
+	Counter     bool               `json:"counter,omitempty" bson:"counter,omitempty"`
+	Tags        map[string]string  `json:"tags,omitempty" bson:"tags,omitempty"`
+	GroupBy     []string           `json:"group_by,omitempty" bson:"group_by,omitempty"`
+	Warn        *AlertCondition    `json:"warn,omitempty" bson:"warn,omitempty"`
+	Crit        *AlertCondition    `json:"crit,omitempty" bson:"crit,omitempty"`
+	Channels    []string           `json:"channels,omitempty" bson:"channels,omitempty"`
+	Disabled    bool               `json:"disabled,omitempty" bson:"disabled,omitempty"`
+	Author      string             `json:"author,omitempty" bson:"author,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// AlertCondition is the condition of a level. Threshold and rate rules
+// compare the value with Op and Value, deadman rules trigger once a group
+// sent nothing for After, e.g. "5m".
+type AlertCondition struct {
+	Op    string  `json:"op,omitempty" bson:"op,omitempty"`
+	Value float64 `json:"value" bson:"value"`
+	After string  `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// alertOps are the comparisons supported by AlertCondition.
+var alertOps = map[string]bool{">": true, ">=": true, "<": true, "<=": true, "==": true, "!=": true}
+
+// Match reports whether the value meets the condition of a threshold or
+// rate rule.
+func (c *AlertCondition) Match(value float64) bool {
+	switch c.Op {
+	case ">":
+		return value > c.Value
+	case ">=":
+		return value >= c.Value
+	case "<":
+		return value < c.Value
+	case "<=":
+		return value <= c.Value
+	case "==":
+		return value == c.Value
+	case "!=":
+		return value != c.Value
+	}
+	return false
+}
+
+// Validate checks that the rule can be evaluated.
+func (r *AlertRule) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.Measurement == "" {
+		return errors.New("measurement is required")
+	}
+	if r.Field == "" {
+		return errors.New("field is required")
+	}
+	if r.Warn == nil && r.Crit == nil {
+		return errors.New("warn or crit is required")
+	}
+	if r.Counter && r.Type != AlertRate {
+		return errors.New("counter is only used by rate rules")
+	}
+	switch r.Type {
+	case AlertThreshold, AlertRate:
+		for level, c := range map[string]*AlertCondition{AlertWarn: r.Warn, AlertCrit: r.Crit} {
+			if c == nil {
+				continue
+			}
+			if !alertOps[c.Op] {
+				return fmt.Errorf("%s: unknown op %q", level, c.Op)
+			}
+			if c.After != "" {
+				return fmt.Errorf("%s: after is only used by deadman rules", level)
+			}
+		}
+	case AlertDeadman:
+		for level, c := range map[string]*AlertCondition{AlertWarn: r.Warn, AlertCrit: r.Crit} {
+			if c == nil {
+				continue
+			}
+			if d, err := time.ParseDuration(c.After); err != nil || d <= 0 {
+				return fmt.Errorf("%s: after must be a positive duration", level)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown type %q", r.Type)
+	}
+	return nil
+}
+
+// AlertState is the level of a group of an alert rule.
+type AlertState struct {
+	RuleID   primitive.ObjectID `json:"rule_id"`
+	RuleName string             `json:"rule_name"`
+	Tags     map[string]string  `json:"tags,omitempty"`
+	Level    string             `json:"level"`
+	Value    float64            `json:"value"`
+	Since    time.Time          `json:"since"`
+	LastSeen time.Time          `json:"last_seen"`
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Annotation marks an event such as a deploy, a maintenance or an alert on
+// dashboards. Annotations without an end time mark a single point in time.
+// Annotations without a dashboard apply to every dashboard; those with a
+// panel index only apply to that panel of the dashboard.
+type Annotation struct {
+	ID          primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	Time        time.Time           `json:"time" bson:"time"`
+	TimeEnd     *time.Time          `json:"time_end,omitempty" bson:"time_end,omitempty"`
+	Text        string              `json:"text" bson:"text"`
+	Tags        []string            `json:"tags,omitempty" bson:"tags,omitempty"`
+	DashboardID *primitive.ObjectID `json:"dashboard_id,omitempty" bson:"dashboard_id,omitempty"`
+	PanelIndex  *int                `json:"panel_index,omitempty" bson:"panel_index,omitempty"`
+	Author      string              `json:"author,omitempty" bson:"author,omitempty"`
+	CreatedAt   time.Time           `json:"created_at" bson:"created_at"`
+}
+
+// AnnotationFilter selects the annotations overlapping a time window. Only
+// annotations carrying all tags match. Filtering by dashboard also returns
+// the annotations applying to every dashboard, filtering by panel those
+// applying to the whole dashboard. Zero values match every annotation.
+type AnnotationFilter struct {
+	From        time.Time
+	To          time.Time
+	DashboardID *primitive.ObjectID
+	PanelIndex  *int
+	// Hidden are dashboards whose annotations are left out
+	Hidden []primitive.ObjectID
+}
+
+// Validate checks the annotation before it is stored.
+func (a *Annotation) Validate() error {
+	if a.Time.IsZero() {
+		return errors.New("time is required")
+	}
+	if a.Text == "" {
+		return errors.New("text is required")
+	}
+	if a.TimeEnd != nil && a.TimeEnd.Before(a.Time) {
+		return errors.New("time_end: must not be before time")
+	}
+	if a.PanelIndex != nil && a.DashboardID == nil {
+		return errors.New("panel_index: requires dashboard_id")
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TokenScope limits what an API token may change. Every scope may read.
+type TokenScope string
+
+const (
+	ScopeReadOnly   TokenScope = "read-only"
+	ScopeInputs     TokenScope = "inputs"
+	ScopeDashboards TokenScope = "dashboards"
+)
+
+// Valid reports whether s is one of the known scopes.
+func (s TokenScope) Valid() bool {
+	switch s {
+	case ScopeReadOnly, ScopeInputs, ScopeDashboards:
+		return true
+	}
+	return false
+}
+
+// APIToken is a long-lived token for automation. Only the SHA-256 hash of
+// the token is stored; the token itself is shown once on creation.
+type APIToken struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Username   string             `json:"username" bson:"username"`
+	Scope      TokenScope         `json:"scope" bson:"scope"`
+	Hash       string             `json:"-" bson:"hash"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	ExpiresAt  time.Time          `json:"expires_at" bson:"expires_at"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEntry records a call to a mutating API route. Body is a summary of
+// the request body with secrets such as passwords and tokens redacted.
+type AuditEntry struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Time       time.Time          `json:"time" bson:"time"`
+	Username   string             `json:"username,omitempty" bson:"username,omitempty"`
+	Role       Role               `json:"role,omitempty" bson:"role,omitempty"`
+	APITokenID string             `json:"api_token_id,omitempty" bson:"api_token_id,omitempty"`
+	Method     string             `json:"method" bson:"method"`
+	Route      string             `json:"route" bson:"route"`
+	Path       string             `json:"path" bson:"path"`
+	TargetID   string             `json:"target_id,omitempty" bson:"target_id,omitempty"`
+	Params     map[string]string  `json:"params,omitempty" bson:"params,omitempty"`
+	Body       interface{}        `json:"body,omitempty" bson:"body,omitempty"`
+	Status     int                `json:"status" bson:"status"`
+	ClientIP   string             `json:"client_ip" bson:"client_ip"`
+	DurationMS int64              `json:"duration_ms" bson:"duration_ms"`
+}
+
+// AuditFilter selects audit entries by time range, user, method and route.
+// Zero values match every entry.
+type AuditFilter struct {
+	From     time.Time
+	To       time.Time
+	Username string
+	Method   string
+	Route    string
+}
@@ -7,6 +7,17 @@ type Dashboard struct {
 	Name      string             `json:"name" bson:"name"`
 	Panels    []Panel            `json:"panels" bson:"panels"`
 	Variables []Variable         `json:"variables" bson:"variables"`
+	Tags      []string           `json:"tags,omitempty" bson:"tags,omitempty"`
+	// Version is the number of the dashboard's latest revision. Dashboards
+	// created before revisions were kept have version 0.
+	Version int `json:"version" bson:"version"`
+}
+
+// DashboardUpdate is the payload of a dashboard update. Message describes
+// the change in the dashboard's revision history.
+type DashboardUpdate struct {
+	Dashboard
+	Message string `json:"message"`
 }
 
 type Panel struct {
@@ -14,9 +25,37 @@ type Panel struct {
 	Query  []string `json:"query" bson:"query"`
 	Index  int      `json:"index" bson:"index"`
 	Colors []string `json:"color" bson:"color"`
+	// Type is the visualization of the panel, panels without a type are
+	// shown as time series.
+	Type       string          `json:"type,omitempty" bson:"type,omitempty"`
+	GridPos    *GridPos        `json:"grid_pos,omitempty" bson:"grid_pos,omitempty"`
+	Unit       string          `json:"unit,omitempty" bson:"unit,omitempty"`
+	Decimals   *int            `json:"decimals,omitempty" bson:"decimals,omitempty"`
+	Thresholds *Thresholds     `json:"thresholds,omitempty" bson:"thresholds,omitempty"`
+	Legend     *Legend         `json:"legend,omitempty" bson:"legend,omitempty"`
+	Overrides  []FieldOverride `json:"overrides,omitempty" bson:"overrides,omitempty"`
 }
 
+// Variable types. Query variables take their values from a query, custom
+// and interval variables from a comma separated list in Query and constant
+// variables have Query as their only value.
+const (
+	VariableTypeQuery    = "query"
+	VariableTypeCustom   = "custom"
+	VariableTypeConstant = "constant"
+	VariableTypeInterval = "interval"
+)
+
 type Variable struct {
 	Name  string `json:"name" bson:"name"`
 	Query string `json:"query" bson:"query"`
+	// Type is one of the variable types, variables without a type are query
+	// variables.
+	Type string `json:"type,omitempty" bson:"type,omitempty"`
+	// Multi allows selecting more than one value.
+	Multi bool `json:"multi,omitempty" bson:"multi,omitempty"`
+	// IncludeAll adds an "all" option selecting every value. If AllValue is
+	// set it is used in queries instead of the list of values, e.g. ".*".
+	IncludeAll bool   `json:"include_all,omitempty" bson:"include_all,omitempty"`
+	AllValue   string `json:"all_value,omitempty" bson:"all_value,omitempty"`
 }
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DashboardData holds the results of all panel queries of a dashboard for a
+// time range. Panels is keyed by panel index, or by the position of the panel
+// in the dashboard for dashboards that repeat an index.
+type DashboardData struct {
+	From   time.Time                `json:"from"`
+	To     time.Time                `json:"to"`
+	Panels map[int][]PanelQueryData `json:"panels"`
+	Errors int                      `json:"errors"`
+}
+
+// PanelQueryData is the result of a single panel query. Query is the query
+// after variables were substituted. Result holds the InfluxDB response as is,
+// or Error is set if the query failed or timed out.
+type PanelQueryData struct {
+	Query      string          `json:"query"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+}
+
+// VariableValues are the possible and selected values of a dashboard
+// variable. All is set if the "all" option is selected.
+type VariableValues struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Query   string   `json:"query,omitempty"`
+	Options []string `json:"options"`
+	Current []string `json:"current"`
+	All     bool     `json:"all,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
@@ -0,0 +1,32 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// DashboardRevision is an immutable copy of a dashboard as it was after a
+// change.
+type DashboardRevision struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	DashboardID primitive.ObjectID `json:"dashboard_id" bson:"dashboard_id"`
+	Version     int                `json:"version" bson:"version"`
+	Author      string             `json:"author" bson:"author"`
+	Message     string             `json:"message,omitempty" bson:"message"`
+	CreatedAt   primitive.DateTime `json:"created_at" bson:"created_at"`
+	Dashboard   Dashboard          `json:"dashboard" bson:"dashboard"`
+}
+
+// DashboardDiff lists the changes between two revisions of a dashboard.
+type DashboardDiff struct {
+	From    int               `json:"from"`
+	To      int               `json:"to"`
+	Changes []DashboardChange `json:"changes"`
+}
+
+// DashboardChange is a single difference between two revisions. Path points
+// to the changed value, e.g. "panels[2].query[0]", and Type is one of
+// "added", "removed" or "changed".
+type DashboardChange struct {
+	Path string      `json:"path"`
+	Type string      `json:"type"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// DryRunResult is the outcome of testing a plugin configuration without
+// saving or starting it.
+type DryRunResult struct {
+	TOML         string         `json:"toml"`
+	Metrics      []DryRunMetric `json:"metrics"`
+	UnusedFields []string       `json:"unused_fields,omitempty"`
+	Errors       []DryRunError  `json:"errors,omitempty"`
+}
+
+// DryRunMetric is a metric collected during a dry run.
+type DryRunMetric struct {
+	Plugin string                 `json:"plugin"`
+	Name   string                 `json:"name"`
+	Tags   map[string]string      `json:"tags"`
+	Fields map[string]interface{} `json:"fields"`
+	Time   time.Time              `json:"time"`
+}
+
+// DryRunError is an error raised during a dry run. Stage is one of
+// "convert", "config", "init", "start" or "gather".
+type DryRunError struct {
+	Stage   string `json:"stage"`
+	Plugin  string `json:"plugin,omitempty"`
+	Message string `json:"message"`
+}
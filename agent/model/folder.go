@@ -2,7 +2,32 @@ package model
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// Folder groups dashboards and other folders. A dashboard is in at most one
+// folder; folders without a parent are at the top level.
 type Folder struct {
-	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Dashboards []Dashboard        `json:"dashboards" bson:"dashboards"`
+	ID           primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Name         string               `json:"name" bson:"name"`
+	ParentID     *primitive.ObjectID  `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	DashboardIDs []primitive.ObjectID `json:"dashboard_ids" bson:"dashboard_ids"`
+	// Permissions restrict access to the folder, its subfolders and their
+	// dashboards to the listed users. Without permissions the folder
+	// inherits those of its parent.
+	Permissions []FolderPermission `json:"permissions,omitempty" bson:"permissions,omitempty"`
+
+	// LegacyDashboards holds the dashboard copies embedded in folders before
+	// folders referenced dashboards. They are only read for the migration.
+	LegacyDashboards []Dashboard `json:"-" bson:"dashboards,omitempty"`
+}
+
+// FolderPermission grants a user a role on a folder. The role is capped by
+// the user's own role, so it can restrict but never extend their access.
+type FolderPermission struct {
+	Username string `json:"username" bson:"username"`
+	Role     Role   `json:"role" bson:"role"`
+}
+
+// FolderMove is the payload moving a folder or dashboard. A nil FolderID
+// moves it to the top level.
+type FolderMove struct {
+	FolderID *primitive.ObjectID `json:"folder_id"`
 }
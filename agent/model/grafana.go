@@ -0,0 +1,22 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// GrafanaImportResult is returned when importing a Grafana dashboard. Parts
+// of the Grafana dashboard that have no equivalent in a Dashboard are listed
+// in Unsupported.
+type GrafanaImportResult struct {
+	ID          primitive.ObjectID   `json:"id"`
+	Panels      int                  `json:"panels"`
+	Variables   int                  `json:"variables"`
+	Unsupported []GrafanaUnsupported `json:"unsupported,omitempty"`
+}
+
+// GrafanaUnsupported describes a Grafana panel, target or templating variable
+// that was not imported. Kind is one of "panel", "target" or "variable".
+type GrafanaUnsupported struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Type   string `json:"type,omitempty"`
+	Reason string `json:"reason"`
+}
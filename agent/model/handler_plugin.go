@@ -0,0 +1,13 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// HandlerPlugin is an output, processor or aggregator managed through the
+// API. Data holds the plugin settings as they would appear in its TOML table.
+type HandlerPlugin struct {
+	ID       primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	Category string                 `json:"category" bson:"category"`
+	Name     string                 `json:"name" bson:"name"`
+	Type     string                 `json:"type" bson:"type"`
+	Data     map[string]interface{} `json:"data" bson:"data"`
+}
@@ -5,8 +5,41 @@ import "go.mongodb.org/mongo-driver/bson/primitive"
 type Notification struct {
 	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	ChannelName string             `json:"channel_name" bson:"channel_name"`
-	ChatID      int                `json:"chat_id" bson:"chat_id"`
-	CheckName   string             `json:"_check_name" bson:"check_name"`
-	Level       string             `json:"_level" bson:"level"`
-	Message     string             `json:"_message" bson:"message"`
+	// Driver names the notifier delivering messages to the channel and
+	// Config holds its settings, e.g. the URL of a webhook. Channels stored
+	// before drivers existed only have a ChatID and use the Telegram or Bale
+	// bot picked by their name.
+	Driver string                 `json:"driver,omitempty" bson:"driver,omitempty"`
+	Config map[string]interface{} `json:"config,omitempty" bson:"config,omitempty"`
+	ChatID int                    `json:"chat_id,omitempty" bson:"chat_id,omitempty"`
+	// Template overrides the title and body templates the driver uses by
+	// default.
+	Template *NotificationTemplate `json:"template,omitempty" bson:"template,omitempty"`
+	// RateLimit caps the messages delivered to the channel per minute. Zero
+	// uses the default of the driver.
+	RateLimit int `json:"rate_limit,omitempty" bson:"rate_limit,omitempty"`
+
+	CheckName string `json:"_check_name" bson:"check_name"`
+	Level     string `json:"_level" bson:"level"`
+	Message   string `json:"_message" bson:"message"`
+
+	// Tags are the tags of the alerting series sent with a notification.
+	// InfluxDB sends them as top-level keys instead, which works as well.
+	Tags map[string]string `json:"tags,omitempty" bson:"-"`
+
+	// Annotate records a sent notification as an annotation tagged
+	// "notification" and its level, scoped to the dashboard and panel if
+	// given. These fields are never stored with the channel.
+	Annotate       bool                `json:"annotate,omitempty" bson:"-"`
+	AnnotationTags []string            `json:"annotation_tags,omitempty" bson:"-"`
+	DashboardID    *primitive.ObjectID `json:"dashboard_id,omitempty" bson:"-"`
+	PanelIndex     *int                `json:"panel_index,omitempty" bson:"-"`
+}
+
+// NotificationTemplate holds the Go templates rendering the title and body
+// of a channel's messages. The sprig functions are available, and md and
+// mdurl escape values for Markdown.
+type NotificationTemplate struct {
+	Title string `json:"title,omitempty" bson:"title,omitempty"`
+	Body  string `json:"body,omitempty" bson:"body,omitempty"`
 }
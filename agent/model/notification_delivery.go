@@ -0,0 +1,61 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Delivery statuses. Deliveries are pending until they are sent or failed
+// for good; sending deliveries are being worked on. Suppressed deliveries
+// matched a silence and are never sent.
+const (
+	DeliveryPending    = "pending"
+	DeliverySending    = "sending"
+	DeliveryDelivered  = "delivered"
+	DeliveryFailed     = "failed"
+	DeliverySuppressed = "suppressed"
+)
+
+// NotificationDelivery is a notification queued for a channel. The message
+// is rendered when it is queued, so later template changes do not affect
+// it. Every attempt to deliver it is kept in Attempts.
+type NotificationDelivery struct {
+	ID            primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	ChannelName   string                 `json:"channel_name" bson:"channel_name"`
+	Driver        string                 `json:"driver" bson:"driver"`
+	Title         string                 `json:"title" bson:"title"`
+	Body          string                 `json:"body" bson:"body"`
+	Format        string                 `json:"format" bson:"format"`
+	Alert         map[string]interface{} `json:"alert,omitempty" bson:"alert,omitempty"`
+	Status        string                 `json:"status" bson:"status"`
+	MaxAttempts   int                    `json:"max_attempts" bson:"max_attempts"`
+	NextAttemptAt time.Time              `json:"next_attempt_at" bson:"next_attempt_at"`
+	LockedUntil   *time.Time             `json:"-" bson:"locked_until,omitempty"`
+	LastError     string                 `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	SilenceID     *primitive.ObjectID    `json:"silence_id,omitempty" bson:"silence_id,omitempty"`
+	Reason        string                 `json:"reason,omitempty" bson:"reason,omitempty"`
+	Attempts      []DeliveryAttempt      `json:"attempts" bson:"attempts"`
+	Author        string                 `json:"author,omitempty" bson:"author,omitempty"`
+	CreatedAt     time.Time              `json:"created_at" bson:"created_at"`
+	DeliveredAt   *time.Time             `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+// DeliveryAttempt is the outcome of a single attempt to deliver a
+// notification. RetryAfterMS is set when the destination asked to wait.
+type DeliveryAttempt struct {
+	Time         time.Time `json:"time" bson:"time"`
+	DurationMS   int64     `json:"duration_ms" bson:"duration_ms"`
+	StatusCode   int       `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error        string    `json:"error,omitempty" bson:"error,omitempty"`
+	RetryAfterMS int64     `json:"retry_after_ms,omitempty" bson:"retry_after_ms,omitempty"`
+}
+
+// DeliveryFilter selects deliveries by channel, status and creation time.
+// Zero values match every delivery.
+type DeliveryFilter struct {
+	ChannelName string
+	Status      string
+	From        time.Time
+	To          time.Time
+}
@@ -0,0 +1,21 @@
+package model
+
+// ListOptions are the paging, sorting and search parameters accepted by the
+// list endpoints. Sort names a field, prefixed with "-" for descending
+// order. Cursor is the NextCursor of the previous page.
+type ListOptions struct {
+	Limit  int
+	Cursor string
+	Sort   string
+	Query  string
+	// Tags only lists items having all of the tags
+	Tags []string
+}
+
+// Page is a single page of a list endpoint. Total counts all items matching
+// the filters; NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
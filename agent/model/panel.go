@@ -0,0 +1,207 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Panel types
+const (
+	PanelTypeTimeseries = "timeseries"
+	PanelTypeGauge      = "gauge"
+	PanelTypeStat       = "stat"
+	PanelTypeTable      = "table"
+	PanelTypeHeatmap    = "heatmap"
+)
+
+// GridColumns is the width of the dashboard grid panels are placed on.
+const GridColumns = 24
+
+// GridPos places a panel on the dashboard grid. X and W are given in grid
+// columns, Y and H in grid rows.
+type GridPos struct {
+	X int `json:"x" bson:"x"`
+	Y int `json:"y" bson:"y"`
+	W int `json:"w" bson:"w"`
+	H int `json:"h" bson:"h"`
+}
+
+// Thresholds color values by the steps they reach. The first step is the
+// base and has no value; the values of the following steps must increase.
+// Mode is "absolute" or "percentage" of the value range.
+type Thresholds struct {
+	Mode  string          `json:"mode,omitempty" bson:"mode,omitempty"`
+	Steps []ThresholdStep `json:"steps" bson:"steps"`
+}
+
+type ThresholdStep struct {
+	Value *float64 `json:"value" bson:"value"`
+	Color string   `json:"color" bson:"color"`
+}
+
+// Legend configures the legend of time series and heatmap panels. Calcs are
+// the values computed per series and shown in the legend, e.g. "mean".
+type Legend struct {
+	Show        bool     `json:"show" bson:"show"`
+	Placement   string   `json:"placement,omitempty" bson:"placement,omitempty"`
+	DisplayMode string   `json:"display_mode,omitempty" bson:"display_mode,omitempty"`
+	Calcs       []string `json:"calcs,omitempty" bson:"calcs,omitempty"`
+}
+
+// FieldOverride changes how the series selected by Matcher are shown.
+type FieldOverride struct {
+	Matcher     FieldMatcher `json:"matcher" bson:"matcher"`
+	DisplayName string       `json:"display_name,omitempty" bson:"display_name,omitempty"`
+	Unit        string       `json:"unit,omitempty" bson:"unit,omitempty"`
+	Decimals    *int         `json:"decimals,omitempty" bson:"decimals,omitempty"`
+	Color       string       `json:"color,omitempty" bson:"color,omitempty"`
+}
+
+// FieldMatcher selects series by name ("by_name"), by a regular expression
+// on the name ("by_regexp") or by the query returning them ("by_query"),
+// where the value is the query letter, "A" for the first query.
+type FieldMatcher struct {
+	Type  string `json:"type" bson:"type"`
+	Value string `json:"value" bson:"value"`
+}
+
+var (
+	panelTypes = map[string]bool{
+		PanelTypeTimeseries: true,
+		PanelTypeGauge:      true,
+		PanelTypeStat:       true,
+		PanelTypeTable:      true,
+		PanelTypeHeatmap:    true,
+	}
+	thresholdModes   = map[string]bool{"": true, "absolute": true, "percentage": true}
+	legendPlacements = map[string]bool{"": true, "bottom": true, "right": true}
+	legendModes      = map[string]bool{"": true, "list": true, "table": true, "hidden": true}
+	legendCalcs      = map[string]bool{
+		"min": true, "max": true, "mean": true, "last": true, "lastNotNull": true,
+		"first": true, "firstNotNull": true, "sum": true, "count": true,
+	}
+	matcherTypes  = map[string]bool{"by_name": true, "by_regexp": true, "by_query": true}
+	variableTypes = map[string]bool{"": true, VariableTypeQuery: true, VariableTypeCustom: true, VariableTypeConstant: true, VariableTypeInterval: true}
+)
+
+// maxDecimals is the largest number of decimals a value can be shown with.
+const maxDecimals = 20
+
+// Validate checks the panels and variables of the dashboard. The first
+// problem found is returned with the path of the offending field.
+func (d *Dashboard) Validate() error {
+	indexes := make(map[int]bool, len(d.Panels))
+	for i, p := range d.Panels {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("panels[%d].%w", i, err)
+		}
+		if indexes[p.Index] {
+			return fmt.Errorf("panels[%d].index: duplicate index %d", i, p.Index)
+		}
+		indexes[p.Index] = true
+	}
+
+	names := make(map[string]bool, len(d.Variables))
+	for i, v := range d.Variables {
+		if v.Name == "" {
+			return fmt.Errorf("variables[%d].name: name is required", i)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("variables[%d].name: duplicate variable %q", i, v.Name)
+		}
+		names[v.Name] = true
+		if !variableTypes[v.Type] {
+			return fmt.Errorf("variables[%d].type: unknown type %q", i, v.Type)
+		}
+	}
+	return nil
+}
+
+// Validate checks the panel's settings. Errors start with the name of the
+// offending field.
+func (p *Panel) Validate() error {
+	if p.Type != "" && !panelTypes[p.Type] {
+		return fmt.Errorf("type: unknown type %q", p.Type)
+	}
+	if g := p.GridPos; g != nil {
+		switch {
+		case g.W < 1 || g.W > GridColumns:
+			return fmt.Errorf("grid_pos.w: must be between 1 and %d", GridColumns)
+		case g.X < 0 || g.X+g.W > GridColumns:
+			return fmt.Errorf("grid_pos.x: panel must fit into %d columns", GridColumns)
+		case g.Y < 0:
+			return errors.New("grid_pos.y: must not be negative")
+		case g.H < 1:
+			return errors.New("grid_pos.h: must be at least 1")
+		}
+	}
+	if p.Decimals != nil && (*p.Decimals < 0 || *p.Decimals > maxDecimals) {
+		return fmt.Errorf("decimals: must be between 0 and %d", maxDecimals)
+	}
+	if t := p.Thresholds; t != nil {
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("thresholds.%w", err)
+		}
+	}
+	if l := p.Legend; l != nil {
+		if !legendPlacements[l.Placement] {
+			return fmt.Errorf("legend.placement: unknown placement %q", l.Placement)
+		}
+		if !legendModes[l.DisplayMode] {
+			return fmt.Errorf("legend.display_mode: unknown mode %q", l.DisplayMode)
+		}
+		for _, c := range l.Calcs {
+			if !legendCalcs[c] {
+				return fmt.Errorf("legend.calcs: unknown calculation %q", c)
+			}
+		}
+	}
+	for i, o := range p.Overrides {
+		if err := o.validate(); err != nil {
+			return fmt.Errorf("overrides[%d].%w", i, err)
+		}
+	}
+	return nil
+}
+
+func (t *Thresholds) validate() error {
+	if !thresholdModes[t.Mode] {
+		return fmt.Errorf("mode: unknown mode %q", t.Mode)
+	}
+	for i, step := range t.Steps {
+		if step.Color == "" {
+			return fmt.Errorf("steps[%d].color: color is required", i)
+		}
+		switch {
+		case i == 0 && step.Value != nil:
+			return errors.New("steps[0].value: the base step has no value")
+		case i > 0 && step.Value == nil:
+			return fmt.Errorf("steps[%d].value: value is required", i)
+		case i > 1 && *step.Value <= *t.Steps[i-1].Value:
+			return fmt.Errorf("steps[%d].value: values must increase", i)
+		}
+	}
+	return nil
+}
+
+func (o *FieldOverride) validate() error {
+	if !matcherTypes[o.Matcher.Type] {
+		return fmt.Errorf("matcher.type: unknown type %q", o.Matcher.Type)
+	}
+	if o.Matcher.Value == "" {
+		return errors.New("matcher.value: value is required")
+	}
+	if o.Matcher.Type == "by_regexp" {
+		if _, err := regexp.Compile(o.Matcher.Value); err != nil {
+			return fmt.Errorf("matcher.value: %w", err)
+		}
+	}
+	if o.Decimals != nil && (*o.Decimals < 0 || *o.Decimals > maxDecimals) {
+		return fmt.Errorf("decimals: must be between 0 and %d", maxDecimals)
+	}
+	if o.DisplayName == "" && o.Unit == "" && o.Decimals == nil && o.Color == "" {
+		return errors.New("matcher: override must set display_name, unit, decimals or color")
+	}
+	return nil
+}
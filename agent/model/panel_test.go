@@ -0,0 +1,109 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDashboardValidate(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+	decimals := func(v int) *int { return &v }
+
+	valid := Dashboard{
+		Panels: []Panel{
+			{Name: "cpu", Index: 0, Type: PanelTypeTimeseries, GridPos: &GridPos{X: 0, Y: 0, W: 12, H: 8}},
+			{
+				Name:     "memory",
+				Index:    1,
+				Type:     PanelTypeGauge,
+				GridPos:  &GridPos{X: 12, Y: 0, W: 12, H: 8},
+				Decimals: decimals(2),
+				Thresholds: &Thresholds{Mode: "percentage", Steps: []ThresholdStep{
+					{Color: "green"},
+					{Value: value(80), Color: "orange"},
+					{Value: value(90), Color: "red"},
+				}},
+			},
+			{
+				Name:      "disk",
+				Index:     5,
+				Legend:    &Legend{Show: true, Placement: "right", DisplayMode: "table", Calcs: []string{"mean", "max"}},
+				Overrides: []FieldOverride{{Matcher: FieldMatcher{Type: "by_regexp", Value: "^disk.*"}, Unit: "bytes"}},
+			},
+		},
+		Variables: []Variable{
+			{Name: "host", Query: "SHOW TAG VALUES WITH KEY = host"},
+			{Name: "interval", Query: "1m,5m", Type: VariableTypeInterval},
+		},
+	}
+	require.NoError(t, valid.Validate())
+	require.NoError(t, (&Dashboard{}).Validate())
+
+	invalid := map[string]struct {
+		dashboard Dashboard
+		path      string
+	}{
+		"duplicate index": {
+			dashboard: Dashboard{Panels: []Panel{{Name: "a"}, {Name: "b", Index: 1}, {Name: "c"}}},
+			path:      "panels[2].index",
+		},
+		"unknown panel type": {
+			dashboard: Dashboard{Panels: []Panel{{Type: "pie"}}},
+			path:      "panels[0].type",
+		},
+		"panel wider than the grid": {
+			dashboard: Dashboard{Panels: []Panel{{GridPos: &GridPos{X: 20, W: 8, H: 1}}}},
+			path:      "panels[0].grid_pos.x",
+		},
+		"panel without height": {
+			dashboard: Dashboard{Panels: []Panel{{GridPos: &GridPos{W: 8}}}},
+			path:      "panels[0].grid_pos.h",
+		},
+		"too many decimals": {
+			dashboard: Dashboard{Panels: []Panel{{Decimals: decimals(21)}}},
+			path:      "panels[0].decimals",
+		},
+		"base step with a value": {
+			dashboard: Dashboard{Panels: []Panel{{Thresholds: &Thresholds{Steps: []ThresholdStep{{Value: value(1), Color: "green"}}}}}},
+			path:      "panels[0].thresholds.steps[0].value",
+		},
+		"decreasing steps": {
+			dashboard: Dashboard{Panels: []Panel{{Thresholds: &Thresholds{Steps: []ThresholdStep{
+				{Color: "green"}, {Value: value(90), Color: "orange"}, {Value: value(80), Color: "red"},
+			}}}}},
+			path: "panels[0].thresholds.steps[2].value",
+		},
+		"unknown legend calculation": {
+			dashboard: Dashboard{Panels: []Panel{{Legend: &Legend{Calcs: []string{"median"}}}}},
+			path:      "panels[0].legend.calcs",
+		},
+		"invalid override pattern": {
+			dashboard: Dashboard{Panels: []Panel{{Overrides: []FieldOverride{{Matcher: FieldMatcher{Type: "by_regexp", Value: "[cpu"}, Unit: "percent"}}}}},
+			path:      "panels[0].overrides[0].matcher.value",
+		},
+		"override without changes": {
+			dashboard: Dashboard{Panels: []Panel{{Overrides: []FieldOverride{{Matcher: FieldMatcher{Type: "by_name", Value: "cpu"}}}}}},
+			path:      "panels[0].overrides[0].matcher",
+		},
+		"variable without name": {
+			dashboard: Dashboard{Variables: []Variable{{Query: "1m"}}},
+			path:      "variables[0].name",
+		},
+		"duplicate variable": {
+			dashboard: Dashboard{Variables: []Variable{{Name: "host"}, {Name: "host"}}},
+			path:      "variables[1].name",
+		},
+		"unknown variable type": {
+			dashboard: Dashboard{Variables: []Variable{{Name: "host", Type: "datasource"}}},
+			path:      "variables[0].type",
+		},
+	}
+	for name, tt := range invalid {
+		t.Run(name, func(t *testing.T) {
+			err := tt.dashboard.Validate()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.path+":")
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Silence suppresses the notifications matching it while it is active. A
+// silence without a schedule is active from StartsAt until EndsAt. A
+// silence with a schedule is a recurring maintenance window: it is active
+// for Duration every time the cron schedule fires, e.g. "0 2 * * SUN" with
+// a duration of "2h", between StartsAt and EndsAt if given. Schedules are
+// in UTC unless they start with CRON_TZ=.
+type Silence struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Matcher   SilenceMatcher     `json:"matcher" bson:"matcher"`
+	StartsAt  time.Time          `json:"starts_at" bson:"starts_at"`
+	EndsAt    *time.Time         `json:"ends_at,omitempty" bson:"ends_at,omitempty"`
+	Schedule  string             `json:"schedule,omitempty" bson:"schedule,omitempty"`
+	Duration  string             `json:"duration,omitempty" bson:"duration,omitempty"`
+	Comment   string             `json:"comment" bson:"comment"`
+	CreatedBy string             `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+
+	// Active tells whether the silence suppresses notifications right now.
+	// It is set in responses and never stored.
+	Active bool `json:"active" bson:"-"`
+}
+
+// SilenceMatcher selects notifications by check name and tags. CheckName
+// may contain the wildcards of path.Match, e.g. "disk*". Every tag must
+// have the given value. An empty matcher matches every notification.
+type SilenceMatcher struct {
+	CheckName string            `json:"check_name,omitempty" bson:"check_name,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty" bson:"tags,omitempty"`
+}
+
+// SilenceFilter selects silences. Expired silences are left out unless
+// Expired is set.
+type SilenceFilter struct {
+	Expired bool
+}
+
+// Validate checks the time range, schedule and matcher of the silence.
+func (s *Silence) Validate() error {
+	if s.Comment == "" {
+		return errors.New("comment is required")
+	}
+	if _, err := path.Match(s.Matcher.CheckName, ""); err != nil {
+		return fmt.Errorf("invalid check_name pattern: %w", err)
+	}
+	if s.Schedule == "" {
+		if s.Duration != "" {
+			return errors.New("duration requires a schedule")
+		}
+		if s.EndsAt == nil {
+			return errors.New("ends_at is required")
+		}
+	} else {
+		if _, err := cron.ParseStandard(s.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+		if d, err := time.ParseDuration(s.Duration); err != nil || d <= 0 {
+			return errors.New("duration must be a positive duration")
+		}
+	}
+	if s.EndsAt != nil && !s.EndsAt.After(s.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// IsActive reports whether the silence is active at the given time.
+func (s *Silence) IsActive(t time.Time) bool {
+	if t.Before(s.StartsAt) || (s.EndsAt != nil && !t.Before(*s.EndsAt)) {
+		return false
+	}
+	if s.Schedule == "" {
+		return true
+	}
+
+	schedule, err := cron.ParseStandard(s.Schedule)
+	if err != nil {
+		return false
+	}
+	duration, err := time.ParseDuration(s.Duration)
+	if err != nil {
+		return false
+	}
+	// Active if the schedule fired within the last duration
+	return !schedule.Next(t.UTC().Add(-duration)).After(t)
+}
+
+// Matches reports whether a notification of the check with the given tags
+// matches the silence.
+func (m *SilenceMatcher) Matches(checkName string, tags map[string]string) bool {
+	if m.CheckName != "" {
+		if ok, _ := path.Match(m.CheckName, checkName); !ok {
+			return false
+		}
+	}
+	for key, value := range m.Tags {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSilenceIsActive(t *testing.T) {
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		require.NoError(t, err)
+		return v
+	}
+	ptr := func(v time.Time) *time.Time { return &v }
+
+	tests := []struct {
+		name    string
+		silence Silence
+		times   map[string]bool
+	}{
+		{
+			name: "fixed range",
+			silence: Silence{
+				StartsAt: utc("2024-05-01T10:00:00Z"),
+				EndsAt:   ptr(utc("2024-05-01T12:00:00Z")),
+			},
+			times: map[string]bool{
+				"2024-05-01T09:59:59Z": false,
+				"2024-05-01T10:00:00Z": true,
+				"2024-05-01T11:59:59Z": true,
+				"2024-05-01T12:00:00Z": false,
+				// Times in other zones are the same instants
+				"2024-05-01T13:30:00+03:30": true,
+				"2024-05-01T14:00:00+02:00": false,
+			},
+		},
+		{
+			name: "weekly window",
+			silence: Silence{
+				StartsAt: utc("2024-01-01T00:00:00Z"),
+				Schedule: "0 2 * * SUN",
+				Duration: "2h",
+			},
+			times: map[string]bool{
+				// Sunday, May 5th 2024
+				"2024-05-05T01:59:59Z":      false,
+				"2024-05-05T02:00:00Z":      true,
+				"2024-05-05T03:59:59Z":      true,
+				"2024-05-05T04:00:00Z":      false,
+				"2024-05-04T02:30:00Z":      false,
+				"2024-05-12T02:30:00Z":      true,
+				"2024-05-12T05:30:00+03:00": true,
+			},
+		},
+		{
+			name: "window across midnight",
+			silence: Silence{
+				StartsAt: utc("2024-01-01T00:00:00Z"),
+				Schedule: "30 23 * * *",
+				Duration: "1h",
+			},
+			times: map[string]bool{
+				"2024-05-01T23:29:59Z": false,
+				"2024-05-01T23:30:00Z": true,
+				"2024-05-02T00:29:59Z": true,
+				"2024-05-02T00:30:00Z": false,
+			},
+		},
+		{
+			name: "schedule in another time zone",
+			silence: Silence{
+				StartsAt: utc("2024-01-01T00:00:00Z"),
+				Schedule: "CRON_TZ=Europe/Berlin 0 2 * * *",
+				Duration: "30m",
+			},
+			times: map[string]bool{
+				// 02:00 in Berlin is 00:00 UTC in summer and 01:00 in winter
+				"2024-07-01T00:00:00Z":      true,
+				"2024-07-01T00:29:59Z":      true,
+				"2024-07-01T00:30:00Z":      false,
+				"2024-07-01T02:00:00Z":      false,
+				"2024-12-01T00:00:00Z":      false,
+				"2024-12-01T01:00:00Z":      true,
+				"2024-12-01T02:15:00+01:00": true,
+			},
+		},
+		{
+			name: "window limited by the range",
+			silence: Silence{
+				StartsAt: utc("2024-05-02T02:30:00Z"),
+				EndsAt:   ptr(utc("2024-05-03T03:00:00Z")),
+				Schedule: "0 2 * * *",
+				Duration: "2h",
+			},
+			times: map[string]bool{
+				"2024-05-01T02:30:00Z": false,
+				"2024-05-02T02:00:00Z": false,
+				"2024-05-02T02:30:00Z": true,
+				"2024-05-03T02:30:00Z": true,
+				"2024-05-03T03:00:00Z": false,
+				"2024-05-04T02:30:00Z": false,
+			},
+		},
+		{
+			name: "expired",
+			silence: Silence{
+				StartsAt: utc("2024-01-01T00:00:00Z"),
+				EndsAt:   ptr(utc("2024-02-01T00:00:00Z")),
+			},
+			times: map[string]bool{
+				"2024-01-31T23:59:59Z": true,
+				"2024-02-01T00:00:00Z": false,
+				"2025-01-01T00:00:00Z": false,
+			},
+		},
+		{
+			name: "invalid schedule",
+			silence: Silence{
+				StartsAt: utc("2024-01-01T00:00:00Z"),
+				Schedule: "every sunday",
+				Duration: "2h",
+			},
+			times: map[string]bool{
+				"2024-05-05T02:30:00Z": false,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for at, expected := range tt.times {
+				require.Equal(t, expected, tt.silence.IsActive(utc(at)), at)
+			}
+		})
+	}
+}
+
+func TestSilenceValidate(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	valid := []Silence{
+		{Comment: "deploy", StartsAt: start, EndsAt: &end},
+		{Comment: "backup", StartsAt: start, Schedule: "0 2 * * SUN", Duration: "2h"},
+		{Comment: "backup", StartsAt: start, Schedule: "CRON_TZ=Asia/Tehran 0 2 * * *", Duration: "90m", EndsAt: &end},
+	}
+	for _, s := range valid {
+		require.NoError(t, s.Validate(), s)
+	}
+
+	invalid := map[string]Silence{
+		"no comment":            {StartsAt: start, EndsAt: &end},
+		"no end":                {Comment: "deploy", StartsAt: start},
+		"end before start":      {Comment: "deploy", StartsAt: end, EndsAt: &start},
+		"duration without cron": {Comment: "deploy", StartsAt: start, EndsAt: &end, Duration: "1h"},
+		"invalid schedule":      {Comment: "backup", StartsAt: start, Schedule: "0 25 * * *", Duration: "1h"},
+		"no duration":           {Comment: "backup", StartsAt: start, Schedule: "0 2 * * *"},
+		"negative duration":     {Comment: "backup", StartsAt: start, Schedule: "0 2 * * *", Duration: "-1h"},
+		"invalid pattern":       {Comment: "deploy", StartsAt: start, EndsAt: &end, Matcher: SilenceMatcher{CheckName: "[disk"}},
+	}
+	for name, s := range invalid {
+		require.Error(t, s.Validate(), name)
+	}
+}
+
+func TestSilenceMatcher(t *testing.T) {
+	m := SilenceMatcher{CheckName: "disk*", Tags: map[string]string{"host": "db1"}}
+
+	require.True(t, m.Matches("disk usage", map[string]string{"host": "db1", "path": "/"}))
+	require.False(t, m.Matches("disk usage", map[string]string{"host": "db2"}))
+	require.False(t, m.Matches("disk usage", nil))
+	require.False(t, m.Matches("cpu usage", map[string]string{"host": "db1"}))
+
+	var all SilenceMatcher
+	require.True(t, all.Matches("anything", nil))
+}
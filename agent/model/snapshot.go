@@ -0,0 +1,88 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Snapshot is a dashboard frozen together with the results of its panel
+// queries, shared through a public link until it expires. Only the SHA-256
+// hash of the link's token is stored; the token itself is shown once on
+// creation.
+type Snapshot struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	DashboardID primitive.ObjectID `json:"dashboard_id" bson:"dashboard_id"`
+	Name        string             `json:"name" bson:"name"`
+	Hash        string             `json:"-" bson:"hash"`
+	Prefix      string             `json:"prefix" bson:"prefix"`
+	Author      string             `json:"author" bson:"author"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt   time.Time          `json:"expires_at" bson:"expires_at"`
+
+	// The frozen content is left out when snapshots are listed
+	Dashboard *Dashboard       `json:"dashboard,omitempty" bson:"dashboard,omitempty"`
+	Variables []VariableValues `json:"variables,omitempty" bson:"variables,omitempty"`
+	Data      *DashboardData   `json:"data,omitempty" bson:"data,omitempty"`
+}
+
+// PublicSnapshot is the part of a snapshot served through its public link.
+type PublicSnapshot struct {
+	Name      string           `json:"name"`
+	CreatedAt time.Time        `json:"created_at"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	Dashboard *Dashboard       `json:"dashboard"`
+	Variables []VariableValues `json:"variables"`
+	Data      *DashboardData   `json:"data"`
+}
+
+// Public returns the part of the snapshot shown to anyone with the link.
+// Queries are left out, both as written in the dashboard and as run, so the
+// link does not reveal the schema of the database. Panels keep a blank query
+// for each of their results.
+func (s *Snapshot) Public() *PublicSnapshot {
+	public := &PublicSnapshot{
+		Name:      s.Name,
+		CreatedAt: s.CreatedAt,
+		ExpiresAt: s.ExpiresAt,
+		Variables: make([]VariableValues, 0, len(s.Variables)),
+	}
+
+	if s.Dashboard != nil {
+		dashboard := *s.Dashboard
+		dashboard.Panels = make([]Panel, 0, len(s.Dashboard.Panels))
+		for _, p := range s.Dashboard.Panels {
+			p.Query = make([]string, len(p.Query))
+			dashboard.Panels = append(dashboard.Panels, p)
+		}
+		dashboard.Variables = make([]Variable, 0, len(s.Dashboard.Variables))
+		for _, v := range s.Dashboard.Variables {
+			if v.Type == "" || v.Type == VariableTypeQuery {
+				v.Query = ""
+			}
+			dashboard.Variables = append(dashboard.Variables, v)
+		}
+		public.Dashboard = &dashboard
+	}
+
+	for _, v := range s.Variables {
+		v.Query = ""
+		public.Variables = append(public.Variables, v)
+	}
+
+	if s.Data != nil {
+		data := *s.Data
+		data.Panels = make(map[int][]PanelQueryData, len(s.Data.Panels))
+		for key, results := range s.Data.Panels {
+			stripped := make([]PanelQueryData, 0, len(results))
+			for _, r := range results {
+				r.Query = ""
+				stripped = append(stripped, r)
+			}
+			data.Panels[key] = stripped
+		}
+		public.Data = &data
+	}
+
+	return public
+}
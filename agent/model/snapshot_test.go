@@ -0,0 +1,62 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotPublicWithoutQueries(t *testing.T) {
+	snapshot := &Snapshot{
+		Name: "incident",
+		Hash: "secret",
+		Dashboard: &Dashboard{
+			Name: "Servers",
+			Panels: []Panel{
+				{Name: "CPU", Query: []string{`SELECT mean("usage") FROM "cpu" WHERE host =~ /^$host$/`, `SELECT 1`}},
+			},
+			Variables: []Variable{
+				{Name: "host", Query: `SHOW TAG VALUES WITH KEY = "host"`},
+				{Name: "dc", Type: VariableTypeCustom, Query: "eu,us"},
+			},
+		},
+		Variables: []VariableValues{
+			{Name: "host", Query: `SHOW TAG VALUES WITH KEY = "host"`, Options: []string{"web-1"}, Current: []string{"web-1"}},
+		},
+		Data: &DashboardData{
+			Panels: map[int][]PanelQueryData{
+				0: {
+					{Query: `SELECT mean("usage") FROM "cpu" WHERE host =~ /^web-1$/`, Result: json.RawMessage(`{"results":[]}`)},
+					{Query: `SELECT 1`, Error: "failed"},
+				},
+			},
+			Errors: 1,
+		},
+	}
+
+	public := snapshot.Public()
+
+	require.Equal(t, []string{"", ""}, public.Dashboard.Panels[0].Query)
+	require.Equal(t, "CPU", public.Dashboard.Panels[0].Name)
+	require.Empty(t, public.Dashboard.Variables[0].Query)
+	require.Equal(t, "eu,us", public.Dashboard.Variables[1].Query)
+	require.Empty(t, public.Variables[0].Query)
+	require.Equal(t, []string{"web-1"}, public.Variables[0].Current)
+	require.Len(t, public.Data.Panels[0], 2)
+	require.Empty(t, public.Data.Panels[0][0].Query)
+	require.JSONEq(t, `{"results":[]}`, string(public.Data.Panels[0][0].Result))
+	require.Equal(t, "failed", public.Data.Panels[0][1].Error)
+	require.Equal(t, 1, public.Data.Errors)
+
+	body, err := json.Marshal(public)
+	require.NoError(t, err)
+	require.NotContains(t, string(body), "SELECT")
+	require.NotContains(t, string(body), "SHOW")
+	require.NotContains(t, string(body), "secret")
+
+	// The stored snapshot is left as it is
+	require.Equal(t, `SELECT 1`, snapshot.Dashboard.Panels[0].Query[1])
+	require.Equal(t, `SELECT 1`, snapshot.Data.Panels[0][1].Query)
+	require.NotEmpty(t, snapshot.Variables[0].Query)
+}
@@ -0,0 +1,50 @@
+package model
+
+import "time"
+
+// AgentStatus is the running state of the agent and its plugins.
+type AgentStatus struct {
+	Version       string         `json:"version"`
+	Running       bool           `json:"running"`
+	StartTime     *time.Time     `json:"start_time,omitempty"`
+	UptimeSeconds float64        `json:"uptime_seconds"`
+	Inputs        []InputStatus  `json:"inputs"`
+	Outputs       []OutputStatus `json:"outputs"`
+	Stats         []StatMetric   `json:"stats"`
+}
+
+// InputStatus is the state of a running input. StoredID is the id of the
+// stored input the plugin was created from, if it was created through the
+// API.
+type InputStatus struct {
+	ID                 string     `json:"id"`
+	Name               string     `json:"name"`
+	Alias              string     `json:"alias,omitempty"`
+	StoredID           string     `json:"stored_id,omitempty"`
+	LastGather         *time.Time `json:"last_gather,omitempty"`
+	LastGatherDuration string     `json:"last_gather_duration,omitempty"`
+	LastError          string     `json:"last_error,omitempty"`
+	MetricsGathered    int64      `json:"metrics_gathered"`
+	GatherErrors       int64      `json:"gather_errors"`
+	GatherTimeouts     int64      `json:"gather_timeouts"`
+}
+
+// OutputStatus is the state of a running output and its buffer.
+type OutputStatus struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Alias           string `json:"alias,omitempty"`
+	BufferSize      int64  `json:"buffer_size"`
+	BufferLimit     int64  `json:"buffer_limit"`
+	MetricsAdded    int64  `json:"metrics_added"`
+	MetricsWritten  int64  `json:"metrics_written"`
+	MetricsRejected int64  `json:"metrics_rejected"`
+	MetricsDropped  int64  `json:"metrics_dropped"`
+}
+
+// StatMetric is one metric of the agent's internal statistics.
+type StatMetric struct {
+	Name   string                 `json:"name"`
+	Tags   map[string]string      `json:"tags"`
+	Fields map[string]interface{} `json:"fields"`
+}
@@ -2,10 +2,52 @@ package model
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// Role grants a user access to a set of API routes. Each role includes the
+// permissions of the roles below it.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+// Level orders the roles from least to most privileged. Unknown roles have
+// no privileges at all.
+func (r Role) Level() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleEditor:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Valid reports whether r is one of the known roles.
+func (r Role) Valid() bool {
+	return r.Level() > 0
+}
+
 type User struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Username  string             `json:"username" bson:"username"`
-	Password  string             `json:"password" bson:"password"`
-	Email     string             `json:"email" bson:"email"`
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Username string             `json:"username" bson:"username"`
+	Password string             `json:"password,omitempty" bson:"password"`
+	Email    string             `json:"email" bson:"email"`
+	Role     Role               `json:"role" bson:"role"`
+	Disabled bool               `json:"disabled" bson:"disabled"`
+	// Bootstrap marks the admin created by the first registration of an
+	// empty installation. A unique index allows only one such user.
+	Bootstrap bool               `json:"-" bson:"bootstrap,omitempty"`
 	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at"`
+	UpdatedAt primitive.DateTime `json:"updated_at" bson:"updated_at"`
+}
+
+// PasswordChange is the payload used to change or reset a user's password.
+// OldPassword is only checked when a user changes their own password.
+type PasswordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
 }
@@ -0,0 +1,187 @@
+package notification
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailConfig configures the SMTP email driver. With TLS set the connection
+// is encrypted from the start, otherwise STARTTLS is used if the server
+// offers it. Credentials are only sent over encrypted connections.
+type EmailConfig struct {
+	Host               string   `json:"host"`
+	Port               int      `json:"port,omitempty"`
+	Username           string   `json:"username,omitempty"`
+	Password           string   `json:"password,omitempty"`
+	From               string   `json:"from"`
+	To                 []string `json:"to"`
+	TLS                bool     `json:"tls,omitempty"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify,omitempty"`
+}
+
+type email struct {
+	EmailConfig
+	// envelope addresses without display names
+	from string
+	to   []string
+}
+
+func newEmail(config map[string]interface{}) (Notifier, error) {
+	e := &email{}
+	if err := decodeConfig(config, &e.EmailConfig); err != nil {
+		return nil, err
+	}
+	if e.Host == "" {
+		return nil, errors.New("host is required")
+	}
+	if e.Port == 0 {
+		e.Port = 587
+		if e.TLS {
+			e.Port = 465
+		}
+	}
+	from, err := mail.ParseAddress(e.From)
+	if err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+	e.from = from.Address
+	if len(e.To) == 0 {
+		return nil, errors.New("to requires at least one address")
+	}
+	for _, to := range e.To {
+		address, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+		e.to = append(e.to, address.Address)
+	}
+	return e, nil
+}
+
+// Send delivers the message. Rejections by the SMTP server are reported as
+// DeliveryError, temporary for 4xx replies.
+func (e *email) Send(ctx context.Context, msg *Message) error {
+	err := e.send(ctx, msg)
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return &DeliveryError{
+			StatusCode: protoErr.Code,
+			Temporary:  protoErr.Code < 500,
+			Err:        err,
+		}
+	}
+	return err
+}
+
+func (e *email) send(ctx context.Context, msg *Message) error {
+	address := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	tlsConfig := &tls.Config{
+		ServerName:         e.Host,
+		InsecureSkipVerify: e.InsecureSkipVerify, //nolint:gosec // explicitly enabled by the user
+	}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{}
+	if e.TLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	client, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	encrypted := e.TLS
+	if !encrypted {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("failed to start TLS: %w", err)
+			}
+			encrypted = true
+		}
+	}
+	if e.Username != "" {
+		if !encrypted {
+			return errors.New("refusing to send credentials over an unencrypted connection")
+		}
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	if err := client.Mail(e.from); err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(e.compose(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// compose renders the message as an email with the title as subject.
+func (e *email) compose(msg *Message) []byte {
+	contentType := "text/plain"
+	if msg.Format == FormatHTML {
+		contentType = "text/html"
+	}
+
+	var b strings.Builder
+	b.WriteString("From: " + e.From + "\r\n")
+	b.WriteString("To: " + strings.Join(e.To, ", ") + "\r\n")
+	b.WriteString("Subject: " + mimeHeader(msg.Title) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: " + contentType + "; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// mimeHeader encodes a header value, dropping line breaks that would allow
+// injecting headers.
+func mimeHeader(value string) string {
+	value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+func init() {
+	Add("email", FormatHTML, newEmail)
+}
@@ -0,0 +1,49 @@
+package notification
+
+import "context"
+
+// MattermostConfig configures the driver for Mattermost incoming webhooks.
+type MattermostConfig struct {
+	WebhookURL string `json:"webhook_url"`
+	Channel    string `json:"channel,omitempty"`
+	Username   string `json:"username,omitempty"`
+	IconURL    string `json:"icon_url,omitempty"`
+}
+
+type mattermost struct {
+	MattermostConfig
+}
+
+func newMattermost(config map[string]interface{}) (Notifier, error) {
+	m := &mattermost{}
+	if err := decodeConfig(config, &m.MattermostConfig); err != nil {
+		return nil, err
+	}
+	if err := checkURL("webhook_url", m.WebhookURL); err != nil {
+		return nil, err
+	}
+	if m.IconURL != "" {
+		if err := checkURL("icon_url", m.IconURL); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *mattermost) Send(ctx context.Context, msg *Message) error {
+	payload := map[string]interface{}{"text": msg.Body}
+	if m.Channel != "" {
+		payload["channel"] = m.Channel
+	}
+	if m.Username != "" {
+		payload["username"] = m.Username
+	}
+	if m.IconURL != "" {
+		payload["icon_url"] = m.IconURL
+	}
+	return sendJSON(ctx, "POST", m.WebhookURL, nil, payload)
+}
+
+func init() {
+	Add("mattermost", FormatMarkdown, newMattermost)
+}
@@ -1,40 +1,67 @@
 package notification
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"strings"
 )
 
-// SendNotification sends a message via a Telegram or Bale bot
-func SendNotification(url, token, text string, chatID int64) error {
-	apiURL := fmt.Sprintf("%s/bot%s/sendMessage", url, token)
-	payload := map[string]interface{}{
-		"chat_id": chatID,
-		"text":    text,
-	}
+// Default API endpoints of the bot drivers
+const (
+	telegramAPI = "https://api.telegram.org"
+	baleAPI     = "https://tapi.bale.ai"
+)
 
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
-	}
+// BotConfig configures the Telegram and Bale drivers. Token may be left
+// empty to use the bot token of the server config.
+type BotConfig struct {
+	Token  string `json:"token,omitempty"`
+	ChatID int64  `json:"chat_id"`
+	APIURL string `json:"api_url,omitempty"`
+}
 
-	resp, err := http.Post(apiURL, "application/json", bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			return
+// bot sends messages via the sendMessage method of a Telegram or Bale bot.
+type bot struct {
+	BotConfig
+}
+
+func newBot(defaultAPI string) Creator {
+	return func(config map[string]interface{}) (Notifier, error) {
+		b := &bot{}
+		if err := decodeConfig(config, &b.BotConfig); err != nil {
+			return nil, err
+		}
+		if b.Token == "" {
+			return nil, errors.New("token is required")
 		}
-	}(resp.Body)
+		if b.ChatID == 0 {
+			return nil, errors.New("chat_id is required")
+		}
+		if b.APIURL == "" {
+			b.APIURL = defaultAPI
+		}
+		if err := checkURL("api_url", b.APIURL); err != nil {
+			return nil, err
+		}
+		b.APIURL = strings.TrimSuffix(b.APIURL, "/")
+		return b, nil
+	}
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("telegram API responded with status code %d", resp.StatusCode)
+func (b *bot) Send(ctx context.Context, msg *Message) error {
+	apiURL := fmt.Sprintf("%s/bot%s/sendMessage", b.APIURL, b.Token)
+	payload := map[string]interface{}{
+		"chat_id": b.ChatID,
+		"text":    msg.Body,
 	}
+	if msg.Format == FormatMarkdown {
+		payload["parse_mode"] = "MarkdownV2"
+	}
+	return sendJSON(ctx, "POST", apiURL, nil, payload)
+}
 
-	return nil
+func init() {
+	Add("telegram", FormatMarkdown, newBot(telegramAPI))
+	Add("bale", FormatText, newBot(baleAPI))
 }
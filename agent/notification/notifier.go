@@ -0,0 +1,206 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrUnknownDriver is returned when no notifier is registered under the
+// requested driver name.
+var ErrUnknownDriver = errors.New("unknown notification driver")
+
+// Format is the markup a driver sends messages in.
+type Format string
+
+const (
+	FormatText     Format = "text"
+	FormatMarkdown Format = "markdown"
+	FormatHTML     Format = "html"
+)
+
+// Alert is the data a notification is rendered from. Fields holds every
+// field of the alert payload and Tags the tags of the alerting series.
+type Alert struct {
+	CheckName    string                 `json:"check_name"`
+	Level        string                 `json:"level"`
+	Message      string                 `json:"message"`
+	Time         time.Time              `json:"time"`
+	Fields       map[string]interface{} `json:"fields,omitempty"`
+	Tags         map[string]string      `json:"tags,omitempty"`
+	DashboardURL string                 `json:"dashboard_url,omitempty"`
+	Channel      string                 `json:"channel"`
+}
+
+// Message is a notification rendered for a channel. Title is used by the
+// drivers supporting one, e.g. as the subject of emails.
+type Message struct {
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	Format Format `json:"format"`
+	Alert  *Alert `json:"-"`
+}
+
+// DeliveryError is returned by notifiers when the destination rejected a
+// message. Temporary errors are worth retrying, after RetryAfter if the
+// destination asked to wait. Errors of other types, e.g. timeouts, are
+// temporary as well.
+type DeliveryError struct {
+	StatusCode int
+	Temporary  bool
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *DeliveryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// Notifier delivers messages to the destination it was created for.
+type Notifier interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Creator builds a notifier from the driver config stored with a channel.
+// The config is decoded into the driver's own settings and rejected if
+// invalid.
+type Creator func(config map[string]interface{}) (Notifier, error)
+
+var (
+	Notifiers = make(map[string]Creator)
+	Formats   = make(map[string]Format)
+)
+
+// Add registers a driver and the format its messages are rendered in.
+func Add(name string, format Format, creator Creator) {
+	Notifiers[name] = creator
+	Formats[name] = format
+}
+
+// New creates a notifier of the given driver.
+func New(driver string, config map[string]interface{}) (Notifier, error) {
+	creator, found := Notifiers[driver]
+	if !found {
+		return nil, fmt.Errorf("%w %q", ErrUnknownDriver, driver)
+	}
+	return creator(config)
+}
+
+// Drivers returns the names of all registered drivers.
+func Drivers() []string {
+	names := make([]string, 0, len(Notifiers))
+	for name := range Notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decodeConfig converts a stored driver config into the driver's settings,
+// rejecting unknown fields so typos do not go unnoticed.
+func decodeConfig(config map[string]interface{}, settings interface{}) error {
+	buf, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(settings); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	return nil
+}
+
+// checkURL checks that a configured URL is an absolute http(s) URL.
+func checkURL(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be an http or https URL", name)
+	}
+	return nil
+}
+
+// sendJSON sends the payload as JSON and fails unless the destination
+// answers with a 2xx status.
+func sendJSON(ctx context.Context, method, endpoint string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Leave out the URL, it may hold a token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &DeliveryError{
+			StatusCode: resp.StatusCode,
+			Temporary: resp.StatusCode == http.StatusTooManyRequests ||
+				resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500,
+			RetryAfter: retryAfter(resp.Header, detail),
+			Err:        fmt.Errorf("destination responded with status code %d: %s", resp.StatusCode, bytes.TrimSpace(detail)),
+		}
+	}
+	return nil
+}
+
+// retryAfter returns the delay a destination asked for, either in the
+// Retry-After header or, like Telegram and Bale do, as retry_after in the
+// parameters of the response.
+func retryAfter(header http.Header, body []byte) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if t, err := http.ParseTime(value); err == nil {
+			return time.Until(t)
+		}
+	}
+
+	var response struct {
+		Parameters struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil && response.Parameters.RetryAfter > 0 {
+		return time.Duration(response.Parameters.RetryAfter) * time.Second
+	}
+	return 0
+}
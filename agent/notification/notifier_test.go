@@ -0,0 +1,376 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrivers(t *testing.T) {
+	require.Equal(t, []string{"bale", "email", "mattermost", "slack", "teams", "telegram", "webhook"}, Drivers())
+
+	formats := map[string]Format{
+		"webhook":    FormatText,
+		"slack":      FormatText,
+		"teams":      FormatMarkdown,
+		"mattermost": FormatMarkdown,
+		"email":      FormatHTML,
+	}
+	for driver, format := range formats {
+		require.Equal(t, format, Formats[driver], driver)
+	}
+
+	_, err := New("pager", nil)
+	require.ErrorIs(t, err, ErrUnknownDriver)
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	tests := []struct {
+		driver string
+		config map[string]interface{}
+		err    string
+	}{
+		{driver: "webhook", config: map[string]interface{}{}, err: "url is required"},
+		{driver: "webhook", config: map[string]interface{}{"url": "ftp://example.com"}, err: "url must be an http or https URL"},
+		{driver: "webhook", config: map[string]interface{}{"url": "https://example.com", "method": "GET"}, err: "method must be POST, PUT or PATCH"},
+		{driver: "webhook", config: map[string]interface{}{"url": "https://example.com", "headres": map[string]string{}}, err: "unknown field"},
+		{driver: "slack", config: map[string]interface{}{"url": "https://hooks.slack.com/x"}, err: "unknown field"},
+		{driver: "slack", config: map[string]interface{}{"webhook_url": "hooks.slack.com/x"}, err: "webhook_url must be an http or https URL"},
+		{driver: "teams", config: map[string]interface{}{}, err: "webhook_url is required"},
+		{driver: "mattermost", config: map[string]interface{}{"webhook_url": "https://chat.example.com/hooks/x", "icon_url": "icon.png"}, err: "icon_url must be an http or https URL"},
+		{driver: "email", config: map[string]interface{}{"from": "dana@example.com", "to": []string{"ops@example.com"}}, err: "host is required"},
+		{driver: "email", config: map[string]interface{}{"host": "smtp.example.com", "from": "dana", "to": []string{"ops@example.com"}}, err: "from:"},
+		{driver: "email", config: map[string]interface{}{"host": "smtp.example.com", "from": "dana@example.com"}, err: "to requires at least one address"},
+		{driver: "email", config: map[string]interface{}{"host": "smtp.example.com", "from": "dana@example.com", "to": []string{"ops"}}, err: "to:"},
+		{driver: "email", config: map[string]interface{}{"host": "smtp.example.com", "port": "25"}, err: "invalid config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driver+" "+tt.err, func(t *testing.T) {
+			_, err := New(tt.driver, tt.config)
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+// request is a request received by the test destination.
+type request struct {
+	method  string
+	header  http.Header
+	payload map[string]interface{}
+}
+
+// newDestination returns a server answering with status and recording the
+// requests it receives.
+func newDestination(t *testing.T, status int, header http.Header, body string) (*httptest.Server, <-chan request) {
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		requests <- request{method: r.Method, header: r.Header.Clone(), payload: payload}
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestWebhookDrivers(t *testing.T) {
+	msg := &Message{Title: "[CRIT] CPU usage", Body: "CPU usage is 97%", Alert: SampleAlert()}
+
+	tests := []struct {
+		driver  string
+		config  func(url string) map[string]interface{}
+		method  string
+		payload map[string]interface{}
+	}{
+		{
+			driver: "webhook",
+			config: func(url string) map[string]interface{} {
+				return map[string]interface{}{"url": url, "method": "put", "headers": map[string]string{"X-Token": "secret"}}
+			},
+			method: http.MethodPut,
+			payload: map[string]interface{}{
+				"title":      "[CRIT] CPU usage",
+				"text":       "CPU usage is 97%",
+				"check_name": "CPU usage",
+				"level":      "crit",
+			},
+		},
+		{
+			driver: "slack",
+			config: func(url string) map[string]interface{} {
+				return map[string]interface{}{"webhook_url": url, "channel": "#ops", "username": "dana", "icon_emoji": ":fire:"}
+			},
+			method: http.MethodPost,
+			payload: map[string]interface{}{
+				"text":       "CPU usage is 97%",
+				"channel":    "#ops",
+				"username":   "dana",
+				"icon_emoji": ":fire:",
+			},
+		},
+		{
+			driver: "teams",
+			config: func(url string) map[string]interface{} {
+				return map[string]interface{}{"webhook_url": url}
+			},
+			method: http.MethodPost,
+			payload: map[string]interface{}{
+				"@type":      "MessageCard",
+				"title":      "[CRIT] CPU usage",
+				"summary":    "[CRIT] CPU usage",
+				"text":       "CPU usage is 97%",
+				"themeColor": "D32F2F",
+			},
+		},
+		{
+			driver: "mattermost",
+			config: func(url string) map[string]interface{} {
+				return map[string]interface{}{"webhook_url": url, "channel": "ops", "icon_url": "https://example.com/icon.png"}
+			},
+			method: http.MethodPost,
+			payload: map[string]interface{}{
+				"text":     "CPU usage is 97%",
+				"channel":  "ops",
+				"icon_url": "https://example.com/icon.png",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			server, requests := newDestination(t, http.StatusOK, nil, "")
+			notifier, err := New(tt.driver, tt.config(server.URL))
+			require.NoError(t, err)
+			require.NoError(t, notifier.Send(context.Background(), msg))
+
+			r := <-requests
+			require.Equal(t, tt.method, r.method)
+			require.Equal(t, "application/json", r.header.Get("Content-Type"))
+			for key, value := range tt.payload {
+				require.Equal(t, value, r.payload[key], key)
+			}
+			if tt.driver == "webhook" {
+				require.Equal(t, "secret", r.header.Get("X-Token"))
+			}
+		})
+	}
+}
+
+func TestTeamsLevelColor(t *testing.T) {
+	require.Equal(t, "D32F2F", levelColor("CRIT"))
+	require.Equal(t, "F57C00", levelColor("warn"))
+	require.Equal(t, "388E3C", levelColor("ok"))
+	require.Equal(t, "1976D2", levelColor("info"))
+	require.Equal(t, "1976D2", levelColor(""))
+}
+
+func TestSendJSONErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		body       string
+		temporary  bool
+		retryAfter time.Duration
+	}{
+		{name: "rejected", status: http.StatusBadRequest, body: "invalid_payload"},
+		{name: "not found", status: http.StatusNotFound},
+		{name: "server error", status: http.StatusBadGateway, temporary: true},
+		{
+			name:       "rate limited",
+			status:     http.StatusTooManyRequests,
+			header:     http.Header{"Retry-After": {"30"}},
+			temporary:  true,
+			retryAfter: 30 * time.Second,
+		},
+		{
+			name:       "rate limited by a bot API",
+			status:     http.StatusTooManyRequests,
+			body:       `{"ok":false,"parameters":{"retry_after":12}}`,
+			temporary:  true,
+			retryAfter: 12 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newDestination(t, tt.status, tt.header, tt.body)
+			notifier, err := New("slack", map[string]interface{}{"webhook_url": server.URL})
+			require.NoError(t, err)
+
+			err = notifier.Send(context.Background(), &Message{Body: "hello"})
+			var deliveryErr *DeliveryError
+			require.ErrorAs(t, err, &deliveryErr)
+			require.Equal(t, tt.status, deliveryErr.StatusCode)
+			require.Equal(t, tt.temporary, deliveryErr.Temporary)
+			require.Equal(t, tt.retryAfter, deliveryErr.RetryAfter)
+			require.Contains(t, err.Error(), strconv.Itoa(tt.status))
+			if tt.body != "" {
+				require.Contains(t, err.Error(), tt.body)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		notifier, err := New("webhook", map[string]interface{}{"url": "http://127.0.0.1:1/hook?token=secret"})
+		require.NoError(t, err)
+		err = notifier.Send(context.Background(), &Message{Body: "hello"})
+		require.Error(t, err)
+		// The URL may hold a token and is left out
+		require.NotContains(t, err.Error(), "secret")
+	})
+}
+
+// smtpSession is what the test SMTP server received.
+type smtpSession struct {
+	commands []string
+	data     string
+}
+
+// newSMTPServer runs an SMTP server for a single session, answering RCPT
+// with rcptCode. It does not offer STARTTLS.
+func newSMTPServer(t *testing.T, rcptCode int) (string, int, <-chan smtpSession) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var session smtpSession
+		defer func() { sessions <- session }()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+
+		reply("220 test ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimRight(line, "\r\n")
+			session.commands = append(session.commands, command)
+			switch verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0]); verb {
+			case "EHLO", "HELO":
+				reply("250 test")
+			case "MAIL":
+				reply("250 OK")
+			case "RCPT":
+				reply(strconv.Itoa(rcptCode) + " recipient")
+			case "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				session.data = data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, sessions
+}
+
+func TestEmail(t *testing.T) {
+	msg := &Message{Title: "[CRIT] CPU\r\nBcc: evil@example.com", Body: "<p>CPU usage</p>\n<p>is high</p>", Format: FormatHTML}
+
+	t.Run("delivered", func(t *testing.T) {
+		host, port, sessions := newSMTPServer(t, 250)
+		notifier, err := New("email", map[string]interface{}{
+			"host": host,
+			"port": port,
+			"from": "Dana <dana@example.com>",
+			"to":   []string{"ops@example.com", "Oncall <oncall@example.com>"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, notifier.Send(context.Background(), msg))
+
+		session := <-sessions
+		require.Contains(t, session.commands, "MAIL FROM:<dana@example.com>")
+		require.Contains(t, session.commands, "RCPT TO:<ops@example.com>")
+		require.Contains(t, session.commands, "RCPT TO:<oncall@example.com>")
+		require.Contains(t, session.data, "From: Dana <dana@example.com>\r\n")
+		require.Contains(t, session.data, "To: ops@example.com, Oncall <oncall@example.com>\r\n")
+		require.Contains(t, session.data, "Content-Type: text/html; charset=UTF-8\r\n")
+		require.Contains(t, session.data, "\r\n\r\n<p>CPU usage</p>\r\n<p>is high</p>\r\n")
+		// Line breaks in the title cannot add headers
+		require.Contains(t, session.data, "Subject: [CRIT] CPU  Bcc: evil@example.com\r\n")
+		require.NotContains(t, session.data, "\r\nBcc:")
+	})
+
+	for code, temporary := range map[int]bool{450: true, 550: false} {
+		t.Run("recipient rejected with "+strconv.Itoa(code), func(t *testing.T) {
+			host, port, _ := newSMTPServer(t, code)
+			notifier, err := New("email", map[string]interface{}{
+				"host": host, "port": port, "from": "dana@example.com", "to": []string{"ops@example.com"},
+			})
+			require.NoError(t, err)
+
+			err = notifier.Send(context.Background(), msg)
+			var deliveryErr *DeliveryError
+			require.ErrorAs(t, err, &deliveryErr)
+			require.Equal(t, code, deliveryErr.StatusCode)
+			require.Equal(t, temporary, deliveryErr.Temporary)
+		})
+	}
+
+	t.Run("credentials need encryption", func(t *testing.T) {
+		host, port, sessions := newSMTPServer(t, 250)
+		notifier, err := New("email", map[string]interface{}{
+			"host": host, "port": port, "username": "dana", "password": "hunter2",
+			"from": "dana@example.com", "to": []string{"ops@example.com"},
+		})
+		require.NoError(t, err)
+
+		err = notifier.Send(context.Background(), msg)
+		require.ErrorContains(t, err, "refusing to send credentials")
+		session := <-sessions
+		for _, command := range session.commands {
+			require.NotContains(t, command, "AUTH")
+			require.NotContains(t, command, "MAIL")
+		}
+	})
+}
+
+func TestEmailDefaultPort(t *testing.T) {
+	config := map[string]interface{}{"host": "smtp.example.com", "from": "dana@example.com", "to": []string{"ops@example.com"}}
+	notifier, err := New("email", config)
+	require.NoError(t, err)
+	require.Equal(t, 587, notifier.(*email).Port)
+
+	config["tls"] = true
+	notifier, err = New("email", config)
+	require.NoError(t, err)
+	require.Equal(t, 465, notifier.(*email).Port)
+}
@@ -0,0 +1,45 @@
+package notification
+
+import "context"
+
+// SlackConfig configures the driver for Slack incoming webhooks. It also
+// works with other services accepting Slack-compatible webhooks.
+type SlackConfig struct {
+	WebhookURL string `json:"webhook_url"`
+	Channel    string `json:"channel,omitempty"`
+	Username   string `json:"username,omitempty"`
+	IconEmoji  string `json:"icon_emoji,omitempty"`
+}
+
+type slack struct {
+	SlackConfig
+}
+
+func newSlack(config map[string]interface{}) (Notifier, error) {
+	s := &slack{}
+	if err := decodeConfig(config, &s.SlackConfig); err != nil {
+		return nil, err
+	}
+	if err := checkURL("webhook_url", s.WebhookURL); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *slack) Send(ctx context.Context, msg *Message) error {
+	payload := map[string]interface{}{"text": msg.Body}
+	if s.Channel != "" {
+		payload["channel"] = s.Channel
+	}
+	if s.Username != "" {
+		payload["username"] = s.Username
+	}
+	if s.IconEmoji != "" {
+		payload["icon_emoji"] = s.IconEmoji
+	}
+	return sendJSON(ctx, "POST", s.WebhookURL, nil, payload)
+}
+
+func init() {
+	Add("slack", FormatText, newSlack)
+}
@@ -0,0 +1,60 @@
+package notification
+
+import (
+	"context"
+	"strings"
+)
+
+// TeamsConfig configures the driver for Microsoft Teams incoming webhooks.
+type TeamsConfig struct {
+	WebhookURL string `json:"webhook_url"`
+}
+
+type teams struct {
+	TeamsConfig
+}
+
+func newTeams(config map[string]interface{}) (Notifier, error) {
+	t := &teams{}
+	if err := decodeConfig(config, &t.TeamsConfig); err != nil {
+		return nil, err
+	}
+	if err := checkURL("webhook_url", t.WebhookURL); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Send posts the message as a MessageCard colored by the alert's level.
+func (t *teams) Send(ctx context.Context, msg *Message) error {
+	level := ""
+	if msg.Alert != nil {
+		level = msg.Alert.Level
+	}
+	payload := map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    msg.Title,
+		"title":      msg.Title,
+		"text":       msg.Body,
+		"themeColor": levelColor(level),
+	}
+	return sendJSON(ctx, "POST", t.WebhookURL, nil, payload)
+}
+
+// levelColor returns the card color of the InfluxDB check levels.
+func levelColor(level string) string {
+	switch strings.ToLower(level) {
+	case "crit":
+		return "D32F2F"
+	case "warn":
+		return "F57C00"
+	case "ok":
+		return "388E3C"
+	}
+	return "1976D2"
+}
+
+func init() {
+	Add("teams", FormatMarkdown, newTeams)
+}
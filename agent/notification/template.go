@@ -0,0 +1,157 @@
+package notification
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/Masterminds/sprig/v3"
+)
+
+// Default templates used by channels without templates of their own. The
+// text body matches the messages sent before templates existed.
+const (
+	defaultTitle = `[{{ .Level | upper }}] {{ .CheckName }}`
+
+	defaultTextBody = `checkname: {{ .CheckName }}
+level: {{ .Level }}
+message: {{ .Message }}
+{{- if .DashboardURL }}
+dashboard: {{ .DashboardURL }}
+{{- end }}`
+
+	defaultMarkdownBody = `*{{ .Level | upper | md }}* {{ .CheckName | md }}
+{{ .Message | md }}
+{{- if .DashboardURL }}
+[Open dashboard]({{ .DashboardURL | mdurl }})
+{{- end }}`
+
+	defaultHTMLBody = `<p><b>{{ .Level | upper }}</b> {{ .CheckName }}</p>
+<p>{{ .Message }}</p>
+{{- if .DashboardURL }}
+<p><a href="{{ .DashboardURL }}">Open dashboard</a></p>
+{{- end }}`
+)
+
+// markdownEscaper escapes the characters with a meaning in Telegram's
+// MarkdownV2. Escaping them with a backslash is valid CommonMark as well.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "<", `\<`, "#", `\#`, "+", `\+`, "-", `\-`,
+	"=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// markdownURLEscaper escapes the characters not allowed in the URL of a
+// MarkdownV2 link.
+var markdownURLEscaper = strings.NewReplacer(`\`, `\\`, ")", `\)`)
+
+// unsafeFuncs are the sprig functions removed from templates. Templates are
+// written by editors, who must not read the agent's environment, where
+// secrets such as the database URI are kept, or probe its network.
+var unsafeFuncs = []string{"env", "expandenv", "getHostByName"}
+
+// Template holds the templates of a channel's messages. Empty templates
+// fall back to the defaults of the driver's format.
+type Template struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+// SampleAlert returns the alert used to preview and check templates.
+func SampleAlert() *Alert {
+	return &Alert{
+		CheckName: "CPU usage",
+		Level:     "crit",
+		Message:   "CPU usage on server01 is 97.2%",
+		Time:      time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Fields: map[string]interface{}{
+			"_check_name": "CPU usage",
+			"_level":      "crit",
+			"_message":    "CPU usage on server01 is 97.2%",
+			"usage_user":  97.2,
+		},
+		Tags:         map[string]string{"host": "server01", "cpu": "cpu-total"},
+		DashboardURL: "https://dana.example.com/dashboards/65a1f0c2e4b0a1b2c3d4e5f6",
+		Channel:      "ops",
+	}
+}
+
+// Render renders the alert with the channel's templates in the given format.
+// HTML templates escape the alert's values automatically, Markdown templates
+// escape them with the md and mdurl functions.
+func Render(format Format, tmpl Template, alert *Alert) (*Message, error) {
+	if tmpl.Title == "" {
+		tmpl.Title = defaultTitle
+	}
+	if tmpl.Body == "" {
+		switch format {
+		case FormatMarkdown:
+			tmpl.Body = defaultMarkdownBody
+		case FormatHTML:
+			tmpl.Body = defaultHTMLBody
+		default:
+			tmpl.Body = defaultTextBody
+		}
+	}
+
+	// Titles end up in subjects and card titles, which are plain text
+	title, err := renderText("title", tmpl.Title, alert)
+	if err != nil {
+		return nil, err
+	}
+	var body string
+	if format == FormatHTML {
+		body, err = renderHTML("body", tmpl.Body, alert)
+	} else {
+		body, err = renderText("body", tmpl.Body, alert)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Title:  strings.TrimSpace(title),
+		Body:   strings.TrimSpace(body),
+		Format: format,
+		Alert:  alert,
+	}, nil
+}
+
+func renderText(name, text string, alert *Alert) (string, error) {
+	funcs := sprig.TxtFuncMap()
+	for _, name := range unsafeFuncs {
+		delete(funcs, name)
+	}
+	funcs["md"] = markdownEscaper.Replace
+	funcs["mdurl"] = markdownURLEscaper.Replace
+
+	t, err := texttemplate.New(name).Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parsing %s template: %w", name, err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, alert); err != nil {
+		return "", fmt.Errorf("executing %s template: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+func renderHTML(name, text string, alert *Alert) (string, error) {
+	funcs := sprig.HtmlFuncMap()
+	for _, name := range unsafeFuncs {
+		delete(funcs, name)
+	}
+
+	t, err := htmltemplate.New(name).Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parsing %s template: %w", name, err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, alert); err != nil {
+		return "", fmt.Errorf("executing %s template: %w", name, err)
+	}
+	return b.String(), nil
+}
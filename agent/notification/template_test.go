@@ -0,0 +1,39 @@
+package notification
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderUnsafeFuncs(t *testing.T) {
+	t.Setenv("DANA_TEMPLATE_SECRET", "hunter2")
+
+	templates := []Template{
+		{Body: `{{ env "DANA_TEMPLATE_SECRET" }}`},
+		{Body: `{{ expandenv "$DANA_TEMPLATE_SECRET" }}`},
+		{Title: `{{ env "DANA_TEMPLATE_SECRET" }}`},
+		{Body: `{{ getHostByName "localhost" }}`},
+	}
+	for _, format := range []Format{FormatText, FormatMarkdown, FormatHTML} {
+		for _, tmpl := range templates {
+			_, err := Render(format, tmpl, SampleAlert())
+			require.ErrorContains(t, err, "not defined", "%s: %+v", format, tmpl)
+		}
+	}
+}
+
+func TestRenderDefaults(t *testing.T) {
+	msg, err := Render(FormatText, Template{}, SampleAlert())
+	require.NoError(t, err)
+	require.Equal(t, "[CRIT] CPU usage", msg.Title)
+	require.Contains(t, msg.Body, "message: CPU usage on server01 is 97.2%")
+
+	msg, err = Render(FormatMarkdown, Template{}, SampleAlert())
+	require.NoError(t, err)
+	require.Contains(t, msg.Body, `CPU usage on server01 is 97\.2%`)
+
+	msg, err = Render(FormatHTML, Template{Body: `<p>{{ .Tags.host | upper }} {{ "<b>" }}</p>`}, SampleAlert())
+	require.NoError(t, err)
+	require.Equal(t, "<p>SERVER01 &lt;b&gt;</p>", msg.Body)
+}
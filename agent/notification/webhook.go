@@ -0,0 +1,52 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// WebhookConfig configures the generic webhook driver, which sends the
+// alert as a JSON object together with the rendered title and text.
+type WebhookConfig struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type webhook struct {
+	WebhookConfig
+}
+
+func newWebhook(config map[string]interface{}) (Notifier, error) {
+	w := &webhook{}
+	if err := decodeConfig(config, &w.WebhookConfig); err != nil {
+		return nil, err
+	}
+	if err := checkURL("url", w.URL); err != nil {
+		return nil, err
+	}
+	w.Method = strings.ToUpper(w.Method)
+	switch w.Method {
+	case "":
+		w.Method = http.MethodPost
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return nil, errors.New("method must be POST, PUT or PATCH")
+	}
+	return w, nil
+}
+
+func (w *webhook) Send(ctx context.Context, msg *Message) error {
+	payload := struct {
+		*Alert
+		Title string `json:"title"`
+		Text  string `json:"text"`
+	}{msg.Alert, msg.Title, msg.Body}
+	return sendJSON(ctx, w.Method, w.URL, w.Headers, payload)
+}
+
+func init() {
+	Add("webhook", FormatText, newWebhook)
+}
@@ -0,0 +1,315 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/time/rate"
+
+	"Dana/agent/model"
+	"Dana/agent/notification"
+)
+
+// Settings of the notification delivery workers. A delivery failing
+// temporarily is retried after minDeliveryBackoff, doubling up to
+// maxDeliveryBackoff, until maxDeliveryAttempts were made.
+const (
+	notificationWorkers  = 4
+	deliveryPollInterval = time.Second
+	deliveryLease        = 2 * notificationTimeout
+	maxDeliveryAttempts  = 8
+	minDeliveryBackoff   = 5 * time.Second
+	maxDeliveryBackoff   = time.Hour
+)
+
+// Default rate limits in messages per minute. Telegram and Bale bots may
+// send about 20 messages a minute to a group.
+const (
+	defaultRateLimit = 60
+	botRateLimit     = 20
+)
+
+// notificationQueue holds the state the delivery workers share. Deliveries
+// themselves are kept in the NotificationQueueRepo, so they survive
+// restarts.
+type notificationQueue struct {
+	wake chan struct{}
+
+	sync.Mutex
+	limiters map[string]*channelLimiter
+}
+
+// channelLimiter limits the deliveries to a channel. Deliveries are held
+// back until the end of a pause a destination asked for.
+type channelLimiter struct {
+	perMinute int
+	limiter   *rate.Limiter
+	paused    time.Time
+}
+
+func newNotificationQueue() *notificationQueue {
+	return &notificationQueue{
+		wake:     make(chan struct{}, 1),
+		limiters: make(map[string]*channelLimiter),
+	}
+}
+
+// notify wakes a worker to deliver a new notification right away.
+func (q *notificationQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// reserve takes a slot for a message to the channel. If the channel is
+// paused or over its rate limit nothing is taken and the time to wait is
+// returned.
+func (q *notificationQueue) reserve(n *model.Notification, now time.Time) time.Duration {
+	q.Lock()
+	defer q.Unlock()
+
+	l := q.limiter(n)
+	if now.Before(l.paused) {
+		return l.paused.Sub(now)
+	}
+	r := l.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// pause holds back the deliveries to a channel until the given time.
+func (q *notificationQueue) pause(n *model.Notification, until time.Time) {
+	q.Lock()
+	defer q.Unlock()
+
+	if l := q.limiter(n); until.After(l.paused) {
+		l.paused = until
+	}
+}
+
+// limiter returns the limiter of a channel, replacing it if the channel's
+// rate limit changed. The lock must be held.
+func (q *notificationQueue) limiter(n *model.Notification) *channelLimiter {
+	perMinute := n.RateLimit
+	if perMinute == 0 {
+		perMinute = defaultRateLimit
+		if driver, _ := channelDriver(n); driver == "telegram" || driver == "bale" {
+			perMinute = botRateLimit
+		}
+	}
+
+	l, found := q.limiters[n.ChannelName]
+	if !found || l.perMinute != perMinute {
+		paused := time.Time{}
+		if found {
+			paused = l.paused
+		}
+		l = &channelLimiter{
+			perMinute: perMinute,
+			limiter:   rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), 1),
+			paused:    paused,
+		}
+		q.limiters[n.ChannelName] = l
+	}
+	return l
+}
+
+// runNotificationWorkers delivers queued notifications until the context is
+// done.
+func (a *Server) runNotificationWorkers(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < notificationWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.runNotificationWorker(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (a *Server) runNotificationWorker(ctx context.Context) {
+	ticker := time.NewTicker(deliveryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Work through everything due before waiting again
+		for a.deliverNext(ctx) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-a.deliveries.wake:
+		}
+	}
+}
+
+// deliverNext claims the delivery due first and attempts it. It returns
+// false if nothing was due.
+func (a *Server) deliverNext(ctx context.Context) bool {
+	delivery, err := a.NotificationQueueRepo.Claim(ctx, time.Now(), deliveryLease)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) && ctx.Err() == nil {
+			log.Printf("E! [agent] Claiming notification failed: %v", err)
+		}
+		return false
+	}
+	a.deliver(ctx, delivery)
+	return ctx.Err() == nil
+}
+
+// deliver attempts a claimed delivery and records the outcome. Deliveries
+// the channel's rate limit holds back are postponed without an attempt.
+func (a *Server) deliver(ctx context.Context, delivery *model.NotificationDelivery) {
+	n, err := a.NotificationRepo.GetNotification(ctx, delivery.ChannelName)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		// The delivery is claimed again once the lease ends
+		log.Printf("E! [agent] Retrieving channel %q failed: %v", delivery.ChannelName, err)
+		return
+	}
+	var notifier notification.Notifier
+	if err == nil {
+		notifier, err = a.channelNotifier(n)
+	} else {
+		err = errors.New("channel not found")
+	}
+	if err != nil {
+		a.recordAttempt(ctx, delivery, model.DeliveryAttempt{
+			Time:  time.Now(),
+			Error: "invalid channel: " + err.Error(),
+		}, false)
+		return
+	}
+
+	now := time.Now()
+	if wait := a.deliveries.reserve(n, now); wait > 0 {
+		if err := a.NotificationQueueRepo.Postpone(ctx, delivery.ID, now.Add(wait)); err != nil {
+			log.Printf("E! [agent] Postponing notification %s failed: %v", delivery.ID.Hex(), err)
+		}
+		return
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, notificationTimeout)
+	err = notifier.Send(sendCtx, deliveryMessage(delivery))
+	cancel()
+	if err != nil && ctx.Err() != nil {
+		// Shutting down, the delivery is claimed again after a restart
+		return
+	}
+
+	attempt := model.DeliveryAttempt{
+		Time:       now,
+		DurationMS: time.Since(now).Milliseconds(),
+	}
+	temporary := true
+	if err != nil {
+		attempt.Error = err.Error()
+		var deliveryErr *notification.DeliveryError
+		if errors.As(err, &deliveryErr) {
+			attempt.StatusCode = deliveryErr.StatusCode
+			attempt.RetryAfterMS = deliveryErr.RetryAfter.Milliseconds()
+			temporary = deliveryErr.Temporary
+			if deliveryErr.RetryAfter > 0 {
+				a.deliveries.pause(n, now.Add(deliveryErr.RetryAfter))
+			}
+		}
+	}
+	a.recordAttempt(ctx, delivery, attempt, temporary)
+}
+
+// recordAttempt stores an attempt and the resulting status. Temporary
+// failures are retried with exponential backoff, or after the delay the
+// destination asked for if that is longer.
+func (a *Server) recordAttempt(ctx context.Context, delivery *model.NotificationDelivery, attempt model.DeliveryAttempt, temporary bool) {
+	status := model.DeliveryDelivered
+	next := delivery.NextAttemptAt
+	if attempt.Error != "" {
+		attempts := len(delivery.Attempts) + 1
+		status = model.DeliveryFailed
+		if temporary && attempts < delivery.MaxAttempts {
+			status = model.DeliveryPending
+			wait := max(deliveryBackoff(attempts), time.Duration(attempt.RetryAfterMS)*time.Millisecond)
+			next = attempt.Time.Add(wait)
+		} else {
+			log.Printf("W! [agent] Notification %s to %q failed after %d attempts: %s",
+				delivery.ID.Hex(), delivery.ChannelName, attempts, attempt.Error)
+		}
+	}
+	if err := a.NotificationQueueRepo.RecordAttempt(ctx, delivery.ID, attempt, status, next); err != nil {
+		log.Printf("E! [agent] Recording attempt of notification %s failed: %v", delivery.ID.Hex(), err)
+	}
+}
+
+// deliveryBackoff returns the time to wait after the given number of failed
+// attempts. Up to a fifth is added at random, so deliveries failing together
+// are not retried together.
+func deliveryBackoff(attempts int) time.Duration {
+	d := maxDeliveryBackoff
+	if attempts < 20 {
+		d = min(minDeliveryBackoff<<(attempts-1), maxDeliveryBackoff)
+	}
+	return d + rand.N(d/5+1)
+}
+
+// newDelivery returns a message queued for a channel.
+func newDelivery(n *model.Notification, msg *notification.Message, author string) *model.NotificationDelivery {
+	driver, _ := channelDriver(n)
+	now := time.Now()
+	return &model.NotificationDelivery{
+		ChannelName:   n.ChannelName,
+		Driver:        driver,
+		Title:         msg.Title,
+		Body:          msg.Body,
+		Format:        string(msg.Format),
+		Alert:         alertMap(msg.Alert),
+		Status:        model.DeliveryPending,
+		MaxAttempts:   maxDeliveryAttempts,
+		NextAttemptAt: now,
+		Attempts:      []model.DeliveryAttempt{},
+		Author:        author,
+		CreatedAt:     now,
+	}
+}
+
+// deliveryMessage returns the message of a queued delivery.
+func deliveryMessage(delivery *model.NotificationDelivery) *notification.Message {
+	msg := &notification.Message{
+		Title:  delivery.Title,
+		Body:   delivery.Body,
+		Format: notification.Format(delivery.Format),
+	}
+	if delivery.Alert != nil {
+		alert := new(notification.Alert)
+		if b, err := json.Marshal(normalizeValue(delivery.Alert)); err == nil && json.Unmarshal(b, alert) == nil {
+			msg.Alert = alert
+		}
+	}
+	return msg
+}
+
+// alertMap stores an alert the way it is sent by webhooks.
+func alertMap(alert *notification.Alert) map[string]interface{} {
+	if alert == nil {
+		return nil
+	}
+	b, err := json.Marshal(alert)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil
+	}
+	return m
+}
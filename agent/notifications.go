@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"Dana/agent/model"
+	"Dana/agent/notification"
+)
+
+// notificationTimeout limits delivering a single notification.
+const notificationTimeout = 30 * time.Second
+
+// errNoDriver is returned for channels that name no driver and cannot be
+// told apart by their name either.
+var errNoDriver = errors.New("channel has no driver")
+
+// notificationKeys are the keys of a notification payload controlling the
+// delivery rather than describing the alert.
+var notificationKeys = map[string]bool{
+	"id":              true,
+	"channel_name":    true,
+	"driver":          true,
+	"config":          true,
+	"chat_id":         true,
+	"template":        true,
+	"tags":            true,
+	"annotate":        true,
+	"annotation_tags": true,
+	"dashboard_id":    true,
+	"panel_index":     true,
+}
+
+// channelDriver returns the driver and config of a channel. Channels stored
+// before drivers existed pick the Telegram or Bale bot by their name.
+func channelDriver(n *model.Notification) (string, map[string]interface{}) {
+	if n.Driver != "" {
+		config, _ := normalizeValue(n.Config).(map[string]interface{})
+		if config == nil {
+			config = make(map[string]interface{})
+		}
+		return n.Driver, config
+	}
+
+	config := map[string]interface{}{"chat_id": n.ChatID}
+	switch {
+	case strings.Contains(n.ChannelName, "telegram"):
+		return "telegram", config
+	case strings.Contains(n.ChannelName, "bale"):
+		return "bale", config
+	}
+	return "", nil
+}
+
+// channelNotifier creates the notifier of a channel. Bot channels without a
+// token of their own use the bot token of the server config.
+func (a *Server) channelNotifier(n *model.Notification) (notification.Notifier, error) {
+	driver, config := channelDriver(n)
+	if driver == "" {
+		return nil, errNoDriver
+	}
+
+	if _, found := config["token"]; !found {
+		switch driver {
+		case "telegram":
+			config["token"] = a.Config.ServerConfig.TelegramToken
+		case "bale":
+			config["token"] = a.Config.ServerConfig.BaleToken
+		}
+	}
+	return notification.New(driver, config)
+}
+
+// newAlert returns the alert described by a notification payload. raw is the
+// payload as sent, so fields added by InfluxDB such as _check_id or
+// _notification_rule_name are kept. Keys not starting with an underscore and
+// holding strings are taken as the tags of the alerting series.
+func (a *Server) newAlert(notif *model.Notification, raw map[string]interface{}) *notification.Alert {
+	alert := &notification.Alert{
+		CheckName: notif.CheckName,
+		Level:     notif.Level,
+		Message:   notif.Message,
+		Time:      time.Now(),
+		Fields:    make(map[string]interface{}, len(raw)),
+		Tags:      make(map[string]string, len(notif.Tags)),
+		Channel:   notif.ChannelName,
+	}
+	for key, value := range raw {
+		if notificationKeys[key] {
+			continue
+		}
+		alert.Fields[key] = value
+		if s, ok := value.(string); ok && !strings.HasPrefix(key, "_") {
+			alert.Tags[key] = s
+		}
+	}
+	for key, value := range notif.Tags {
+		alert.Tags[key] = value
+	}
+	for _, key := range []string{"_time", "_status_timestamp"} {
+		if s, ok := raw[key].(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				alert.Time = t
+				break
+			}
+		}
+	}
+	if notif.DashboardID != nil {
+		alert.DashboardURL = a.dashboardURL(*notif.DashboardID)
+	}
+	return alert
+}
+
+// dashboardURL returns the link to a dashboard. Without an external URL in
+// the server config the link is relative.
+func (a *Server) dashboardURL(id primitive.ObjectID) string {
+	return strings.TrimSuffix(a.Config.ServerConfig.ExternalURL, "/") + "/dashboards/" + id.Hex()
+}
+
+// renderNotification renders an alert with the templates of a channel in the
+// format of the channel's driver.
+func renderNotification(n *model.Notification, alert *notification.Alert) (*notification.Message, error) {
+	driver, _ := channelDriver(n)
+	var tmpl notification.Template
+	if n.Template != nil {
+		tmpl = notification.Template{Title: n.Template.Title, Body: n.Template.Body}
+	}
+	return notification.Render(notification.Formats[driver], tmpl, alert)
+}
+
+// queueAlert renders an alert for a channel and queues it for delivery.
+func (a *Server) queueAlert(ctx context.Context, channelName string, alert *notification.Alert, author string) (*model.NotificationDelivery, error) {
+	n, err := a.NotificationRepo.GetNotification(ctx, channelName)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving channel: %w", err)
+	}
+	msg, err := renderNotification(n, alert)
+	if err != nil {
+		return nil, err
+	}
+	return a.enqueueDelivery(ctx, n, msg, author)
+}
+
+// enqueueDelivery queues a rendered message for a channel. Messages matching
+// an active silence are recorded as suppressed instead, so the history shows
+// why they were not sent.
+func (a *Server) enqueueDelivery(ctx context.Context, n *model.Notification, msg *notification.Message, author string) (*model.NotificationDelivery, error) {
+	delivery := newDelivery(n, msg, author)
+	silence, err := a.activeSilence(ctx, msg.Alert, delivery.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving silences: %w", err)
+	}
+	if silence != nil {
+		delivery.Status = model.DeliverySuppressed
+		delivery.SilenceID = &silence.ID
+		delivery.Reason = fmt.Sprintf("silenced by %s: %s", silence.CreatedBy, silence.Comment)
+	}
+
+	if err := a.NotificationQueueRepo.Enqueue(ctx, delivery); err != nil {
+		return nil, err
+	}
+	if silence != nil {
+		log.Printf("I! [agent] Notification %s to %q suppressed by silence %s, %s",
+			delivery.ID.Hex(), n.ChannelName, silence.ID.Hex(), delivery.Reason)
+		return delivery, nil
+	}
+	a.deliveries.notify()
+	return delivery, nil
+}
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"Dana/agent/model"
+)
+
+type AlertRuleRepo interface {
+	// CreateIndexes creates the indexes used to list alert rules
+	CreateIndexes(ctx context.Context) error
+	// CreateRule stores a new alert rule
+	CreateRule(ctx context.Context, rule *model.AlertRule) (primitive.ObjectID, error)
+	// GetRule gets an alert rule by id
+	GetRule(ctx context.Context, id string) (*model.AlertRule, error)
+	// GetRules gets all alert rules
+	GetRules(ctx context.Context) ([]*model.AlertRule, error)
+	// UpdateRule replaces an alert rule by id, keeping its author and
+	// creation time, and returns the updated rule
+	UpdateRule(ctx context.Context, id string, rule *model.AlertRule) (*model.AlertRule, error)
+	// DeleteRule deletes an alert rule by id
+	DeleteRule(ctx context.Context, id string) error
+	// ListRules gets a page of the alert rules, by name by default
+	ListRules(ctx context.Context, opts model.ListOptions) (*model.Page[*model.AlertRule], error)
+}
+
+type alertRuleRepo struct {
+	collection *mongo.Collection
+}
+
+func NewAlertRuleRepo(client *mongo.Client, databaseName, collectionName string) AlertRuleRepo {
+	collection := client.Database(databaseName).Collection(collectionName)
+	return &alertRuleRepo{
+		collection: collection,
+	}
+}
+
+func (r *alertRuleRepo) CreateIndexes(ctx context.Context) error {
+	return createIndexes(ctx, r.collection, "name", "measurement")
+}
+
+func (r *alertRuleRepo) CreateRule(ctx context.Context, rule *model.AlertRule) (primitive.ObjectID, error) {
+	result, err := r.collection.InsertOne(ctx, rule)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (r *alertRuleRepo) GetRule(ctx context.Context, id string) (*model.AlertRule, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var rule model.AlertRule
+	if err := r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *alertRuleRepo) GetRules(ctx context.Context) ([]*model.AlertRule, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer func(cursor *mongo.Cursor, ctx context.Context) {
+		err := cursor.Close(ctx)
+		if err != nil {
+			return
+		}
+	}(cursor, ctx)
+
+	var rules []*model.AlertRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (r *alertRuleRepo) UpdateRule(ctx context.Context, id string, rule *model.AlertRule) (*model.AlertRule, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{"$set": bson.M{
+		"name":        rule.Name,
+		"type":        rule.Type,
+		"measurement": rule.Measurement,
+		"field":       rule.Field,
+		"counter":     rule.Counter,
+		"tags":        rule.Tags,
+		"group_by":    rule.GroupBy,
+		"warn":        rule.Warn,
+		"crit":        rule.Crit,
+		"channels":    rule.Channels,
+		"disabled":    rule.Disabled,
+		"updated_at":  rule.UpdatedAt,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated model.AlertRule
+	if err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID}, update, opts).Decode(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (r *alertRuleRepo) DeleteRule(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *alertRuleRepo) ListRules(ctx context.Context, opts model.ListOptions) (*model.Page[*model.AlertRule], error) {
+	filter := bson.M{}
+	if opts.Query != "" {
+		filter = searchFilter(opts.Query, "name", "measurement", "field")
+	}
+
+	return findPage[*model.AlertRule](ctx, r.collection, filter, opts, map[string]string{
+		"name":       "name",
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	}, "name")
+}
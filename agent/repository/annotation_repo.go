@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"Dana/agent/model"
+)
+
+type AnnotationRepo interface {
+	// CreateIndexes creates the indexes used to query annotations
+	CreateIndexes(ctx context.Context) error
+	// CreateAnnotation stores a new annotation
+	CreateAnnotation(ctx context.Context, annotation *model.Annotation) (primitive.ObjectID, error)
+	// GetAnnotation gets an annotation by id
+	GetAnnotation(ctx context.Context, id string) (*model.Annotation, error)
+	// UpdateAnnotation replaces the time, text, tags and scope of an
+	// annotation by id and returns the updated annotation
+	UpdateAnnotation(ctx context.Context, id string, annotation *model.Annotation) (*model.Annotation, error)
+	// DeleteAnnotation deletes an annotation by id
+	DeleteAnnotation(ctx context.Context, id string) error
+	// DeleteDashboardAnnotations deletes all annotations of a dashboard
+	DeleteDashboardAnnotations(ctx context.Context, dashboardID primitive.ObjectID) error
+	// ListAnnotations gets a page of the annotations matching the filter,
+	// latest first by default
+	ListAnnotations(ctx context.Context, filter model.AnnotationFilter, opts model.ListOptions) (*model.Page[*model.Annotation], error)
+}
+
+type annotationRepo struct {
+	collection *mongo.Collection
+}
+
+func NewAnnotationRepo(client *mongo.Client, databaseName, collectionName string) AnnotationRepo {
+	collection := client.Database(databaseName).Collection(collectionName)
+	return &annotationRepo{
+		collection: collection,
+	}
+}
+
+func (r *annotationRepo) CreateIndexes(ctx context.Context) error {
+	return createIndexes(ctx, r.collection, "time", "tags", "dashboard_id")
+}
+
+func (r *annotationRepo) CreateAnnotation(ctx context.Context, annotation *model.Annotation) (primitive.ObjectID, error) {
+	result, err := r.collection.InsertOne(ctx, annotation)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (r *annotationRepo) GetAnnotation(ctx context.Context, id string) (*model.Annotation, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var annotation model.Annotation
+	if err := r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&annotation); err != nil {
+		return nil, err
+	}
+	return &annotation, nil
+}
+
+func (r *annotationRepo) UpdateAnnotation(ctx context.Context, id string, annotation *model.Annotation) (*model.Annotation, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{
+		"time": annotation.Time,
+		"text": annotation.Text,
+	}
+	// Optional fields left out of the update are removed
+	unset := bson.M{}
+	if annotation.TimeEnd != nil {
+		set["time_end"] = *annotation.TimeEnd
+	} else {
+		unset["time_end"] = ""
+	}
+	if annotation.DashboardID != nil {
+		set["dashboard_id"] = *annotation.DashboardID
+	} else {
+		unset["dashboard_id"] = ""
+	}
+	if annotation.PanelIndex != nil {
+		set["panel_index"] = *annotation.PanelIndex
+	} else {
+		unset["panel_index"] = ""
+	}
+	if len(annotation.Tags) > 0 {
+		set["tags"] = annotation.Tags
+	} else {
+		unset["tags"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var updated model.Annotation
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID}, update, opts).Decode(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (r *annotationRepo) DeleteAnnotation(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *annotationRepo) DeleteDashboardAnnotations(ctx context.Context, dashboardID primitive.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"dashboard_id": dashboardID})
+	return err
+}
+
+func (r *annotationRepo) ListAnnotations(ctx context.Context, filter model.AnnotationFilter, opts model.ListOptions) (*model.Page[*model.Annotation], error) {
+	conditions := bson.A{}
+	if opts.Query != "" {
+		conditions = append(conditions, searchFilter(opts.Query, "text"))
+	}
+	if len(opts.Tags) > 0 {
+		conditions = append(conditions, bson.M{"tags": bson.M{"$all": opts.Tags}})
+	}
+
+	// An annotation overlaps the window if it starts before the window ends
+	// and ends, or for point annotations starts, after the window starts.
+	if !filter.To.IsZero() {
+		conditions = append(conditions, bson.M{"time": bson.M{"$lte": filter.To}})
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"time_end": bson.M{"$gte": filter.From}},
+			bson.M{"time_end": bson.M{"$exists": false}, "time": bson.M{"$gte": filter.From}},
+		}})
+	}
+
+	if filter.DashboardID != nil {
+		scope := bson.A{
+			bson.M{"dashboard_id": bson.M{"$exists": false}},
+		}
+		if filter.PanelIndex != nil {
+			scope = append(scope, bson.M{
+				"dashboard_id": *filter.DashboardID,
+				"$or": bson.A{
+					bson.M{"panel_index": bson.M{"$exists": false}},
+					bson.M{"panel_index": *filter.PanelIndex},
+				},
+			})
+		} else {
+			scope = append(scope, bson.M{"dashboard_id": *filter.DashboardID})
+		}
+		conditions = append(conditions, bson.M{"$or": scope})
+	}
+	if len(filter.Hidden) > 0 {
+		conditions = append(conditions, bson.M{"dashboard_id": bson.M{"$nin": filter.Hidden}})
+	}
+
+	query := bson.M{}
+	if len(conditions) > 0 {
+		query = bson.M{"$and": conditions}
+	}
+
+	return findPage[*model.Annotation](ctx, r.collection, query, opts, map[string]string{
+		"time":       "time",
+		"created_at": "created_at",
+	}, "-time")
+}
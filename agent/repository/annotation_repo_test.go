@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"Dana/agent/model"
+)
+
+func TestAnnotationRepoListAnnotations(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	from := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	dashboardID, hidden := primitive.NewObjectID(), primitive.NewObjectID()
+	panel := 3
+
+	// list runs ListAnnotations and returns the conditions of the query
+	list := func(mt *mtest.T, filter model.AnnotationFilter, opts model.ListOptions) []bson.M {
+		mt.AddMockResponses(countResponse(0), mtest.CreateCursorResponse(0, "db.annotations", mtest.FirstBatch))
+		_, err := (&annotationRepo{collection: mt.Coll}).ListAnnotations(context.Background(), filter, opts)
+		require.NoError(t, err)
+
+		find := findCommand(t, mt)
+		require.Equal(t, int32(-1), find.Lookup("sort", "time").Int32())
+		var query struct {
+			And []bson.M `bson:"$and"`
+		}
+		require.NoError(t, find.Lookup("filter").Unmarshal(&query))
+		return query.And
+	}
+
+	mt.Run("everything", func(mt *mtest.T) {
+		require.Empty(t, list(mt, model.AnnotationFilter{}, model.ListOptions{}))
+	})
+
+	mt.Run("time window overlaps", func(mt *mtest.T) {
+		conditions := list(mt, model.AnnotationFilter{From: from, To: to}, model.ListOptions{})
+		require.Len(t, conditions, 2)
+		// Starts before the window ends
+		require.Equal(t, bson.M{"time": bson.M{"$lte": primitive.NewDateTimeFromTime(to)}}, conditions[0])
+		// Ends, or for points starts, after the window starts
+		require.Equal(t, bson.M{"$or": bson.A{
+			bson.M{"time_end": bson.M{"$gte": primitive.NewDateTimeFromTime(from)}},
+			bson.M{"time_end": bson.M{"$exists": false}, "time": bson.M{"$gte": primitive.NewDateTimeFromTime(from)}},
+		}}, conditions[1])
+	})
+
+	mt.Run("tags", func(mt *mtest.T) {
+		conditions := list(mt, model.AnnotationFilter{}, model.ListOptions{Tags: []string{"deploy", "prod"}})
+		require.Equal(t, []bson.M{{"tags": bson.M{"$all": bson.A{"deploy", "prod"}}}}, conditions)
+	})
+
+	mt.Run("dashboard", func(mt *mtest.T) {
+		conditions := list(mt, model.AnnotationFilter{DashboardID: &dashboardID, Hidden: []primitive.ObjectID{hidden}}, model.ListOptions{})
+		require.Equal(t, []bson.M{
+			{"$or": bson.A{
+				bson.M{"dashboard_id": bson.M{"$exists": false}},
+				bson.M{"dashboard_id": dashboardID},
+			}},
+			{"dashboard_id": bson.M{"$nin": bson.A{hidden}}},
+		}, conditions)
+	})
+
+	mt.Run("panel", func(mt *mtest.T) {
+		conditions := list(mt, model.AnnotationFilter{DashboardID: &dashboardID, PanelIndex: &panel}, model.ListOptions{})
+		require.Equal(t, []bson.M{
+			{"$or": bson.A{
+				bson.M{"dashboard_id": bson.M{"$exists": false}},
+				bson.M{
+					"dashboard_id": dashboardID,
+					"$or": bson.A{
+						bson.M{"panel_index": bson.M{"$exists": false}},
+						bson.M{"panel_index": int32(panel)},
+					},
+				},
+			}},
+		}, conditions)
+	})
+}
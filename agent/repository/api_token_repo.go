@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"Dana/agent/model"
+)
+
+type APITokenRepo interface {
+	// CreateIndexes creates the indexes used to look up and sort tokens
+	CreateIndexes(ctx context.Context) error
+	// CreateToken stores a new token
+	CreateToken(ctx context.Context, token *model.APIToken) error
+	// ListTokens gets a page of the tokens of a user
+	ListTokens(ctx context.Context, username string, opts model.ListOptions) (*model.Page[*model.APIToken], error)
+	// GetTokenByHash gets a token by the hash of its value
+	GetTokenByHash(ctx context.Context, hash string) (*model.APIToken, error)
+	// TouchToken records that a token was used
+	TouchToken(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// DeleteToken deletes a token of a user by id
+	DeleteToken(ctx context.Context, username, id string) error
+}
+
+type apiTokenRepo struct {
+	collection *mongo.Collection
+}
+
+func NewAPITokenRepo(client *mongo.Client, databaseName, collectionName string) APITokenRepo {
+	collection := client.Database(databaseName).Collection(collectionName)
+	return &apiTokenRepo{
+		collection: collection,
+	}
+}
+
+func (r *apiTokenRepo) CreateIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"hash": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.M{"username": 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return createIndexes(ctx, r.collection, "name", "created_at", "expires_at")
+}
+
+func (r *apiTokenRepo) CreateToken(ctx context.Context, token *model.APIToken) error {
+	result, err := r.collection.InsertOne(ctx, token)
+	if err != nil {
+		return err
+	}
+	token.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *apiTokenRepo) ListTokens(ctx context.Context, username string, opts model.ListOptions) (*model.Page[*model.APIToken], error) {
+	filter := bson.M{}
+	if opts.Query != "" {
+		filter = searchFilter(opts.Query, "name", "prefix")
+	}
+	filter["username"] = username
+
+	return findPage[*model.APIToken](ctx, r.collection, filter, opts, map[string]string{
+		"id":         "_id",
+		"name":       "name",
+		"created_at": "created_at",
+		"expires_at": "expires_at",
+	}, "-created_at")
+}
+
+func (r *apiTokenRepo) GetTokenByHash(ctx context.Context, hash string) (*model.APIToken, error) {
+	var token model.APIToken
+	if err := r.collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *apiTokenRepo) TouchToken(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
+
+func (r *apiTokenRepo) DeleteToken(ctx context.Context, username, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID, "username": username})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"Dana/agent/model"
+)
+
+// AuditRepo stores the audit log. Entries are never changed or deleted
+// through the repository.
+type AuditRepo interface {
+	// CreateIndexes creates the indexes used to query the audit log
+	CreateIndexes(ctx context.Context) error
+	// AddEntry appends an entry to the audit log
+	AddEntry(ctx context.Context, entry *model.AuditEntry) error
+	// ListEntries gets a page of the entries matching the filter, latest first by default
+	ListEntries(ctx context.Context, filter model.AuditFilter, opts model.ListOptions) (*model.Page[*model.AuditEntry], error)
+}
+
+type auditRepo struct {
+	collection *mongo.Collection
+}
+
+func NewAuditRepo(client *mongo.Client, databaseName, collectionName string) AuditRepo {
+	collection := client.Database(databaseName).Collection(collectionName)
+	return &auditRepo{
+		collection: collection,
+	}
+}
+
+func (r *auditRepo) CreateIndexes(ctx context.Context) error {
+	return createIndexes(ctx, r.collection, "time", "username")
+}
+
+func (r *auditRepo) AddEntry(ctx context.Context, entry *model.AuditEntry) error {
+	_, err := r.collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (r *auditRepo) ListEntries(ctx context.Context, filter model.AuditFilter, opts model.ListOptions) (*model.Page[*model.AuditEntry], error) {
+	query := bson.M{}
+	if opts.Query != "" {
+		query = searchFilter(opts.Query, "path", "target_id")
+	}
+	timeRange := bson.M{}
+	if !filter.From.IsZero() {
+		timeRange["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		timeRange["$lte"] = filter.To
+	}
+	if len(timeRange) > 0 {
+		query["time"] = timeRange
+	}
+	if filter.Username != "" {
+		query["username"] = filter.Username
+	}
+	if filter.Method != "" {
+		query["method"] = filter.Method
+	}
+	if filter.Route != "" {
+		query["route"] = filter.Route
+	}
+
+	return findPage[*model.AuditEntry](ctx, r.collection, query, opts, map[string]string{
+		"time":     "time",
+		"username": "username",
+	}, "-time")
+}
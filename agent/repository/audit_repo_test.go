@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"Dana/agent/model"
+)
+
+func TestAuditRepoListEntries(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	from := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	mt.Run("filters", func(mt *mtest.T) {
+		mt.AddMockResponses(countResponse(0), mtest.CreateCursorResponse(0, "db.audit_log", mtest.FirstBatch))
+		filter := model.AuditFilter{From: from, To: to, Username: "alice", Method: "DELETE", Route: "/api/v1/users/:id"}
+		_, err := (&auditRepo{collection: mt.Coll}).ListEntries(context.Background(), filter, model.ListOptions{})
+		require.NoError(t, err)
+
+		find := findCommand(t, mt)
+		require.Equal(t, from, find.Lookup("filter", "time", "$gte").Time().UTC())
+		require.Equal(t, to, find.Lookup("filter", "time", "$lte").Time().UTC())
+		require.Equal(t, "alice", find.Lookup("filter", "username").StringValue())
+		require.Equal(t, "DELETE", find.Lookup("filter", "method").StringValue())
+		require.Equal(t, "/api/v1/users/:id", find.Lookup("filter", "route").StringValue())
+		// Latest first
+		require.Equal(t, int32(-1), find.Lookup("sort", "time").Int32())
+	})
+
+	mt.Run("open time range", func(mt *mtest.T) {
+		mt.AddMockResponses(countResponse(0), mtest.CreateCursorResponse(0, "db.audit_log", mtest.FirstBatch))
+		_, err := (&auditRepo{collection: mt.Coll}).ListEntries(context.Background(), model.AuditFilter{From: from}, model.ListOptions{})
+		require.NoError(t, err)
+
+		find := findCommand(t, mt)
+		require.Equal(t, from, find.Lookup("filter", "time", "$gte").Time().UTC())
+		_, err = find.LookupErr("filter", "time", "$lte")
+		require.Error(t, err)
+		_, err = find.LookupErr("filter", "username")
+		require.Error(t, err)
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"Dana/agent/model"
 )
@@ -15,12 +16,18 @@ type DashboardRepo interface {
 	CreateDashboard(ctx context.Context, dashboard *model.Dashboard) (primitive.ObjectID, error)
 	// GetDashboard gets a dashboard by id
 	GetDashboard(ctx context.Context, id string) (*model.Dashboard, error)
-	// UpdateDashboard updates a dashboard by id
-	UpdateDashboard(ctx context.Context, dashboard *model.Dashboard, dashboardID primitive.ObjectID) error
+	// UpdateDashboard updates the non-empty fields of a dashboard by id, bumps
+	// its version and returns the updated dashboard
+	UpdateDashboard(ctx context.Context, dashboard *model.Dashboard, dashboardID primitive.ObjectID) (*model.Dashboard, error)
+	// RestoreDashboard replaces a dashboard by id with the given snapshot,
+	// bumps its version and returns the restored dashboard
+	RestoreDashboard(ctx context.Context, snapshot *model.Dashboard, dashboardID primitive.ObjectID) (*model.Dashboard, error)
 	// DeleteDashboard deletes a dashboard by id
 	DeleteDashboard(ctx context.Context, id string) error
-	//GetDashboards gets all dashboards
-	GetDashboards(ctx context.Context) ([]*model.Dashboard, error)
+	// ListDashboards gets a page of dashboards, leaving out the hidden ones
+	ListDashboards(ctx context.Context, opts model.ListOptions, hidden []primitive.ObjectID) (*model.Page[*model.Dashboard], error)
+	// CreateIndexes creates the indexes used to sort and search dashboards
+	CreateIndexes(ctx context.Context) error
 }
 
 func NewDashboardRepo(client *mongo.Client, databaseName, collectionName string) DashboardRepo {
@@ -40,6 +47,10 @@ func (d *dashboardRepo) CreateDashboard(ctx context.Context, dashboard *model.Da
 		"name":      dashboard.Name,
 		"panels":    dashboard.Panels,
 		"variables": dashboard.Variables,
+		"version":   1,
+	}
+	if len(dashboard.Tags) > 0 {
+		document["tags"] = dashboard.Tags
 	}
 
 	// Insert the document into the collection
@@ -67,7 +78,7 @@ func (d *dashboardRepo) GetDashboard(ctx context.Context, id string) (*model.Das
 	return &dashboard, nil
 }
 
-func (d *dashboardRepo) UpdateDashboard(ctx context.Context, dashboard *model.Dashboard, dashboardID primitive.ObjectID) error {
+func (d *dashboardRepo) UpdateDashboard(ctx context.Context, dashboard *model.Dashboard, dashboardID primitive.ObjectID) (*model.Dashboard, error) {
 	filter := bson.M{"_id": dashboardID}
 	updateFields := bson.M{}
 
@@ -80,15 +91,39 @@ func (d *dashboardRepo) UpdateDashboard(ctx context.Context, dashboard *model.Da
 	if dashboard.Variables != nil && len(dashboard.Variables) > 0 {
 		updateFields["variables"] = dashboard.Variables
 	}
+	if dashboard.Tags != nil {
+		updateFields["tags"] = dashboard.Tags
+	}
 
 	if len(updateFields) == 0 {
-		return nil
+		return d.GetDashboard(ctx, dashboardID.Hex())
 	}
 
-	update := bson.M{"$set": updateFields}
+	update := bson.M{"$set": updateFields, "$inc": bson.M{"version": 1}}
+	return d.findAndUpdate(ctx, filter, update)
+}
 
-	_, err := d.collection.UpdateOne(ctx, filter, update)
-	return err
+func (d *dashboardRepo) RestoreDashboard(ctx context.Context, snapshot *model.Dashboard, dashboardID primitive.ObjectID) (*model.Dashboard, error) {
+	filter := bson.M{"_id": dashboardID}
+	update := bson.M{
+		"$set": bson.M{
+			"name":      snapshot.Name,
+			"panels":    snapshot.Panels,
+			"variables": snapshot.Variables,
+			"tags":      snapshot.Tags,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+	return d.findAndUpdate(ctx, filter, update)
+}
+
+func (d *dashboardRepo) findAndUpdate(ctx context.Context, filter, update bson.M) (*model.Dashboard, error) {
+	var dashboard model.Dashboard
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := d.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&dashboard); err != nil {
+		return nil, err
+	}
+	return &dashboard, nil
 }
 
 func (d *dashboardRepo) DeleteDashboard(ctx context.Context, id string) error {
@@ -102,32 +137,25 @@ func (d *dashboardRepo) DeleteDashboard(ctx context.Context, id string) error {
 	return err
 }
 
-func (d *dashboardRepo) GetDashboards(ctx context.Context) ([]*model.Dashboard, error) {
-	// Find all documents in the collection
-	cursor, err := d.collection.Find(ctx, bson.M{})
-	if err != nil {
-		return nil, err
+func (d *dashboardRepo) ListDashboards(ctx context.Context, opts model.ListOptions, hidden []primitive.ObjectID) (*model.Page[*model.Dashboard], error) {
+	filter := bson.M{}
+	if opts.Query != "" {
+		filter = searchFilter(opts.Query, "name")
 	}
-	defer func(cursor *mongo.Cursor, ctx context.Context) {
-		err := cursor.Close(ctx)
-		if err != nil {
-			return
-		}
-	}(cursor, ctx)
-
-	var dashboards []*model.Dashboard
-	for cursor.Next(ctx) {
-		var dashboard model.Dashboard
-		if err := cursor.Decode(&dashboard); err != nil {
-			return nil, err
-		}
-
-		dashboards = append(dashboards, &dashboard)
+	if len(opts.Tags) > 0 {
+		filter["tags"] = bson.M{"$all": opts.Tags}
 	}
-
-	if err := cursor.Err(); err != nil {
-		return nil, err
+	if len(hidden) > 0 {
+		filter["_id"] = bson.M{"$nin": hidden}
 	}
 
-	return dashboards, nil
+	return findPage[*model.Dashboard](ctx, d.collection, filter, opts, map[string]string{
+		"id":      "_id",
+		"name":    "name",
+		"version": "version",
+	}, "name")
+}
+
+func (d *dashboardRepo) CreateIndexes(ctx context.Context) error {
+	return createIndexes(ctx, d.collection, "name", "tags", "version")
 }
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"Dana/agent/model"
+)
+
+type DashboardRevisionRepo interface {
+	// CreateIndexes creates the index keeping one revision per version
+	CreateIndexes(ctx context.Context) error
+	// AddRevision stores a new revision and sets its id
+	AddRevision(ctx context.Context, revision *model.DashboardRevision) error
+	// ListRevisions gets a page of the revisions of a dashboard, latest first
+	// by default
+	ListRevisions(ctx context.Context, dashboardID string, opts model.ListOptions) (*model.Page[*model.DashboardRevision], error)
+	// GetRevision gets a revision of a dashboard by version
+	GetRevision(ctx context.Context, dashboardID string, version int) (*model.DashboardRevision, error)
+	// DeleteRevisions deletes all revisions of a dashboard
+	DeleteRevisions(ctx context.Context, dashboardID string) error
+}
+
+type dashboardRevisionRepo struct {
+	collection *mongo.Collection
+}
+
+func NewDashboardRevisionRepo(client *mongo.Client, databaseName, collectionName string) DashboardRevisionRepo {
+	collection := client.Database(databaseName).Collection(collectionName)
+	return &dashboardRevisionRepo{
+		collection: collection,
+	}
+}
+
+func (r *dashboardRevisionRepo) CreateIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "dashboard_id", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (r *dashboardRevisionRepo) AddRevision(ctx context.Context, revision *model.DashboardRevision) error {
+	revision.ID = primitive.NilObjectID
+	result, err := r.collection.InsertOne(ctx, revision)
+	if err != nil {
+		return err
+	}
+	revision.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *dashboardRevisionRepo) ListRevisions(ctx context.Context, dashboardID string, opts model.ListOptions) (*model.Page[*model.DashboardRevision], error) {
+	objectID, err := primitive.ObjectIDFromHex(dashboardID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{}
+	if opts.Query != "" {
+		filter = searchFilter(opts.Query, "author", "message")
+	}
+	filter["dashboard_id"] = objectID
+
+	return findPage[*model.DashboardRevision](ctx, r.collection, filter, opts, map[string]string{
+		"version":    "version",
+		"created_at": "created_at",
+	}, "-version")
+}
+func (r *dashboardRevisionRepo) GetRevision(ctx context.Context, dashboardID string, version int) (*model.DashboardRevision, error) {
+	objectID, err := primitive.ObjectIDFromHex(dashboardID)
+	if err != nil {
+		return nil, err
+	}
+
+	var revision model.DashboardRevision
+	err = r.collection.FindOne(ctx, bson.M{"dashboard_id": objectID, "version": version}).Decode(&revision)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func (r *dashboardRevisionRepo) DeleteRevisions(ctx context.Context, dashboardID string) error {
+	objectID, err := primitive.ObjectIDFromHex(dashboardID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.collection.DeleteMany(ctx, bson.M{"dashboard_id": objectID})
+	return err
+}
@@ -6,7 +6,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"Dana/agent/model"
 )
@@ -16,12 +15,26 @@ type FolderRepo interface {
 	CreateFolder(ctx context.Context, folder *model.Folder) (primitive.ObjectID, error)
 	// GetFolder gets a folder by id
 	GetFolder(ctx context.Context, id string) (*model.Folder, error)
-	// UpdateDashboardInFolder updates a dashboard in a folder by folder id and dashboard id
-	UpdateDashboardInFolder(ctx context.Context, folderID string, dashboardID string, dashboard *model.Dashboard) error
-	// DeleteFolder deletes a folder by id
-	DeleteFolder(ctx context.Context, id string) error
 	// GetFolders gets all folders
 	GetFolders(ctx context.Context) ([]*model.Folder, error)
+	// ListFolders gets a page of folders, leaving out the hidden ones
+	ListFolders(ctx context.Context, opts model.ListOptions, hidden []primitive.ObjectID) (*model.Page[*model.Folder], error)
+	// CreateIndexes creates the indexes used to sort and search folders
+	CreateIndexes(ctx context.Context) error
+	// RenameFolder renames a folder by id
+	RenameFolder(ctx context.Context, id string, name string) error
+	// MoveFolder sets the parent of a folder by id, nil moves it to the top level
+	MoveFolder(ctx context.Context, id string, parentID *primitive.ObjectID) error
+	// SetPermissions replaces the permissions of a folder by id
+	SetPermissions(ctx context.Context, id string, permissions []model.FolderPermission) error
+	// MoveDashboard removes a dashboard from its folder and adds it to the given one, if any
+	MoveDashboard(ctx context.Context, dashboardID primitive.ObjectID, folderID *primitive.ObjectID) error
+	// DeleteFolder deletes a folder by id, moving its dashboards and subfolders to its parent
+	DeleteFolder(ctx context.Context, id string) error
+	// GetLegacyFolders gets the folders still embedding dashboards
+	GetLegacyFolders(ctx context.Context) ([]*model.Folder, error)
+	// ConvertLegacyFolder replaces the embedded dashboards of a folder by id with references
+	ConvertLegacyFolder(ctx context.Context, id primitive.ObjectID, name string, dashboardIDs []primitive.ObjectID) error
 }
 
 func NewFolderRepo(client *mongo.Client, databaseName, collectionName string) FolderRepo {
@@ -36,8 +49,18 @@ type folderRepo struct {
 }
 
 func (f *folderRepo) CreateFolder(ctx context.Context, folder *model.Folder) (primitive.ObjectID, error) {
+	if folder.DashboardIDs == nil {
+		folder.DashboardIDs = make([]primitive.ObjectID, 0)
+	}
 	document := bson.M{
-		"dashboards": folder.Dashboards,
+		"name":          folder.Name,
+		"dashboard_ids": folder.DashboardIDs,
+	}
+	if folder.ParentID != nil {
+		document["parent_id"] = folder.ParentID
+	}
+	if len(folder.Permissions) > 0 {
+		document["permissions"] = folder.Permissions
 	}
 
 	result, err := f.collection.InsertOne(ctx, document)
@@ -64,62 +87,133 @@ func (f *folderRepo) GetFolder(ctx context.Context, id string) (*model.Folder, e
 	return &folder, nil
 }
 
-func (f *folderRepo) UpdateDashboardInFolder(ctx context.Context, folderID string, dashboardID string, dashboard *model.Dashboard) error {
-	folderObjectID, err := primitive.ObjectIDFromHex(folderID)
+func (f *folderRepo) GetFolders(ctx context.Context) ([]*model.Folder, error) {
+	return f.find(ctx, bson.M{})
+}
+
+func (f *folderRepo) ListFolders(ctx context.Context, opts model.ListOptions, hidden []primitive.ObjectID) (*model.Page[*model.Folder], error) {
+	filter := bson.M{}
+	if opts.Query != "" {
+		filter = searchFilter(opts.Query, "name")
+	}
+	if len(hidden) > 0 {
+		filter["_id"] = bson.M{"$nin": hidden}
+	}
+
+	return findPage[*model.Folder](ctx, f.collection, filter, opts, map[string]string{
+		"id":   "_id",
+		"name": "name",
+	}, "name")
+}
+
+func (f *folderRepo) CreateIndexes(ctx context.Context) error {
+	return createIndexes(ctx, f.collection, "name", "parent_id", "dashboard_ids")
+}
+
+func (f *folderRepo) RenameFolder(ctx context.Context, id string, name string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return err
 	}
+	return f.update(ctx, bson.M{"_id": objectID}, bson.M{"$set": bson.M{"name": name}})
+}
 
-	dashboardObjectID, err := primitive.ObjectIDFromHex(dashboardID)
+func (f *folderRepo) MoveFolder(ctx context.Context, id string, parentID *primitive.ObjectID) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return err
 	}
 
-	filter := bson.M{"_id": folderObjectID}
-
-	updateFields := bson.M{}
+	update := bson.M{"$unset": bson.M{"parent_id": ""}}
+	if parentID != nil {
+		update = bson.M{"$set": bson.M{"parent_id": parentID}}
+	}
+	return f.update(ctx, bson.M{"_id": objectID}, update)
+}
 
-	if dashboard.Name != "" {
-		updateFields["dashboards.$[elem].name"] = dashboard.Name
+func (f *folderRepo) SetPermissions(ctx context.Context, id string, permissions []model.FolderPermission) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
 	}
-	if dashboard.Panels != nil && len(dashboard.Panels) > 0 {
-		updateFields["dashboards.$[elem].panels"] = dashboard.Panels
+
+	update := bson.M{"$unset": bson.M{"permissions": ""}}
+	if len(permissions) > 0 {
+		update = bson.M{"$set": bson.M{"permissions": permissions}}
 	}
-	if dashboard.Variables != nil && len(dashboard.Variables) > 0 {
-		updateFields["dashboards.$[elem].variables"] = dashboard.Variables
+	return f.update(ctx, bson.M{"_id": objectID}, update)
+}
+
+func (f *folderRepo) MoveDashboard(ctx context.Context, dashboardID primitive.ObjectID, folderID *primitive.ObjectID) error {
+	if folderID != nil {
+		count, err := f.collection.CountDocuments(ctx, bson.M{"_id": folderID})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return mongo.ErrNoDocuments
+		}
 	}
 
-	if len(updateFields) == 0 {
+	_, err := f.collection.UpdateMany(ctx,
+		bson.M{"dashboard_ids": dashboardID},
+		bson.M{"$pull": bson.M{"dashboard_ids": dashboardID}},
+	)
+	if err != nil {
+		return err
+	}
+	if folderID == nil {
 		return nil
 	}
+	return f.update(ctx, bson.M{"_id": folderID}, bson.M{"$addToSet": bson.M{"dashboard_ids": dashboardID}})
+}
 
-	update := bson.M{"$set": updateFields}
-
-	arrayFilters := options.ArrayFilters{
-		Filters: []interface{}{
-			bson.M{"elem._id": dashboardObjectID},
-		},
+func (f *folderRepo) DeleteFolder(ctx context.Context, id string) error {
+	folder, err := f.GetFolder(ctx, id)
+	if err != nil {
+		return err
 	}
 
-	_, err = f.collection.UpdateOne(ctx, filter, update, &options.UpdateOptions{
-		ArrayFilters: &arrayFilters,
-	})
-	return err
-}
+	// Keep the contents by moving them one level up
+	children := bson.M{"$unset": bson.M{"parent_id": ""}}
+	if folder.ParentID != nil {
+		children = bson.M{"$set": bson.M{"parent_id": folder.ParentID}}
+		if len(folder.DashboardIDs) > 0 {
+			err := f.update(ctx, bson.M{"_id": folder.ParentID}, bson.M{
+				"$addToSet": bson.M{"dashboard_ids": bson.M{"$each": folder.DashboardIDs}},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := f.collection.UpdateMany(ctx, bson.M{"parent_id": folder.ID}, children); err != nil {
+		return err
+	}
 
-func (f *folderRepo) DeleteFolder(ctx context.Context, id string) error {
-	objectID, err := primitive.ObjectIDFromHex(id)
+	result, err := f.collection.DeleteOne(ctx, bson.M{"_id": folder.ID})
 	if err != nil {
 		return err
 	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
 
-	filter := bson.M{"_id": objectID}
-	_, err = f.collection.DeleteOne(ctx, filter)
-	return err
+func (f *folderRepo) GetLegacyFolders(ctx context.Context) ([]*model.Folder, error) {
+	return f.find(ctx, bson.M{"dashboards": bson.M{"$exists": true}})
 }
 
-func (f *folderRepo) GetFolders(ctx context.Context) ([]*model.Folder, error) {
-	cursor, err := f.collection.Find(ctx, bson.M{})
+func (f *folderRepo) ConvertLegacyFolder(ctx context.Context, id primitive.ObjectID, name string, dashboardIDs []primitive.ObjectID) error {
+	return f.update(ctx, bson.M{"_id": id}, bson.M{
+		"$set":   bson.M{"name": name, "dashboard_ids": dashboardIDs},
+		"$unset": bson.M{"dashboards": ""},
+	})
+}
+
+func (f *folderRepo) find(ctx context.Context, filter bson.M) ([]*model.Folder, error) {
+	cursor, err := f.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -146,3 +240,14 @@ func (f *folderRepo) GetFolders(ctx context.Context) ([]*model.Folder, error) {
 
 	return folders, nil
 }
+
+func (f *folderRepo) update(ctx context.Context, filter, update bson.M) error {
+	result, err := f.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
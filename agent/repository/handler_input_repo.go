@@ -11,9 +11,22 @@ import (
 )
 
 type HandlerInputRepo interface {
+	// AddServerInput stores a new input and sets its id
 	AddServerInput(context.Context, *model.HandlerInput) error
+	// GetServers gets all inputs
 	GetServers(context.Context) ([]*model.HandlerInput, error)
-	GetServersByType(context.Context, string) ([]*model.HandlerInput, error)
+	// ListServers gets a page of inputs
+	ListServers(ctx context.Context, opts model.ListOptions) (*model.Page[*model.HandlerInput], error)
+	// CreateIndexes creates the indexes used to sort and search inputs
+	CreateIndexes(ctx context.Context) error
+	// ListServersByType gets a page of the inputs of a plugin type
+	ListServersByType(ctx context.Context, serverType string, opts model.ListOptions) (*model.Page[*model.HandlerInput], error)
+	// GetServer gets an input by id
+	GetServer(ctx context.Context, id string) (*model.HandlerInput, error)
+	// UpdateServerInput replaces the name and data of an input by id
+	UpdateServerInput(ctx context.Context, id string, handlerInput *model.HandlerInput) error
+	// DeleteServerInput deletes an input by id
+	DeleteServerInput(ctx context.Context, id string) error
 }
 
 func NewHandlerInputRepo(client *mongo.Client, databaseName, collectionName string) HandlerInputRepo {
@@ -46,6 +59,36 @@ func (p *handlerInputRepo) AddServerInput(ctx context.Context, handlerInput *mod
 	return nil
 }
 
+func (p *handlerInputRepo) ListServers(ctx context.Context, opts model.ListOptions) (*model.Page[*model.HandlerInput], error) {
+	return p.list(ctx, bson.M{}, opts)
+}
+
+func (p *handlerInputRepo) ListServersByType(ctx context.Context, serverType string, opts model.ListOptions) (*model.Page[*model.HandlerInput], error) {
+	return p.list(ctx, bson.M{"type": serverType}, opts)
+}
+
+// list returns a page of the inputs matching the conditions and the search
+// of opts
+func (p *handlerInputRepo) list(ctx context.Context, conditions bson.M, opts model.ListOptions) (*model.Page[*model.HandlerInput], error) {
+	filter := bson.M{}
+	if opts.Query != "" {
+		filter = searchFilter(opts.Query, "name", "type")
+	}
+	for field, value := range conditions {
+		filter[field] = value
+	}
+
+	return findPage[*model.HandlerInput](ctx, p.collection, filter, opts, map[string]string{
+		"id":   "_id",
+		"name": "name",
+		"type": "type",
+	}, "name")
+}
+
+func (p *handlerInputRepo) CreateIndexes(ctx context.Context) error {
+	return createIndexes(ctx, p.collection, "name", "type")
+}
+
 func (p *handlerInputRepo) GetServers(ctx context.Context) ([]*model.HandlerInput, error) {
 	// Find all documents in the collection
 	cursor, err := p.collection.Find(ctx, bson.M{})
@@ -76,34 +119,52 @@ func (p *handlerInputRepo) GetServers(ctx context.Context) ([]*model.HandlerInpu
 	return servers, nil
 }
 
-func (p *handlerInputRepo) GetServersByType(ctx context.Context, serverType string) ([]*model.HandlerInput, error) {
-	// Filter documents by the "type" field
-	filter := bson.M{"type": serverType}
-
-	cursor, err := p.collection.Find(ctx, filter)
+func (p *handlerInputRepo) GetServer(ctx context.Context, id string) (*model.HandlerInput, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
-	defer func(cursor *mongo.Cursor, ctx context.Context) {
-		err := cursor.Close(ctx)
-		if err != nil {
-			return
-		}
-	}(cursor, ctx)
 
-	var servers []*model.HandlerInput
-	for cursor.Next(ctx) {
-		var handlerInput model.HandlerInput
-		if err := cursor.Decode(&handlerInput); err != nil {
-			return nil, err
-		}
+	var handlerInput model.HandlerInput
+	if err := p.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&handlerInput); err != nil {
+		return nil, err
+	}
+	return &handlerInput, nil
+}
 
-		servers = append(servers, &handlerInput)
+func (p *handlerInputRepo) UpdateServerInput(ctx context.Context, id string, handlerInput *model.HandlerInput) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
 	}
 
-	if err := cursor.Err(); err != nil {
-		return nil, err
+	update := bson.M{"$set": bson.M{
+		"name": handlerInput.Name,
+		"data": handlerInput.Data,
+	}}
+	result, err := p.collection.UpdateOne(ctx, bson.M{"_id": objectID, "type": handlerInput.Type}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
 	}
+	handlerInput.ID = objectID
+	return nil
+}
 
-	return servers, nil
+func (p *handlerInputRepo) DeleteServerInput(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := p.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
 }
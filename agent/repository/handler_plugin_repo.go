@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"Dana/agent/model"
+)
+
+type HandlerPluginRepo interface {
+	// AddPlugin stores a new plugin and sets its id
+	AddPlugin(ctx context.Context, plugin *model.HandlerPlugin) error
+	// GetPlugins gets all plugins of a category, or of every category if
+	// category is empty, in the order they were created
+	GetPlugins(ctx context.Context, category string) ([]*model.HandlerPlugin, error)
+	// ListPlugins gets a page of the plugins of a category
+	ListPlugins(ctx context.Context, category string, opts model.ListOptions) (*model.Page[*model.HandlerPlugin], error)
+	// ListPluginsByType gets a page of the plugins of a category and plugin type
+	ListPluginsByType(ctx context.Context, category, pluginType string, opts model.ListOptions) (*model.Page[*model.HandlerPlugin], error)
+	// CreateIndexes creates the indexes used to sort and search plugins
+	CreateIndexes(ctx context.Context) error
+	// GetPlugin gets a plugin by id
+	GetPlugin(ctx context.Context, id string) (*model.HandlerPlugin, error)
+	// UpdatePlugin replaces the name and data of a plugin by id
+	UpdatePlugin(ctx context.Context, id string, plugin *model.HandlerPlugin) error
+	// DeletePlugin deletes a plugin by id
+	DeletePlugin(ctx context.Context, id string) error
+}
+
+func NewHandlerPluginRepo(client *mongo.Client, databaseName, collectionName string) HandlerPluginRepo {
+	collection := client.Database(databaseName).Collection(collectionName)
+	return &handlerPluginRepo{
+		collection: collection,
+	}
+}
+
+type handlerPluginRepo struct {
+	collection *mongo.Collection
+}
+
+func (p *handlerPluginRepo) AddPlugin(ctx context.Context, plugin *model.HandlerPlugin) error {
+	document := bson.M{
+		"category": plugin.Category,
+		"name":     plugin.Name,
+		"type":     plugin.Type,
+		"data":     plugin.Data,
+	}
+
+	result, err := p.collection.InsertOne(ctx, document)
+	if err != nil {
+		return err
+	}
+
+	plugin.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (p *handlerPluginRepo) GetPlugins(ctx context.Context, category string) ([]*model.HandlerPlugin, error) {
+	filter := bson.M{}
+	if category != "" {
+		filter["category"] = category
+	}
+	return p.find(ctx, filter)
+}
+
+func (p *handlerPluginRepo) ListPlugins(ctx context.Context, category string, opts model.ListOptions) (*model.Page[*model.HandlerPlugin], error) {
+	return p.list(ctx, bson.M{"category": category}, opts)
+}
+
+func (p *handlerPluginRepo) ListPluginsByType(ctx context.Context, category, pluginType string, opts model.ListOptions) (*model.Page[*model.HandlerPlugin], error) {
+	return p.list(ctx, bson.M{"category": category, "type": pluginType}, opts)
+}
+
+func (p *handlerPluginRepo) CreateIndexes(ctx context.Context) error {
+	return createIndexes(ctx, p.collection, "category", "name", "type")
+}
+func (p *handlerPluginRepo) GetPlugin(ctx context.Context, id string) (*model.HandlerPlugin, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var plugin model.HandlerPlugin
+	if err := p.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&plugin); err != nil {
+		return nil, err
+	}
+	return &plugin, nil
+}
+
+func (p *handlerPluginRepo) UpdatePlugin(ctx context.Context, id string, plugin *model.HandlerPlugin) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objectID, "category": plugin.Category, "type": plugin.Type}
+	update := bson.M{"$set": bson.M{
+		"name": plugin.Name,
+		"data": plugin.Data,
+	}}
+	result, err := p.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	plugin.ID = objectID
+	return nil
+}
+
+func (p *handlerPluginRepo) DeletePlugin(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := p.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// find returns the plugins matching the filter sorted by id, i.e. by
+// creation time, which is the order they are added to the pipeline in
+func (p *handlerPluginRepo) find(ctx context.Context, filter bson.M) ([]*model.HandlerPlugin, error) {
+	cursor, err := p.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func(cursor *mongo.Cursor, ctx context.Context) {
+		err := cursor.Close(ctx)
+		if err != nil {
+			return
+		}
+	}(cursor, ctx)
+
+	var plugins []*model.HandlerPlugin
+	for cursor.Next(ctx) {
+		var plugin model.HandlerPlugin
+		if err := cursor.Decode(&plugin); err != nil {
+			return nil, err
+		}
+
+		plugins = append(plugins, &plugin)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return plugins, nil
+}
+
+// list returns a page of the plugins matching the conditions and the search
+// of opts. Plugins are listed in the order they were created by default.
+func (p *handlerPluginRepo) list(ctx context.Context, conditions bson.M, opts model.ListOptions) (*model.Page[*model.HandlerPlugin], error) {
+	filter := bson.M{}
+	if opts.Query != "" {
+		filter = searchFilter(opts.Query, "name", "type")
+	}
+	for field, value := range conditions {
+		filter[field] = value
+	}
+
+	return findPage[*model.HandlerPlugin](ctx, p.collection, filter, opts, map[string]string{
+		"id":   "_id",
+		"name": "name",
+		"type": "type",
+	}, "id")
+}
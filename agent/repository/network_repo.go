@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"Dana/agent/model"
@@ -13,8 +14,10 @@ type NetworkRepo interface {
 	CreateNetwork(ctx context.Context, network *model.KnownServer) error
 	// GetNetwork gets a network by name
 	GetNetwork(ctx context.Context, name string) (*model.KnownServer, error)
-	// GetNetworks gets all networks
-	GetNetworks(ctx context.Context) ([]*model.KnownServer, error)
+	// ListNetworks gets a page of networks
+	ListNetworks(ctx context.Context, opts model.ListOptions) (*model.Page[*model.KnownServer], error)
+	// CreateIndexes creates the indexes used to sort and search networks
+	CreateIndexes(ctx context.Context) error
 	// DeleteNetwork deletes a network by name
 	DeleteNetwork(ctx context.Context, name string) error
 }
@@ -47,27 +50,21 @@ func (n *networkRepo) GetNetwork(ctx context.Context, name string) (*model.Known
 	return &network, nil
 }
 
-func (n *networkRepo) GetNetworks(ctx context.Context) ([]*model.KnownServer, error) {
-	cursor, err := n.collection.Find(ctx, map[string]string{})
-	if err != nil {
-		return nil, err
+func (n *networkRepo) ListNetworks(ctx context.Context, opts model.ListOptions) (*model.Page[*model.KnownServer], error) {
+	filter := bson.M{}
+	if opts.Query != "" {
+		filter = searchFilter(opts.Query, "name", "network_address")
 	}
-	defer func(cursor *mongo.Cursor, ctx context.Context) {
-		err := cursor.Close(ctx)
-		if err != nil {
-			return
-		}
-	}(cursor, ctx)
 
-	var networks []*model.KnownServer
-	for cursor.Next(ctx) {
-		var network model.KnownServer
-		if err := cursor.Decode(&network); err != nil {
-			return nil, err
-		}
-		networks = append(networks, &network)
-	}
-	return networks, nil
+	return findPage[*model.KnownServer](ctx, n.collection, filter, opts, map[string]string{
+		"id":              "_id",
+		"name":            "name",
+		"network_address": "network_address",
+	}, "name")
+}
+
+func (n *networkRepo) CreateIndexes(ctx context.Context) error {
+	return createIndexes(ctx, n.collection, "name", "network_address")
 }
 
 func (n *networkRepo) DeleteNetwork(ctx context.Context, name string) error {
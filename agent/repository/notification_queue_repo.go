@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"Dana/agent/model"
+)
+
+// deliveryRetention is how long deliveries are kept in the history.
+// Deliveries still pending by then are dropped as well.
+const deliveryRetention = 30 * 24 * time.Hour
+
+// NotificationQueueRepo stores the queue of notifications to deliver. The
+// queue doubles as the delivery history.
+type NotificationQueueRepo interface {
+	// CreateIndexes creates the indexes used to claim and list deliveries and
+	// to drop them from the history after a while
+	CreateIndexes(ctx context.Context) error
+	// Enqueue adds a delivery to the queue
+	Enqueue(ctx context.Context, delivery *model.NotificationDelivery) error
+	// Claim marks the pending delivery due first as sending until the lease
+	// ends and returns it. Deliveries whose lease ended without a result are
+	// claimed again. mongo.ErrNoDocuments is returned if nothing is due.
+	Claim(ctx context.Context, now time.Time, lease time.Duration) (*model.NotificationDelivery, error)
+	// RecordAttempt adds an attempt to a delivery and sets its status. A
+	// pending delivery is retried at next.
+	RecordAttempt(ctx context.Context, id primitive.ObjectID, attempt model.DeliveryAttempt, status string, next time.Time) error
+	// Postpone puts a claimed delivery back into the queue until the given
+	// time without counting an attempt
+	Postpone(ctx context.Context, id primitive.ObjectID, until time.Time) error
+	// GetDelivery gets a delivery by id
+	GetDelivery(ctx context.Context, id string) (*model.NotificationDelivery, error)
+	// ListDeliveries gets a page of the deliveries matching the filter,
+	// latest first by default
+	ListDeliveries(ctx context.Context, filter model.DeliveryFilter, opts model.ListOptions) (*model.Page[*model.NotificationDelivery], error)
+}
+
+type notificationQueueRepo struct {
+	collection *mongo.Collection
+}
+
+func NewNotificationQueueRepo(client *mongo.Client, databaseName, collectionName string) NotificationQueueRepo {
+	collection := client.Database(databaseName).Collection(collectionName)
+	return &notificationQueueRepo{
+		collection: collection,
+	}
+}
+
+func (r *notificationQueueRepo) CreateIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "channel_name", Value: 1}, {Key: "_id", Value: 1}},
+		},
+		{
+			Keys:    bson.M{"created_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(int32(deliveryRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+func (r *notificationQueueRepo) Enqueue(ctx context.Context, delivery *model.NotificationDelivery) error {
+	result, err := r.collection.InsertOne(ctx, delivery)
+	if err != nil {
+		return err
+	}
+	delivery.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *notificationQueueRepo) Claim(ctx context.Context, now time.Time, lease time.Duration) (*model.NotificationDelivery, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": model.DeliveryPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"status": model.DeliverySending, "locked_until": bson.M{"$lt": now}},
+	}}
+	update := bson.M{"$set": bson.M{
+		"status":       model.DeliverySending,
+		"locked_until": now.Add(lease),
+	}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var delivery model.NotificationDelivery
+	if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (r *notificationQueueRepo) RecordAttempt(ctx context.Context, id primitive.ObjectID, attempt model.DeliveryAttempt,
+	status string, next time.Time) error {
+	set := bson.M{
+		"status":          status,
+		"next_attempt_at": next,
+		"last_error":      attempt.Error,
+	}
+	if status == model.DeliveryDelivered {
+		set["delivered_at"] = attempt.Time
+	}
+	update := bson.M{
+		"$set":   set,
+		"$push":  bson.M{"attempts": attempt},
+		"$unset": bson.M{"locked_until": ""},
+	}
+	return r.update(ctx, id, update)
+}
+
+func (r *notificationQueueRepo) Postpone(ctx context.Context, id primitive.ObjectID, until time.Time) error {
+	update := bson.M{
+		"$set": bson.M{
+			"status":          model.DeliveryPending,
+			"next_attempt_at": until,
+		},
+		"$unset": bson.M{"locked_until": ""},
+	}
+	return r.update(ctx, id, update)
+}
+
+func (r *notificationQueueRepo) GetDelivery(ctx context.Context, id string) (*model.NotificationDelivery, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var delivery model.NotificationDelivery
+	if err := r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (r *notificationQueueRepo) ListDeliveries(ctx context.Context, filter model.DeliveryFilter, opts model.ListOptions) (*model.Page[*model.NotificationDelivery], error) {
+	query := bson.M{}
+	if opts.Query != "" {
+		query = searchFilter(opts.Query, "title", "body", "last_error")
+	}
+	if filter.ChannelName != "" {
+		query["channel_name"] = filter.ChannelName
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	created := bson.M{}
+	if !filter.From.IsZero() {
+		created["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		created["$lte"] = filter.To
+	}
+	if len(created) > 0 {
+		query["created_at"] = created
+	}
+
+	return findPage[*model.NotificationDelivery](ctx, r.collection, query, opts, map[string]string{
+		"created_at":      "created_at",
+		"next_attempt_at": "next_attempt_at",
+	}, "-created_at")
+}
+
+func (r *notificationQueueRepo) update(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
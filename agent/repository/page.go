@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"Dana/agent/model"
+)
+
+const (
+	// DefaultPageSize is used when a list request has no limit
+	DefaultPageSize = 50
+	// MaxPageSize is the largest page a list request may ask for
+	MaxPageSize = 500
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort field")
+)
+
+// pageCursor is the position after the last item of a page: the value of the
+// sort field and the id breaking ties between equal values.
+type pageCursor struct {
+	Value interface{}        `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+// findPage returns a page of the documents matching the filter. Paging uses
+// the sort field and the document id as key instead of skipping documents,
+// so pages stay stable while documents are added. sortable maps the sort
+// fields accepted from the request to document fields; defaultSort is used
+// if the request does not ask for an order.
+func findPage[T any](ctx context.Context, collection *mongo.Collection, filter bson.M,
+	opts model.ListOptions, sortable map[string]string, defaultSort string) (*model.Page[T], error) {
+	return findProjectedPage[T](ctx, collection, filter, nil, opts, sortable, defaultSort)
+}
+
+// findProjectedPage is findPage leaving out the fields excluded by the
+// projection, e.g. large content not shown in lists.
+func findProjectedPage[T any](ctx context.Context, collection *mongo.Collection, filter, projection bson.M,
+	opts model.ListOptions, sortable map[string]string, defaultSort string) (*model.Page[T], error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	sortName := opts.Sort
+	if sortName == "" {
+		sortName = defaultSort
+	}
+	direction := 1
+	if name, found := strings.CutPrefix(sortName, "-"); found {
+		sortName = name
+		direction = -1
+	}
+	field, found := sortable[sortName]
+	if !found {
+		return nil, fmt.Errorf("%w %q", ErrInvalidSort, sortName)
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := filter
+	if opts.Cursor != "" {
+		after, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		op := "$gt"
+		if direction < 0 {
+			op = "$lt"
+		}
+		position := bson.M{"_id": bson.M{op: after.ID}}
+		if field != "_id" {
+			position = bson.M{"$or": bson.A{
+				bson.M{field: bson.M{op: after.Value}},
+				bson.M{field: after.Value, "_id": bson.M{op: after.ID}},
+			}}
+		}
+		query = bson.M{"$and": bson.A{filter, position}}
+	}
+
+	sort := bson.D{{Key: field, Value: direction}}
+	if field != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: direction})
+	}
+	findOpts := options.Find().SetSort(sort).SetLimit(int64(limit) + 1)
+	if projection != nil {
+		findOpts.SetProjection(projection)
+	}
+	cursor, err := collection.Find(ctx, query, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer func(cursor *mongo.Cursor, ctx context.Context) {
+		err := cursor.Close(ctx)
+		if err != nil {
+			return
+		}
+	}(cursor, ctx)
+
+	page := &model.Page[T]{Items: make([]T, 0, limit), Total: total}
+	var last bson.Raw
+	for cursor.Next(ctx) {
+		if len(page.Items) == limit {
+			next, err := encodeCursor(last, field)
+			if err != nil {
+				return nil, err
+			}
+			page.NextCursor = next
+			break
+		}
+
+		var item T
+		if err := cursor.Decode(&item); err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, item)
+		last = append(last[:0], cursor.Current...)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+func encodeCursor(doc bson.Raw, field string) (string, error) {
+	id, ok := doc.Lookup("_id").ObjectIDOK()
+	if !ok {
+		return "", errors.New("document without object id")
+	}
+	var value interface{}
+	if raw, err := doc.LookupErr(field); err == nil {
+		value = raw
+	}
+	buf, err := bson.Marshal(pageCursor{Value: value, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c pageCursor
+	if err := bson.Unmarshal(buf, &c); err != nil || c.ID.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	// Cursors come from the client, so only values a sort field can hold are
+	// accepted. Documents would be read as query operators.
+	switch c.Value.(type) {
+	case nil, string, bool, int32, int64, float64, primitive.DateTime, primitive.ObjectID, primitive.Decimal128:
+	default:
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// searchFilter matches documents where any of the fields contains the query,
+// ignoring case.
+func searchFilter(query string, fields ...string) bson.M {
+	pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+	conditions := make(bson.A, 0, len(fields))
+	for _, field := range fields {
+		conditions = append(conditions, bson.M{field: pattern})
+	}
+	return bson.M{"$or": conditions}
+}
+
+// createIndexes creates an ascending index on each of the fields.
+func createIndexes(ctx context.Context, collection *mongo.Collection, fields ...string) error {
+	models := make([]mongo.IndexModel, 0, len(fields))
+	for _, field := range fields {
+		models = append(models, mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}, {Key: "_id", Value: 1}}})
+	}
+	_, err := collection.Indexes().CreateMany(ctx, models)
+	return err
+}
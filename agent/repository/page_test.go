@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"Dana/agent/model"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	created := primitive.NewDateTimeFromTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name     string
+		field    string
+		expected interface{}
+	}{
+		{name: "string", field: "name", expected: "web servers"},
+		{name: "date", field: "created_at", expected: created},
+		{name: "integer", field: "version", expected: int32(3)},
+		{name: "id", field: "_id", expected: id},
+		{name: "missing field", field: "updated_at", expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := bson.Marshal(bson.D{
+				{Key: "_id", Value: id},
+				{Key: "name", Value: "web servers"},
+				{Key: "created_at", Value: created},
+				{Key: "version", Value: int32(3)},
+			})
+			require.NoError(t, err)
+
+			s, err := encodeCursor(doc, tt.field)
+			require.NoError(t, err)
+			require.NotContains(t, s, "=")
+
+			c, err := decodeCursor(s)
+			require.NoError(t, err)
+			require.Equal(t, id, c.ID)
+			require.Equal(t, tt.expected, c.Value)
+		})
+	}
+}
+
+func TestEncodeCursorWithoutID(t *testing.T) {
+	doc, err := bson.Marshal(bson.D{{Key: "_id", Value: "name"}})
+	require.NoError(t, err)
+	_, err = encodeCursor(doc, "name")
+	require.Error(t, err)
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	encode := func(v interface{}) string {
+		buf, err := bson.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(buf)
+	}
+	id := primitive.NewObjectID()
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "not base64", cursor: "not a cursor!"},
+		{name: "padded base64", cursor: base64.URLEncoding.EncodeToString([]byte("abcd"))},
+		{name: "not bson", cursor: base64.RawURLEncoding.EncodeToString([]byte("hello world"))},
+		{name: "truncated", cursor: encode(bson.M{"v": "name", "id": id})[:10]},
+		{name: "without id", cursor: encode(bson.M{"v": "name"})},
+		{name: "id of wrong type", cursor: encode(bson.M{"v": "name", "id": "abc"})},
+		{name: "operator value", cursor: encode(bson.M{"v": bson.M{"$ne": nil}, "id": id})},
+		{name: "array value", cursor: encode(bson.M{"v": bson.A{"a", "b"}, "id": id})},
+		{name: "regex value", cursor: encode(bson.M{"v": primitive.Regex{Pattern: ".*"}, "id": id})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.cursor)
+			require.ErrorIs(t, err, ErrInvalidCursor)
+		})
+	}
+}
+
+// findCommand returns the find command sent to the mock deployment
+func findCommand(t *testing.T, mt *mtest.T) bson.Raw {
+	for _, e := range mt.GetAllStartedEvents() {
+		if e.CommandName == "find" {
+			return e.Command
+		}
+	}
+	t.Fatal("no find command sent")
+	return nil
+}
+
+func TestListFilters(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	dashboardID := primitive.NewObjectID()
+	opts := model.ListOptions{Limit: 1, Query: "web"}
+
+	mt.Run("users", func(mt *mtest.T) {
+		first, second := primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(countResponse(2), mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: first}, {Key: "username", Value: "alice"}},
+			bson.D{{Key: "_id", Value: second}, {Key: "username", Value: "bob"}},
+		))
+		page, err := (&userRepo{collection: mt.Coll}).ListUsers(context.Background(), opts)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), page.Total)
+		require.Len(t, page.Items, 1)
+		require.NotEmpty(t, page.NextCursor)
+		// Users stored before roles existed are viewers
+		require.Equal(t, model.RoleViewer, page.Items[0].Role)
+
+		find := findCommand(t, mt)
+		require.Equal(t, int32(0), find.Lookup("projection", "password").Int32())
+		require.Equal(t, int32(1), find.Lookup("sort", "username").Int32())
+		require.Equal(t, bson.TypeArray, find.Lookup("filter", "$or").Type)
+	})
+
+	mt.Run("tokens of a user", func(mt *mtest.T) {
+		mt.AddMockResponses(countResponse(0), mtest.CreateCursorResponse(0, "db.api_tokens", mtest.FirstBatch))
+		page, err := (&apiTokenRepo{collection: mt.Coll}).ListTokens(context.Background(), "alice", opts)
+		require.NoError(t, err)
+		require.Empty(t, page.Items)
+
+		find := findCommand(t, mt)
+		require.Equal(t, "alice", find.Lookup("filter", "username").StringValue())
+		require.Equal(t, int32(-1), find.Lookup("sort", "created_at").Int32())
+	})
+
+	mt.Run("inputs by type", func(mt *mtest.T) {
+		mt.AddMockResponses(countResponse(0), mtest.CreateCursorResponse(0, "db.inputs", mtest.FirstBatch))
+		_, err := (&handlerInputRepo{collection: mt.Coll}).ListServersByType(context.Background(), "cpu", opts)
+		require.NoError(t, err)
+		require.Equal(t, "cpu", findCommand(t, mt).Lookup("filter", "type").StringValue())
+	})
+
+	mt.Run("plugins by type", func(mt *mtest.T) {
+		mt.AddMockResponses(countResponse(0), mtest.CreateCursorResponse(0, "db.plugins", mtest.FirstBatch))
+		_, err := (&handlerPluginRepo{collection: mt.Coll}).ListPluginsByType(context.Background(), "outputs", "file", opts)
+		require.NoError(t, err)
+
+		find := findCommand(t, mt)
+		require.Equal(t, "outputs", find.Lookup("filter", "category").StringValue())
+		require.Equal(t, "file", find.Lookup("filter", "type").StringValue())
+		// Plugins are listed in the order they run in
+		require.Equal(t, int32(1), find.Lookup("sort", "_id").Int32())
+	})
+
+	mt.Run("snapshots of a dashboard", func(mt *mtest.T) {
+		mt.AddMockResponses(countResponse(0), mtest.CreateCursorResponse(0, "db.snapshots", mtest.FirstBatch))
+		_, err := (&snapshotRepo{collection: mt.Coll}).ListSnapshots(context.Background(), dashboardID.Hex(), opts)
+		require.NoError(t, err)
+
+		find := findCommand(t, mt)
+		require.Equal(t, dashboardID, find.Lookup("filter", "dashboard_id").ObjectID())
+		require.Equal(t, int32(0), find.Lookup("projection", "data").Int32())
+		require.Equal(t, int32(-1), find.Lookup("sort", "created_at").Int32())
+	})
+
+	mt.Run("revisions of a dashboard", func(mt *mtest.T) {
+		mt.AddMockResponses(countResponse(0), mtest.CreateCursorResponse(0, "db.dashboard_revisions", mtest.FirstBatch))
+		_, err := (&dashboardRevisionRepo{collection: mt.Coll}).ListRevisions(context.Background(), dashboardID.Hex(), opts)
+		require.NoError(t, err)
+
+		find := findCommand(t, mt)
+		require.Equal(t, dashboardID, find.Lookup("filter", "dashboard_id").ObjectID())
+		require.Equal(t, int32(-1), find.Lookup("sort", "version").Int32())
+	})
+
+	mt.Run("invalid dashboard id", func(mt *mtest.T) {
+		_, err := (&snapshotRepo{collection: mt.Coll}).ListSnapshots(context.Background(), "dashboard", opts)
+		require.ErrorIs(t, err, primitive.ErrInvalidHex)
+		_, err = (&dashboardRevisionRepo{collection: mt.Coll}).ListRevisions(context.Background(), "dashboard", opts)
+		require.ErrorIs(t, err, primitive.ErrInvalidHex)
+	})
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"Dana/agent/model"
+)
+
+type SilenceRepo interface {
+	// CreateIndexes creates the indexes used to find current silences
+	CreateIndexes(ctx context.Context) error
+	// CreateSilence stores a new silence
+	CreateSilence(ctx context.Context, silence *model.Silence) (primitive.ObjectID, error)
+	// GetSilence gets a silence by id
+	GetSilence(ctx context.Context, id string) (*model.Silence, error)
+	// GetCurrentSilences gets the silences that started and did not end yet.
+	// Maintenance windows among them may be inactive at the moment.
+	GetCurrentSilences(ctx context.Context, now time.Time) ([]*model.Silence, error)
+	// UpdateSilence replaces the matcher, time range, schedule and comment of
+	// a silence by id and returns the updated silence
+	UpdateSilence(ctx context.Context, id string, silence *model.Silence) (*model.Silence, error)
+	// DeleteSilence deletes a silence by id
+	DeleteSilence(ctx context.Context, id string) error
+	// ListSilences gets a page of the silences matching the filter, latest
+	// first by default
+	ListSilences(ctx context.Context, filter model.SilenceFilter, opts model.ListOptions) (*model.Page[*model.Silence], error)
+}
+
+type silenceRepo struct {
+	collection *mongo.Collection
+}
+
+func NewSilenceRepo(client *mongo.Client, databaseName, collectionName string) SilenceRepo {
+	collection := client.Database(databaseName).Collection(collectionName)
+	return &silenceRepo{
+		collection: collection,
+	}
+}
+
+func (r *silenceRepo) CreateIndexes(ctx context.Context) error {
+	return createIndexes(ctx, r.collection, "ends_at", "starts_at")
+}
+
+func (r *silenceRepo) CreateSilence(ctx context.Context, silence *model.Silence) (primitive.ObjectID, error) {
+	result, err := r.collection.InsertOne(ctx, silence)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (r *silenceRepo) GetSilence(ctx context.Context, id string) (*model.Silence, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var silence model.Silence
+	if err := r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&silence); err != nil {
+		return nil, err
+	}
+	return &silence, nil
+}
+
+func (r *silenceRepo) GetCurrentSilences(ctx context.Context, now time.Time) ([]*model.Silence, error) {
+	filter := notExpired(now)
+	filter["starts_at"] = bson.M{"$lte": now}
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer func(cursor *mongo.Cursor, ctx context.Context) {
+		err := cursor.Close(ctx)
+		if err != nil {
+			return
+		}
+	}(cursor, ctx)
+
+	var silences []*model.Silence
+	if err := cursor.All(ctx, &silences); err != nil {
+		return nil, err
+	}
+	return silences, nil
+}
+
+func (r *silenceRepo) UpdateSilence(ctx context.Context, id string, silence *model.Silence) (*model.Silence, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{
+		"matcher":   silence.Matcher,
+		"starts_at": silence.StartsAt,
+		"comment":   silence.Comment,
+	}
+	// Optional fields left out of the update are removed
+	unset := bson.M{}
+	if silence.EndsAt != nil {
+		set["ends_at"] = silence.EndsAt
+	} else {
+		unset["ends_at"] = ""
+	}
+	if silence.Schedule != "" {
+		set["schedule"] = silence.Schedule
+		set["duration"] = silence.Duration
+	} else {
+		unset["schedule"] = ""
+		unset["duration"] = ""
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated model.Silence
+	if err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID}, update, opts).Decode(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (r *silenceRepo) DeleteSilence(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *silenceRepo) ListSilences(ctx context.Context, filter model.SilenceFilter, opts model.ListOptions) (*model.Page[*model.Silence], error) {
+	query := bson.M{}
+	if !filter.Expired {
+		query = notExpired(time.Now())
+	}
+	if opts.Query != "" {
+		query = bson.M{"$and": bson.A{query, searchFilter(opts.Query, "comment", "matcher.check_name", "created_by")}}
+	}
+
+	return findPage[*model.Silence](ctx, r.collection, query, opts, map[string]string{
+		"created_at": "created_at",
+		"starts_at":  "starts_at",
+	}, "-created_at")
+}
+
+// notExpired selects the silences without an end or ending after now.
+func notExpired(now time.Time) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"ends_at": nil},
+		bson.M{"ends_at": bson.M{"$gt": now}},
+	}}
+}
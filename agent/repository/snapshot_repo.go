@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"Dana/agent/model"
+)
+
+type SnapshotRepo interface {
+	// CreateIndexes creates the indexes looking up snapshots and expiring them
+	CreateIndexes(ctx context.Context) error
+	// CreateSnapshot stores a new snapshot
+	CreateSnapshot(ctx context.Context, snapshot *model.Snapshot) error
+	// GetSnapshotByHash gets an unexpired snapshot by the hash of its token
+	GetSnapshotByHash(ctx context.Context, hash string) (*model.Snapshot, error)
+	// ListSnapshots gets a page of the snapshots of a dashboard without their
+	// content
+	ListSnapshots(ctx context.Context, dashboardID string, opts model.ListOptions) (*model.Page[*model.Snapshot], error)
+	// DeleteSnapshot deletes a snapshot of a dashboard by id
+	DeleteSnapshot(ctx context.Context, dashboardID, id string) error
+	// DeleteDashboardSnapshots deletes all snapshots of a dashboard
+	DeleteDashboardSnapshots(ctx context.Context, dashboardID primitive.ObjectID) error
+}
+
+type snapshotRepo struct {
+	collection *mongo.Collection
+}
+
+func NewSnapshotRepo(client *mongo.Client, databaseName, collectionName string) SnapshotRepo {
+	collection := client.Database(databaseName).Collection(collectionName)
+	return &snapshotRepo{
+		collection: collection,
+	}
+}
+
+func (r *snapshotRepo) CreateIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"hash": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.M{"dashboard_id": 1},
+		},
+		{
+			// Let Mongo drop snapshots once their link expired
+			Keys:    bson.M{"expires_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+func (r *snapshotRepo) CreateSnapshot(ctx context.Context, snapshot *model.Snapshot) error {
+	result, err := r.collection.InsertOne(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+	snapshot.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *snapshotRepo) GetSnapshotByHash(ctx context.Context, hash string) (*model.Snapshot, error) {
+	// Expired snapshots are only removed periodically, so check the expiry
+	filter := bson.M{
+		"hash":       hash,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	var snapshot model.Snapshot
+	if err := r.collection.FindOne(ctx, filter).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (r *snapshotRepo) ListSnapshots(ctx context.Context, dashboardID string, opts model.ListOptions) (*model.Page[*model.Snapshot], error) {
+	objectID, err := primitive.ObjectIDFromHex(dashboardID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{}
+	if opts.Query != "" {
+		filter = searchFilter(opts.Query, "name", "author")
+	}
+	filter["dashboard_id"] = objectID
+
+	projection := bson.M{"dashboard": 0, "variables": 0, "data": 0}
+	return findProjectedPage[*model.Snapshot](ctx, r.collection, filter, projection, opts, map[string]string{
+		"id":         "_id",
+		"name":       "name",
+		"created_at": "created_at",
+		"expires_at": "expires_at",
+	}, "-created_at")
+}
+func (r *snapshotRepo) DeleteSnapshot(ctx context.Context, dashboardID, id string) error {
+	dashboardObjectID, err := primitive.ObjectIDFromHex(dashboardID)
+	if err != nil {
+		return err
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID, "dashboard_id": dashboardObjectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *snapshotRepo) DeleteDashboardSnapshots(ctx context.Context, dashboardID primitive.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"dashboard_id": dashboardID})
+	return err
+}
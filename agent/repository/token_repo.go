@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TokenRepo interface {
+	// CreateIndexes creates the index expiring revoked tokens
+	CreateIndexes(ctx context.Context) error
+	// Revoke adds a token id to the denylist until it expires
+	Revoke(ctx context.Context, id string, expiresAt time.Time) error
+	// IsRevoked reports whether a token id is on the denylist
+	IsRevoked(ctx context.Context, id string) (bool, error)
+}
+
+type tokenRepo struct {
+	collection *mongo.Collection
+}
+
+func NewTokenRepo(client *mongo.Client, databaseName, collectionName string) TokenRepo {
+	collection := client.Database(databaseName).Collection(collectionName)
+	return &tokenRepo{
+		collection: collection,
+	}
+}
+
+func (r *tokenRepo) CreateIndexes(ctx context.Context) error {
+	// Let Mongo drop entries once the token could not be used anyway
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (r *tokenRepo) Revoke(ctx context.Context, id string, expiresAt time.Time) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *tokenRepo) IsRevoked(ctx context.Context, id string) (bool, error) {
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Err()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
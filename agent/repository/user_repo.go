@@ -2,19 +2,60 @@ package repository
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
 
 	"Dana/agent/model"
 )
 
+var (
+	ErrUsernameExists      = errors.New("username already exists")
+	ErrInvalidCredentials  = errors.New("invalid username or password")
+	ErrUserDisabled        = errors.New("user is disabled")
+	ErrInvalidRole         = errors.New("invalid role")
+	ErrMissingCredentials  = errors.New("username and password are required")
+	ErrUsernameChange      = errors.New("username cannot be changed")
+	ErrLastAdmin           = errors.New("the last active admin cannot be removed, disabled or demoted")
+	ErrAlreadyBootstrapped = errors.New("the first admin has already been registered")
+)
+
 type UserRepo interface {
+	// CreateIndexes makes usernames unique, allows a single bootstrap admin
+	// and creates the indexes used to sort and search users
+	CreateIndexes(ctx context.Context) error
+	// AddUser hashes the user's password and stores the user
 	AddUser(ctx context.Context, user *model.User) error
-	UserAuth(ctx context.Context, username, password string) error
+	// UserAuth checks the credentials of an enabled user and returns it
+	UserAuth(ctx context.Context, username, password string) (*model.User, error)
+	// HasUsers reports whether any user exists
+	HasUsers(ctx context.Context) (bool, error)
+	// GetUser gets a user by id
+	GetUser(ctx context.Context, id string) (*model.User, error)
+	// GetUserByUsername gets a user by username
+	GetUserByUsername(ctx context.Context, username string) (*model.User, error)
+	// ListUsers gets a page of users without their passwords
+	ListUsers(ctx context.Context, opts model.ListOptions) (*model.Page[*model.User], error)
+	// UpdateUser updates the email and role of a user by id. Usernames are
+	// fixed, as API tokens and issued sessions refer to them, and the last
+	// active admin cannot be demoted.
+	UpdateUser(ctx context.Context, id string, user *model.User) error
+	// SetDisabled enables or disables a user by id. The last active admin
+	// cannot be disabled.
+	SetDisabled(ctx context.Context, id string, disabled bool) error
+	// DeleteUser deletes a user by id. The last active admin cannot be
+	// deleted.
+	DeleteUser(ctx context.Context, id string) error
+	// ChangePassword replaces the password of a user after checking the old one
+	ChangePassword(ctx context.Context, username, oldPassword, newPassword string) error
+	// ResetPassword replaces the password of a user by id without checking the old one
+	ResetPassword(ctx context.Context, id, newPassword string) error
 }
 
 type userRepo struct {
@@ -28,10 +69,41 @@ func NewUserRepo(client *mongo.Client, databaseName, collectionName string) User
 	}
 }
 
+func (r *userRepo) CreateIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"username": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			// Concurrent first registrations race on HasUsers, so only
+			// one of them may store the bootstrap admin
+			Keys: bson.M{"bootstrap": 1},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"bootstrap": true}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return createIndexes(ctx, r.collection, "username", "email", "role")
+}
+
 func (r *userRepo) AddUser(ctx context.Context, user *model.User) error {
+	if user.Username == "" || user.Password == "" {
+		return ErrMissingCredentials
+	}
+	if user.Role == "" {
+		user.Role = model.RoleViewer
+	}
+	if !user.Role.Valid() {
+		return ErrInvalidRole
+	}
 
 	// Set creation time
-	user.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	now := primitive.NewDateTimeFromTime(time.Now())
+	user.CreatedAt = now
+	user.UpdatedAt = now
 
 	// Check if username already exists
 	count, err := r.collection.CountDocuments(ctx, bson.M{"username": user.Username})
@@ -39,31 +111,273 @@ func (r *userRepo) AddUser(ctx context.Context, user *model.User) error {
 		return err
 	}
 	if count > 0 {
-		return errors.New("username already exists")
+		return ErrUsernameExists
+	}
+
+	hash, err := hashPassword(user.Password)
+	if err != nil {
+		return err
 	}
+	user.Password = hash
 
-	// Insert the user
-	_, err = r.collection.InsertOne(ctx, user)
+	// Insert the user. The check above is only a shortcut, the unique
+	// indexes decide between concurrent registrations.
+	result, err := r.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		if user.Bootstrap {
+			return ErrAlreadyBootstrapped
+		}
+		return ErrUsernameExists
+	}
 	if err != nil {
 		return err
 	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
 
 	return nil
 }
 
-func (r *userRepo) UserAuth(ctx context.Context, username, password string) error {
+func (r *userRepo) UserAuth(ctx context.Context, username, password string) (*model.User, error) {
+	var user model.User
+	err := r.collection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if isPasswordHash(user.Password) {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+			return nil, ErrInvalidCredentials
+		}
+	} else {
+		// Records created before passwords were hashed still hold the
+		// plaintext; check it once and replace it with a hash.
+		if subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+			return nil, ErrInvalidCredentials
+		}
+		if err := r.setPassword(ctx, user.ID, password); err != nil {
+			return nil, err
+		}
+	}
+
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+	defaultRole(&user)
+	return &user, nil
+}
+
+func (r *userRepo) HasUsers(ctx context.Context) (bool, error) {
+	count, err := r.collection.CountDocuments(ctx, bson.M{}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *userRepo) GetUser(ctx context.Context, id string) (*model.User, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var user model.User
+	if err := r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user); err != nil {
+		return nil, err
+	}
+	defaultRole(&user)
+	return &user, nil
+}
 
+func (r *userRepo) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
 	var user model.User
-	err := r.collection.FindOne(ctx, bson.M{
-		"username": username,
-		"password": password,
-	}).Decode(&user)
+	if err := r.collection.FindOne(ctx, bson.M{"username": username}).Decode(&user); err != nil {
+		return nil, err
+	}
+	defaultRole(&user)
+	return &user, nil
+}
+
+func (r *userRepo) ListUsers(ctx context.Context, opts model.ListOptions) (*model.Page[*model.User], error) {
+	filter := bson.M{}
+	if opts.Query != "" {
+		filter = searchFilter(opts.Query, "username", "email")
+	}
 
+	page, err := findProjectedPage[*model.User](ctx, r.collection, filter, bson.M{"password": 0}, opts, map[string]string{
+		"id":       "_id",
+		"username": "username",
+		"email":    "email",
+		"role":     "role",
+	}, "username")
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return errors.New("invalid username or password")
+		return nil, err
+	}
+	for _, user := range page.Items {
+		defaultRole(user)
+	}
+	return page, nil
+}
+
+func (r *userRepo) UpdateUser(ctx context.Context, id string, user *model.User) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	updateFields := bson.M{}
+	if user.Username != "" {
+		var current model.User
+		if err := r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&current); err != nil {
+			return err
 		}
+		if current.Username != user.Username {
+			return ErrUsernameChange
+		}
+	}
+	if user.Email != "" {
+		updateFields["email"] = user.Email
+	}
+	if user.Role != "" {
+		if !user.Role.Valid() {
+			return ErrInvalidRole
+		}
+		if user.Role != model.RoleAdmin {
+			if err := r.keepAdmin(ctx, objectID); err != nil {
+				return err
+			}
+		}
+		updateFields["role"] = user.Role
+	}
+
+	if len(updateFields) == 0 {
+		return nil
+	}
+	updateFields["updated_at"] = primitive.NewDateTimeFromTime(time.Now())
+
+	return r.update(ctx, objectID, updateFields)
+}
+
+func (r *userRepo) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	if disabled {
+		if err := r.keepAdmin(ctx, objectID); err != nil {
+			return err
+		}
+	}
+
+	return r.update(ctx, objectID, bson.M{
+		"disabled":   disabled,
+		"updated_at": primitive.NewDateTimeFromTime(time.Now()),
+	})
+}
+
+func (r *userRepo) DeleteUser(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	if err := r.keepAdmin(ctx, objectID); err != nil {
 		return err
 	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
 	return nil
 }
+
+func (r *userRepo) ChangePassword(ctx context.Context, username, oldPassword, newPassword string) error {
+	if newPassword == "" {
+		return errors.New("new password is required")
+	}
+	user, err := r.UserAuth(ctx, username, oldPassword)
+	if err != nil {
+		return err
+	}
+	return r.setPassword(ctx, user.ID, newPassword)
+}
+
+func (r *userRepo) ResetPassword(ctx context.Context, id, newPassword string) error {
+	if newPassword == "" {
+		return errors.New("new password is required")
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	return r.setPassword(ctx, objectID, newPassword)
+}
+
+func (r *userRepo) setPassword(ctx context.Context, id primitive.ObjectID, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return r.update(ctx, id, bson.M{
+		"password":   hash,
+		"updated_at": primitive.NewDateTimeFromTime(time.Now()),
+	})
+}
+
+// keepAdmin returns ErrLastAdmin if the user is the only active admin, so
+// the installation is not left without anyone able to manage it.
+func (r *userRepo) keepAdmin(ctx context.Context, id primitive.ObjectID) error {
+	activeAdmin := bson.M{"role": model.RoleAdmin, "disabled": bson.M{"$ne": true}}
+	count, err := r.collection.CountDocuments(ctx, bson.M{"$and": bson.A{bson.M{"_id": id}, activeAdmin}})
+	if err != nil || count == 0 {
+		return err
+	}
+	count, err = r.collection.CountDocuments(ctx, activeAdmin, options.Count().SetLimit(2))
+	if err != nil {
+		return err
+	}
+	if count < 2 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+func (r *userRepo) update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// defaultRole gives users stored before roles existed the least privileged role
+func defaultRole(user *model.User) {
+	if user.Role == "" {
+		user.Role = model.RoleViewer
+	}
+}
+
+// hashPassword hashes a plaintext password with bcrypt
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// isPasswordHash reports whether the stored value is a bcrypt hash rather
+// than a legacy plaintext password
+func isPasswordHash(stored string) bool {
+	_, err := bcrypt.Cost([]byte(stored))
+	return err == nil
+}
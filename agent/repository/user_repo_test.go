@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"golang.org/x/crypto/bcrypt"
+
+	"Dana/agent/model"
+)
+
+// countResponse answers a CountDocuments call with n
+func countResponse(n int) bson.D {
+	return mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, bson.D{{Key: "n", Value: n}})
+}
+
+// commandNames lists the commands sent to the mock deployment
+func commandNames(mt *mtest.T) []string {
+	var names []string
+	for _, e := range mt.GetAllStartedEvents() {
+		names = append(names, e.CommandName)
+	}
+	return names
+}
+
+func TestUserRepoKeepAdmin(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	id := primitive.NewObjectID().Hex()
+	ok := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+
+	actions := map[string]func(r UserRepo) error{
+		"delete": func(r UserRepo) error {
+			return r.DeleteUser(context.Background(), id)
+		},
+		"disable": func(r UserRepo) error {
+			return r.SetDisabled(context.Background(), id, true)
+		},
+		"demote": func(r UserRepo) error {
+			return r.UpdateUser(context.Background(), id, &model.User{Role: model.RoleEditor})
+		},
+	}
+	writes := map[string]string{"delete": "delete", "disable": "update", "demote": "update"}
+
+	for name, action := range actions {
+		mt.Run(name+" last admin", func(mt *mtest.T) {
+			// The user is an active admin and the only one
+			mt.AddMockResponses(countResponse(1), countResponse(1))
+			require.ErrorIs(t, action(&userRepo{collection: mt.Coll}), ErrLastAdmin)
+			require.Equal(t, []string{"aggregate", "aggregate"}, commandNames(mt))
+		})
+		mt.Run(name+" one of two admins", func(mt *mtest.T) {
+			mt.AddMockResponses(countResponse(1), countResponse(2), ok)
+			require.NoError(t, action(&userRepo{collection: mt.Coll}))
+			require.Equal(t, []string{"aggregate", "aggregate", writes[name]}, commandNames(mt))
+		})
+		mt.Run(name+" not an active admin", func(mt *mtest.T) {
+			// An empty batch counts as zero
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch), ok)
+			require.NoError(t, action(&userRepo{collection: mt.Coll}))
+			require.Equal(t, []string{"aggregate", writes[name]}, commandNames(mt))
+		})
+	}
+
+	mt.Run("enable does not check admins", func(mt *mtest.T) {
+		mt.AddMockResponses(ok)
+		require.NoError(t, (&userRepo{collection: mt.Coll}).SetDisabled(context.Background(), id, false))
+		require.Equal(t, []string{"update"}, commandNames(mt))
+	})
+}
+
+func TestUserRepoUserAuth(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	id := primitive.NewObjectID()
+	hash, err := hashPassword("secret")
+	require.NoError(t, err)
+
+	found := func(password string, disabled bool) bson.D {
+		return mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: id},
+			{Key: "username", Value: "alice"},
+			{Key: "password", Value: password},
+			{Key: "disabled", Value: disabled},
+		})
+	}
+	ok := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+
+	mt.Run("hashed password", func(mt *mtest.T) {
+		mt.AddMockResponses(found(hash, false))
+		user, err := (&userRepo{collection: mt.Coll}).UserAuth(context.Background(), "alice", "secret")
+		require.NoError(t, err)
+		require.Equal(t, id, user.ID)
+		// Stored before roles existed
+		require.Equal(t, model.RoleViewer, user.Role)
+		require.Equal(t, []string{"find"}, commandNames(mt))
+	})
+
+	mt.Run("wrong password", func(mt *mtest.T) {
+		mt.AddMockResponses(found(hash, false))
+		_, err := (&userRepo{collection: mt.Coll}).UserAuth(context.Background(), "alice", "Secret")
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	mt.Run("plaintext password is rehashed", func(mt *mtest.T) {
+		mt.AddMockResponses(found("secret", false), ok)
+		_, err := (&userRepo{collection: mt.Coll}).UserAuth(context.Background(), "alice", "secret")
+		require.NoError(t, err)
+
+		update := mt.GetAllStartedEvents()[1]
+		require.Equal(t, "update", update.CommandName)
+		stored := update.Command.Lookup("updates").Array().Index(0).Value().Document().
+			Lookup("u", "$set", "password").StringValue()
+		require.NotEqual(t, "secret", stored)
+		require.NoError(t, bcrypt.CompareHashAndPassword([]byte(stored), []byte("secret")))
+	})
+
+	mt.Run("wrong plaintext password is not rehashed", func(mt *mtest.T) {
+		mt.AddMockResponses(found("secret", false))
+		_, err := (&userRepo{collection: mt.Coll}).UserAuth(context.Background(), "alice", "secre")
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+		require.Equal(t, []string{"find"}, commandNames(mt))
+	})
+
+	mt.Run("disabled user", func(mt *mtest.T) {
+		mt.AddMockResponses(found(hash, true))
+		_, err := (&userRepo{collection: mt.Coll}).UserAuth(context.Background(), "alice", "secret")
+		require.ErrorIs(t, err, ErrUserDisabled)
+	})
+}
+
+func TestUserRepoAddUserDuplicate(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	duplicate := mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"})
+
+	// Another registration inserted the same username after the check
+	mt.Run("username", func(mt *mtest.T) {
+		mt.AddMockResponses(countResponse(0), duplicate)
+		err := (&userRepo{collection: mt.Coll}).AddUser(context.Background(), &model.User{Username: "alice", Password: "secret"})
+		require.ErrorIs(t, err, ErrUsernameExists)
+	})
+
+	mt.Run("bootstrap admin", func(mt *mtest.T) {
+		mt.AddMockResponses(countResponse(0), duplicate)
+		user := &model.User{Username: "bob", Password: "secret", Role: model.RoleAdmin, Bootstrap: true}
+		require.ErrorIs(t, (&userRepo{collection: mt.Coll}).AddUser(context.Background(), user), ErrAlreadyBootstrapped)
+	})
+}
@@ -0,0 +1,27 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"Dana/agent/model"
+	"Dana/agent/notification"
+)
+
+// activeSilence returns the first silence active at the given time that
+// matches the alert, or nil if the alert is not silenced.
+func (a *Server) activeSilence(ctx context.Context, alert *notification.Alert, now time.Time) (*model.Silence, error) {
+	if alert == nil {
+		return nil, nil
+	}
+	silences, err := a.SilenceRepo.GetCurrentSilences(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, silence := range silences {
+		if silence.IsActive(now) && silence.Matcher.Matches(alert.CheckName, alert.Tags) {
+			return silence, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"Dana/agent/model"
+)
+
+const (
+	// defaultSnapshotDays is the lifetime of snapshots created without one
+	defaultSnapshotDays = 7
+	// maxSnapshotDays is the longest lifetime a snapshot can be given
+	maxSnapshotDays = 90
+	// snapshotPrefixLength is the number of token characters kept to tell
+	// snapshot links apart
+	snapshotPrefixLength = 6
+)
+
+// generateSnapshotToken returns a new random snapshot token and its hash.
+func generateSnapshotToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("generating snapshot token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashSnapshotToken(token), nil
+}
+
+// hashSnapshotToken returns the hash stored for a snapshot token. Tokens are
+// random, so a plain SHA-256 is sufficient and allows lookups by hash.
+func hashSnapshotToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// frozenDashboard returns the copy of a dashboard kept in a snapshot. The
+// dashboard's id, version and tags are not shared.
+func frozenDashboard(dashboard *model.Dashboard) *model.Dashboard {
+	return &model.Dashboard{
+		ID:        primitive.NilObjectID,
+		Name:      dashboard.Name,
+		Panels:    dashboard.Panels,
+		Variables: dashboard.Variables,
+	}
+}
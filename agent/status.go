@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"sort"
+	"time"
+
+	"Dana/agent/model"
+	"Dana/internal"
+	"Dana/models"
+	"Dana/selfstat"
+)
+
+// status reports the running state of the agent from the statistics the
+// plugins already keep.
+func (a *Server) status() *model.AgentStatus {
+	status := &model.AgentStatus{
+		Version: internal.FormatFullVersion(),
+		Inputs:  make([]model.InputStatus, 0),
+		Outputs: make([]model.OutputStatus, 0),
+		Stats:   make([]model.StatMetric, 0),
+	}
+
+	if unit := a.plugins.outputsUnit(); unit != nil {
+		unit.RLock()
+		status.Running = !unit.closed
+		for _, output := range unit.outputs {
+			status.Outputs = append(status.Outputs, outputStatus(output))
+		}
+		unit.RUnlock()
+	}
+	if status.Running && !a.StartTime.IsZero() {
+		startTime := a.StartTime
+		status.StartTime = &startTime
+		status.UptimeSeconds = time.Since(startTime).Seconds()
+	}
+
+	for _, input := range a.Config.Inputs {
+		status.Inputs = append(status.Inputs, inputStatus(input, ""))
+	}
+	a.managed.Lock()
+	ids := make([]string, 0, len(a.managed.running))
+	for id := range a.managed.running {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		for _, input := range a.managed.running[id].unit.inputs {
+			status.Inputs = append(status.Inputs, inputStatus(input, id))
+		}
+	}
+	a.managed.Unlock()
+
+	for _, m := range selfstat.Metrics() {
+		status.Stats = append(status.Stats, model.StatMetric{
+			Name:   m.Name(),
+			Tags:   m.Tags(),
+			Fields: m.Fields(),
+		})
+	}
+	sort.SliceStable(status.Stats, func(i, j int) bool {
+		return status.Stats[i].Name < status.Stats[j].Name
+	})
+
+	return status
+}
+
+func inputStatus(input *models.RunningInput, storedID string) model.InputStatus {
+	s := model.InputStatus{
+		ID:              input.ID(),
+		Name:            input.Config.Name,
+		Alias:           input.Config.Alias,
+		StoredID:        storedID,
+		MetricsGathered: input.MetricsGathered.Get(),
+		GatherErrors:    input.GatherErrors.Get(),
+		GatherTimeouts:  input.GatherTimeouts.Get(),
+	}
+
+	last := input.LastGather()
+	if !last.Start.IsZero() {
+		s.LastGather = &last.Start
+		s.LastGatherDuration = last.Duration.String()
+	}
+	if last.Err != nil {
+		s.LastError = last.Err.Error()
+	}
+	return s
+}
+
+func outputStatus(output *models.RunningOutput) model.OutputStatus {
+	stats := output.BufferStats()
+	return model.OutputStatus{
+		ID:              output.ID(),
+		Name:            output.Config.Name,
+		Alias:           output.Config.Alias,
+		BufferSize:      stats.BufferSize.Get(),
+		BufferLimit:     stats.BufferLimit.Get(),
+		MetricsAdded:    stats.MetricsAdded.Get(),
+		MetricsWritten:  stats.MetricsWritten.Get(),
+		MetricsRejected: stats.MetricsRejected.Get(),
+		MetricsDropped:  stats.MetricsDropped.Get(),
+	}
+}
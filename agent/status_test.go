@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"Dana"
+	"Dana/agent/model"
+	"Dana/metric"
+	"Dana/models"
+	"Dana/plugins/inputs"
+)
+
+// failingInput reports an error on every gather.
+type failingInput struct{}
+
+func (*failingInput) SampleConfig() string { return "" }
+
+func (*failingInput) Gather(acc Dana.Accumulator) error {
+	acc.AddError(errors.New("partial failure"))
+	return errors.New("gather failed")
+}
+
+func init() {
+	inputs.Add("agent_status_test", func() Dana.Input { return &failingInput{} })
+}
+
+func TestStatus(t *testing.T) {
+	a, _ := newManagedInputsServer(t)
+	a.StartTime = time.Now().Add(-time.Minute)
+
+	// The statistics are global, a new alias keeps them apart between runs
+	alias := "status-" + primitive.NewObjectID().Hex()
+	fileInputs := buildTestInputs(t, a, &model.HandlerInput{Type: "agent_status_test", Data: map[string]interface{}{"alias": alias}})
+	a.Config.Inputs = fileInputs
+	stored := testInput(map[string]interface{}{"name": "stored"})
+	require.NoError(t, a.startManagedInputs(stored.ID.Hex(), buildTestInputs(t, a, stored)))
+
+	// Nothing gathered and no outputs running yet
+	status := a.status()
+	require.False(t, status.Running)
+	require.Nil(t, status.StartTime)
+	require.Empty(t, status.Outputs)
+	require.Len(t, status.Inputs, 2)
+	require.Equal(t, "agent_status_test", status.Inputs[0].Name)
+	require.Equal(t, alias, status.Inputs[0].Alias)
+	require.Empty(t, status.Inputs[0].StoredID)
+	require.Nil(t, status.Inputs[0].LastGather)
+	require.Equal(t, "agent_test", status.Inputs[1].Name)
+	require.Equal(t, stored.ID.Hex(), status.Inputs[1].StoredID)
+
+	dst := make(chan Dana.Metric, 10)
+	require.Error(t, fileInputs[0].Gather(NewAccumulator(fileInputs[0], dst)))
+
+	a.Config.Agent.MetricBufferLimit = 5
+	output, err := a.buildPlugins(testPlugin("outputs", map[string]interface{}{"alias": alias}))
+	require.NoError(t, err)
+	ro := output.Outputs[0]
+	ro.AddMetric(metric.New("cpu", nil, map[string]interface{}{"value": 1}, time.Now()))
+	a.plugins.outputs = &outputUnit{outputs: []*models.RunningOutput{ro}}
+
+	status = a.status()
+	require.True(t, status.Running)
+	require.NotNil(t, status.StartTime)
+	require.GreaterOrEqual(t, status.UptimeSeconds, 60.0)
+
+	input := status.Inputs[0]
+	require.NotNil(t, input.LastGather)
+	require.NotEmpty(t, input.LastGatherDuration)
+	require.Equal(t, "gather failed", input.LastError)
+	require.Equal(t, int64(1), input.GatherErrors)
+
+	require.Len(t, status.Outputs, 1)
+	require.Equal(t, model.OutputStatus{
+		ID:           ro.ID(),
+		Name:         "agent_test",
+		Alias:        alias,
+		BufferSize:   1,
+		BufferLimit:  5,
+		MetricsAdded: 1,
+	}, status.Outputs[0])
+
+	// The internal statistics include those of the plugins, sorted by name
+	require.NotEmpty(t, status.Stats)
+	var found bool
+	for i, s := range status.Stats {
+		if i > 0 {
+			require.LessOrEqual(t, status.Stats[i-1].Name, s.Name)
+		}
+		if s.Name == "internal_gather" && s.Tags["alias"] == alias {
+			found = true
+			require.Equal(t, int64(1), s.Fields["errors"])
+		}
+	}
+	require.True(t, found)
+
+	a.plugins.outputs.closed = true
+	require.False(t, a.status().Running)
+}
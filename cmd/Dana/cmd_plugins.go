@@ -2,11 +2,14 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"sort"
 	"strings"
 
+	"Dana/config"
 	"Dana/plugins/aggregators"
 	"Dana/plugins/inputs"
 	"Dana/plugins/outputs"
@@ -167,6 +170,28 @@ func getPluginCommands(outputBuffer io.Writer) []*cli.Command {
 						return nil
 					},
 				},
+				{
+					Name:      "schema",
+					Usage:     "Print the JSON Schema of a plugin's configuration",
+					ArgsUsage: "<category> <name> | <category.name>",
+					Action: func(cCtx *cli.Context) error {
+						category, name := cCtx.Args().Get(0), cCtx.Args().Get(1)
+						if cCtx.NArg() == 1 {
+							category, name, _ = strings.Cut(category, ".")
+						}
+						if category == "" || name == "" {
+							return errors.New("expected a plugin like \"inputs snmp\" or \"inputs.snmp\"")
+						}
+
+						schema, err := config.PluginSchema(category, name)
+						if err != nil {
+							return err
+						}
+						encoder := json.NewEncoder(outputBuffer)
+						encoder.SetIndent("", "  ")
+						return encoder.Encode(schema)
+					},
+				},
 				{
 					Name:  "serializers",
 					Usage: "Print available serializer plugins",
@@ -109,6 +109,24 @@ type ServerConfig struct {
 	TelegramToken string `toml:"telegram_token"`
 	BaleToken     string `toml:"bale_token"`
 	InfluxToken   string `toml:"influx_token"`
+	// ExternalURL is the address users reach Dana at, used for links in
+	// notifications.
+	ExternalURL string `toml:"external_url"`
+
+	// JWTKeys are the keys used to sign and verify API tokens. Tokens are
+	// signed with JWTActiveKey; the other keys are only accepted for
+	// verification so keys can be rotated without logging everybody out.
+	JWTKeys         []JWTKey `toml:"jwt_keys"`
+	JWTActiveKey    string   `toml:"jwt_active_key"`
+	AccessTokenTTL  Duration `toml:"access_token_ttl"`
+	RefreshTokenTTL Duration `toml:"refresh_token_ttl"`
+}
+
+// JWTKey is a named HMAC key used for signing API tokens. The secret may
+// reference a secret-store.
+type JWTKey struct {
+	ID     string `toml:"id"`
+	Secret Secret `toml:"secret"`
 }
 
 // MongoURI returns the MongoDB connection URI based on the host and port
@@ -0,0 +1,357 @@
+package config
+
+import (
+	"bufio"
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/influxdata/toml"
+
+	"Dana"
+	"Dana/plugins/aggregators"
+	"Dana/plugins/inputs"
+	"Dana/plugins/outputs"
+	"Dana/plugins/parsers"
+	"Dana/plugins/processors"
+	"Dana/plugins/secretstores"
+	"Dana/plugins/serializers"
+)
+
+// ErrPluginNotFound is returned when no plugin is registered under the
+// requested category and name.
+var ErrPluginNotFound = errors.New("plugin not found")
+
+// Regexps used to pick descriptions out of the sample configurations
+var (
+	sampleHeaderRe  = regexp.MustCompile(`^#?\s*\[\[?\s*([A-Za-z0-9_.\-]+)\s*\]\]?`)
+	sampleSettingRe = regexp.MustCompile(`^#?\s*([A-Za-z0-9_\-]+)\s*=`)
+)
+
+var (
+	secretType       = reflect.TypeOf(Secret{})
+	durationType     = reflect.TypeOf(Duration(0))
+	sizeType         = reflect.TypeOf(Size(0))
+	timeDurationType = reflect.TypeOf(time.Duration(0))
+	textUnmarshaler  = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// PluginSchema returns a JSON Schema describing the configuration accepted by
+// the plugin registered as name in the given category, e.g. "inputs" and
+// "snmp". The properties follow the plugin's toml tags, defaults are taken
+// from a freshly created plugin and descriptions from its sample config.
+func PluginSchema(category, name string) (map[string]interface{}, error) {
+	plugin, err := newPlugin(category, name)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := plugin.(processors.HasUnwrap); ok {
+		plugin = p.Unwrap()
+	}
+
+	schema := valueSchema(reflect.ValueOf(plugin), make(map[reflect.Type]bool))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = category + "." + name
+	// The plugin table also takes the options handled by the config loader
+	// such as "interval" or "alias".
+	delete(schema, "additionalProperties")
+
+	if p, ok := plugin.(Dana.PluginDescriber); ok {
+		description, docs := parseSampleConfig(p.SampleConfig(), category+"."+name)
+		if description != "" {
+			schema["description"] = description
+		}
+		applyDescriptions(schema, docs, "")
+	}
+
+	return schema, nil
+}
+
+// newPlugin creates an unconfigured instance of a registered plugin.
+func newPlugin(category, name string) (interface{}, error) {
+	switch category {
+	case "inputs":
+		if creator, ok := inputs.Inputs[name]; ok {
+			return creator(), nil
+		}
+	case "outputs":
+		if creator, ok := outputs.Outputs[name]; ok {
+			return creator(), nil
+		}
+	case "processors":
+		if creator, ok := processors.Processors[name]; ok {
+			return creator(), nil
+		}
+	case "aggregators":
+		if creator, ok := aggregators.Aggregators[name]; ok {
+			return creator(), nil
+		}
+	case "secretstores":
+		if creator, ok := secretstores.SecretStores[name]; ok {
+			return creator(""), nil
+		}
+	case "parsers":
+		if creator, ok := parsers.Parsers[name]; ok {
+			return creator("schema"), nil
+		}
+	case "serializers":
+		if creator, ok := serializers.Serializers[name]; ok {
+			return creator(), nil
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown category %q", ErrPluginNotFound, category)
+	}
+	return nil, fmt.Errorf("%w: %s.%s", ErrPluginNotFound, category, name)
+}
+
+// valueSchema returns the schema of the given value. Non-zero values are
+// reported as defaults. The seen map guards against recursive types.
+func valueSchema(v reflect.Value, seen map[reflect.Type]bool) map[string]interface{} {
+	t := v.Type()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		if v.IsValid() && !v.IsNil() {
+			v = v.Elem()
+		} else {
+			v = reflect.Value{}
+		}
+	}
+	if !v.IsValid() {
+		v = reflect.Zero(t)
+	}
+
+	switch t {
+	case secretType:
+		// Secrets are never returned, whatever the plugin was created with
+		return map[string]interface{}{
+			"type":      "string",
+			"format":    "password",
+			"writeOnly": true,
+		}
+	case durationType, timeDurationType:
+		schema := map[string]interface{}{
+			"type":   "string",
+			"format": "duration",
+		}
+		if d := time.Duration(v.Int()); d != 0 {
+			schema["default"] = d.String()
+		}
+		return schema
+	case sizeType:
+		schema := map[string]interface{}{
+			"type":    []string{"string", "integer"},
+			"pattern": `^\d+(\.\d+)?\s*[A-Za-z]*$`,
+		}
+		if size := v.Int(); size != 0 {
+			schema["default"] = size
+		}
+		return schema
+	}
+
+	// Types parsing themselves from strings, e.g. regular expressions or
+	// enumerations, are configured as strings.
+	if t.Kind() != reflect.Struct && reflect.PointerTo(t).Implements(textUnmarshaler) {
+		schema := map[string]interface{}{"type": "string"}
+		if t.Kind() == reflect.String && v.String() != "" {
+			schema["default"] = v.String()
+		}
+		return schema
+	}
+
+	schema := make(map[string]interface{})
+	switch t.Kind() {
+	case reflect.Bool:
+		schema["type"] = "boolean"
+		if v.Bool() {
+			schema["default"] = true
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema["type"] = "integer"
+		if v.Int() != 0 {
+			schema["default"] = v.Int()
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+		schema["minimum"] = 0
+		if v.Uint() != 0 {
+			schema["default"] = v.Uint()
+		}
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+		if v.Float() != 0 {
+			schema["default"] = v.Float()
+		}
+	case reflect.String:
+		schema["type"] = "string"
+		if v.String() != "" {
+			schema["default"] = v.String()
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			schema["type"] = "string"
+			break
+		}
+		schema["type"] = "array"
+		schema["items"] = valueSchema(reflect.Zero(t.Elem()), seen)
+		if v.Len() > 0 && isScalar(t.Elem()) {
+			schema["default"] = v.Interface()
+		}
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = valueSchema(reflect.Zero(t.Elem()), seen)
+		if v.Len() > 0 && isScalar(t.Elem()) {
+			schema["default"] = v.Interface()
+		}
+	case reflect.Struct:
+		if seen[t] {
+			schema["type"] = "object"
+			break
+		}
+		seen[t] = true
+		properties := make(map[string]interface{})
+		structProperties(v, properties, seen)
+		delete(seen, t)
+
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+	}
+	return schema
+}
+
+// structProperties adds the schema of every field of the struct that can be
+// set from TOML, following the rules of the toml package: fields tagged "-"
+// and unexported fields are skipped, untagged fields use their snake_case
+// name and untagged embedded structs are flattened.
+func structProperties(v reflect.Value, properties map[string]interface{}, seen map[reflect.Type]bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		key, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		if key == "-" {
+			continue
+		}
+		if field.Anonymous && key == "" && field.Type.Kind() == reflect.Struct {
+			structProperties(v.Field(i), properties, seen)
+			continue
+		}
+		if field.PkgPath != "" || !isConfigurable(field.Type) {
+			continue
+		}
+		if key == "" {
+			key = toml.DefaultConfig.FieldToKey(t, field.Name)
+		}
+		if _, found := properties[key]; found {
+			continue
+		}
+		properties[key] = valueSchema(v.Field(i), seen)
+	}
+}
+
+// isConfigurable reports whether a field of the given type can be set from
+// TOML at all.
+func isConfigurable(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Interface, reflect.Func, reflect.Chan, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return false
+	}
+	return true
+}
+
+// isScalar reports whether values of the given type can be reported as
+// defaults as they are.
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return t != durationType && t != timeDurationType
+	}
+	return false
+}
+
+// parseSampleConfig extracts the plugin description and the "##" comment
+// blocks documenting each setting from a sample configuration. Settings are
+// keyed by their dotted path below the plugin's table, e.g. "field.oid" for
+// the "oid" setting of [[inputs.snmp.field]].
+func parseSampleConfig(sample, table string) (string, map[string]string) {
+	var description string
+	docs := make(map[string]string)
+
+	var path string
+	var comment []string
+	inPlugin := false
+	scanner := bufio.NewScanner(strings.NewReader(sample))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if !inPlugin && description == "" && strings.HasPrefix(line, "# ") {
+			description = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			continue
+		}
+
+		switch {
+		case line == "":
+			comment = comment[:0]
+		case strings.HasPrefix(line, "##"):
+			comment = append(comment, strings.TrimSpace(strings.TrimLeft(line, "#")))
+		case sampleHeaderRe.MatchString(line):
+			name := sampleHeaderRe.FindStringSubmatch(line)[1]
+			switch {
+			case name == table:
+				inPlugin = true
+				path = ""
+			case strings.HasPrefix(name, table+"."):
+				path = strings.TrimPrefix(name, table+".")
+			}
+			comment = comment[:0]
+		case sampleSettingRe.MatchString(line):
+			key := sampleSettingRe.FindStringSubmatch(line)[1]
+			if path != "" {
+				key = path + "." + key
+			}
+			if _, found := docs[key]; !found && len(comment) > 0 {
+				docs[key] = strings.Join(comment, "\n")
+			}
+			comment = comment[:0]
+		}
+	}
+
+	return description, docs
+}
+
+// applyDescriptions sets the descriptions found in the sample config on the
+// matching properties of the schema.
+func applyDescriptions(schema map[string]interface{}, docs map[string]string, prefix string) {
+	properties, ok := schema["properties"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for key, p := range properties {
+		property := p.(map[string]interface{})
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if doc, found := docs[path]; found {
+			property["description"] = doc
+		}
+
+		// Nested tables such as [[inputs.snmp.field]] or [inputs.x.tags]
+		if items, ok := property["items"].(map[string]interface{}); ok {
+			applyDescriptions(items, docs, path)
+		}
+		applyDescriptions(property, docs, path)
+	}
+}
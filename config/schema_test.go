@@ -0,0 +1,76 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"Dana/config"
+	_ "Dana/plugins/outputs/influxdb"
+)
+
+func TestPluginSchemaInfluxDBOutput(t *testing.T) {
+	schema, err := config.PluginSchema("outputs", "influxdb")
+	require.NoError(t, err)
+
+	require.Equal(t, "outputs.influxdb", schema["title"])
+	require.Equal(t, "object", schema["type"])
+	require.Equal(t, "Configuration for sending metrics to InfluxDB", schema["description"])
+	require.NotContains(t, schema, "additionalProperties")
+
+	properties, ok := schema["properties"].(map[string]interface{})
+	require.True(t, ok)
+
+	property := func(name string) map[string]interface{} {
+		t.Helper()
+		require.Contains(t, properties, name)
+		return properties[name].(map[string]interface{})
+	}
+
+	urls := property("urls")
+	require.Equal(t, "array", urls["type"])
+	require.Equal(t, map[string]interface{}{"type": "string"}, urls["items"])
+	require.NotContains(t, urls, "default")
+	require.Contains(t, urls["description"], "The full HTTP or UDP URL")
+
+	timeout := property("timeout")
+	require.Equal(t, "string", timeout["type"])
+	require.Equal(t, "duration", timeout["format"])
+	require.Equal(t, "5s", timeout["default"])
+
+	encoding := property("content_encoding")
+	require.Equal(t, "string", encoding["type"])
+	require.Equal(t, "gzip", encoding["default"])
+
+	skip := property("skip_database_creation")
+	require.Equal(t, "boolean", skip["type"])
+	require.NotContains(t, skip, "default")
+
+	headers := property("http_headers")
+	require.Equal(t, "object", headers["type"])
+	require.Equal(t, map[string]interface{}{"type": "string"}, headers["additionalProperties"])
+
+	password := property("password")
+	require.Equal(t, "string", password["type"])
+	require.Equal(t, true, password["writeOnly"])
+
+	payload := property("udp_payload")
+	require.Equal(t, []string{"string", "integer"}, payload["type"])
+
+	// Embedded TLS settings are flattened into the plugin table
+	require.Equal(t, "boolean", property("insecure_skip_verify")["type"])
+	require.Equal(t, "string", property("tls_ca")["type"])
+
+	// Fields that cannot be set from TOML are left out
+	for _, name := range []string{"log", "clients", "create_httpclient_f", "CreateHTTPClientF"} {
+		require.NotContains(t, properties, name)
+	}
+}
+
+func TestPluginSchemaNotFound(t *testing.T) {
+	_, err := config.PluginSchema("outputs", "does_not_exist")
+	require.ErrorIs(t, err, config.ErrPluginNotFound)
+
+	_, err = config.PluginSchema("unknown", "influxdb")
+	require.ErrorIs(t, err, config.ErrPluginNotFound)
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"Dana"
@@ -30,12 +31,24 @@ type RunningInput struct {
 	gatherStart time.Time
 	gatherEnd   time.Time
 
+	// The result of the last gather, read concurrently by the status API
+	lastGatherMutex sync.Mutex
+	lastGather      GatherStatus
+
 	MetricsGathered selfstat.Stat
 	GatherTime      selfstat.Stat
 	GatherTimeouts  selfstat.Stat
+	GatherErrors    selfstat.Stat
 	StartupErrors   selfstat.Stat
 }
 
+// GatherStatus describes the last completed gather of an input.
+type GatherStatus struct {
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
 func NewRunningInput(input Dana.Input, config *InputConfig) *RunningInput {
 	tags := map[string]string{"input": config.Name}
 	if config.Alias != "" {
@@ -71,6 +84,7 @@ func NewRunningInput(input Dana.Input, config *InputConfig) *RunningInput {
 			"gather_timeouts",
 			tags,
 		),
+		GatherErrors: inputErrorsRegister,
 		StartupErrors: selfstat.Register(
 			"write",
 			"startup_errors",
@@ -252,9 +266,26 @@ func (r *RunningInput) Gather(acc Dana.Accumulator) error {
 	r.gatherEnd = time.Now()
 
 	r.GatherTime.Incr(r.gatherEnd.Sub(r.gatherStart).Nanoseconds())
+
+	r.lastGatherMutex.Lock()
+	r.lastGather = GatherStatus{
+		Start:    r.gatherStart,
+		Duration: r.gatherEnd.Sub(r.gatherStart),
+		Err:      err,
+	}
+	r.lastGatherMutex.Unlock()
+
 	return err
 }
 
+// LastGather returns the status of the last completed gather. The start time
+// is zero if the input did not gather yet.
+func (r *RunningInput) LastGather() GatherStatus {
+	r.lastGatherMutex.Lock()
+	defer r.lastGatherMutex.Unlock()
+	return r.lastGather
+}
+
 func (r *RunningInput) SetDefaultTags(tags map[string]string) {
 	r.defaultTags = tags
 }
@@ -398,3 +398,8 @@ func (r *RunningOutput) Log() Dana.Logger {
 func (r *RunningOutput) BufferLength() int {
 	return r.buffer.Len()
 }
+
+// BufferStats returns the statistics of the output's buffer.
+func (r *RunningOutput) BufferStats() BufferStats {
+	return r.buffer.Stats()
+}